@@ -24,18 +24,35 @@ import (
 	"github.com/ghetzel/go-stockutil/typeutil"
 	"github.com/oliveagle/jsonpath"
 	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v2"
 )
 
 var DefaultBindingTimeout = 60 * time.Second
 
 var registeredProtocols = map[string]Protocol{
-	``:           new(HttpProtocol),
-	`http`:       new(HttpProtocol),
-	`https`:      new(HttpProtocol),
-	`http+unix`:  new(HttpProtocol),
-	`https+unix`: new(HttpProtocol),
-	`redis`:      new(RedisProtocol),
+	``:               new(HttpProtocol),
+	`http`:           new(HttpProtocol),
+	`https`:          new(HttpProtocol),
+	`http+unix`:      new(HttpProtocol),
+	`https+unix`:     new(HttpProtocol),
+	`redis`:          new(RedisProtocol),
+	`rediss`:         new(RedisProtocol),
+	`redis+unix`:     new(RedisProtocol),
+	`redis+sentinel`: new(RedisProtocol),
+	`file`:           new(FileProtocol),
+	`sqlite`:         new(SQLProtocol),
+	`sqlite3`:        new(SQLProtocol),
+	`postgres`:       new(SQLProtocol),
+	`postgresql`:     new(SQLProtocol),
+	`mysql`:          new(SQLProtocol),
+	`exec`:           new(ExecProtocol),
+	`cmd`:            new(ExecProtocol),
+	`graphql+http`:   new(GraphQLProtocol),
+	`graphql+https`:  new(GraphQLProtocol),
+	`grpc`:           new(GrpcProtocol),
+	`grpcs`:          new(GrpcProtocol),
+	`s3`:             new(S3Protocol),
 }
 
 // Register a new protocol handler that will handle URLs with the given scheme.
@@ -67,6 +84,7 @@ type PaginatorConfig struct {
 	Done         string            `yaml:"done"    json:"done"`
 	Maximum      int64             `yaml:"max"     json:"max"`
 	Data         string            `yaml:"data"    json:"data"`
+	Cursor       string            `yaml:"cursor"  json:"cursor"`
 	QueryStrings map[string]string `yaml:"params"  json:"params"`
 	Headers      map[string]string `yaml:"headers" json:"headers"`
 }
@@ -78,11 +96,13 @@ type ResultsPage struct {
 	Data    any     `yaml:"data"           json:"data"`
 	Counter int64   `yaml:"counter"        json:"counter"`
 	Total   int64   `yaml:"total"          json:"total"`
+	Cursor  string  `yaml:"cursor"         json:"cursor"`
 }
 
 type Binding struct {
 	BodyParams         map[string]any                `yaml:"body,omitempty"                 json:"body,omitempty"`                 // If the request receives an open-ended body, this will allow structured data to be passed in.
-	DisableCache       bool                          `yaml:"disable_cache,omitempty"        json:"disable_cache,omitempty"`        // Reserved for future use.
+	CacheTTL           any                           `yaml:"cache_ttl,omitempty"            json:"cache_ttl,omitempty"`            // Cache responses for this long, regardless of what the upstream response headers permit.
+	DisableCache       bool                          `yaml:"disable_cache,omitempty"        json:"disable_cache,omitempty"`        // Never read this binding's response from (or store it in) the binding cache.
 	Fallback           any                           `yaml:"fallback,omitempty"             json:"fallback,omitempty"`             // The value to place in $.bindings if the request fails.
 	Formatter          string                        `yaml:"formatter,omitempty"            json:"formatter,omitempty"`            // How to serialize BodyParams into a string before the request is made.
 	Headers            map[string]string             `yaml:"headers,omitempty"              json:"headers,omitempty"`              // Additional headers to include in the request.
@@ -102,9 +122,12 @@ type Binding struct {
 	ProtocolOptions    map[string]any                `yaml:"protocol,omitempty"             json:"protocol,omitempty"`             // An open-ended set of options that are available for protocol implementations to use.
 	RawBody            string                        `yaml:"rawbody,omitempty"              json:"rawbody,omitempty"`              // If the request receives an open-ended body, this will allow raw data to be passed in as-is.
 	Repeat             string                        `yaml:"repeat,omitempty"               json:"repeat,omitempty"`               // A templated value that yields an array.  The binding request will be performed once for each array element, wherein the Resource value is passed into a template that includes the $index and $item variables, which represent the repeat array item's position and value, respectively.
+	Retry              *RetryConfig                  `yaml:"retry,omitempty"                json:"retry,omitempty"`                // Retry failed requests (overriding the server's default bindingRetry settings).
 	Resource           string                        `yaml:"resource,omitempty"             json:"resource,omitempty"`             // The URL that specifies the protocol and resource to retrieve.
+	Schema             any                           `yaml:"schema,omitempty"               json:"schema,omitempty"`               // A JSON Schema (inline, or the path to a JSON or YAML file) that the response data must conform to after parsing and transformation.
 	SkipInheritHeaders bool                          `yaml:"skip_inherit_headers,omitempty" json:"skip_inherit_headers,omitempty"` // Do not passthrough the headers that were sent to the template from the client's browser, even if Passthrough mode is enabled.
-	Timeout            any                           `yaml:"timeout,omitempty"              json:"timeout,omitempty"`              // A duration specifying the timeout for the request.
+	SurrogateKeys      []string                      `yaml:"surrogate_keys,omitempty"       json:"surrogate_keys,omitempty"`       // Additional keys (evaluated after the binding) to tag cached pages that use this binding with, so that they may be purged together.
+	Timeout            any                           `yaml:"timeout,omitempty"              json:"timeout,omitempty"`              // A duration specifying the timeout for the request (bare numbers below 1000 are seconds, and below 1000000 are milliseconds).
 	Transform          string                        `yaml:"transform,omitempty"            json:"transform,omitempty"`            // Specifies a JSONPath expression that can be used to transform the response data received from the binding into the data that is provided to the template.
 	TlsCertificate     string                        `yaml:"tlscrt,omitempty"               json:"tlscrt,omitempty"`               // Provide the path to a TLS client certificate to present if the server requests one.
 	TlsKey             string                        `yaml:"tlskey,omitempty"               json:"tlskey,omitempty"`               // Provide the path to a TLS client certificate key to present if the server requests one.
@@ -114,9 +137,42 @@ type Binding struct {
 	Restrict           any                           `yaml:"restrict,omitempty"             json:"restrict,omitempty"`             // DEPRECATED: use OnlyPaths/ExceptPaths instead.
 	server             *Server
 	lastRefreshedAt    time.Time
+	lastStatus         int
+	lastProtocol       string
+	lastAttempts       int
+	lastBreaker        string
+	lastViolations     []string
 	syncing            bool
 }
 
+// returns a copy of this binding whose maps can be modified without affecting the original.
+func (binding *Binding) clone() *Binding {
+	var b = *binding
+
+	b.Params = deepCopyMap(binding.Params)
+	b.BodyParams = deepCopyMap(binding.BodyParams)
+	b.ProtocolOptions = deepCopyMap(binding.ProtocolOptions)
+	b.Headers = copyStringMap(binding.Headers)
+
+	if binding.IfStatus != nil {
+		b.IfStatus = make(map[string]BindingErrorAction)
+
+		for k, v := range binding.IfStatus {
+			b.IfStatus[k] = v
+		}
+	}
+
+	if binding.Paginate != nil {
+		var pg = *binding.Paginate
+
+		pg.QueryStrings = copyStringMap(binding.Paginate.QueryStrings)
+		pg.Headers = copyStringMap(binding.Paginate.Headers)
+		b.Paginate = &pg
+	}
+
+	return &b
+}
+
 func (binding *Binding) shouldEvaluate(req *http.Request, data map[string]any, funcs FuncMap) error {
 	if httputil.RequestGetValue(req, `force`).Bool() {
 		return nil
@@ -235,8 +291,64 @@ func (binding *Binding) tracedEvaluate(req *http.Request, header *TemplateHeader
 		}
 	}
 
+	var otelSpan, otelHeaders = startOtelChildSpan(
+		req,
+		binding.server.traceName(fmt.Sprintf("Binding: %s", binding.Name)),
+		attribute.String(`diecast.binding`, binding.Name),
+	)
+
+	if otelSpan != nil && header != nil {
+		if len(header.additionalHeaders) == 0 {
+			header.additionalHeaders = make(map[string]any)
+		}
+
+		for k := range otelHeaders {
+			header.additionalHeaders[k] = otelHeaders.Get(k)
+		}
+	}
+
+	var start = time.Now()
+
+	binding.lastStatus = 0
+	binding.lastProtocol = ``
+	binding.lastAttempts = 0
+	binding.lastBreaker = ``
+	binding.lastViolations = nil
 	out, err = binding.Evaluate(req, header, data, funcs)
 
+	var took = time.Since(start)
+
+	var entry = requestLogBinding{
+		Name:       binding.Name,
+		Status:     binding.lastStatus,
+		DurationMs: millis(took),
+	}
+
+	if binding.lastAttempts > 1 {
+		entry.Attempts = binding.lastAttempts
+	}
+
+	if binding.lastBreaker != breakerClosed {
+		entry.Breaker = binding.lastBreaker
+	}
+
+	if len(binding.lastViolations) > 0 {
+		entry.Violations = binding.lastViolations
+	}
+
+	if err == ErrSkipEval {
+		entry.Skipped = true
+	} else {
+		binding.server.metrics.observe(`diecast_binding_duration_seconds`, took, binding.Name, binding.lastProtocol)
+
+		if err != nil {
+			entry.Error = err.Error()
+			binding.server.metrics.inc(`diecast_binding_errors_total`, binding.Name, binding.lastProtocol)
+		}
+	}
+
+	reqbinding(req, entry)
+
 	if childSpan != nil {
 		if err != nil {
 			childSpan.SetTag(`error`, err.Error())
@@ -245,6 +357,18 @@ func (binding *Binding) tracedEvaluate(req *http.Request, header *TemplateHeader
 		childSpan.Finish()
 	}
 
+	if otelSpan != nil {
+		if binding.lastStatus > 0 {
+			otelSpan.SetAttributes(attribute.Int(`http.status_code`, binding.lastStatus))
+		}
+
+		if err == ErrSkipEval {
+			finishOtelSpan(otelSpan, nil)
+		} else {
+			finishOtelSpan(otelSpan, err)
+		}
+	}
+
 	return
 }
 
@@ -310,10 +434,13 @@ func (binding *Binding) Evaluate(req *http.Request, header *TemplateHeader, data
 
 	if reqUrl, err := url.Parse(uri); err == nil {
 		reqUrl.Scheme = strings.ToLower(reqUrl.Scheme)
+		binding.lastProtocol = reqUrl.Scheme
 
 		var protocol Protocol
 
-		if p, ok := registeredProtocols[reqUrl.Scheme]; ok && p != nil {
+		if p := binding.server.pluginProtocol(reqUrl.Scheme); p != nil {
+			protocol = p
+		} else if p, ok := registeredProtocols[reqUrl.Scheme]; ok && p != nil {
 			protocol = p
 		} else {
 			return nil, fmt.Errorf("cannot evaluate binding %v: invalid protocol scheme %q", binding.Name, reqUrl.Scheme)
@@ -328,7 +455,7 @@ func (binding *Binding) Evaluate(req *http.Request, header *TemplateHeader, data
 			additionalHeaders = header.additionalHeaders
 		}
 
-		if response, err := protocol.Retrieve(&ProtocolRequest{
+		if response, err := binding.retrieveWithRetry(protocol, &ProtocolRequest{
 			Verb:              method,
 			URL:               reqUrl,
 			Binding:           binding,
@@ -341,6 +468,8 @@ func (binding *Binding) Evaluate(req *http.Request, header *TemplateHeader, data
 		}); err == nil {
 			defer response.Close()
 
+			binding.lastStatus = response.StatusCode
+
 			var onError BindingErrorAction
 
 			if oe, err := EvalInline(string(binding.OnError), data, funcs); err == nil {
@@ -401,7 +530,7 @@ func (binding *Binding) Evaluate(req *http.Request, header *TemplateHeader, data
 			var data, err = io.ReadAll(response)
 
 			if response.StatusCode >= 400 {
-				err = fmt.Errorf("%v", data)
+				err = fmt.Errorf("%s", data)
 			}
 
 			if err != nil {
@@ -508,9 +637,13 @@ func (binding *Binding) Evaluate(req *http.Request, header *TemplateHeader, data
 						}
 					}
 
-					return ApplyJPath(rv, binding.Transform)
+					if rv, err = ApplyJPath(rv, binding.Transform); err != nil {
+						return nil, err
+					}
+
+					return binding.checkSchema(req, rv, onError)
 				} else {
-					return nil, nil
+					return binding.checkSchema(req, nil, onError)
 				}
 			} else {
 				return nil, fmt.Errorf("[%s] unhandled binding error: %v", id, err)
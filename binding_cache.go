@@ -0,0 +1,392 @@
+package diecast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+var DefaultBindingCacheSize = 1024
+
+// A BindingCache stores the raw responses retrieved by bindings so that subsequent evaluations
+// can be served without repeating the upstream request.  Implementations must be safe for
+// concurrent use.
+type BindingCache interface {
+	Get(key string) (*BindingCacheEntry, bool)
+	Set(key string, entry *BindingCacheEntry)
+	Delete(key string)
+}
+
+// A BindingCacheEntry is a single cached binding response.
+type BindingCacheEntry struct {
+	MimeType     string    `json:"mime_type"`
+	StatusCode   int       `json:"status_code"`
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	StoredAt     time.Time `json:"stored_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Returns whether the entry can be used as-is without revalidating it.
+func (entry *BindingCacheEntry) IsFresh(at time.Time) bool {
+	return at.Before(entry.ExpiresAt)
+}
+
+// Returns whether the entry has validators that can be used to perform a conditional request.
+func (entry *BindingCacheEntry) CanRevalidate() bool {
+	return entry.ETag != `` || entry.LastModified != ``
+}
+
+func (entry *BindingCacheEntry) response() *ProtocolResponse {
+	var response = NewProtocolResponse(io.NopCloser(bytes.NewReader(entry.Body)))
+
+	response.MimeType = entry.MimeType
+	response.StatusCode = entry.StatusCode
+
+	return response
+}
+
+type BindingCacheConfig struct {
+	Enable  bool         `yaml:"enable" json:"enable"` // Cache binding responses whose upstream permits it (via Cache-Control or Expires headers).
+	Size    int          `yaml:"size"   json:"size"`   // The maximum number of responses held by the default in-memory cache.
+	Backend BindingCache `yaml:"-"      json:"-"`      // Replace the default in-memory cache with a custom implementation.
+}
+
+// MemoryBindingCache is the default BindingCache; it holds a fixed number of responses in memory,
+// evicting the least-recently used ones first.
+type MemoryBindingCache struct {
+	entries *lruCache[*BindingCacheEntry]
+}
+
+func NewMemoryBindingCache(size int) *MemoryBindingCache {
+	if size <= 0 {
+		size = DefaultBindingCacheSize
+	}
+
+	return &MemoryBindingCache{
+		entries: newLruCache[*BindingCacheEntry](size),
+	}
+}
+
+func (cache *MemoryBindingCache) Get(key string) (*BindingCacheEntry, bool) {
+	return cache.entries.Get(key)
+}
+
+func (cache *MemoryBindingCache) Set(key string, entry *BindingCacheEntry) {
+	cache.entries.Set(key, entry)
+}
+
+func (cache *MemoryBindingCache) Delete(key string) {
+	cache.entries.Delete(key)
+}
+
+// return the binding cache backend for this server, creating the default one if necessary.
+func (server *Server) bindingCache() BindingCache {
+	server.bindingCacheLock.Lock()
+	defer server.bindingCacheLock.Unlock()
+
+	if server.bindingCacheBackend == nil {
+		if cfg := server.BindingCache; cfg != nil && cfg.Backend != nil {
+			server.bindingCacheBackend = cfg.Backend
+		} else if cfg != nil {
+			server.bindingCacheBackend = NewMemoryBindingCache(cfg.Size)
+		} else {
+			server.bindingCacheBackend = NewMemoryBindingCache(0)
+		}
+	}
+
+	return server.bindingCacheBackend
+}
+
+// returns the explicit cache lifetime for this binding, if any.
+func (binding *Binding) cacheTTL() time.Duration {
+	return secondsOrDuration(binding.CacheTTL)
+}
+
+// determine whether this binding's response may be read from or stored in the cache at all.
+func (binding *Binding) isCacheable(rr *ProtocolRequest) bool {
+	if binding.DisableCache || binding.server == nil {
+		return false
+	} else if binding.cacheTTL() > 0 {
+		return true
+	} else if cfg := binding.server.BindingCache; cfg == nil || !cfg.Enable {
+		return false
+	}
+
+	switch rr.Verb {
+	case http.MethodGet, http.MethodHead:
+		return true
+	default:
+		return false
+	}
+}
+
+// generate a key that uniquely identifies the request this binding is about to make.
+func (binding *Binding) cacheKey(rr *ProtocolRequest) (string, error) {
+	var hash = sha256.New()
+	var tpl = func(in any) (string, error) {
+		if binding.NoTemplate {
+			return typeutil.String(in), nil
+		} else if v, err := rr.Template(in); err == nil {
+			return v.String(), nil
+		} else {
+			return ``, err
+		}
+	}
+
+	fmt.Fprintf(hash, "%s %s\n", rr.Verb, rr.URL.String())
+
+	for _, k := range maputil.StringKeys(binding.Params) {
+		if v, err := tpl(binding.Params[k]); err == nil {
+			fmt.Fprintf(hash, "param:%s=%s\n", k, v)
+		} else {
+			return ``, fmt.Errorf("param %q: %v", k, err)
+		}
+	}
+
+	for _, k := range maputil.StringKeys(binding.Headers) {
+		if v, err := tpl(binding.Headers[k]); err == nil {
+			fmt.Fprintf(hash, "header:%s=%s\n", http.CanonicalHeaderKey(k), v)
+		} else {
+			return ``, fmt.Errorf("header %q: %v", k, err)
+		}
+	}
+
+	for _, k := range maputil.StringKeys(binding.ProtocolOptions) {
+		if v, err := tpl(binding.ProtocolOptions[k]); err == nil {
+			fmt.Fprintf(hash, "protocol:%s=%s\n", k, v)
+		} else {
+			return ``, fmt.Errorf("protocol option %q: %v", k, err)
+		}
+	}
+
+	// responses may vary by the identity of the requester, so those inherited headers are included
+	if !binding.SkipInheritHeaders && rr.Request != nil {
+		for _, k := range []string{`Authorization`, `Cookie`} {
+			if v := rr.Request.Header.Get(k); v != `` {
+				fmt.Fprintf(hash, "inherit:%s=%s\n", k, v)
+			}
+		}
+	}
+
+	if len(binding.BodyParams) > 0 {
+		var body = make(map[string]any)
+
+		if err := maputil.Walk(binding.BodyParams, func(value any, path []string, isLeaf bool) error {
+			if isLeaf {
+				if v, err := tpl(value); err == nil {
+					maputil.DeepSet(body, path, v)
+				} else {
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+			return ``, fmt.Errorf("body: %v", err)
+		}
+
+		if b, err := json.Marshal(body); err == nil {
+			fmt.Fprintf(hash, "body:%s:%s\n", binding.Formatter, string(b))
+		} else {
+			return ``, fmt.Errorf("body: %v", err)
+		}
+	} else if binding.RawBody != `` {
+		if v, err := tpl(binding.RawBody); err == nil {
+			fmt.Fprintf(hash, "rawbody:%s\n", v)
+		} else {
+			return ``, fmt.Errorf("rawbody: %v", err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// retrieve performs the binding request using the given protocol, consulting the binding cache
+// first and storing the response afterwards when permitted.
+func (binding *Binding) retrieve(protocol Protocol, rr *ProtocolRequest) (*ProtocolResponse, error) {
+	if !binding.isCacheable(rr) {
+		return protocol.Retrieve(rr)
+	}
+
+	var id = reqid(rr.Request)
+	var cache = binding.server.bindingCache()
+	var key string
+	var now = time.Now()
+
+	if k, err := binding.cacheKey(rr); err == nil {
+		key = k
+	} else {
+		return nil, err
+	}
+
+	var entry, hasEntry = cache.Get(key)
+
+	if hasEntry {
+		if entry.IsFresh(now) {
+			log.Debugf("[%s]  binding %q: cache hit (age: %v)", id, binding.Name, now.Sub(entry.StoredAt).Round(time.Millisecond))
+			return entry.response(), nil
+		} else if _, isHttp := protocol.(*HttpProtocol); isHttp && entry.CanRevalidate() {
+			log.Debugf("[%s]  binding %q: revalidating stale cache entry", id, binding.Name)
+			rr.Conditional = make(http.Header)
+
+			if entry.ETag != `` {
+				rr.Conditional.Set(`If-None-Match`, entry.ETag)
+			}
+
+			if entry.LastModified != `` {
+				rr.Conditional.Set(`If-Modified-Since`, entry.LastModified)
+			}
+		}
+	}
+
+	var response, err = protocol.Retrieve(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var res, _ = response.Raw.(*http.Response)
+
+	// the upstream has confirmed that our stale copy is still good
+	if hasEntry && response.StatusCode == http.StatusNotModified {
+		response.Close()
+
+		var refreshed = *entry
+
+		refreshed.StoredAt = now
+		refreshed.ExpiresAt = now.Add(binding.cacheLifetime(res, now))
+		cache.Set(key, &refreshed)
+
+		log.Debugf("[%s]  binding %q: cache revalidated", id, binding.Name)
+		return refreshed.response(), nil
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response, nil
+	} else if !binding.mayStore(res) {
+		cache.Delete(key)
+		return response, nil
+	}
+
+	var body, rerr = io.ReadAll(response)
+	response.Close()
+
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	response.data = io.NopCloser(bytes.NewReader(body))
+
+	var stored = &BindingCacheEntry{
+		MimeType:   response.MimeType,
+		StatusCode: response.StatusCode,
+		Body:       body,
+		StoredAt:   now,
+		ExpiresAt:  now.Add(binding.cacheLifetime(res, now)),
+	}
+
+	if res != nil {
+		stored.ETag = res.Header.Get(`ETag`)
+		stored.LastModified = res.Header.Get(`Last-Modified`)
+	}
+
+	// only keep entries that will be useful later: either still fresh or able to be revalidated
+	if stored.IsFresh(now) || stored.CanRevalidate() {
+		cache.Set(key, stored)
+		log.Debugf("[%s]  binding %q: cached response until %v", id, binding.Name, stored.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return response, nil
+}
+
+// returns whether the response may be stored, as directed by its Cache-Control header.
+func (binding *Binding) mayStore(res *http.Response) bool {
+	if binding.cacheTTL() > 0 {
+		return true
+	} else if res == nil {
+		return false
+	}
+
+	var directives = parseCacheControl(res.Header.Get(`Cache-Control`))
+
+	if _, ok := directives[`no-store`]; ok {
+		return false
+	} else if _, ok := directives[`private`]; ok {
+		return false
+	}
+
+	return true
+}
+
+// returns how long a response should be considered fresh for.  An explicit cache_ttl on the
+// binding always wins; otherwise the Cache-Control and Expires response headers are consulted.
+func (binding *Binding) cacheLifetime(res *http.Response, now time.Time) time.Duration {
+	if ttl := binding.cacheTTL(); ttl > 0 {
+		return ttl
+	} else if res == nil {
+		return 0
+	}
+
+	var directives = parseCacheControl(res.Header.Get(`Cache-Control`))
+
+	if _, ok := directives[`no-cache`]; ok {
+		return 0
+	}
+
+	for _, directive := range []string{`s-maxage`, `max-age`} {
+		if v, ok := directives[directive]; ok {
+			if secs := typeutil.Int(v); secs > 0 {
+				var ttl = time.Duration(secs) * time.Second
+
+				// discount time the response already spent in upstream caches
+				if age := typeutil.Int(res.Header.Get(`Age`)); age > 0 {
+					ttl -= time.Duration(age) * time.Second
+				}
+
+				return ttl
+			} else {
+				return 0
+			}
+		}
+	}
+
+	if expires := res.Header.Get(`Expires`); expires != `` {
+		if at, err := http.ParseTime(expires); err == nil {
+			var origin = now
+
+			if date, err := http.ParseTime(res.Header.Get(`Date`)); err == nil {
+				origin = date
+			}
+
+			return at.Sub(origin)
+		}
+	}
+
+	return 0
+}
+
+func parseCacheControl(value string) map[string]string {
+	var directives = make(map[string]string)
+
+	for _, part := range strings.Split(value, `,`) {
+		var k, v, _ = strings.Cut(strings.TrimSpace(part), `=`)
+
+		if k = strings.ToLower(strings.TrimSpace(k)); k != `` {
+			directives[k] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+
+	return directives
+}
@@ -0,0 +1,164 @@
+package diecast
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestMemoryBindingCacheEviction(t *testing.T) {
+	var assert = require.New(t)
+	var cache = NewMemoryBindingCache(2)
+
+	cache.Set(`a`, &BindingCacheEntry{Body: []byte(`a`)})
+	cache.Set(`b`, &BindingCacheEntry{Body: []byte(`b`)})
+
+	// touch "a" so that "b" becomes the least-recently used entry
+	_, ok := cache.Get(`a`)
+	assert.True(ok)
+
+	cache.Set(`c`, &BindingCacheEntry{Body: []byte(`c`)})
+
+	_, ok = cache.Get(`b`)
+	assert.False(ok)
+
+	entry, ok := cache.Get(`a`)
+	assert.True(ok)
+	assert.Equal([]byte(`a`), entry.Body)
+
+	cache.Delete(`a`)
+	_, ok = cache.Get(`a`)
+	assert.False(ok)
+}
+
+func TestBindingCache(t *testing.T) {
+	var assert = require.New(t)
+	var mux = http.NewServeMux()
+	var hits int64
+	var revalidations int64
+
+	mux.HandleFunc(`/fresh`, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(`Cache-Control`, `max-age=60`)
+		httputil.RespondJSON(w, map[string]any{
+			`hit`: atomic.AddInt64(&hits, 1),
+		})
+	})
+
+	mux.HandleFunc(`/nostore`, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(`Cache-Control`, `no-store, max-age=60`)
+		httputil.RespondJSON(w, map[string]any{
+			`hit`: atomic.AddInt64(&hits, 1),
+		})
+	})
+
+	mux.HandleFunc(`/etag`, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(`Cache-Control`, `no-cache`)
+		w.Header().Set(`ETag`, `"v1"`)
+
+		if req.Header.Get(`If-None-Match`) == `"v1"` {
+			atomic.AddInt64(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		httputil.RespondJSON(w, map[string]any{
+			`hit`: atomic.AddInt64(&hits, 1),
+		})
+	})
+
+	mux.HandleFunc(`/uncached`, func(w http.ResponseWriter, req *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`hit`: atomic.AddInt64(&hits, 1),
+		})
+	})
+
+	var upstream = httptest.NewServer(mux)
+	defer upstream.Close()
+
+	var dc = NewServer(`./tests/hello`)
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+
+	dc.BindingCache = &BindingCacheConfig{
+		Enable: true,
+	}
+
+	var eval = func(binding *Binding) any {
+		binding.server = dc
+
+		out, err := binding.Evaluate(
+			httptest.NewRequest(`GET`, `/`, nil),
+			&TemplateHeader{},
+			make(map[string]any),
+			funcs,
+		)
+
+		assert.NoError(err)
+		return out
+	}
+
+	// responses with a max-age are served from cache until they expire
+	// ---------------------------------------------------------------------------------------------
+	atomic.StoreInt64(&hits, 0)
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `fresh`, Resource: upstream.URL + `/fresh`}))
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `fresh`, Resource: upstream.URL + `/fresh`}))
+
+	// ...but params are part of the cache key
+	assert.Equal(map[string]any{`hit`: float64(2)}, eval(&Binding{
+		Name:     `fresh`,
+		Resource: upstream.URL + `/fresh`,
+		Params: map[string]any{
+			`page`: `{{ add 1 1 }}`,
+		},
+	}))
+
+	// disable_cache skips the cache entirely
+	assert.Equal(map[string]any{`hit`: float64(3)}, eval(&Binding{Name: `fresh`, Resource: upstream.URL + `/fresh`, DisableCache: true}))
+
+	// no-store is honored
+	// ---------------------------------------------------------------------------------------------
+	atomic.StoreInt64(&hits, 0)
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `nostore`, Resource: upstream.URL + `/nostore`}))
+	assert.Equal(map[string]any{`hit`: float64(2)}, eval(&Binding{Name: `nostore`, Resource: upstream.URL + `/nostore`}))
+
+	// stale responses with an ETag are revalidated with a conditional request
+	// ---------------------------------------------------------------------------------------------
+	atomic.StoreInt64(&hits, 0)
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `etag`, Resource: upstream.URL + `/etag`}))
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `etag`, Resource: upstream.URL + `/etag`}))
+	assert.EqualValues(1, atomic.LoadInt64(&revalidations))
+
+	// responses without caching headers are only cached if the binding specifies a cache_ttl
+	// ---------------------------------------------------------------------------------------------
+	atomic.StoreInt64(&hits, 0)
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `uncached`, Resource: upstream.URL + `/uncached`}))
+	assert.Equal(map[string]any{`hit`: float64(2)}, eval(&Binding{Name: `uncached`, Resource: upstream.URL + `/uncached`}))
+	assert.Equal(map[string]any{`hit`: float64(3)}, eval(&Binding{Name: `uncached`, Resource: upstream.URL + `/uncached`, CacheTTL: `1m`}))
+	assert.Equal(map[string]any{`hit`: float64(3)}, eval(&Binding{Name: `uncached`, Resource: upstream.URL + `/uncached`, CacheTTL: 60}))
+
+	// non-idempotent methods are not cached by default
+	// ---------------------------------------------------------------------------------------------
+	atomic.StoreInt64(&hits, 0)
+	assert.Equal(map[string]any{`hit`: float64(1)}, eval(&Binding{Name: `post`, Method: `post`, Resource: upstream.URL + `/fresh`}))
+	assert.Equal(map[string]any{`hit`: float64(2)}, eval(&Binding{Name: `post`, Method: `post`, Resource: upstream.URL + `/fresh`}))
+}
+
+func TestBindingCacheTTL(t *testing.T) {
+	var assert = require.New(t)
+
+	for in, want := range map[any]time.Duration{
+		3600:   time.Hour,
+		`3600`: time.Hour,
+		`1h`:   time.Hour,
+		1.5:    1500 * time.Millisecond,
+		`90s`:  90 * time.Second,
+	} {
+		assert.Equal(want, (&Binding{CacheTTL: in}).cacheTTL(), "%v", in)
+	}
+
+	assert.Zero((&Binding{}).cacheTTL())
+}
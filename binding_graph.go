@@ -0,0 +1,333 @@
+package diecast
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// matches "$.bindings.name", "$.b.name", and bare references to the whole bindings object.
+var rxBindingReference = regexp.MustCompile(`(?:^|[^\w\)\]])\$?\.(?:bindings|b)\b(?:\.(\w+))?`)
+
+// matches references to the page object, whose contents may themselves be derived from bindings.
+var rxPageReference = regexp.MustCompile(`(?:^|[^\w\)\]])\$?\.(?:page|p)\b`)
+
+// matches the "payload" function, which exposes all template data (including bindings).
+var rxPayloadReference = regexp.MustCompile(`\bpayload\b`)
+
+// returns all of the binding properties that are evaluated as templates before the request is made.
+func (binding *Binding) templatedValues() []string {
+	var values = []string{
+		binding.Resource,
+		binding.OnlyIfExpr,
+		binding.NotIfExpr,
+		binding.Repeat,
+		binding.RawBody,
+		string(binding.OnError),
+	}
+
+	var collect = func(value any, _ []string, isLeaf bool) error {
+		if isLeaf {
+			values = append(values, typeutil.String(value))
+		}
+
+		return nil
+	}
+
+	maputil.Walk(binding.Params, collect)
+	maputil.Walk(binding.BodyParams, collect)
+	maputil.Walk(binding.ProtocolOptions, collect)
+
+	for _, v := range binding.Headers {
+		values = append(values, v)
+	}
+
+	for _, v := range binding.IfStatus {
+		values = append(values, string(v))
+	}
+
+	if pg := binding.Paginate; pg != nil {
+		for _, v := range pg.QueryStrings {
+			values = append(values, v)
+		}
+
+		for _, v := range pg.Headers {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// returns whether any page object values refer to binding output, in which case any binding that
+// references $.page implicitly depends on every binding that precedes it.
+func (server *Server) pageReferencesBindings(header *TemplateHeader) bool {
+	var found bool
+	var check = func(value any, _ []string, isLeaf bool) error {
+		if isLeaf && !found {
+			var vS = typeutil.String(value)
+			found = rxBindingReference.MatchString(vS) || rxPayloadReference.MatchString(vS)
+		}
+
+		return nil
+	}
+
+	maputil.Walk(server.DefaultPageObject, check)
+	maputil.Walk(server.OverridePageObject, check)
+
+	if header != nil {
+		maputil.Walk(header.Page, check)
+	}
+
+	return found
+}
+
+// Analyzes the given bindings for references to one another, returning the indices of the
+// bindings each one depends on.  References to a specific binding by name ($.bindings.name)
+// depend on the nearest preceding binding of that name (or, failing that, the first one after
+// it). Ambiguous references to the whole bindings object depend on every preceding binding, which
+// preserves the sequential pipelining behavior.  An error is returned if the references form a
+// cycle.
+func (server *Server) bindingDependencies(list []Binding, header *TemplateHeader) ([][]int, error) {
+	var deps = make([][]int, len(list))
+	var byName = make(map[string][]int)
+	var pageIsDynamic = server.pageReferencesBindings(header)
+
+	for i, binding := range list {
+		byName[binding.Name] = append(byName[binding.Name], i)
+	}
+
+	for i, binding := range list {
+		var generic bool
+		var seen = make(map[int]bool)
+		var add = func(j int) {
+			if j != i && !seen[j] {
+				seen[j] = true
+				deps[i] = append(deps[i], j)
+			}
+		}
+
+		// bindings sharing a name must still be evaluated in order, since the last one wins
+		for _, j := range byName[binding.Name] {
+			if j < i {
+				add(j)
+			}
+		}
+
+		if !binding.NoTemplate {
+			for _, value := range binding.templatedValues() {
+				if rxPayloadReference.MatchString(value) {
+					generic = true
+				} else if pageIsDynamic && rxPageReference.MatchString(value) {
+					generic = true
+				}
+
+				for _, match := range rxBindingReference.FindAllStringSubmatch(value, -1) {
+					var name = match[1]
+
+					if name == `` {
+						generic = true
+						continue
+					} else if name == binding.Name {
+						continue
+					}
+
+					var target = -1
+
+					for _, j := range byName[name] {
+						if j < i {
+							target = j
+						} else if target < 0 {
+							target = j
+							break
+						}
+					}
+
+					if target >= 0 {
+						add(target)
+					}
+				}
+			}
+		}
+
+		if generic {
+			for j := 0; j < i; j++ {
+				add(j)
+			}
+		}
+	}
+
+	// depth-first search for cycles
+	var state = make([]int, len(list))
+	var path = make([]int, 0)
+	var visit func(i int) error
+
+	visit = func(i int) error {
+		switch state[i] {
+		case 1:
+			var names = make([]string, 0)
+			var inCycle bool
+
+			for _, j := range path {
+				if j == i {
+					inCycle = true
+				}
+
+				if inCycle {
+					names = append(names, list[j].Name)
+				}
+			}
+
+			return fmt.Errorf("bindings: dependency cycle detected: %s -> %s", strings.Join(names, ` -> `), list[i].Name)
+		case 2:
+			return nil
+		}
+
+		state[i] = 1
+		path = append(path, i)
+
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[i] = 2
+
+		return nil
+	}
+
+	for i := range list {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
+
+// Evaluates the given bindings concurrently (up to BindingConcurrency at a time), starting each
+// one as soon as all of the bindings it depends on have completed.  Each binding is evaluated
+// against its own copy of the template data, which contains the output of every binding that
+// had completed before it started.
+func (server *Server) evalBindingsConcurrently(req *http.Request, header *TemplateHeader, list []Binding, data map[string]any, bindings map[string]any) error {
+	var deps, err = server.bindingDependencies(list, header)
+
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failed atomic.Bool
+	var errs = make([]error, len(list))
+	var done = make([]chan struct{}, len(list))
+	var slots = make(chan struct{}, server.BindingConcurrency)
+
+	for i := range list {
+		done[i] = make(chan struct{})
+	}
+
+	for i := range list {
+		wg.Add(1)
+
+		go func(i int, binding Binding) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for _, j := range deps[i] {
+				<-done[j]
+			}
+
+			if failed.Load() {
+				return
+			}
+
+			slots <- struct{}{}
+			defer func() {
+				<-slots
+			}()
+
+			// give this binding its own view of the request, data, and header so that nothing it
+			// modifies during evaluation is visible to its siblings
+			var bindReq *http.Request
+			var bindHeader *TemplateHeader
+			var bindData = make(map[string]any)
+			var bindBindings = make(map[string]any)
+
+			if header != nil {
+				var h = *header
+
+				h.additionalHeaders = make(map[string]any)
+
+				for k, v := range header.additionalHeaders {
+					h.additionalHeaders[k] = v
+				}
+
+				bindHeader = &h
+			}
+
+			lock.Lock()
+
+			bindReq = req.WithContext(req.Context())
+
+			for k, v := range data {
+				bindData[k] = v
+			}
+
+			for k, v := range bindings {
+				bindBindings[k] = v
+			}
+
+			lock.Unlock()
+
+			if vars, ok := bindData[`vars`].(map[string]any); ok {
+				bindData[`vars`] = maputil.DeepCopy(vars)
+			}
+
+			server.updateBindings(bindData, bindBindings)
+
+			var bindFuncs = server.GetTemplateFunctions(bindData, bindHeader)
+
+			server.evalPageData(false, bindReq, bindHeader, bindFuncs, bindData)
+
+			var err = server.evalBinding(bindReq, bindHeader, &binding, i, bindData, bindFuncs, bindBindings)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err == nil {
+				if v, ok := bindBindings[binding.Name]; ok {
+					bindings[binding.Name] = v
+					server.updateBindings(data, bindings)
+				}
+			} else {
+				if code := httputil.RequestGetValue(bindReq, ContextStatusKey); !code.IsNil() {
+					httputil.RequestSetValue(req, ContextStatusKey, code.Value)
+				}
+
+				errs[i] = err
+				failed.Store(true)
+			}
+		}(i, list[i])
+	}
+
+	wg.Wait()
+
+	// report the error from the earliest binding, as sequential evaluation would have
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,441 @@
+package diecast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+var DefaultRetryBackoff = 100 * time.Millisecond
+var DefaultRetryMaxBackoff = 5 * time.Second
+var DefaultRetryStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+var DefaultRetryErrors = []string{`timeout`, `connection`}
+var DefaultCircuitBreakerThreshold = 5
+var DefaultCircuitBreakerCooldown = 30 * time.Second
+
+// Specifies how binding requests that fail are retried.  A RetryConfig set on a binding takes
+// precedence over the server's default for any fields it sets.
+type RetryConfig struct {
+	Attempts   int      `yaml:"attempts"    json:"attempts"`    // The maximum number of times a request is made (including the first).  Values less than 2 disable retrying.
+	Backoff    string   `yaml:"backoff"     json:"backoff"`     // How long to wait before the first retry; the delay doubles with each subsequent retry.
+	MaxBackoff string   `yaml:"max_backoff" json:"max_backoff"` // The longest delay between retries.
+	Jitter     float64  `yaml:"jitter"      json:"jitter"`      // Randomly vary each delay by up to this fraction of it (0.0-1.0), so that many clients don't retry in lockstep.
+	Statuses   []int    `yaml:"statuses"    json:"statuses"`    // Response statuses that are retried.
+	Errors     []string `yaml:"errors"      json:"errors"`      // The kinds of errors that are retried: "timeout", "connection", "tls", or "any".
+	Unsafe     bool     `yaml:"unsafe"      json:"unsafe"`      // Also retry POST and PATCH requests, which may not be safe to repeat.
+}
+
+// returns a copy of this configuration with any unset fields taken from the given defaults.
+func (config *RetryConfig) merge(defaults *RetryConfig) *RetryConfig {
+	var out RetryConfig
+
+	if defaults != nil {
+		out = *defaults
+	}
+
+	if config != nil {
+		if config.Attempts > 0 {
+			out.Attempts = config.Attempts
+		}
+
+		if config.Backoff != `` {
+			out.Backoff = config.Backoff
+		}
+
+		if config.MaxBackoff != `` {
+			out.MaxBackoff = config.MaxBackoff
+		}
+
+		if config.Jitter > 0 {
+			out.Jitter = config.Jitter
+		}
+
+		if len(config.Statuses) > 0 {
+			out.Statuses = config.Statuses
+		}
+
+		if len(config.Errors) > 0 {
+			out.Errors = config.Errors
+		}
+
+		out.Unsafe = out.Unsafe || config.Unsafe
+	}
+
+	return &out
+}
+
+// returns whether the outcome of an attempt should be retried.
+func (config *RetryConfig) shouldRetry(response *ProtocolResponse, err error) bool {
+	if err != nil {
+		var class = retryErrorClass(err)
+		var classes = config.Errors
+
+		if len(classes) == 0 {
+			classes = DefaultRetryErrors
+		}
+
+		return class != `` && (sliceutil.ContainsString(classes, class) || sliceutil.ContainsString(classes, `any`))
+	} else if response != nil {
+		var statuses = config.Statuses
+
+		if len(statuses) == 0 {
+			statuses = DefaultRetryStatuses
+		}
+
+		for _, status := range statuses {
+			if response.StatusCode == status {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// returns how long to wait before the given retry (starting at 1).
+func (config *RetryConfig) delay(retry int, response *ProtocolResponse) time.Duration {
+	var delay = typeutil.Duration(config.Backoff)
+	var max = typeutil.Duration(config.MaxBackoff)
+
+	if delay <= 0 {
+		delay = DefaultRetryBackoff
+	}
+
+	if max <= 0 {
+		max = DefaultRetryMaxBackoff
+	}
+
+	for i := 1; i < retry && delay < max; i++ {
+		delay *= 2
+	}
+
+	if config.Jitter > 0 {
+		var jitter = float64(delay) * config.Jitter
+
+		delay += time.Duration((rand.Float64()*2 - 1) * jitter)
+	}
+
+	// honor the upstream's request to wait a certain amount of time
+	if response != nil {
+		if res, ok := response.Raw.(*http.Response); ok {
+			if after := typeutil.Int(res.Header.Get(`Retry-After`)); after > 0 {
+				if wait := time.Duration(after) * time.Second; wait > delay {
+					delay = wait
+				}
+			}
+		}
+	}
+
+	if delay > max {
+		delay = max
+	} else if delay < 0 {
+		delay = 0
+	}
+
+	return delay
+}
+
+// classify an error returned by a protocol, returning an empty string for errors that should never
+// be retried.
+func retryErrorClass(err error) string {
+	var nerr net.Error
+
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), log.ErrContains(err, `request canceled`) && !log.ErrContains(err, `Client.Timeout`):
+		return ``
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &nerr) && nerr.Timeout():
+		return `timeout`
+	case log.ErrContains(err, `x509:`), log.ErrContains(err, `tls:`):
+		return `tls`
+	case IsHardStop(err), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return `connection`
+	default:
+		return `other`
+	}
+}
+
+// Specifies when requests to a failing upstream host are stopped.  After "threshold" consecutive
+// failures, bindings that request anything from that host fail immediately (with a 503 status)
+// without making a request, until the "cooldown" has elapsed.  A single request is then permitted,
+// which closes the breaker again if it succeeds.
+type CircuitBreakerConfig struct {
+	Enable    bool   `yaml:"enable"    json:"enable"`    // Enable circuit breakers for binding requests.
+	Threshold int    `yaml:"threshold" json:"threshold"` // The number of consecutive failures that opens the breaker for a host.
+	Cooldown  string `yaml:"cooldown"  json:"cooldown"`  // How long the breaker stays open before another request is permitted.
+}
+
+const (
+	breakerClosed   = `closed`
+	breakerOpen     = `open`
+	breakerHalfOpen = `half-open`
+)
+
+// the circuit breaker for a single upstream host.
+type circuitBreaker struct {
+	Host      string    `json:"host"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	OpenedAt  time.Time `json:"opened_at,omitempty"`
+	threshold int
+	cooldown  time.Duration
+	trial     bool
+	lock      sync.Mutex
+}
+
+// returns whether a request may be made, and the current state of the breaker.
+func (breaker *circuitBreaker) allow() (bool, string) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	switch breaker.State {
+	case breakerOpen:
+		if time.Since(breaker.OpenedAt) < breaker.cooldown {
+			return false, breaker.State
+		}
+
+		breaker.State = breakerHalfOpen
+		breaker.trial = true
+		log.Noticef("circuit breaker for %s is half-open, permitting a trial request", breaker.Host)
+
+		return true, breaker.State
+	case breakerHalfOpen:
+		// only one trial request is permitted at a time
+		if breaker.trial {
+			return false, breaker.State
+		}
+
+		breaker.trial = true
+	}
+
+	return true, breaker.State
+}
+
+// record the outcome of a request, returning the new state of the breaker.
+func (breaker *circuitBreaker) record(failed bool) string {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.trial = false
+
+	if !failed {
+		if breaker.State != breakerClosed {
+			log.Noticef("circuit breaker for %s is closed", breaker.Host)
+		}
+
+		breaker.State = breakerClosed
+		breaker.Failures = 0
+
+		return breaker.State
+	}
+
+	breaker.Failures += 1
+
+	if breaker.State == breakerHalfOpen || breaker.Failures >= breaker.threshold {
+		if breaker.State != breakerOpen {
+			log.Warningf("circuit breaker for %s is open after %d consecutive failures", breaker.Host, breaker.Failures)
+		}
+
+		breaker.State = breakerOpen
+		breaker.OpenedAt = time.Now()
+	}
+
+	return breaker.State
+}
+
+func (breaker *circuitBreaker) snapshot() *circuitBreaker {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	return &circuitBreaker{
+		Host:     breaker.Host,
+		State:    breaker.State,
+		Failures: breaker.Failures,
+		OpenedAt: breaker.OpenedAt,
+	}
+}
+
+// returns whether the outcome of a request counts as a failure of the upstream host.  Errors that
+// are not caused by the upstream (e.g.: template and parsing errors) never do.
+func isBreakerFailure(response *ProtocolResponse, err error) bool {
+	if err != nil {
+		switch retryErrorClass(err) {
+		case `timeout`, `connection`, `tls`:
+			return true
+		default:
+			return false
+		}
+	} else if response != nil {
+		return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	}
+
+	return false
+}
+
+// return the circuit breaker for the host being requested, or nil if breakers are disabled.
+func (server *Server) circuitBreaker(rr *ProtocolRequest) *circuitBreaker {
+	var config = server.CircuitBreaker
+
+	if config == nil || !config.Enable {
+		return nil
+	}
+
+	var host = rr.URL.Scheme + `://` + rr.URL.Host
+
+	if v, ok := server.breakers.Load(host); ok {
+		return v.(*circuitBreaker)
+	}
+
+	var breaker = &circuitBreaker{
+		Host:      host,
+		State:     breakerClosed,
+		threshold: config.Threshold,
+		cooldown:  typeutil.Duration(config.Cooldown),
+	}
+
+	if breaker.threshold <= 0 {
+		breaker.threshold = DefaultCircuitBreakerThreshold
+	}
+
+	if breaker.cooldown <= 0 {
+		breaker.cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	var v, _ = server.breakers.LoadOrStore(host, breaker)
+
+	return v.(*circuitBreaker)
+}
+
+// retrieve the binding's response, retrying failed attempts as configured and tracking the health
+// of the upstream host.
+func (binding *Binding) retrieveWithRetry(protocol Protocol, rr *ProtocolRequest) (*ProtocolResponse, error) {
+	var server = binding.server
+	var id = reqid(rr.Request)
+	var retry = binding.Retry.merge(server.BindingRetry)
+	var breaker = server.circuitBreaker(rr)
+	var attempts = retry.Attempts
+
+	binding.lastAttempts = 0
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	if !retry.Unsafe {
+		switch strings.ToUpper(rr.Verb) {
+		case http.MethodPost, http.MethodPatch:
+			attempts = 1
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if breaker != nil {
+			if ok, state := breaker.allow(); !ok {
+				binding.lastBreaker = state
+				log.Warningf("[%s]  binding %q: circuit breaker for %s is %s, not making request", id, binding.Name, breaker.Host, state)
+				server.metrics.inc(`diecast_circuit_breaker_rejections_total`, breaker.Host)
+				server.metrics.set(`diecast_circuit_breaker_state`, breakerStateValue(state), breaker.Host)
+
+				var message = fmt.Sprintf("circuit breaker for %s is %s", breaker.Host, state)
+
+				return &ProtocolResponse{
+					MimeType:   `text/plain; charset=utf-8`,
+					StatusCode: http.StatusServiceUnavailable,
+					Raw:        message,
+					data:       io.NopCloser(bytes.NewBufferString(message)),
+				}, nil
+			}
+		}
+
+		binding.lastAttempts = attempt
+
+		var response, err = binding.retrieve(protocol, rr)
+
+		if breaker != nil {
+			var state = breaker.record(isBreakerFailure(response, err))
+
+			binding.lastBreaker = state
+			server.metrics.set(`diecast_circuit_breaker_state`, breakerStateValue(state), breaker.Host)
+		}
+
+		if attempt >= attempts || !retry.shouldRetry(response, err) {
+			return response, err
+		}
+
+		var delay = retry.delay(attempt, response)
+
+		if err != nil {
+			log.Warningf("[%s]  binding %q: attempt %d/%d failed: %v; retrying in %v", id, binding.Name, attempt, attempts, err, delay)
+		} else {
+			log.Warningf("[%s]  binding %q: attempt %d/%d returned status %d; retrying in %v", id, binding.Name, attempt, attempts, response.StatusCode, delay)
+			response.Close()
+		}
+
+		server.metrics.inc(`diecast_binding_retries_total`, binding.Name, rr.URL.Scheme)
+
+		var done <-chan struct{}
+
+		if rr.Request != nil {
+			done = rr.Request.Context().Done()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return nil, rr.Request.Context().Err()
+		}
+	}
+}
+
+func breakerStateValue(state string) float64 {
+	switch state {
+	case breakerHalfOpen:
+		return 1
+	case breakerOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+func (server *Server) initCircuitBreakerEndpoint() error {
+	if config := server.CircuitBreaker; config == nil || !config.Enable || !server.EnableDebugging {
+		return nil
+	}
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:    `breakers`,
+		Methods: []string{http.MethodGet},
+		Handler: server.handleCircuitBreakers,
+	})
+}
+
+// handle GET /_diecast/breakers
+func (server *Server) handleCircuitBreakers(w http.ResponseWriter, req *http.Request) {
+	var breakers = make([]*circuitBreaker, 0)
+
+	server.breakers.Range(func(_ any, v any) bool {
+		breakers = append(breakers, v.(*circuitBreaker).snapshot())
+		return true
+	})
+
+	sort.Slice(breakers, func(i, j int) bool {
+		return breakers[i].Host < breakers[j].Host
+	})
+
+	httputil.RespondJSON(w, breakers)
+}
@@ -0,0 +1,264 @@
+package diecast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestRetryErrorClass(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.Equal(``, retryErrorClass(nil))
+	assert.Equal(``, retryErrorClass(context.Canceled))
+	assert.Equal(`timeout`, retryErrorClass(context.DeadlineExceeded))
+	assert.Equal(`timeout`, retryErrorClass(&url.Error{Op: `Get`, URL: `http://x`, Err: &net.DNSError{IsTimeout: true}}))
+	assert.Equal(`connection`, retryErrorClass(&url.Error{Op: `Get`, URL: `http://x`, Err: fmt.Errorf("dial tcp: connection refused")}))
+	assert.Equal(`tls`, retryErrorClass(&url.Error{Op: `Get`, URL: `https://x`, Err: fmt.Errorf("x509: certificate signed by unknown authority")}))
+	assert.Equal(`other`, retryErrorClass(fmt.Errorf("bad template")))
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	var assert = require.New(t)
+
+	assert.True(isBreakerFailure(nil, context.DeadlineExceeded))
+	assert.True(isBreakerFailure(nil, &url.Error{Op: `Get`, URL: `http://x`, Err: fmt.Errorf("dial tcp: connection refused")}))
+	assert.True(isBreakerFailure(nil, &url.Error{Op: `Get`, URL: `https://x`, Err: fmt.Errorf("x509: certificate has expired")}))
+	assert.True(isBreakerFailure(&ProtocolResponse{StatusCode: http.StatusTooManyRequests}, nil))
+	assert.True(isBreakerFailure(&ProtocolResponse{StatusCode: http.StatusBadGateway}, nil))
+	assert.False(isBreakerFailure(nil, fmt.Errorf("bad template")))
+	assert.False(isBreakerFailure(nil, context.Canceled))
+	assert.False(isBreakerFailure(&ProtocolResponse{StatusCode: http.StatusNotFound}, nil))
+	assert.False(isBreakerFailure(&ProtocolResponse{StatusCode: http.StatusOK}, nil))
+}
+
+func TestRetryConfigDelay(t *testing.T) {
+	var assert = require.New(t)
+	var config = (&RetryConfig{
+		Backoff:    `100ms`,
+		MaxBackoff: `1s`,
+	}).merge(nil)
+
+	assert.Equal(100*time.Millisecond, config.delay(1, nil))
+	assert.Equal(200*time.Millisecond, config.delay(2, nil))
+	assert.Equal(800*time.Millisecond, config.delay(4, nil))
+	assert.Equal(time.Second, config.delay(10, nil))
+
+	// Retry-After is honored, up to the maximum
+	var res = &http.Response{Header: http.Header{}}
+
+	res.Header.Set(`Retry-After`, `2`)
+	assert.Equal(time.Second, config.delay(1, &ProtocolResponse{Raw: res}))
+
+	config.MaxBackoff = `5s`
+	assert.Equal(2*time.Second, config.delay(1, &ProtocolResponse{Raw: res}))
+
+	// jitter stays within the given fraction of the delay
+	config.Jitter = 0.5
+
+	for i := 0; i < 100; i++ {
+		var delay = config.delay(1, nil)
+
+		assert.True(delay >= 50*time.Millisecond && delay <= 150*time.Millisecond, "delay %v out of range", delay)
+	}
+
+	// binding settings take precedence over the server's
+	var merged = (&RetryConfig{Attempts: 5}).merge(&RetryConfig{Attempts: 2, Backoff: `1s`})
+
+	assert.Equal(5, merged.Attempts)
+	assert.Equal(`1s`, merged.Backoff)
+}
+
+func TestBindingRetry(t *testing.T) {
+	var assert = require.New(t)
+	var hits int64
+	var failures int64
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&hits, 1)
+
+		if atomic.AddInt64(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		httputil.RespondJSON(w, map[string]any{`ok`: true})
+	}))
+
+	defer upstream.Close()
+
+	var dc = NewServer(`./tests/hello`)
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+
+	dc.BindingRetry = &RetryConfig{
+		Attempts: 3,
+		Backoff:  `1ms`,
+	}
+
+	var eval = func(binding *Binding, failFirst int64) (any, error) {
+		atomic.StoreInt64(&hits, 0)
+		atomic.StoreInt64(&failures, failFirst)
+		binding.Name = `flaky`
+		binding.server = dc
+
+		return binding.Evaluate(httptest.NewRequest(`GET`, `/`, nil), &TemplateHeader{}, make(map[string]any), funcs)
+	}
+
+	// the server default retries until the request succeeds
+	out, err := eval(&Binding{Resource: upstream.URL}, 2)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`ok`: true}, out)
+	assert.EqualValues(3, atomic.LoadInt64(&hits))
+
+	// ...but gives up after the configured number of attempts
+	_, err = eval(&Binding{Resource: upstream.URL}, 5)
+	assert.Error(err)
+	assert.EqualValues(3, atomic.LoadInt64(&hits))
+
+	// bindings can override the server default
+	_, err = eval(&Binding{Resource: upstream.URL, Retry: &RetryConfig{Attempts: 5}}, 4)
+	assert.NoError(err)
+	assert.EqualValues(5, atomic.LoadInt64(&hits))
+
+	// statuses that aren't listed aren't retried
+	_, err = eval(&Binding{Resource: upstream.URL, Retry: &RetryConfig{Statuses: []int{502}}}, 2)
+	assert.Error(err)
+	assert.EqualValues(1, atomic.LoadInt64(&hits))
+
+	// POST requests aren't retried unless explicitly permitted
+	_, err = eval(&Binding{Resource: upstream.URL, Method: `POST`}, 2)
+	assert.Error(err)
+	assert.EqualValues(1, atomic.LoadInt64(&hits))
+
+	_, err = eval(&Binding{Resource: upstream.URL, Method: `POST`, Retry: &RetryConfig{Unsafe: true}}, 2)
+	assert.NoError(err)
+	assert.EqualValues(3, atomic.LoadInt64(&hits))
+
+	// connection errors are retried too
+	var binding = &Binding{Resource: `http://127.0.0.1:1/`}
+
+	_, err = eval(binding, 0)
+	assert.Error(err)
+	assert.Equal(3, binding.lastAttempts)
+}
+
+func TestBindingCircuitBreaker(t *testing.T) {
+	var assert = require.New(t)
+	var hits int64
+	var healthy atomic.Bool
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&hits, 1)
+
+		if healthy.Load() {
+			httputil.RespondJSON(w, map[string]any{`ok`: true})
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	defer upstream.Close()
+
+	var dc = NewServer(`./tests/hello`)
+
+	dc.EnableDebugging = true
+	dc.Metrics = &MetricsConfig{
+		Enable: true,
+	}
+
+	dc.CircuitBreaker = &CircuitBreakerConfig{
+		Enable:    true,
+		Threshold: 2,
+		Cooldown:  `100ms`,
+	}
+
+	assert.NoError(dc.Initialize())
+
+	var host = `http://` + upstream.Listener.Addr().String()
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+	var eval = func() (map[string]any, error) {
+		var bindings = make(map[string]any)
+		var binding = &Binding{
+			Name:     `upstream`,
+			Resource: upstream.URL,
+			Fallback: map[string]any{`ok`: false},
+			server:   dc,
+		}
+
+		var err = dc.evalBinding(httptest.NewRequest(`GET`, `/`, nil), &TemplateHeader{}, binding, 0, make(map[string]any), funcs, bindings)
+
+		return bindings, err
+	}
+
+	var breakers = func() []map[string]any {
+		var out []map[string]any
+
+		doTestServerRequest(dc, `GET`, `/_diecast/breakers`, func(w *httptest.ResponseRecorder) {
+			assert.Equal(200, w.Code)
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), &out))
+		})
+
+		return out
+	}
+
+	// failures use the fallback, and open the breaker once the threshold is reached
+	for i := 0; i < 2; i++ {
+		bindings, err := eval()
+		assert.NoError(err)
+		assert.Equal(map[string]any{`ok`: false}, bindings[`upstream`])
+	}
+
+	assert.EqualValues(2, atomic.LoadInt64(&hits))
+	assert.Equal(host, breakers()[0][`host`])
+	assert.Equal(`open`, breakers()[0][`state`])
+
+	// while open, no requests are made at all
+	healthy.Store(true)
+
+	bindings, err := eval()
+	assert.NoError(err)
+	assert.Equal(map[string]any{`ok`: false}, bindings[`upstream`])
+	assert.EqualValues(2, atomic.LoadInt64(&hits))
+
+	doTestServerRequest(dc, `GET`, `/_diecast/metrics`, func(w *httptest.ResponseRecorder) {
+		assert.Contains(w.Body.String(), `# TYPE diecast_circuit_breaker_state gauge`)
+		assert.Contains(w.Body.String(), fmt.Sprintf("diecast_circuit_breaker_state{host=%q} 2", host))
+		assert.Contains(w.Body.String(), fmt.Sprintf("diecast_circuit_breaker_rejections_total{host=%q} 1", host))
+	})
+
+	// after the cooldown, a successful request closes the breaker again
+	time.Sleep(150 * time.Millisecond)
+
+	bindings, err = eval()
+	assert.NoError(err)
+	assert.Equal(map[string]any{`ok`: true}, bindings[`upstream`])
+	assert.EqualValues(3, atomic.LoadInt64(&hits))
+	assert.Equal(`closed`, breakers()[0][`state`])
+
+	doTestServerRequest(dc, `GET`, `/_diecast/metrics`, func(w *httptest.ResponseRecorder) {
+		assert.Contains(w.Body.String(), fmt.Sprintf("diecast_circuit_breaker_state{host=%q} 0", host))
+	})
+
+	// a failed trial request re-opens the breaker immediately
+	healthy.Store(false)
+
+	for i := 0; i < 2; i++ {
+		eval()
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	eval()
+
+	assert.EqualValues(6, atomic.LoadInt64(&hits))
+	assert.Equal(`open`, breakers()[0][`state`])
+}
@@ -0,0 +1,181 @@
+package diecast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v2"
+)
+
+// Returned by bindings whose response data does not conform to the binding's schema.
+type SchemaError struct {
+	Violations []string // each violation, formatted as "<JSON pointer to the value>: <reason>"
+}
+
+func (err *SchemaError) Error() string {
+	return fmt.Sprintf("schema validation failed: %s", strings.Join(err.Violations, `; `))
+}
+
+// validate the given (parsed and transformed) binding data against the binding's schema, if it
+// has one.  Violations are handled according to the given on_error action.
+func (binding *Binding) checkSchema(req *http.Request, value any, onError BindingErrorAction) (any, error) {
+	if binding.Schema == nil {
+		return value, nil
+	}
+
+	var id = reqid(req)
+
+	if schema, err := binding.server.compileSchema(binding.Schema); err == nil {
+		if violations, err := validateSchema(schema, value); err == nil {
+			if len(violations) == 0 {
+				return value, nil
+			}
+
+			binding.lastViolations = violations
+
+			var serr = &SchemaError{
+				Violations: violations,
+			}
+
+			switch onError {
+			case ActionIgnore:
+				log.Warningf("[%s] binding %q: %v", id, binding.Name, serr)
+				return value, nil
+			case ActionPrint:
+				return nil, serr
+			default:
+				var redirect = string(onError)
+
+				// if a url or path was specified, redirect the parent request to it
+				if strings.HasPrefix(redirect, `http`) || strings.HasPrefix(redirect, `/`) {
+					return nil, RedirectTo(redirect)
+				} else {
+					return nil, fmt.Errorf("[%s] %w", id, serr)
+				}
+			}
+		} else {
+			return nil, fmt.Errorf("[%s] schema: %v", id, err)
+		}
+	} else {
+		return nil, fmt.Errorf("[%s] schema: %v", id, err)
+	}
+}
+
+// compile a binding schema, which is either inline (a map or a string containing a JSON object)
+// or the path to a JSON or YAML file in the server's filesystem.  Compiled schemas are cached by
+// their contents, so edits to a schema file take effect on the next request.
+func (server *Server) compileSchema(spec any) (*jsonschema.Schema, error) {
+	var source []byte
+
+	if typeutil.IsMap(spec) {
+		if data, err := json.Marshal(maputil.DeepCopy(spec)); err == nil {
+			source = data
+		} else {
+			return nil, err
+		}
+	} else if filename := strings.TrimSpace(typeutil.String(spec)); strings.HasPrefix(filename, `{`) {
+		source = []byte(filename)
+	} else if filename != `` {
+		if data, err := readFromFS(server, filename); err == nil {
+			switch strings.ToLower(filepath.Ext(filename)) {
+			case `.yml`, `.yaml`:
+				var doc any
+
+				if err := yaml.Unmarshal(data, &doc); err != nil {
+					return nil, fmt.Errorf("%s: %v", filename, err)
+				}
+
+				if data, err = json.Marshal(maputil.DeepCopy(doc)); err != nil {
+					return nil, fmt.Errorf("%s: %v", filename, err)
+				}
+			}
+
+			source = data
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("must be an object or a filename")
+	}
+
+	var sum = sha256.Sum256(source)
+	var key = hex.EncodeToString(sum[:])
+
+	if cached, ok := server.schemas.Load(key); ok {
+		return cached.(*jsonschema.Schema), nil
+	}
+
+	if doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(source)); err == nil {
+		var compiler = jsonschema.NewCompiler()
+		var url = `diecast:///schemas/` + key + `.json`
+
+		if err := compiler.AddResource(url, doc); err != nil {
+			return nil, err
+		}
+
+		if schema, err := compiler.Compile(url); err == nil {
+			server.schemas.Store(key, schema)
+			return schema, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// validate a value against the given schema, returning a description of each violation.  The
+// value is normalized into its JSON representation first, so that it is validated exactly as
+// it would be serialized.
+func validateSchema(schema *jsonschema.Schema, value any) ([]string, error) {
+	var instance any
+
+	if data, err := json.Marshal(value); err == nil {
+		if instance, err = jsonschema.UnmarshalJSON(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("cannot validate %T: %v", value, err)
+	}
+
+	var verr *jsonschema.ValidationError
+
+	if err := schema.Validate(instance); err == nil {
+		return nil, nil
+	} else if errors.As(err, &verr) {
+		var violations []string
+
+		for _, unit := range verr.BasicOutput().Errors {
+			if unit.Error == nil {
+				continue
+			}
+
+			var location = unit.InstanceLocation
+
+			if location == `` {
+				location = `/`
+			}
+
+			violations = append(violations, fmt.Sprintf("%s: %s", location, unit.Error.String()))
+		}
+
+		if len(violations) == 0 {
+			violations = append(violations, fmt.Sprintf("/: %v", err))
+		}
+
+		return violations, nil
+	} else {
+		return nil, err
+	}
+}
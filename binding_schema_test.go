@@ -0,0 +1,112 @@
+package diecast
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestBindingSchema(t *testing.T) {
+	var assert = require.New(t)
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`count`: req.URL.Query().Get(`count`),
+			`items`: []any{
+				map[string]any{`name`: `first`},
+				map[string]any{`name`: 2},
+			},
+		})
+	}))
+
+	defer upstream.Close()
+
+	var root = t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `item.yml`), []byte("type: object\nrequired: [name]\nproperties:\n  name:\n    type: string\n"), 0644))
+
+	var dc = NewServer(root)
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+
+	dc.SetFileSystem(http.Dir(root))
+
+	var eval = func(binding *Binding) (any, error) {
+		binding.Name = `checked`
+		binding.server = dc
+
+		return binding.Evaluate(httptest.NewRequest(`GET`, `/`, nil), &TemplateHeader{}, make(map[string]any), funcs)
+	}
+
+	// as parsed from YAML configuration
+	var countIsNumber = map[any]any{
+		`type`:     `object`,
+		`required`: []any{`count`},
+		`properties`: map[any]any{
+			`count`: map[any]any{
+				`type`: `string`,
+			},
+		},
+	}
+
+	out, err := eval(&Binding{Resource: upstream.URL + `/?count=3`, Schema: countIsNumber})
+	assert.NoError(err)
+	assert.Equal(`3`, out.(map[string]any)[`count`])
+
+	// violations are reported with the location of each offending value
+	var binding = &Binding{
+		Resource: upstream.URL,
+		Schema:   `{"properties": {"items": {"items": {"$ref": "#/$defs/item"}}}, "$defs": {"item": {"properties": {"name": {"type": "string"}}}}}`,
+	}
+
+	_, err = eval(binding)
+	assert.Error(err)
+	assert.Contains(err.Error(), `schema validation failed`)
+	assert.Contains(err.Error(), `/items/1/name`)
+
+	var serr *SchemaError
+
+	assert.True(errors.As(err, &serr))
+	assert.Len(serr.Violations, 1)
+	assert.Equal(serr.Violations, binding.lastViolations)
+
+	// schemas are applied after the transform, and may be read from files
+	out, err = eval(&Binding{Resource: upstream.URL, Transform: `$.items[0]`, Schema: `/item.yml`})
+	assert.NoError(err)
+	assert.Equal(map[string]any{`name`: `first`}, out)
+
+	_, err = eval(&Binding{Resource: upstream.URL, Transform: `$.items[1]`, Schema: `/item.yml`})
+	assert.Error(err)
+	assert.Contains(err.Error(), `/name`)
+
+	_, err = eval(&Binding{Resource: upstream.URL, Schema: `/missing.yml`})
+	assert.Error(err)
+	assert.Contains(err.Error(), `schema:`)
+
+	// on_error applies to violations
+	out, err = eval(&Binding{Resource: upstream.URL, Transform: `$.items[1]`, Schema: `/item.yml`, OnError: ActionIgnore})
+	assert.NoError(err)
+	assert.Equal(map[string]any{`name`: float64(2)}, out)
+
+	_, err = eval(&Binding{Resource: upstream.URL, Transform: `$.items[1]`, Schema: `/item.yml`, OnError: `/invalid`})
+	assert.Equal(RedirectTo(`/invalid`), err)
+
+	// ...as do fallbacks
+	var bindings = make(map[string]any)
+
+	assert.NoError(dc.evalBinding(httptest.NewRequest(`GET`, `/`, nil), &TemplateHeader{}, &Binding{
+		Name:      `checked`,
+		Resource:  upstream.URL,
+		Transform: `$.items[1]`,
+		Schema:    `/item.yml`,
+		Fallback:  map[string]any{`name`: `unknown`},
+		server:    dc,
+	}, 0, make(map[string]any), funcs, bindings))
+
+	assert.Equal(map[string]any{`name`: `unknown`}, bindings[`checked`])
+}
@@ -1,17 +1,36 @@
 package diecast
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis"
+	redisserver "github.com/alicebob/miniredis/server"
+	"github.com/ghetzel/go-stockutil/executil"
 	"github.com/ghetzel/go-stockutil/httputil"
 	"github.com/ghetzel/go-stockutil/log"
 	"github.com/ghetzel/go-stockutil/typeutil"
 	"github.com/ghetzel/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 func req(method string, path string) *http.Request {
@@ -149,3 +168,1223 @@ func TestBindingRedis(t *testing.T) {
 		`key1`: `foof`,
 	}, out)
 }
+
+func TestBindingRedisHelpers(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hi`), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(root, `greet.lua`), []byte(`return redis.call('GET', KEYS[1]) .. ARGV[1]`), 0644))
+
+	var dc = NewServer(root)
+	assert.NoError(dc.Initialize())
+
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `redis`
+		binding.server = dc
+
+		return binding.Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			make(map[string]any),
+			funcs,
+		)
+	}
+
+	redis, err := miniredis.Run()
+	assert.NoError(err)
+	defer redis.Close()
+
+	redis.RequireAuth(`secret`)
+
+	for i := 1; i <= 5; i++ {
+		redis.Set(fmt.Sprintf("key.%d", i), `v`)
+	}
+
+	redis.Set(`other`, `world`)
+
+	dc.Protocols = map[string]ProtocolConfig{
+		`redis`: {
+			`password`: `secret`,
+			`scripts`: map[string]any{
+				`greet`: `/greet.lua`,
+			},
+		},
+	}
+
+	// SCAN aggregates keys across cursors
+	out, err := evaluate(&Binding{
+		Resource: `redis://` + redis.Addr(),
+		Method:   `SCAN`,
+		Params: map[string]any{
+			`match`: `key.*`,
+			`count`: 2,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{`key.1`, `key.2`, `key.3`, `key.4`, `key.5`}, out)
+
+	out, err = evaluate(&Binding{
+		Resource: `redis://` + redis.Addr(),
+		Method:   `SCAN`,
+		Params: map[string]any{
+			`match`: `key.*`,
+			`max`:   2,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Len(out, 2)
+
+	// only registered scripts can be run
+	out, err = evaluate(&Binding{
+		Resource: `redis://` + redis.Addr() + `/greet/other`,
+		Method:   `SCRIPT`,
+		Params: map[string]any{
+			`args`: `!`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(`world!`, out)
+
+	_, err = evaluate(&Binding{
+		Resource: `redis://` + redis.Addr() + `/nope`,
+		Method:   `SCRIPT`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `not permitted`)
+
+	_, err = evaluate(&Binding{
+		Resource: `redis://` + redis.Addr() + `/return 1/0`,
+		Method:   `EVAL`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `not permitted`)
+
+	// a stand-in for a Redis server that supports streams, and a Sentinel that points to itself
+	var fake, ferr = redisserver.NewServer(`127.0.0.1:0`)
+	var streamArgs []string
+
+	assert.NoError(ferr)
+	defer fake.Close()
+
+	var writeEntries = func(c *redisserver.Peer, cmd string, args []string) {
+		streamArgs = append([]string{cmd}, args...)
+		c.WriteLen(2)
+
+		for _, id := range []string{`1-0`, `2-0`} {
+			c.WriteLen(2)
+			c.WriteBulk(id)
+			c.WriteLen(2)
+			c.WriteBulk(`n`)
+			c.WriteBulk(id)
+		}
+	}
+
+	fake.Register(`XRANGE`, writeEntries)
+	fake.Register(`XREVRANGE`, writeEntries)
+	fake.Register(`GET`, func(c *redisserver.Peer, cmd string, args []string) {
+		c.WriteBulk(`value of ` + args[0])
+	})
+	fake.Register(`SENTINEL`, func(c *redisserver.Peer, cmd string, args []string) {
+		if len(args) == 2 && args[1] == `primary` {
+			c.WriteLen(2)
+			c.WriteBulk(fake.Addr().IP.String())
+			c.WriteBulk(typeutil.String(fake.Addr().Port))
+		} else {
+			c.WriteNull()
+		}
+	})
+
+	dc.Protocols = nil
+
+	out, err = evaluate(&Binding{
+		Resource: `redis+sentinel://` + fake.Addr().String() + `/events?master=primary`,
+		Method:   `XRANGE`,
+		Params: map[string]any{
+			`count`: 10,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]string{`XRANGE`, `events`, `-`, `+`, `COUNT`, `10`}, streamArgs)
+	assert.Equal([]any{
+		map[string]any{`id`: `1-0`, `fields`: map[string]any{`n`: `1-0`}},
+		map[string]any{`id`: `2-0`, `fields`: map[string]any{`n`: `2-0`}},
+	}, out)
+
+	_, err = evaluate(&Binding{
+		Resource: `redis+sentinel://` + fake.Addr().String() + `/events/5-0?master=primary`,
+		Method:   `XREVRANGE`,
+	})
+
+	assert.NoError(err)
+	assert.Equal([]string{`XREVRANGE`, `events`, `5-0`, `-`}, streamArgs)
+
+	_, err = evaluate(&Binding{
+		Resource: `redis+sentinel://` + fake.Addr().String() + `/events?master=unknown`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `cannot locate master`)
+
+	// unix sockets
+	var socket = filepath.Join(t.TempDir(), `redis.sock`)
+	var listener, lerr = net.Listen(`unix`, socket)
+
+	assert.NoError(lerr)
+	defer listener.Close()
+
+	go func() {
+		for {
+			if conn, err := listener.Accept(); err == nil {
+				fake.ServeConn(conn)
+			} else {
+				return
+			}
+		}
+	}()
+
+	out, err = evaluate(&Binding{
+		Resource: `redis+unix:///thing?socket=` + socket,
+	})
+
+	assert.NoError(err)
+	assert.Equal(`value of thing`, out)
+}
+
+func TestBindingSQL(t *testing.T) {
+	var assert = require.New(t)
+	var dbfile = filepath.Join(t.TempDir(), `test.db`)
+
+	db, err := sql.Open(`sqlite`, dbfile)
+	assert.NoError(err)
+
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL, admin BOOLEAN)`)
+	assert.NoError(err)
+	_, err = db.Exec(`INSERT INTO users VALUES (1, 'alice', 9.5, 1), (2, 'bob', 7, 0), (3, 'o''brien', 3.25, 0)`)
+	assert.NoError(err)
+	assert.NoError(db.Close())
+
+	var dc = NewServer(`./tests/hello`)
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.server = dc
+
+		return binding.Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			map[string]any{
+				`qs`: map[string]any{
+					`name`: `o'brien`,
+				},
+			},
+			funcs,
+		)
+	}
+
+	// rows are returned as typed objects, with params bound to placeholders
+	out, err := evaluate(&Binding{
+		Name:     `users`,
+		Resource: `sqlite://` + dbfile,
+		RawBody:  `SELECT id, name, score FROM users WHERE id IN (:ids) AND name != ':ids' ORDER BY id`,
+		Params: map[string]any{
+			`ids`: []any{1, 2},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`id`: float64(1), `name`: `alice`, `score`: 9.5},
+		map[string]any{`id`: float64(2), `name`: `bob`, `score`: float64(7)},
+	}, out)
+
+	// templated params are still bound, never interpolated into the query
+	out, err = evaluate(&Binding{
+		Name:     `user`,
+		Resource: `sqlite://` + dbfile,
+		Method:   `SELECT id FROM users WHERE name = :name`,
+		Params: map[string]any{
+			`name`: `{{ $.qs.name }}`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`id`: float64(3)},
+	}, out)
+
+	// undefined params are an error
+	_, err = evaluate(&Binding{
+		Name:     `user`,
+		Resource: `sqlite://` + dbfile,
+		RawBody:  `SELECT id FROM users WHERE name = :missing`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `"missing"`)
+
+	// read-only databases refuse writes
+	_, err = evaluate(&Binding{
+		Name:     `delete`,
+		Resource: `sqlite://` + dbfile + `?readonly=true`,
+		RawBody:  `DELETE FROM users`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `readonly`)
+
+	out, err = evaluate(&Binding{
+		Name:     `count`,
+		Resource: `sqlite://` + dbfile,
+		RawBody:  `SELECT COUNT(*) AS n FROM users`,
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`n`: float64(3)},
+	}, out)
+
+	// comments may contain quotes and colons
+	out, err = evaluate(&Binding{
+		Name:     `commented`,
+		Resource: `sqlite://` + dbfile,
+		RawBody:  "-- don't count :admins\nSELECT COUNT(*) AS n /* it's :n */ FROM users WHERE admin = :admin",
+		Params: map[string]any{
+			`admin`: 0,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`n`: float64(2)},
+	}, out)
+
+	// relative paths are relative to the server's root directory
+	var site = NewServer(filepath.Dir(dbfile))
+
+	out, err = (&Binding{
+		Name:     `relative`,
+		Resource: `sqlite://` + filepath.Base(dbfile),
+		RawBody:  `SELECT COUNT(*) AS n FROM users`,
+		server:   site,
+	}).Evaluate(httptest.NewRequest(`GET`, `/yay`, nil), &TemplateHeader{}, make(map[string]any), funcs)
+
+	assert.NoError(err)
+	assert.Equal([]any{
+		map[string]any{`n`: float64(3)},
+	}, out)
+}
+
+func TestBindingFile(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var external = t.TempDir()
+
+	for name, contents := range map[string]string{
+		`data/site.json`:        `{"title": "Site", "tags": ["a"], "meta": {"x": 1}}`,
+		`data/site.yaml`:        "tags: [b]\nmeta:\n  w: 2\n",
+		`data/more/extra.toml`:  "title = \"Extra\"\n[meta]\nz = 3\n",
+		`data/people.csv`:       "name,age\nalice,31\n",
+		`data/people2.csv`:      "name,age\nbob,42\n",
+		`data/events.jsonl`:     "{\"id\": 1}\n\n{\"id\": 2}\n",
+		`data/notes.txt`:        `hello`,
+		`index.html`:            `hi`,
+		`../` + `outside.json`:  `{}`,
+		`data/deep/x/list.json`: `[1, 2]`,
+	} {
+		var filename = filepath.Join(root, name)
+
+		assert.NoError(os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(os.WriteFile(filename, []byte(contents), 0644))
+	}
+
+	assert.NoError(os.WriteFile(filepath.Join(external, `ext.json`), []byte(`{"external": true}`), 0644))
+
+	var dc = NewServer(root)
+	assert.NoError(dc.Initialize())
+
+	var funcs = dc.GetTemplateFunctions(make(map[string]any), nil)
+	var evaluate = func(resource string) (any, error) {
+		return (&Binding{
+			Name:     `file`,
+			Resource: resource,
+			server:   dc,
+		}).Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			make(map[string]any),
+			funcs,
+		)
+	}
+
+	// single files are parsed according to their extension
+	out, err := evaluate(`file://data/site.yaml`)
+	assert.NoError(err)
+	assert.Equal(map[string]any{`tags`: []any{`b`}, `meta`: map[string]any{`w`: float64(2)}}, out)
+
+	out, err = evaluate(`file://data/events.jsonl`)
+	assert.NoError(err)
+	assert.Equal([]any{map[string]any{`id`: float64(1)}, map[string]any{`id`: float64(2)}}, out)
+
+	out, err = evaluate(`file://data/notes.txt`)
+	assert.NoError(err)
+	assert.Equal(`hello`, out)
+
+	// globs return a list, sorted by path
+	out, err = evaluate(`file://data/**.json`)
+	assert.NoError(err)
+	assert.Len(out, 2)
+	assert.Equal([]any{float64(1), float64(2)}, out.([]any)[0])
+
+	// ...or a single merged value
+	out, err = evaluate(`file://data/{site.json,site.yaml,more/extra.toml}?merge=true`)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`title`: `Site`,
+		`tags`:  []any{`a`, `b`},
+		`meta`:  map[string]any{`x`: float64(1), `w`: float64(2), `z`: float64(3)},
+	}, out)
+
+	out, err = evaluate(`file://data/people*.csv?merge=true`)
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`headers`: []any{`name`, `age`},
+		`records`: []any{[]any{`alice`, float64(31)}, []any{`bob`, float64(42)}},
+	}, out)
+
+	// no escaping the root
+	_, err = evaluate(`file://data/../../outside.json`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `not permitted`)
+
+	// absolute paths must be explicitly allowed
+	_, err = evaluate(`file://` + filepath.Join(external, `ext.json`))
+	assert.Error(err)
+
+	dc.Protocols = map[string]ProtocolConfig{
+		`file`: {
+			`roots`: []any{external},
+		},
+	}
+
+	out, err = evaluate(`file://` + filepath.Join(external, `ext.json`))
+	assert.NoError(err)
+	assert.Equal(map[string]any{`external`: true}, out)
+
+	_, err = evaluate(`file://` + filepath.Join(external, `..`, `ext.json`))
+	assert.Error(err)
+
+	// symlinks are followed, but only to files within the root
+	var secret = filepath.Join(t.TempDir(), `secret.json`)
+
+	assert.NoError(os.WriteFile(secret, []byte(`{"secret": true}`), 0644))
+	assert.NoError(os.Symlink(secret, filepath.Join(external, `escape.json`)))
+	assert.NoError(os.Symlink(`ext.json`, filepath.Join(external, `alias.json`)))
+
+	out, err = evaluate(`file://` + filepath.Join(external, `alias.json`))
+	assert.NoError(err)
+	assert.Equal(map[string]any{`external`: true}, out)
+
+	_, err = evaluate(`file://` + filepath.Join(external, `escape.json`))
+	assert.Error(err)
+	assert.Contains(err.Error(), `outside of the permitted root`)
+
+	// the same applies to symlinks under the server's root
+	assert.NoError(os.Symlink(secret, filepath.Join(root, `data`, `escape.json`)))
+	assert.NoError(os.Symlink(`site.json`, filepath.Join(root, `data`, `alias.json`)))
+
+	out, err = evaluate(`file://data/alias.json`)
+	assert.NoError(err)
+	assert.Equal(`Site`, out.(map[string]any)[`title`])
+
+	_, err = evaluate(`file://data/escape.json`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `outside of the permitted root`)
+}
+
+func TestBindingExec(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hi`), 0644))
+
+	var dc = NewServer(root)
+	assert.NoError(dc.Initialize())
+
+	dc.Protocols = map[string]ProtocolConfig{
+		`exec`: {
+			`allow`: []any{`echo`, `cat`, `sh`, `sleep`},
+			`statuses`: map[string]any{
+				`3`: 404,
+			},
+		},
+	}
+
+	var funcs = dc.GetTemplateFunctions(map[string]any{`name`: `world`}, nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `exec`
+		binding.server = dc
+
+		return binding.Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			map[string]any{`name`: `world`},
+			funcs,
+		)
+	}
+
+	if executil.IsRoot() {
+		t.Setenv(`DIECAST_ALLOW_ROOT_ACTIONS`, ``)
+
+		_, err := evaluate(&Binding{Resource: `exec://echo`})
+		assert.Error(err)
+		assert.Contains(err.Error(), `as root`)
+
+		t.Setenv(`DIECAST_ALLOW_ROOT_ACTIONS`, `true`)
+	}
+
+	// arguments from the URL and params
+	out, err := evaluate(&Binding{
+		Resource: `exec://echo?arg=hello`,
+		Params: map[string]any{
+			`args`: []any{`{{ $.name }}`},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal("hello world\n", out)
+
+	// stdin from rawbody, parsed by the binding parser
+	out, err = evaluate(&Binding{
+		Resource: `cmd://cat`,
+		RawBody:  `{"name": "{{ $.name }}"}`,
+		Parser:   `json`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`name`: `world`}, out)
+
+	// templated arguments can't be split into words, since their values could add arguments
+	_, err = evaluate(&Binding{
+		Resource: `exec://echo`,
+		Params: map[string]any{
+			`args`: `{{ $.name }} --flag`,
+		},
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `must be given as a list`)
+
+	out, err = evaluate(&Binding{
+		Resource: `exec://echo`,
+		Params: map[string]any{
+			`args`: []any{`{{ "hello -n" }}`},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal("hello -n\n", out)
+
+	// arguments and environment variables are passed as-is when templating is disabled
+	out, err = evaluate(&Binding{
+		Resource:   `exec://sh`,
+		NoTemplate: true,
+		Params: map[string]any{
+			`args`:     []any{`-c`, `echo "{{ $.name }} $GREETING"`},
+			`GREETING`: `{{ $.name }}`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal("{{ $.name }} {{ $.name }}\n", out)
+
+	// other params are passed as environment variables
+	out, err = evaluate(&Binding{
+		Resource: `exec://sh`,
+		Params: map[string]any{
+			`args`:     `-c 'echo "$GREETING"'`,
+			`GREETING`: `hi {{ $.name }}`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal("hi world\n", out)
+
+	// exit statuses are mapped to status codes
+	var binding = &Binding{
+		Resource: `exec://sh`,
+		Params: map[string]any{
+			`args`: []any{`-c`, `exit 3`},
+		},
+		IfStatus: map[string]BindingErrorAction{
+			`404`: `/not-found`,
+		},
+	}
+
+	_, err = evaluate(binding)
+	assert.Equal(RedirectTo(`/not-found`), err)
+	assert.Equal(404, binding.lastStatus)
+
+	binding = &Binding{
+		Resource: `exec://sh`,
+		Params: map[string]any{
+			`args`: []any{`-c`, `exit 1`},
+		},
+	}
+
+	_, err = evaluate(binding)
+	assert.Error(err)
+	assert.Equal(500, binding.lastStatus)
+
+	// timeouts kill the program
+	binding = &Binding{
+		Resource: `exec://sleep?arg=5`,
+		Timeout:  `100ms`,
+	}
+
+	_, err = evaluate(binding)
+	assert.Error(err)
+	assert.Equal(504, binding.lastStatus)
+
+	// programs must be allowed
+	_, err = evaluate(&Binding{Resource: `exec://ls`})
+	assert.Error(err)
+	assert.Contains(err.Error(), `not permitted`)
+
+	_, err = evaluate(&Binding{Resource: `exec://bin/echo`})
+	assert.Error(err)
+
+	// and are never run when commands are disabled
+	dc.DisableCommands = true
+
+	_, err = evaluate(&Binding{Resource: `exec://echo`})
+	assert.Error(err)
+	assert.Contains(err.Error(), `DisableCommands`)
+}
+
+func TestBindingGraphQL(t *testing.T) {
+	var assert = require.New(t)
+	var requests []map[string]any
+
+	var api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var payload map[string]any
+
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil || req.Method != `POST` || req.Header.Get(`Content-Type`) != `application/json` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		requests = append(requests, payload)
+
+		var variables, _ = payload[`variables`].(map[string]any)
+
+		switch {
+		case strings.Contains(typeutil.String(payload[`query`]), `broken`):
+			httputil.RespondJSON(w, map[string]any{
+				`data`: map[string]any{`user`: nil},
+				`errors`: []any{
+					map[string]any{`message`: `no such user`, `path`: []any{`user`, 0}},
+				},
+			})
+		case variables[`after`] == nil:
+			httputil.RespondJSON(w, map[string]any{
+				`data`: map[string]any{`items`: map[string]any{
+					`nodes`:    []any{`a`, `b`},
+					`pageInfo`: map[string]any{`endCursor`: `c2`, `hasNextPage`: true},
+				}},
+			})
+		default:
+			httputil.RespondJSON(w, map[string]any{
+				`data`: map[string]any{`items`: map[string]any{
+					`nodes`:    []any{`c`},
+					`pageInfo`: map[string]any{`endCursor`: `c3`, `hasNextPage`: false},
+				}},
+			})
+		}
+	}))
+
+	defer api.Close()
+
+	var root = t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hi`), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(root, `queries`), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(root, `queries`, `items.graphql`), []byte(`query Items($after: String) { items(after: $after) { nodes } }`), 0644))
+
+	var dc = NewServer(root)
+	assert.NoError(dc.Initialize())
+
+	var resource = `graphql+` + api.URL + `/graphql`
+	var data = map[string]any{`limit`: `5`, `zip`: `02134`}
+	var funcs = dc.GetTemplateFunctions(data, nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `gql`
+		binding.server = dc
+
+		return binding.Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			data,
+			funcs,
+		)
+	}
+
+	// params become variables, keeping their types; templates are only converted to the scalar
+	// type the query declares for them
+	out, err := evaluate(&Binding{
+		Resource: resource,
+		RawBody:  `query Items($first: Int, $tags: [String], $after: String, $zip: ID!, $q: String, $open: Boolean) { items { nodes } }`,
+		Params: map[string]any{
+			`first`:  `{{ $.limit }}`,
+			`tags`:   []any{`x`, `{{ $.limit }}`},
+			`exact`:  true,
+			`name`:   `{{ "literal" }}`,
+			`after`:  ``,
+			`filter`: map[string]any{`n`: 1},
+			`zip`:    `{{ $.zip }}`,
+			`q`:      `{{ "true" }}`,
+			`open`:   `{{ "true" }}`,
+		},
+		ProtocolOptions: map[string]any{
+			`operation`: `Items`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`items`: map[string]any{
+		`nodes`:    []any{`a`, `b`},
+		`pageInfo`: map[string]any{`endCursor`: `c2`, `hasNextPage`: true},
+	}}, out)
+
+	assert.Len(requests, 1)
+	assert.Equal(`Items`, requests[0][`operationName`])
+	assert.Equal(map[string]any{
+		`first`:  float64(5),
+		`tags`:   []any{`x`, `5`},
+		`exact`:  true,
+		`name`:   `literal`,
+		`after`:  nil,
+		`filter`: map[string]any{`n`: float64(1)},
+		`zip`:    `02134`,
+		`q`:      `true`,
+		`open`:   true,
+	}, requests[0][`variables`])
+
+	// queries can be read from files
+	_, err = evaluate(&Binding{
+		Resource: resource,
+		ProtocolOptions: map[string]any{
+			`query_file`: `/queries/items.graphql`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Contains(requests[1][`query`], `query Items`)
+
+	// errors fail the binding, and can be handled like any other binding error
+	var binding = &Binding{
+		Resource: resource,
+		RawBody:  `query { broken }`,
+		OnError:  ActionPrint,
+	}
+
+	_, err = evaluate(binding)
+	assert.Error(err)
+	assert.Contains(err.Error(), `no such user (at user.0)`)
+	assert.Equal(502, binding.lastStatus)
+
+	_, err = evaluate(&Binding{
+		Resource: resource,
+		RawBody:  `query { broken }`,
+		OnError:  `/error`,
+	})
+
+	assert.Equal(RedirectTo(`/error`), err)
+
+	// ...unless partial results are acceptable
+	out, err = evaluate(&Binding{
+		Resource: resource,
+		RawBody:  `query { broken }`,
+		ProtocolOptions: map[string]any{
+			`partial`: true,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`user`: nil}, out)
+
+	// cursor-based pagination
+	var bindings = make(map[string]any)
+
+	assert.NoError(dc.evalBinding(httptest.NewRequest(`GET`, `/yay`, nil), nil, &Binding{
+		Name:     `pages`,
+		Resource: resource,
+		RawBody:  `query Items($after: String) { items(after: $after) { nodes pageInfo { endCursor hasNextPage } } }`,
+		server:   dc,
+		Paginate: &PaginatorConfig{
+			Count:  `{{ count $.items.nodes }}`,
+			Done:   `{{ not $.items.pageInfo.hasNextPage }}`,
+			Cursor: `{{ $.items.pageInfo.endCursor }}`,
+			Data:   `$.items.nodes`,
+			QueryStrings: map[string]string{
+				`after`: `{{ $.page.cursor }}`,
+			},
+		},
+	}, 0, map[string]any{}, funcs, bindings))
+
+	var pages = bindings[`pages`].([]map[string]any)
+
+	assert.Len(pages, 2)
+	assert.Equal([]any{`a`, `b`}, pages[0][`data`])
+	assert.Equal(`c2`, pages[0][`cursor`])
+	assert.Equal([]any{`c`}, pages[1][`data`])
+	assert.Equal(true, pages[1][`last`])
+	assert.Equal(`c2`, requests[len(requests)-1][`variables`].(map[string]any)[`after`])
+}
+
+func TestBindingGrpc(t *testing.T) {
+	var assert = require.New(t)
+	var seen metadata.MD
+	var servers []*grpc.Server
+
+	var serve = func(withReflection bool) string {
+		var listener, err = net.Listen(`tcp`, `127.0.0.1:0`)
+		assert.NoError(err)
+
+		var srv = grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			seen, _ = metadata.FromIncomingContext(ctx)
+			return handler(ctx, req)
+		}))
+
+		var healthcheck = health.NewServer()
+		healthcheck.SetServingStatus(`web`, healthpb.HealthCheckResponse_NOT_SERVING)
+		healthpb.RegisterHealthServer(srv, healthcheck)
+
+		if withReflection {
+			reflection.Register(srv)
+		}
+
+		go srv.Serve(listener)
+		servers = append(servers, srv)
+
+		return listener.Addr().String()
+	}
+
+	defer func() {
+		for _, srv := range servers {
+			srv.Stop()
+		}
+	}()
+
+	var reflected = serve(true)
+	var unreflected = serve(false)
+	var root = t.TempDir()
+
+	protoset, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+		},
+	})
+
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hi`), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(root, `health.protoset`), protoset, 0644))
+
+	var dc = NewServer(root)
+	assert.NoError(dc.Initialize())
+
+	var data = map[string]any{`svc`: `web`}
+	var funcs = dc.GetTemplateFunctions(data, nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `grpc`
+		binding.server = dc
+
+		return binding.Evaluate(
+			httptest.NewRequest(`GET`, `/yay`, nil),
+			&TemplateHeader{},
+			data,
+			funcs,
+		)
+	}
+
+	// method schemas are retrieved using server reflection
+	out, err := evaluate(&Binding{
+		Resource: `grpc://` + reflected + `/grpc.health.v1.Health/Check`,
+		Headers: map[string]string{
+			`X-Tenant`: `{{ $.svc }}`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`status`: `SERVING`}, out)
+	assert.Equal([]string{`web`}, seen.Get(`x-tenant`))
+
+	// params are converted into the request message
+	out, err = evaluate(&Binding{
+		Resource: `grpc://` + reflected + `/grpc.health.v1.Health/Check`,
+		Params: map[string]any{
+			`service`: `{{ $.svc }}`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`status`: `NOT_SERVING`}, out)
+
+	// status codes are mapped to HTTP statuses
+	var binding = &Binding{
+		Resource: `grpc://` + reflected + `/grpc.health.v1.Health/Check`,
+		Params: map[string]any{
+			`service`: `nope`,
+		},
+		IfStatus: map[string]BindingErrorAction{
+			`404`: `/not-found`,
+		},
+	}
+
+	_, err = evaluate(binding)
+	assert.Equal(RedirectTo(`/not-found`), err)
+	assert.Equal(404, binding.lastStatus)
+
+	// descriptor sets can be used instead of reflection
+	_, err = evaluate(&Binding{
+		Resource: `grpc://` + unreflected + `/grpc.health.v1.Health/Check`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `protoset`)
+
+	out, err = evaluate(&Binding{
+		Resource: `grpc://` + unreflected + `/grpc.health.v1.Health/Check`,
+		ProtocolOptions: map[string]any{
+			`protoset`: `/health.protoset`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`status`: `SERVING`}, out)
+
+	// unknown fields and methods are errors
+	_, err = evaluate(&Binding{
+		Resource: `grpc://` + reflected + `/grpc.health.v1.Health/Check`,
+		Params: map[string]any{
+			`bogus`: true,
+		},
+	})
+
+	assert.Error(err)
+
+	_, err = evaluate(&Binding{
+		Resource: `grpc://` + reflected + `/grpc.health.v1.Health/Nope`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `no method`)
+}
+
+func TestBindingS3(t *testing.T) {
+	var assert = require.New(t)
+	var requests []string
+
+	t.Setenv(`AWS_ACCESS_KEY_ID`, `testing`)
+	t.Setenv(`AWS_SECRET_ACCESS_KEY`, `testing`)
+
+	// a minimal S3-compatible stand-in, addressed using path-style URLs
+	var api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+` `+req.URL.Path)
+
+		switch {
+		case req.URL.Path == `/bucket` && req.URL.Query().Get(`list-type`) == `2`:
+			var contents = `<Contents><Key>data/a.json</Key><LastModified>2024-01-02T03:04:05.000Z</LastModified><ETag>"abc"</ETag><Size>17</Size><StorageClass>STANDARD</StorageClass></Contents>`
+			var next = `<IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>`
+
+			if req.URL.Query().Get(`continuation-token`) == `page2` {
+				contents = `<Contents><Key>data/b.csv</Key><LastModified>2024-01-02T03:04:05.000Z</LastModified><ETag>"def"</ETag><Size>4</Size><StorageClass>STANDARD</StorageClass></Contents>`
+				next = `<IsTruncated>false</IsTruncated>`
+			} else {
+				contents += `<CommonPrefixes><Prefix>data/sub/</Prefix></CommonPrefixes>`
+			}
+
+			w.Header().Set(`Content-Type`, `application/xml`)
+			fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><Prefix>%s</Prefix><Delimiter>%s</Delimiter>%s%s</ListBucketResult>`,
+				req.URL.Query().Get(`prefix`),
+				req.URL.Query().Get(`delimiter`),
+				next,
+				contents,
+			)
+		case req.URL.Path == `/bucket/data/a.json`:
+			w.Header().Set(`Content-Type`, `binary/octet-stream`)
+			w.Header().Set(`Content-Length`, `17`)
+			w.Header().Set(`ETag`, `"abc"`)
+			w.Header().Set(`Last-Modified`, `Tue, 02 Jan 2024 03:04:05 GMT`)
+			w.Header().Set(`X-Amz-Meta-Owner`, `ops`)
+
+			if req.Method == `GET` {
+				w.Write([]byte(`{"hello":"world"}`))
+			}
+		default:
+			w.Header().Set(`Content-Type`, `application/xml`)
+			w.WriteHeader(http.StatusNotFound)
+
+			if req.Method == `GET` {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			}
+		}
+	}))
+
+	defer api.Close()
+
+	var dc = NewServer(`./tests/hello`)
+	assert.NoError(dc.Initialize())
+
+	dc.Protocols = map[string]ProtocolConfig{
+		`s3`: {
+			`endpoint`: api.URL,
+			`region`:   `us-east-1`,
+		},
+	}
+
+	var data = make(map[string]any)
+	var funcs = dc.GetTemplateFunctions(data, nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `s3`
+		binding.server = dc
+
+		return binding.Evaluate(req(`GET`, `/`), &TemplateHeader{}, data, funcs)
+	}
+
+	// objects are parsed according to their extension when stored with a generic type
+	out, err := evaluate(&Binding{
+		Resource: `s3://bucket/data/a.json`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{`hello`: `world`}, out)
+	assert.Equal(`GET /bucket/data/a.json`, requests[len(requests)-1])
+
+	// missing objects are reported as a 404
+	_, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/missing.json`,
+		IfStatus: map[string]BindingErrorAction{
+			`404`: `/not-found`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/not-found`), err)
+
+	_, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/missing.json`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `NoSuchKey`)
+
+	// listings follow continuation tokens and report common prefixes as directories
+	out, err = evaluate(&Binding{
+		Resource: `s3://bucket/data`,
+		Method:   `LIST`,
+	})
+
+	assert.NoError(err)
+
+	var listing = typeutil.MapNative(out)
+
+	assert.Equal(`data/`, listing[`prefix`])
+	assert.Equal(false, listing[`truncated`])
+	assert.Equal([]any{
+		map[string]any{`prefix`: `data/sub/`, `name`: `sub`},
+	}, listing[`directories`])
+
+	var objects = typeutil.V(listing[`objects`]).Slice()
+
+	assert.Len(objects, 2)
+	assert.Equal(`data/a.json`, typeutil.MapNative(objects[0])[`key`])
+	assert.Equal(`a.json`, typeutil.MapNative(objects[0])[`name`])
+	assert.Equal(`abc`, typeutil.MapNative(objects[0])[`etag`])
+	assert.EqualValues(17, typeutil.MapNative(objects[0])[`size`])
+	assert.Equal(`2024-01-02T03:04:05Z`, typeutil.MapNative(objects[0])[`last_modified`])
+	assert.Equal(`b.csv`, typeutil.MapNative(objects[1])[`name`])
+
+	out, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/`,
+		Method:   `LIST`,
+		Params: map[string]any{
+			`max`: 1,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Len(typeutil.V(typeutil.MapNative(out)[`objects`]).Slice(), 1)
+	assert.Equal(true, typeutil.MapNative(out)[`truncated`])
+
+	// object metadata
+	out, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/a.json`,
+		Method:   `HEAD`,
+	})
+
+	assert.NoError(err)
+	assert.EqualValues(17, typeutil.MapNative(out)[`size`])
+	assert.Equal(`abc`, typeutil.MapNative(out)[`etag`])
+	assert.Equal(`a.json`, typeutil.MapNative(out)[`name`])
+	assert.Equal(map[string]any{`owner`: `ops`}, typeutil.MapNative(out)[`metadata`])
+	assert.Equal(`HEAD /bucket/data/a.json`, requests[len(requests)-1])
+
+	// presigned URLs are generated locally
+	var count = len(requests)
+
+	out, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/a.json`,
+		Method:   `PRESIGN`,
+		Params: map[string]any{
+			`expires`: `5m`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Len(requests, count)
+
+	presigned, err := url.Parse(typeutil.String(out))
+
+	assert.NoError(err)
+	assert.Equal(strings.TrimPrefix(api.URL, `http://`), presigned.Host)
+	assert.Equal(`/bucket/data/a.json`, presigned.Path)
+	assert.Equal(`300`, presigned.Query().Get(`X-Amz-Expires`))
+	assert.NotEmpty(presigned.Query().Get(`X-Amz-Signature`))
+
+	_, err = evaluate(&Binding{
+		Resource: `s3://bucket/data/a.json`,
+		Method:   `DELETE`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `unsupported method`)
+}
+
+func TestSQLQueryPlaceholders(t *testing.T) {
+	var assert = require.New(t)
+	var rr = &ProtocolRequest{
+		Binding: &Binding{
+			RawBody: `SELECT id::text, ':skip' FROM t WHERE a = :a AND b IN (:b) AND c = :a`,
+			Params: map[string]any{
+				`a`: `x`,
+				`b`: []int{1, 2},
+			},
+		},
+	}
+
+	query, args, err := sqlQuery(rr, sqlDialects[`postgres`])
+	assert.NoError(err)
+	assert.Equal(`SELECT id::text, ':skip' FROM t WHERE a = $1 AND b IN ($2, $3) AND c = $4`, query)
+	assert.Equal([]any{`x`, 1, 2, `x`}, args)
+
+	query, _, err = sqlQuery(rr, sqlDialects[`mysql`])
+	assert.NoError(err)
+	assert.Equal(`SELECT id::text, ':skip' FROM t WHERE a = ? AND b IN (?, ?) AND c = ?`, query)
+
+	rr.Binding.RawBody = "SELECT 1 -- isn't :a\n/* :b's */ WHERE a = :a /* unterminated :b"
+	query, args, err = sqlQuery(rr, sqlDialects[`postgres`])
+	assert.NoError(err)
+	assert.Equal("SELECT 1 -- isn't :a\n/* :b's */ WHERE a = $1 /* unterminated :b", query)
+	assert.Equal([]any{`x`}, args)
+
+	dsn, err := sqlDSN(&url.URL{Scheme: `mysql`, User: url.UserPassword(`u`, `p`), Host: `db:3306`, Path: `/site`, RawQuery: `readonly=1&parseTime=true`}, sqlDialects[`mysql`], true, ``)
+	assert.NoError(err)
+	assert.Equal(`u:p@tcp(db:3306)/site?parseTime=true`, dsn)
+
+	dsn, err = sqlDSN(&url.URL{Scheme: `sqlite`, Host: `data`, Path: `/site.db`}, sqlDialects[`sqlite`], false, `/srv/www`)
+	assert.NoError(err)
+	assert.Equal(`/srv/www/data/site.db`, dsn)
+
+	dsn, err = sqlDSN(&url.URL{Scheme: `sqlite`, Path: `/var/db/site.db`}, sqlDialects[`sqlite`], false, `/srv/www`)
+	assert.NoError(err)
+	assert.Equal(`/var/db/site.db`, dsn)
+}
+
+func TestBindingDependencies(t *testing.T) {
+	var assert = require.New(t)
+	var dc = NewServer(`./tests/hello`)
+
+	deps, err := dc.bindingDependencies([]Binding{
+		{Name: `a`, Resource: `/a`},
+		{Name: `b`, Resource: `/b`},
+		{Name: `c`, Resource: `/c/{{ $.bindings.a.id }}`, Params: map[string]any{`x`: `{{ $.b.b }}`}},
+		{Name: `d`, Resource: `/d`, OnlyIfExpr: `{{ $.bindings.c }}`},
+		{Name: `e`, Resource: `/e`, Repeat: `$.bindings`},
+		{Name: `a`, Resource: `/a2`},
+	}, nil)
+
+	assert.NoError(err)
+	assert.Empty(deps[0])
+	assert.Empty(deps[1])
+	assert.ElementsMatch([]int{0, 1}, deps[2])
+	assert.ElementsMatch([]int{2}, deps[3])
+	assert.ElementsMatch([]int{0, 1, 2, 3}, deps[4])
+	assert.ElementsMatch([]int{0}, deps[5])
+
+	// references to later bindings are honored, which makes cycles possible
+	_, err = dc.bindingDependencies([]Binding{
+		{Name: `a`, Resource: `/a/{{ $.bindings.b }}`},
+		{Name: `b`, Resource: `/b/{{ $.bindings.a }}`},
+	}, nil)
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `dependency cycle detected: a -> b -> a`)
+}
+
+func TestBindingConcurrentEvaluation(t *testing.T) {
+	var assert = require.New(t)
+	var mux = http.NewServeMux()
+	var inflight int64
+	var maxInflight int64
+
+	mux.HandleFunc(`/slow/`, func(w http.ResponseWriter, req *http.Request) {
+		var n = atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+
+		for {
+			var max = atomic.LoadInt64(&maxInflight)
+
+			if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		httputil.RespondJSON(w, map[string]any{
+			`id`: strings.TrimPrefix(req.URL.Path, `/slow/`),
+		})
+	})
+
+	var upstream = httptest.NewServer(mux)
+	defer upstream.Close()
+
+	var dc = NewServer(`./tests/hello`)
+	dc.BindingConcurrency = 2
+
+	var header = &TemplateHeader{
+		Bindings: []Binding{
+			{Name: `one`, Resource: upstream.URL + `/slow/1`},
+			{Name: `two`, Resource: upstream.URL + `/slow/2`},
+			{Name: `three`, Resource: upstream.URL + `/slow/3`},
+			{Name: `four`, Resource: upstream.URL + `/slow/{{ $.bindings.one.id }}{{ $.bindings.three.id }}`},
+		},
+	}
+
+	_, data, err := dc.GetTemplateData(httptest.NewRequest(`GET`, `/`, nil), header)
+	assert.NoError(err)
+	assert.EqualValues(2, atomic.LoadInt64(&maxInflight))
+
+	var bindings = data[`bindings`].(map[string]any)
+
+	assert.Equal(map[string]any{`id`: `1`}, bindings[`one`])
+	assert.Equal(map[string]any{`id`: `2`}, bindings[`two`])
+	assert.Equal(map[string]any{`id`: `3`}, bindings[`three`])
+	assert.Equal(map[string]any{`id`: `13`}, bindings[`four`])
+}
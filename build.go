@@ -0,0 +1,616 @@
+package diecast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+)
+
+// The name of the file (in the build destination) that records what was written by the last build.
+var BuildManifestFilename = `.diecast-manifest.json`
+
+// The name of the sitemap written to the build destination.
+var BuildSitemapFilename = `sitemap.xml`
+
+// The elements and attributes that are followed when discovering pages from rendered HTML.
+var BuildLinkSelectors = map[string]string{
+	`a[href]`:      `href`,
+	`area[href]`:   `href`,
+	`link[href]`:   `href`,
+	`img[src]`:     `src`,
+	`script[src]`:  `src`,
+	`source[src]`:  `src`,
+	`iframe[src]`:  `src`,
+	`video[src]`:   `src`,
+	`audio[src]`:   `src`,
+	`embed[src]`:   `src`,
+	`object[data]`: `data`,
+}
+
+type BuildOptions struct {
+	Destination string   // The directory the rendered site is written to.
+	Workers     int      // The number of pages to render concurrently (default: the number of CPUs).
+	BaseURL     string   // The public URL the site will be served from; used to generate absolute URLs in the sitemap.
+	Paths       []string // Additional URL paths to render, beyond those found in the site's root directory.
+	NoFollow    bool     // Don't render pages discovered by following links in rendered HTML.
+}
+
+type BuildManifestEntry struct {
+	URL         string    `json:"url"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Modified    time.Time `json:"modified"`
+}
+
+// A record of every file written by a build, keyed on the file's path relative to the destination.
+// The manifest from the previous build is used to skip writing files that have not changed, and to
+// remove files that are no longer part of the site.
+type BuildManifest struct {
+	GeneratedAt time.Time                      `json:"generated_at"`
+	Files       map[string]*BuildManifestEntry `json:"files"`
+}
+
+type BuildResult struct {
+	Manifest  *BuildManifest
+	Rendered  int      // The number of distinct files rendered.
+	Written   int      // The number of files whose contents changed (or were created).
+	Unchanged int      // The number of files that were already up-to-date.
+	Removed   int      // The number of files from the previous build that are no longer part of the site.
+	Broken    []string // Paths discovered by following links that could not be rendered.
+}
+
+type siteBuilder struct {
+	server   *Server
+	options  BuildOptions
+	previous *BuildManifest
+	manifest *BuildManifest
+	result   *BuildResult
+	seen     map[string]bool
+	errors   []string
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+}
+
+// Renders the entire site into a directory of static files.  Every file in the site's root
+// directory is rendered (skipping those starting with "_" or "."), as are the values enumerated by
+// the "expand" header of any dynamic "__id" routes.  Unless disabled, links found in rendered HTML
+// are followed and rendered as well.  Pages are rendered in-process, in parallel, and files are only
+// written if their contents have changed since the last build.
+func (server *Server) Build(options BuildOptions) (*BuildResult, error) {
+	if options.Destination == `` {
+		return nil, fmt.Errorf("build: must specify a destination")
+	}
+
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+
+	if !server.initialized {
+		if err := server.Initialize(); err != nil {
+			return nil, err
+		}
+	}
+
+	var builder = &siteBuilder{
+		server:   server,
+		options:  options,
+		previous: loadBuildManifest(filepath.Join(options.Destination, BuildManifestFilename)),
+		manifest: &BuildManifest{
+			GeneratedAt: time.Now(),
+			Files:       make(map[string]*BuildManifestEntry),
+		},
+		result: new(BuildResult),
+		seen:   make(map[string]bool),
+		sem:    make(chan struct{}, options.Workers),
+	}
+
+	builder.result.Manifest = builder.manifest
+
+	var seeds, routes, err = builder.discover()
+
+	if err != nil {
+		return nil, fmt.Errorf("build: %v", err)
+	}
+
+	for _, urlPath := range append(seeds, options.Paths...) {
+		builder.enqueue(urlPath, true)
+	}
+
+	for _, route := range routes {
+		if values, err := builder.expand(route); err == nil {
+			for _, value := range values {
+				builder.enqueue(route.prefix+url.PathEscape(value), true)
+			}
+		} else {
+			builder.fail("expand %s: %v", route.file, err)
+		}
+	}
+
+	builder.wg.Wait()
+	builder.result.Rendered = len(builder.manifest.Files)
+
+	if len(builder.errors) > 0 {
+		sort.Strings(builder.errors)
+		return builder.result, fmt.Errorf("build: %d error(s):\n  %s", len(builder.errors), strings.Join(builder.errors, "\n  "))
+	}
+
+	if err := builder.finish(); err != nil {
+		return builder.result, fmt.Errorf("build: %v", err)
+	}
+
+	sort.Strings(builder.result.Broken)
+
+	log.Infof(
+		"build: rendered %d pages into %s (%d written, %d unchanged, %d removed, %d broken links)",
+		builder.result.Rendered,
+		options.Destination,
+		builder.result.Written,
+		builder.result.Unchanged,
+		builder.result.Removed,
+		len(builder.result.Broken),
+	)
+
+	return builder.result, nil
+}
+
+// a dynamic route (e.g.: "/users/index__id.html") and the URL prefix its values are appended to.
+type buildRoute struct {
+	file   string
+	prefix string
+}
+
+// walk the site's root directory, returning the URL paths of all static pages and any dynamic routes.
+func (builder *siteBuilder) discover() ([]string, []buildRoute, error) {
+	var root = builder.server.RootPath
+
+	if root == `` {
+		root = `.`
+	}
+
+	var seeds = make([]string, 0)
+	var routes = make([]buildRoute, 0)
+
+	var err = filepath.WalkDir(root, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var base = entry.Name()
+
+		if filename != root && (strings.HasPrefix(base, `_`) || strings.HasPrefix(base, `.`)) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		} else if entry.IsDir() {
+			return nil
+		}
+
+		var rel, _ = filepath.Rel(root, filename)
+		var urlPath = `/` + filepath.ToSlash(rel)
+		var stem = strings.TrimSuffix(urlPath, path.Ext(urlPath))
+
+		if strings.HasSuffix(stem, `/index__id`) {
+			routes = append(routes, buildRoute{
+				file:   filename,
+				prefix: strings.TrimSuffix(stem, `index__id`),
+			})
+		} else if strings.HasSuffix(stem, `__id`) {
+			routes = append(routes, buildRoute{
+				file:   filename,
+				prefix: strings.TrimSuffix(stem, `__id`) + `/`,
+			})
+		} else {
+			seeds = append(seeds, urlPath)
+		}
+
+		return nil
+	})
+
+	return seeds, routes, err
+}
+
+// evaluate the "expand" header of a dynamic route, returning the values to render it for.
+func (builder *siteBuilder) expand(route buildRoute) ([]string, error) {
+	var file, err = os.Open(route.file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	header, _, err := builder.server.splitTemplateFile(file)
+
+	if err != nil {
+		return nil, err
+	} else if header == nil || header.Expand == nil {
+		log.Warningf("build: skipping %s: no 'expand' values are specified", route.prefix)
+		return nil, nil
+	}
+
+	var values = make([]string, 0)
+
+	if expr, ok := header.Expand.(string); ok {
+		var req = httptest.NewRequest(http.MethodGet, builder.server.rp()+route.prefix, nil)
+
+		funcs, data, err := builder.server.GetTemplateData(req, header)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := EvalInline(fmt.Sprintf("{{ range $item := (%v) }}\n{{ $item }}\n{{ end }}", expr), data, funcs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != `` {
+				values = append(values, line)
+			}
+		}
+	} else {
+		for _, value := range sliceutil.Stringify(header.Expand) {
+			if value != `` {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// schedule the given URL path to be rendered, unless it already has been.
+func (builder *siteBuilder) enqueue(urlPath string, required bool) {
+	builder.lock.Lock()
+	defer builder.lock.Unlock()
+
+	if builder.seen[urlPath] {
+		return
+	}
+
+	builder.seen[urlPath] = true
+	builder.wg.Add(1)
+
+	go func() {
+		defer builder.wg.Done()
+
+		builder.sem <- struct{}{}
+		defer func() {
+			<-builder.sem
+		}()
+
+		builder.render(urlPath, required)
+	}()
+}
+
+func (builder *siteBuilder) fail(format string, args ...any) {
+	builder.lock.Lock()
+	defer builder.lock.Unlock()
+
+	builder.errors = append(builder.errors, fmt.Sprintf(format, args...))
+}
+
+// render the given URL path and write the output to the destination.  Paths that are required to
+// exist (i.e.: not discovered by following links) are a build error if they cannot be rendered.
+func (builder *siteBuilder) render(urlPath string, required bool) {
+	var req = httptest.NewRequest(http.MethodGet, (&url.URL{Path: builder.server.rp() + urlPath}).RequestURI(), nil)
+	var res = httptest.NewRecorder()
+
+	builder.server.ServeHTTP(res, req)
+
+	if res.Code >= 300 && res.Code < 400 {
+		if location := res.Header().Get(`Location`); location != `` {
+			builder.follow(urlPath, location)
+		}
+
+		return
+	} else if res.Code >= 400 {
+		if required {
+			builder.fail("%s: HTTP %d", urlPath, res.Code)
+		} else {
+			log.Warningf("build: broken link %s: HTTP %d", urlPath, res.Code)
+
+			builder.lock.Lock()
+			builder.result.Broken = append(builder.result.Broken, urlPath)
+			builder.lock.Unlock()
+		}
+
+		return
+	}
+
+	var body = res.Body.Bytes()
+	var contentType = res.Header().Get(`Content-Type`)
+	var isHTML = strings.HasPrefix(contentType, `text/html`)
+
+	if err := builder.write(builder.outputFile(urlPath, isHTML), urlPath, contentType, body); err != nil {
+		builder.fail("%s: %v", urlPath, err)
+		return
+	}
+
+	if isHTML && !builder.options.NoFollow {
+		if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body)); err == nil {
+			for selector, attr := range BuildLinkSelectors {
+				doc.Find(selector).Each(func(i int, el *goquery.Selection) {
+					builder.follow(urlPath, el.AttrOr(attr, ``))
+				})
+			}
+		}
+	}
+}
+
+// queue the page a link points to, provided it is part of this site.
+func (builder *siteBuilder) follow(from string, link string) {
+	var ref, err = url.Parse(strings.TrimSpace(link))
+
+	if err != nil || ref.Scheme != `` || ref.Host != `` || ref.Opaque != `` || ref.Path == `` {
+		return
+	}
+
+	var target = (&url.URL{Path: from}).ResolveReference(ref).Path
+
+	if prefix := builder.server.rp(); prefix != `` {
+		if !strings.HasPrefix(target, prefix+`/`) {
+			return
+		}
+
+		target = strings.TrimPrefix(target, prefix)
+	}
+
+	if target == InternalRoutePrefix || strings.HasPrefix(target, InternalRoutePrefix+`/`) {
+		return
+	}
+
+	builder.enqueue(target, false)
+}
+
+// returns the path (relative to the destination) that the given URL path is written to.  HTML pages
+// requested by directory or extensionless paths are written as index files so that the same URLs
+// work when served statically.
+func (builder *siteBuilder) outputFile(urlPath string, isHTML bool) string {
+	var index = path.Base(builder.server.IndexFile)
+
+	if index == `` || index == `.` || index == `/` {
+		index = DefaultIndexFile
+	}
+
+	if strings.HasSuffix(urlPath, `/`) {
+		urlPath = path.Join(urlPath, index)
+	} else if isHTML && path.Ext(urlPath) == `` {
+		urlPath = path.Join(urlPath, index)
+	}
+
+	return strings.TrimPrefix(path.Clean(urlPath), `/`)
+}
+
+// write the given data to the destination, unless the file already contains exactly this data.
+func (builder *siteBuilder) write(name string, urlPath string, contentType string, data []byte) error {
+	var sum = sha256.Sum256(data)
+	var entry = &BuildManifestEntry{
+		URL:         urlPath,
+		SHA256:      hex.EncodeToString(sum[:]),
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		Modified:    time.Now(),
+	}
+
+	builder.lock.Lock()
+
+	if _, ok := builder.manifest.Files[name]; ok {
+		// more than one URL renders to this file (e.g.: "/" and "/index.html")
+		builder.lock.Unlock()
+		return nil
+	}
+
+	builder.manifest.Files[name] = entry
+	builder.lock.Unlock()
+
+	var filename = filepath.Join(builder.options.Destination, filepath.FromSlash(name))
+	var unchanged bool
+
+	if prev, ok := builder.previous.Files[name]; ok && prev.SHA256 == entry.SHA256 {
+		if stat, err := os.Stat(filename); err == nil && stat.Size() == entry.Size {
+			entry.Modified = prev.Modified
+			unchanged = true
+		}
+	}
+
+	if !unchanged {
+		if err := writeFileAtomic(filename, data); err != nil {
+			return err
+		}
+
+		log.Debugf("build: wrote %s", filename)
+	}
+
+	builder.lock.Lock()
+	defer builder.lock.Unlock()
+
+	if unchanged {
+		builder.result.Unchanged += 1
+	} else {
+		builder.result.Written += 1
+	}
+
+	return nil
+}
+
+// write the sitemap and manifest, and remove anything left over from the previous build.
+func (builder *siteBuilder) finish() error {
+	if _, ok := builder.manifest.Files[BuildSitemapFilename]; !ok {
+		if data, err := builder.sitemap(); err == nil {
+			if err := builder.write(BuildSitemapFilename, `/`+BuildSitemapFilename, `application/xml`, data); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	for name := range builder.previous.Files {
+		if _, ok := builder.manifest.Files[name]; !ok {
+			var filename, err = builder.destinationFile(name)
+
+			if err != nil {
+				log.Warningf("build: not removing %q: %v", name, err)
+				continue
+			}
+
+			if err := os.Remove(filename); err == nil {
+				log.Debugf("build: removed %s", filename)
+				builder.result.Removed += 1
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if data, err := json.MarshalIndent(builder.manifest, ``, `  `); err == nil {
+		return writeFileAtomic(filepath.Join(builder.options.Destination, BuildManifestFilename), data)
+	} else {
+		return err
+	}
+}
+
+// returns the path of a file named in a manifest, which must be within the destination directory.
+func (builder *siteBuilder) destinationFile(name string) (string, error) {
+	var relative = filepath.FromSlash(name)
+
+	if name == `` || filepath.IsAbs(relative) || path.IsAbs(name) {
+		return ``, fmt.Errorf("must be a relative path")
+	}
+
+	var destination = filepath.Clean(builder.options.Destination)
+	var filename = filepath.Join(destination, filepath.Clean(relative))
+
+	if rel, err := filepath.Rel(destination, filename); err == nil {
+		if rel == `.` || rel == `..` || strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			return ``, fmt.Errorf("outside of the destination directory")
+		}
+	} else {
+		return ``, err
+	}
+
+	return filename, nil
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// generate a sitemap listing every HTML page in the manifest.
+func (builder *siteBuilder) sitemap() ([]byte, error) {
+	var baseURL = strings.TrimSuffix(builder.options.BaseURL, `/`)
+	var set = sitemapURLSet{
+		XMLNS: `http://www.sitemaps.org/schemas/sitemap/0.9`,
+	}
+
+	if baseURL == `` {
+		log.Warningf("build: no base URL was given, sitemap will contain relative URLs")
+	}
+
+	var index = path.Base(builder.outputFile(`/`, true))
+
+	for name, entry := range builder.manifest.Files {
+		if strings.HasPrefix(entry.ContentType, `text/html`) {
+			var loc = `/` + name
+
+			// index pages are listed by their directory
+			if path.Base(name) == index {
+				loc = strings.TrimSuffix(loc, index)
+			}
+
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:     baseURL + builder.server.rp() + loc,
+				LastMod: entry.Modified.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	sort.Slice(set.URLs, func(i int, j int) bool {
+		return set.URLs[i].Loc < set.URLs[j].Loc
+	})
+
+	if data, err := xml.MarshalIndent(set, ``, `  `); err == nil {
+		return append([]byte(xml.Header), append(data, '\n')...), nil
+	} else {
+		return nil, err
+	}
+}
+
+// read the manifest left behind by a previous build.  A missing or unreadable manifest is treated as
+// an empty one, which simply causes every file to be written.
+func loadBuildManifest(filename string) *BuildManifest {
+	var manifest = &BuildManifest{
+		Files: make(map[string]*BuildManifestEntry),
+	}
+
+	if data, err := os.ReadFile(filename); err == nil {
+		if err := json.Unmarshal(data, manifest); err != nil {
+			log.Warningf("build: ignoring invalid manifest %s: %v", filename, err)
+			manifest.Files = make(map[string]*BuildManifestEntry)
+		} else if manifest.Files == nil {
+			manifest.Files = make(map[string]*BuildManifestEntry)
+		}
+	}
+
+	return manifest
+}
+
+// write a file by way of a temporary file in the same directory, so that readers never see a
+// partially-written file.
+func writeFileAtomic(filename string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	var tmp, err = os.CreateTemp(filepath.Dir(filename), `.`+filepath.Base(filename)+`.*`)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,128 @@
+package diecast
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var dest = t.TempDir()
+
+	var write = func(name string, content string) {
+		var filename = filepath.Join(root, name)
+
+		assert.NoError(os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(os.WriteFile(filename, []byte(content), 0644))
+	}
+
+	write(`index.html`, `<html><body><a href="about">About</a> <a href="https://example.com/">elsewhere</a> <a href="/gone">gone</a></body></html>`)
+	write(`_hidden.html`, `nope`)
+	write(`_partials/header.html`, `nope`)
+	write(`about.html`, `<html><body><a href="/users/">users</a> <a href="./#top">top</a></body></html>`)
+	write(`users/index.html`, `<html><body>all users</body></html>`)
+	write(`users/index__id.html`, "---\nexpand: [alice, bob]\n---\n<html><body>user {{ param `id` }}</body></html>")
+	write(`style.css`, `body { color: red; }`)
+
+	var server = NewServer(root)
+	var options = BuildOptions{
+		Destination: dest,
+		Workers:     4,
+		BaseURL:     `https://www.example.com/`,
+	}
+
+	result, err := server.Build(options)
+	assert.NoError(err)
+
+	var exists = func(name string) bool {
+		var _, err = os.Stat(filepath.Join(dest, name))
+		return err == nil
+	}
+
+	var read = func(name string) string {
+		var data, err = os.ReadFile(filepath.Join(dest, name))
+		assert.NoError(err)
+
+		return string(data)
+	}
+
+	assert.Contains(read(`users/alice/index.html`), `user alice`)
+	assert.Contains(read(`users/bob/index.html`), `user bob`)
+	assert.Contains(read(`users/index.html`), `all users`)
+	assert.Equal(`body { color: red; }`, read(`style.css`))
+	assert.Contains(read(`about/index.html`), `href="/users/"`)
+	assert.False(exists(`_hidden.html`))
+	assert.False(exists(`_partials/header.html`))
+	assert.Equal([]string{`/gone`}, result.Broken)
+
+	var sitemap = read(`sitemap.xml`)
+
+	assert.Contains(sitemap, `<loc>https://www.example.com/</loc>`)
+	assert.Contains(sitemap, `<loc>https://www.example.com/users/alice/</loc>`)
+	assert.NotContains(sitemap, `style.css`)
+
+	var manifest BuildManifest
+
+	assert.NoError(json.Unmarshal([]byte(read(BuildManifestFilename)), &manifest))
+	assert.Contains(manifest.Files, `users/bob/index.html`)
+	assert.Equal(`/users/bob`, manifest.Files[`users/bob/index.html`].URL)
+	assert.Equal(result.Written, result.Rendered+1)
+	assert.Zero(result.Unchanged)
+
+	// rebuilding only writes what changed, and removes what is no longer part of the site
+	var stylePath = filepath.Join(dest, `style.css`)
+	var old = time.Now().Add(-time.Hour)
+
+	assert.NoError(os.Chtimes(stylePath, old, old))
+
+	write(`users/index__id.html`, "---\nexpand: '$.page.users'\npage:\n  users: [alice, carol]\n---\n<html><body>user {{ param `id` }}</body></html>")
+
+	server = NewServer(root)
+	result, err = server.Build(options)
+	assert.NoError(err)
+
+	assert.Contains(read(`users/carol/index.html`), `user carol`)
+	assert.False(exists(`users/bob/index.html`))
+	assert.Equal(1, result.Removed)
+	assert.Equal(2, result.Written) // carol, and the sitemap
+	assert.Equal(result.Rendered-1, result.Unchanged)
+
+	if stat, err := os.Stat(stylePath); err == nil {
+		assert.True(stat.ModTime().Equal(old))
+	} else {
+		assert.NoError(err)
+	}
+
+	// entries in the manifest that refer to files outside of the destination are never removed
+	var outside = filepath.Join(filepath.Dir(dest), `outside`)
+
+	assert.NoError(os.WriteFile(outside, []byte(`keep me`), 0644))
+
+	manifest.Files[`../outside`] = &BuildManifestEntry{}
+	manifest.Files[outside] = &BuildManifestEntry{}
+
+	if data, err := json.Marshal(manifest); err == nil {
+		assert.NoError(os.WriteFile(filepath.Join(dest, BuildManifestFilename), data, 0644))
+	} else {
+		assert.NoError(err)
+	}
+
+	server = NewServer(root)
+	result, err = server.Build(options)
+	assert.NoError(err)
+	assert.Equal(`keep me`, read(`../outside`))
+
+	// pages that exist on disk must render successfully
+	write(`broken.html`, `{{ nope }}`)
+
+	server = NewServer(root)
+	_, err = server.Build(options)
+	assert.Error(err)
+	assert.Contains(err.Error(), `/broken.html: HTTP 500`)
+}
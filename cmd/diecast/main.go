@@ -4,12 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"sort"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/ghetzel/cli"
 	"github.com/ghetzel/diecast"
@@ -121,6 +120,18 @@ func main() {
 			Usage: `The destination directory to put files in when rendering a static site.`,
 			Value: `./_site`,
 		},
+		cli.IntFlag{
+			Name:  `build-workers`,
+			Usage: `The number of pages to render concurrently when rendering a static site (default: number of CPUs).`,
+		},
+		cli.StringFlag{
+			Name:  `build-base-url`,
+			Usage: `The public URL the static site will be served from (used to generate sitemap.xml).`,
+		},
+		cli.BoolFlag{
+			Name:  `build-no-follow`,
+			Usage: `Don't render pages discovered by following links when rendering a static site.`,
+		},
 		cli.BoolFlag{
 			Name:  `disable-commands`,
 			Usage: `Set this flag to disable processing of prestart and start commands.`,
@@ -133,6 +144,10 @@ func main() {
 			Name:  `start-command`,
 			Usage: `Execute a command before immediately after starting the built-in web server.`,
 		},
+		cli.BoolFlag{
+			Name:  `watch, W`,
+			Usage: `Watch the site and configuration for changes, reloading connected browsers automatically (for development use).`,
+		},
 		cli.BoolFlag{
 			Name:  `debug, D`,
 			Usage: `Allow template debugging by appending the "?__viewsource=true" query string parameter.`,
@@ -228,6 +243,7 @@ func main() {
 		server.Address = c.String(`address`)
 		server.Environment = c.String(`env`)
 		server.EnableDebugging = c.Bool(`debug`)
+		server.LiveReload = c.Bool(`watch`)
 		server.BindingPrefix = c.String(`binding-prefix`)
 		server.RoutePrefix = c.String(`route-prefix`)
 		server.TryLocalFirst = c.Bool(`local-first`)
@@ -371,6 +387,21 @@ func main() {
 				addr = strings.TrimPrefix(addr, `unix:`)
 			}
 
+			if c.Bool(`build-site`) {
+				log.Infof("Rendering site in %v", servePath)
+
+				if _, err := server.Build(diecast.BuildOptions{
+					Destination: c.String(`build-destination`),
+					Workers:     c.Int(`build-workers`),
+					BaseURL:     c.String(`build-base-url`),
+					NoFollow:    c.Bool(`build-no-follow`),
+				}); err != nil {
+					log.Fatalf("%v", err)
+				}
+
+				return
+			}
+
 			var errchan = make(chan error)
 
 			log.Infof(
@@ -385,86 +416,30 @@ func main() {
 				errchan <- server.Serve()
 			}()
 
-			if c.Bool(`build-site`) {
-				log.Infof("Rendering site in %v", servePath)
-				var paths = make([]string, 0)
-
-				if err := filepath.Walk(servePath, func(path string, info os.FileInfo, err error) error {
-					var base = filepath.Base(path)
-					var ext = filepath.Ext(path)
-
-					if strings.HasPrefix(base, `_`) {
-						if info.IsDir() {
-							return filepath.SkipDir
-						}
-					} else if strings.HasSuffix(strings.TrimSuffix(base, ext), `__id`) {
-						return nil
-					} else if !info.IsDir() {
-						var urlPath = strings.TrimPrefix(path, servePath)
-						urlPath = strings.TrimPrefix(urlPath, `/`)
-						urlPath = `/` + urlPath
-
-						if !sliceutil.ContainsString(paths, urlPath) {
-							paths = append(paths, urlPath)
-						}
-					}
-
-					return nil
-				}); err != nil {
-					log.Fatalf("build error: %v", err)
-				}
-
-				var destinationPath = c.String(`build-destination`)
-
-				if err := os.RemoveAll(destinationPath); err != nil {
-					log.Fatalf("Failed to cleanup destination: %v", err)
-				}
-
-				sort.Strings(paths)
-				var client = &http.Client{
-					Timeout: time.Duration(10) * time.Second,
-				}
-
-				for _, path := range paths {
-					response, err := client.Get(`http://` + server.Address + path)
-
-					if err == nil && response.StatusCode >= 400 {
-						err = fmt.Errorf("%v", response.Status)
-					}
-
-					if err == nil {
-						var destFile = filepath.Join(destinationPath, path)
-
-						if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
-							log.Fatalf("Failed to create destination: %v", err)
-						}
+			// reload the configuration whenever we receive a SIGHUP
+			go func() {
+				var hup = make(chan os.Signal, 1)
 
-						if file, err := os.Create(destFile); err == nil {
-							_, err := io.Copy(file, response.Body)
+				signal.Notify(hup, syscall.SIGHUP)
 
-							if err != nil {
-								log.Fatalf("Failed to write file %v: %v", destFile, err)
-							}
+				for range hup {
+					log.Infof("received SIGHUP, reloading configuration")
 
-							file.Close()
-						} else {
-							log.Fatalf("Failed to create file %v: %v", destFile, err)
-						}
-					} else {
-						log.Fatalf("Request to %v failed: %v", path, err)
+					if err := server.Reload(); err != nil {
+						log.Errorf("%v (keeping current configuration)", err)
 					}
 				}
-			} else {
-				go func() {
-					if renderSingleFile != `` {
-						errchan <- server.RenderPath(os.Stdout, filepath.Base(renderSingleFile))
-					}
-				}()
+			}()
 
-				select {
-				case err := <-errchan:
-					log.FatalIf(err)
+			go func() {
+				if renderSingleFile != `` {
+					errchan <- server.RenderPath(os.Stdout, filepath.Base(renderSingleFile))
 				}
+			}()
+
+			select {
+			case err := <-errchan:
+				log.FatalIf(err)
 			}
 		} else {
 			log.Fatalf("Failed to start HTTP server: %v", err)
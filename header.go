@@ -48,6 +48,7 @@ type TemplateHeader struct {
 	QueryJoiner       string            `yaml:"query_joiner,omitempty"    json:"query_joiner,omitempty"`    // Override the string used to join multiple values of the same query string parameter.
 	HeaderJoiner      string            `yaml:"header_joiner,omitempty"   json:"header_joiner,omitempty"`   // Override the string used to join multiple values of the same HTTP header.
 	StatusCode        int               `yaml:"code,omitempty"            json:"code,omitempty"`            // Override the HTTP response status code of this page
+	Expand            any               `yaml:"expand,omitempty"          json:"expand,omitempty"`          // For dynamic "__id" routes, the values to render this page for when building a static site.  Either an array, or an expression (like a binding's "repeat") that yields one.
 	lines             int
 	additionalHeaders map[string]any
 }
@@ -163,3 +164,35 @@ OtherBindingLoop:
 
 	return newHeader, nil
 }
+
+// returns a copy of this header that shares no mutable state with the original, so that cached
+// headers can be safely modified during rendering.
+func (header *TemplateHeader) clone() *TemplateHeader {
+	if header == nil {
+		return nil
+	}
+
+	var h = *header
+
+	h.Page = deepCopyMap(header.Page)
+	h.Headers = deepCopyMap(header.Headers)
+	h.FlagDefs = deepCopyMap(header.FlagDefs)
+	h.Translations = deepCopyMap(header.Translations)
+	h.Defaults = copyStringMap(header.Defaults)
+	h.DefaultHeaders = copyStringMap(header.DefaultHeaders)
+	h.Includes = copyStringMap(header.Includes)
+	h.Switch = append([]*SwitchCase(nil), header.Switch...)
+	h.UrlParams = append([]KV(nil), header.UrlParams...)
+	h.Postprocessors = append([]string(nil), header.Postprocessors...)
+	h.additionalHeaders = deepCopyMap(header.additionalHeaders)
+
+	if header.Bindings != nil {
+		h.Bindings = make([]Binding, len(header.Bindings))
+
+		for i, binding := range header.Bindings {
+			h.Bindings[i] = *binding.clone()
+		}
+	}
+
+	return &h
+}
@@ -0,0 +1,364 @@
+package diecast
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// How long to wait for filesystem activity to settle before reloading.
+var LiveReloadDebounce = 100 * time.Millisecond
+
+// The name of the postprocessor that injects the live reload client into rendered HTML pages.
+const LiveReloadPostprocessor = `livereload`
+
+const ContextLiveReloadPath = `diecast-livereload-path`
+
+func init() {
+	RegisterPostprocessor(LiveReloadPostprocessor, InjectLiveReloadScript)
+}
+
+// the client script: connects to the live reload endpoint and reloads the page when told to (or
+// when the connection is restored after being lost, e.g.: because the server was restarted.)
+var liveReloadScript = `<script>
+(function() {
+  var url = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + %q;
+  var lost = false;
+
+  function connect() {
+    var ws = new WebSocket(url);
+
+    ws.onopen = function() {
+      if (lost) {
+        location.reload();
+      }
+    };
+
+    ws.onmessage = function(e) {
+      if (JSON.parse(e.data).event === 'reload') {
+        location.reload();
+      }
+    };
+
+    ws.onclose = function() {
+      lost = true;
+      setTimeout(connect, 1000);
+    };
+  }
+
+  connect();
+})();
+</script>
+`
+
+// Injects the live reload client script into the given HTML document, immediately before the
+// closing </body> tag.  Documents that are not HTML are returned unmodified.
+func InjectLiveReloadScript(in string, req *http.Request) (string, error) {
+	if req == nil {
+		return in, nil
+	}
+
+	var wsPath = httputil.RequestGetValue(req, ContextLiveReloadPath).String()
+
+	if wsPath == `` {
+		return in, nil
+	}
+
+	var script = fmt.Sprintf(liveReloadScript, wsPath)
+	var lower = strings.ToLower(in)
+
+	if i := strings.LastIndex(lower, `</body>`); i >= 0 {
+		return in[:i] + script + in[i:], nil
+	} else if strings.Contains(lower, `<html`) {
+		return in + script, nil
+	} else {
+		return in, nil
+	}
+}
+
+// A liveReloader watches the site's files and configuration for changes, notifying all connected
+// browsers when they occur.
+type liveReloader struct {
+	server  *Server
+	watcher *fsnotify.Watcher
+	roots   []string
+	files   map[string]bool
+	clients map[*WebsocketConn]bool
+	pending map[string]bool
+	timer   *time.Timer
+	lock    sync.Mutex
+}
+
+// start watching for changes and register the endpoint browsers connect to, if live reload is enabled.
+func (server *Server) initLiveReload() error {
+	if !server.LiveReload || server.liveReload != nil {
+		return nil
+	}
+
+	var watcher, err = fsnotify.NewWatcher()
+
+	if err != nil {
+		return fmt.Errorf("livereload: %v", err)
+	}
+
+	var reloader = &liveReloader{
+		server:  server,
+		watcher: watcher,
+		files:   make(map[string]bool),
+		clients: make(map[*WebsocketConn]bool),
+		pending: make(map[string]bool),
+	}
+
+	// watch the site itself, including layouts and error pages
+	if !strings.Contains(server.RootPath, `://`) {
+		for _, dir := range []string{
+			server.RootPath,
+			filepath.Join(server.RootPath, server.LayoutPath),
+			filepath.Join(server.RootPath, server.ErrorsPath),
+		} {
+			reloader.watchTree(dir)
+		}
+	}
+
+	// watch any mounts that serve files from local disk
+	for _, mount := range server.reloadable().Mounts {
+		if fm, ok := mount.(*FileMount); ok && fm.FileSystem == nil {
+			reloader.watchTree(fm.Path)
+		}
+	}
+
+	// watch the configuration file(s)
+	if server.configFile != `` {
+		for _, filename := range []string{
+			server.configFile,
+			server.environmentConfigFile(server.configFile),
+		} {
+			reloader.watchFile(filename)
+		}
+	}
+
+	server.liveReload = reloader
+	go reloader.run()
+
+	log.Noticef("livereload: watching %d directories for changes", len(watcher.WatchList()))
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:    `livereload`,
+		Methods: []string{http.MethodGet},
+		Handler: reloader.handleConnection,
+	})
+}
+
+// recursively watch the given directory, skipping hidden subdirectories.
+func (reloader *liveReloader) watchTree(root string) {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	} else {
+		return
+	}
+
+	if stat, err := os.Stat(root); err != nil || !stat.IsDir() {
+		return
+	}
+
+	reloader.lock.Lock()
+	reloader.roots = append(reloader.roots, root)
+	reloader.lock.Unlock()
+
+	reloader.addDirs(root)
+}
+
+func (reloader *liveReloader) addDirs(root string) {
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		} else if path != root && strings.HasPrefix(entry.Name(), `.`) {
+			return filepath.SkipDir
+		}
+
+		if err := reloader.watcher.Add(path); err != nil {
+			log.Warningf("livereload: cannot watch %s: %v", path, err)
+		}
+
+		return nil
+	})
+}
+
+// watch a single file.  The containing directory is what is actually watched, since many editors
+// save files by replacing them.
+func (reloader *liveReloader) watchFile(filename string) {
+	if filename == `` {
+		return
+	} else if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	} else {
+		return
+	}
+
+	reloader.lock.Lock()
+	reloader.files[filename] = true
+	reloader.lock.Unlock()
+
+	if err := reloader.watcher.Add(filepath.Dir(filename)); err != nil {
+		log.Warningf("livereload: cannot watch %s: %v", filename, err)
+	}
+}
+
+// returns whether a change to the given path is one we care about.
+func (reloader *liveReloader) isRelevant(path string) bool {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	if reloader.files[path] {
+		return true
+	}
+
+	for _, root := range reloader.roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return !strings.HasPrefix(filepath.Base(path), `.`)
+		}
+	}
+
+	return false
+}
+
+func (reloader *liveReloader) run() {
+	for {
+		select {
+		case event, ok := <-reloader.watcher.Events:
+			if !ok {
+				return
+			} else if event.Op == fsnotify.Chmod || !reloader.isRelevant(event.Name) {
+				continue
+			}
+
+			// start watching newly-created directories
+			if event.Has(fsnotify.Create) {
+				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+					reloader.addDirs(event.Name)
+				}
+			}
+
+			reloader.lock.Lock()
+			reloader.pending[event.Name] = true
+
+			if reloader.timer != nil {
+				reloader.timer.Stop()
+			}
+
+			reloader.timer = time.AfterFunc(LiveReloadDebounce, reloader.flush)
+			reloader.lock.Unlock()
+
+		case err, ok := <-reloader.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Warningf("livereload: %v", err)
+		}
+	}
+}
+
+// process all of the changes that have accumulated, then tell browsers to reload.
+func (reloader *liveReloader) flush() {
+	reloader.lock.Lock()
+
+	var changed = make([]string, 0, len(reloader.pending))
+	var configChanged bool
+
+	for path := range reloader.pending {
+		changed = append(changed, path)
+		configChanged = configChanged || reloader.files[path]
+	}
+
+	reloader.pending = make(map[string]bool)
+	reloader.lock.Unlock()
+
+	sort.Strings(changed)
+
+	if configChanged {
+		if err := reloader.server.Reload(); err == nil {
+			log.Noticef("livereload: configuration reloaded")
+		} else {
+			log.Errorf("livereload: configuration reload failed, keeping current configuration: %v", err)
+			return
+		}
+	}
+
+	reloader.server.PurgePages(`*`)
+
+	log.Infof("livereload: %d file(s) changed, reloading browsers", len(changed))
+	reloader.broadcast(map[string]any{
+		`event`: `reload`,
+		`paths`: changed,
+	})
+}
+
+// send the given message to every connected browser, dropping any that have gone away.
+func (reloader *liveReloader) broadcast(message any) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	for conn := range reloader.clients {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+
+		if err := conn.WriteJSON(message); err != nil {
+			conn.Close()
+			delete(reloader.clients, conn)
+		}
+	}
+}
+
+// handle GET /_diecast/livereload
+func (reloader *liveReloader) handleConnection(w http.ResponseWriter, req *http.Request) {
+	var conn, err = DefaultUpgrader.Upgrade(w, req, nil)
+
+	if err != nil {
+		log.Warningf("[%s] livereload: %v", reqid(req), err)
+		return
+	}
+
+	reloader.lock.Lock()
+	reloader.clients[conn] = true
+	reloader.lock.Unlock()
+
+	// we don't expect anything from the client, but need to read in order to notice it leaving
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	reloader.lock.Lock()
+	delete(reloader.clients, conn)
+	reloader.lock.Unlock()
+
+	conn.Close()
+}
+
+// stop watching for changes and disconnect all browsers.
+func (reloader *liveReloader) close() error {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	if reloader.timer != nil {
+		reloader.timer.Stop()
+	}
+
+	for conn := range reloader.clients {
+		conn.Close()
+		delete(reloader.clients, conn)
+	}
+
+	return reloader.watcher.Close()
+}
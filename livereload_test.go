@@ -0,0 +1,94 @@
+package diecast
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+	"github.com/gorilla/websocket"
+)
+
+func TestInjectLiveReloadScript(t *testing.T) {
+	var assert = require.New(t)
+	var req = httptest.NewRequest(`GET`, `/`, nil)
+
+	// nothing is injected unless live reload is active for this request
+	out, err := InjectLiveReloadScript(`<html><body>hi</body></html>`, req)
+	assert.NoError(err)
+	assert.Equal(`<html><body>hi</body></html>`, out)
+
+	httputil.RequestSetValue(req, ContextLiveReloadPath, `/_diecast/livereload`)
+
+	out, err = InjectLiveReloadScript(`<html><BODY>hi</BODY></html>`, req)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(out, `<html><BODY>hi<script>`))
+	assert.True(strings.HasSuffix(out, "</script>\n</BODY></html>"))
+	assert.Contains(out, `"/_diecast/livereload"`)
+
+	// non-HTML output is left alone
+	out, err = InjectLiveReloadScript(`{"hello": true}`, req)
+	assert.NoError(err)
+	assert.Equal(`{"hello": true}`, out)
+}
+
+func TestLiveReload(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var config = filepath.Join(root, `diecast.yml`)
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`<html><body>v1</body></html>`), 0644))
+	assert.NoError(os.WriteFile(config, []byte("bindings: []\n"), 0644))
+
+	var server = NewServer(root)
+
+	server.LiveReload = true
+
+	assert.NoError(server.LoadConfig(config))
+	assert.NoError(server.Initialize())
+	defer server.liveReload.close()
+
+	var site = httptest.NewServer(server)
+	defer site.Close()
+
+	// rendered pages include the client script
+	res, err := http.Get(site.URL + `/`)
+	assert.NoError(err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Contains(string(body), `/_diecast/livereload`)
+
+	conn, _, err := websocket.DefaultDialer.Dial(`ws`+strings.TrimPrefix(site.URL, `http`)+`/_diecast/livereload`, nil)
+	assert.NoError(err)
+	defer conn.Close()
+
+	var next = func() map[string]any {
+		var msg map[string]any
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(conn.ReadJSON(&msg))
+
+		return msg
+	}
+
+	// changing a page tells browsers to reload
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`<html><body>v2</body></html>`), 0644))
+
+	var msg = next()
+	assert.Equal(`reload`, msg[`event`])
+	assert.Contains(msg[`paths`], filepath.Join(root, `index.html`))
+
+	// changing the configuration reloads it
+	assert.Empty(server.reloadable().Bindings)
+	assert.NoError(os.WriteFile(config, []byte("bindings:\n- name: greeting\n  resource: /greeting\n"), 0644))
+
+	msg = next()
+	assert.Equal(`reload`, msg[`event`])
+	assert.Len(server.reloadable().Bindings, 1)
+}
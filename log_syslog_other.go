@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package diecast
+
+import (
+	"fmt"
+	"io"
+)
+
+func newSyslogWriter(facility string, tag string) (io.Writer, error) {
+	return nil, fmt.Errorf("not supported on this platform")
+}
@@ -0,0 +1,265 @@
+package diecast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// The socket used to write to the systemd journal.
+var JournaldSocket = `/run/systemd/journal/socket`
+
+// The identifier request logs are written to syslog and the journal with, unless otherwise specified.
+var DefaultLogSyslogTag = `diecast`
+
+// The suffix appended to rotated log files.
+const rotatedLogTimeFormat = `20060102T150405.000000000`
+
+// open the writer that request logs will be written to.  A nil writer means logs are discarded.
+func (config *LogConfig) open() (io.Writer, bool, error) {
+	switch dest := strings.ToLower(config.Destination); dest {
+	case ``, `none`, `false`:
+		return nil, false, nil
+	case `-`, `stdout`:
+		return os.Stdout, true, nil
+	case `stderr`:
+		return os.Stderr, true, nil
+	case `syslog`:
+		if w, err := newSyslogWriter(config.SyslogFacility, config.syslogTag()); err == nil {
+			return w, false, nil
+		} else {
+			return nil, false, fmt.Errorf("syslog: %v", err)
+		}
+	case `journald`:
+		if w, err := newJournaldWriter(JournaldSocket, config.syslogTag()); err == nil {
+			return w, false, nil
+		} else {
+			return nil, false, fmt.Errorf("journald: %v", err)
+		}
+	default:
+		if config.Truncate {
+			os.Truncate(config.Destination, 0)
+		}
+
+		if w, err := newRotatingFile(config); err == nil {
+			return w, false, nil
+		} else {
+			return nil, false, err
+		}
+	}
+}
+
+func (config *LogConfig) syslogTag() string {
+	if config.SyslogTag != `` {
+		return config.SyslogTag
+	}
+
+	return DefaultLogSyslogTag
+}
+
+// A log file that is rotated once it exceeds a given size, or has been open for a given amount of
+// time.  Rotated files are renamed with a timestamp suffix (e.g.:
+// "access.log.20060102T150405.000000000"), and the oldest are removed once there are more than the
+// configured number of them.
+type rotatingFile struct {
+	filename   string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	openedAt   time.Time
+	lock       sync.Mutex
+}
+
+func newRotatingFile(config *LogConfig) (*rotatingFile, error) {
+	var rf = &rotatingFile{
+		filename:   config.Destination,
+		maxBackups: config.MaxBackups,
+	}
+
+	if config.MaxSize != `` {
+		if size, err := stringutil.ToBytes(config.MaxSize); err == nil && size > 0 {
+			rf.maxSize = int64(size)
+		} else {
+			return nil, fmt.Errorf("max_size: invalid size %q", config.MaxSize)
+		}
+	}
+
+	if config.MaxAge != `` {
+		if age := typeutil.V(config.MaxAge).Duration(); age > 0 {
+			rf.maxAge = age
+		} else {
+			return nil, fmt.Errorf("max_age: invalid duration %q", config.MaxAge)
+		}
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	if f, err := os.OpenFile(rf.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+		rf.file = f
+		rf.size = 0
+		rf.openedAt = time.Now()
+
+		if stat, err := f.Stat(); err == nil {
+			rf.size = stat.Size()
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			log.Warningf("logfile: rotate failed: %v", err)
+		}
+	}
+
+	var n, err = rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+func (rf *rotatingFile) shouldRotate(incoming int64) bool {
+	if rf.size == 0 {
+		return false
+	} else if rf.maxSize > 0 && rf.size+incoming > rf.maxSize {
+		return true
+	} else if rf.maxAge > 0 && time.Since(rf.openedAt) >= rf.maxAge {
+		return true
+	}
+
+	return false
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	var rotated = fmt.Sprintf("%s.%s", rf.filename, time.Now().Format(rotatedLogTimeFormat))
+
+	if err := os.Rename(rf.filename, rotated); err != nil {
+		rf.open()
+		return err
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups > 0 {
+		if backups, err := rf.backups(); err == nil && len(backups) > rf.maxBackups {
+			sort.Strings(backups)
+
+			for _, old := range backups[:len(backups)-rf.maxBackups] {
+				os.Remove(old)
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns the files this file has been rotated to, ignoring anything else with a similar name.
+func (rf *rotatingFile) backups() ([]string, error) {
+	if matches, err := filepath.Glob(rf.filename + `.*`); err == nil {
+		var backups = make([]string, 0, len(matches))
+
+		for _, match := range matches {
+			if _, err := time.Parse(rotatedLogTimeFormat, strings.TrimPrefix(match, rf.filename+`.`)); err == nil {
+				backups = append(backups, match)
+			}
+		}
+
+		return backups, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	return rf.file.Close()
+}
+
+// writes each log line as an entry in the systemd journal using its native protocol.
+type journaldWriter struct {
+	conn net.Conn
+	tag  string
+}
+
+func newJournaldWriter(socket string, tag string) (*journaldWriter, error) {
+	if conn, err := net.Dial(`unixgram`, socket); err == nil {
+		return &journaldWriter{
+			conn: conn,
+			tag:  tag,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (jw *journaldWriter) Write(p []byte) (int, error) {
+	var entry bytes.Buffer
+
+	journaldField(&entry, `PRIORITY`, `6`)
+	journaldField(&entry, `SYSLOG_IDENTIFIER`, jw.tag)
+	journaldField(&entry, `MESSAGE`, strings.TrimSuffix(string(p), "\n"))
+
+	if _, err := jw.conn.Write(entry.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (jw *journaldWriter) Close() error {
+	return jw.conn.Close()
+}
+
+// append a field to a journal entry.  Values containing newlines are written as a length-prefixed
+// binary value, as the protocol requires.
+func journaldField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+
+	if strings.Contains(value, "\n") {
+		var size [8]byte
+
+		binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+		buf.WriteByte('\n')
+		buf.Write(size[:])
+	} else {
+		buf.WriteByte('=')
+	}
+
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
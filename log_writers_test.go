@@ -0,0 +1,175 @@
+package diecast
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestJSONRequestLog(t *testing.T) {
+	var assert = require.New(t)
+	var logfile = filepath.Join(t.TempDir(), `access.log`)
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`ok`: true,
+		}, http.StatusAccepted)
+	}))
+
+	defer upstream.Close()
+
+	var server = NewServer(`./tests/hello`)
+
+	server.Log = LogConfig{
+		Format:      JSONLogFormat,
+		Destination: logfile,
+	}
+
+	server.Bindings = SharedBindingSet{
+		{
+			Name:     `upstream`,
+			Resource: upstream.URL,
+		},
+	}
+
+	server.Authenticators = AuthenticatorConfigs{
+		{
+			Name:  `everyone`,
+			Type:  `always`,
+			Paths: []string{`/mnt/**`},
+		},
+	}
+
+	server.SetMounts([]Mount{
+		&FileMount{MountPoint: `/mnt/`, Path: `./tests/hello`},
+	})
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	doTestServerRequest(server, `GET`, `/mnt/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	var data, err = os.ReadFile(logfile)
+	assert.NoError(err)
+
+	var lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(lines, 2)
+
+	var entries = make([]map[string]any, len(lines))
+
+	for i, line := range lines {
+		assert.NoError(json.Unmarshal([]byte(line), &entries[i]))
+	}
+
+	assert.Equal(`GET`, entries[0][`method`])
+	assert.Equal(`http://`+DefaultAddress+`/index.html`, entries[0][`url`])
+	assert.EqualValues(200, entries[0][`status_code`])
+	assert.NotEmpty(entries[0][`request_id`])
+	assert.Contains(entries[0][`timings`], `binding-upstream`)
+	assert.Nil(entries[0][`authenticator`])
+	assert.Nil(entries[0][`mount`])
+
+	var bindings = entries[0][`bindings`].([]any)
+	assert.Len(bindings, 1)
+	assert.Equal(`upstream`, bindings[0].(map[string]any)[`name`])
+	assert.EqualValues(http.StatusAccepted, bindings[0].(map[string]any)[`status`])
+
+	assert.Equal(`http://`+DefaultAddress+`/mnt/index.html`, entries[1][`url`])
+	assert.Equal(`everyone`, entries[1][`authenticator`])
+	assert.Equal(`/mnt/`, entries[1][`mount`])
+}
+
+func TestRotatingLogFile(t *testing.T) {
+	var assert = require.New(t)
+	var logfile = filepath.Join(t.TempDir(), `access.log`)
+
+	rf, err := newRotatingFile(&LogConfig{
+		Destination: logfile,
+		MaxSize:     `16B`,
+		MaxBackups:  2,
+	})
+
+	assert.NoError(err)
+	defer rf.Close()
+
+	// files that weren't created by rotation are left alone
+	for _, name := range []string{`access.log.bak`, `access.log.gz.keep`} {
+		assert.NoError(os.WriteFile(filepath.Join(filepath.Dir(logfile), name), []byte(`keep`), 0644))
+	}
+
+	for _, line := range []string{"aaaaaaaaaa\n", "bbbbbbbbbb\n", "cccccccccc\n", "dddddddddd\n"} {
+		_, err := rf.Write([]byte(line))
+		assert.NoError(err)
+	}
+
+	current, err := os.ReadFile(logfile)
+	assert.NoError(err)
+	assert.Equal("dddddddddd\n", string(current))
+
+	// only the two most recent rotated files are kept
+	backups, err := rf.backups()
+	assert.NoError(err)
+	assert.Len(backups, 2)
+
+	for _, name := range []string{`access.log.bak`, `access.log.gz.keep`} {
+		assert.FileExists(filepath.Join(filepath.Dir(logfile), name))
+	}
+
+	oldest, err := os.ReadFile(backups[0])
+	assert.NoError(err)
+	assert.Equal("bbbbbbbbbb\n", string(oldest))
+
+	_, err = newRotatingFile(&LogConfig{
+		Destination: logfile,
+		MaxAge:      `soon`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `max_age`)
+}
+
+func TestJournaldWriter(t *testing.T) {
+	var assert = require.New(t)
+	var socket = filepath.Join(t.TempDir(), `journal.sock`)
+
+	listener, err := net.ListenUnixgram(`unixgram`, &net.UnixAddr{Name: socket, Net: `unixgram`})
+	assert.NoError(err)
+	defer listener.Close()
+
+	jw, err := newJournaldWriter(socket, `testing`)
+	assert.NoError(err)
+	defer jw.Close()
+
+	_, err = jw.Write([]byte("GET /index.html 200\n"))
+	assert.NoError(err)
+
+	var buf = make([]byte, 4096)
+	n, err := listener.Read(buf)
+	assert.NoError(err)
+
+	var fields = make(map[string]string)
+	var scanner = bufio.NewScanner(strings.NewReader(string(buf[:n])))
+
+	for scanner.Scan() {
+		var k, v, _ = strings.Cut(scanner.Text(), `=`)
+		fields[k] = v
+	}
+
+	assert.Equal(`6`, fields[`PRIORITY`])
+	assert.Equal(`testing`, fields[`SYSLOG_IDENTIFIER`])
+	assert.Equal(`GET /index.html 200`, fields[`MESSAGE`])
+}
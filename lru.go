@@ -0,0 +1,100 @@
+package diecast
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruItem[V any] struct {
+	key   string
+	value V
+}
+
+// lruCache is a fixed-capacity, concurrency-safe key-value store that evicts the least-recently
+// used entry once full.
+type lruCache[V any] struct {
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+	lock     sync.Mutex
+}
+
+func newLruCache[V any](capacity int) *lruCache[V] {
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	return &lruCache[V]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (cache *lruCache[V]) Get(key string) (V, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if el, ok := cache.items[key]; ok {
+		cache.order.MoveToFront(el)
+		return el.Value.(*lruItem[V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (cache *lruCache[V]) Set(key string, value V) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if el, ok := cache.items[key]; ok {
+		el.Value.(*lruItem[V]).value = value
+		cache.order.MoveToFront(el)
+		return
+	}
+
+	cache.items[key] = cache.order.PushFront(&lruItem[V]{
+		key:   key,
+		value: value,
+	})
+
+	for cache.order.Len() > cache.capacity {
+		if oldest := cache.order.Back(); oldest != nil {
+			cache.order.Remove(oldest)
+			delete(cache.items, oldest.Value.(*lruItem[V]).key)
+		}
+	}
+}
+
+func (cache *lruCache[V]) Delete(key string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if el, ok := cache.items[key]; ok {
+		cache.order.Remove(el)
+		delete(cache.items, key)
+	}
+}
+
+// Each calls fn for every entry, most-recently used first, stopping if fn returns false.  The
+// cache is locked for the duration, so fn must not call back into it.
+func (cache *lruCache[V]) Each(fn func(key string, value V) bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for el := cache.order.Front(); el != nil; el = el.Next() {
+		var item = el.Value.(*lruItem[V])
+
+		if !fn(item.key, item.value) {
+			return
+		}
+	}
+}
+
+func (cache *lruCache[V]) Len() int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	return cache.order.Len()
+}
@@ -0,0 +1,300 @@
+package diecast
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// The default histogram buckets (in seconds) used for all latency metrics.
+var DefaultMetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type MetricsConfig struct {
+	Enable        bool                 `yaml:"enable"        json:"enable"`        // Expose metrics in the Prometheus text format at /_diecast/metrics.
+	Buckets       []float64            `yaml:"buckets"       json:"buckets"`       // Override the upper bounds (in seconds) of the buckets used for latency histograms.
+	Authenticator *AuthenticatorConfig `yaml:"authenticator" json:"authenticator"` // If set, the metrics endpoint is protected by this authenticator.
+}
+
+const (
+	metricCounter   = `counter`
+	metricHistogram = `histogram`
+	metricGauge     = `gauge`
+)
+
+type metricSeries struct {
+	labels  []string
+	value   float64
+	sum     float64
+	count   uint64
+	buckets []uint64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// A minimal registry of counters and histograms, rendered in the Prometheus text exposition format.
+type metricsRegistry struct {
+	families map[string]*metricFamily
+	lock     sync.Mutex
+}
+
+func newMetricsRegistry(buckets []float64) *metricsRegistry {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	var registry = &metricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+
+	for _, family := range []*metricFamily{
+		{name: `diecast_request_duration_seconds`, kind: metricHistogram, labels: []string{`route`, `method`, `status`}, help: `Time taken to handle requests, by route and response status.`},
+		{name: `diecast_binding_duration_seconds`, kind: metricHistogram, labels: []string{`binding`, `protocol`}, help: `Time taken to evaluate bindings.`},
+		{name: `diecast_binding_errors_total`, kind: metricCounter, labels: []string{`binding`, `protocol`}, help: `Number of binding evaluations that failed.`},
+		{name: `diecast_mount_duration_seconds`, kind: metricHistogram, labels: []string{`mount`}, help: `Time taken by mounts to open files.`},
+		{name: `diecast_mount_requests_total`, kind: metricCounter, labels: []string{`mount`, `result`}, help: `Number of times each mount was asked for a file, by result (hit, miss, or error).`},
+		{name: `diecast_render_duration_seconds`, kind: metricHistogram, labels: []string{`renderer`}, help: `Time taken by renderers to render responses.`},
+		{name: `diecast_ratelimit_rejections_total`, kind: metricCounter, help: `Number of requests that exceeded the rate limit.`},
+		{name: `diecast_csrf_failures_total`, kind: metricCounter, help: `Number of requests that failed CSRF validation.`},
+		{name: `diecast_binding_retries_total`, kind: metricCounter, labels: []string{`binding`, `protocol`}, help: `Number of times binding requests were retried.`},
+		{name: `diecast_circuit_breaker_state`, kind: metricGauge, labels: []string{`host`}, help: `The state of the circuit breaker for each upstream host (0: closed, 1: half-open, 2: open).`},
+		{name: `diecast_circuit_breaker_rejections_total`, kind: metricCounter, labels: []string{`host`}, help: `Number of binding requests refused because the upstream host's circuit breaker was open.`},
+		{name: `diecast_authenticator_denials_total`, kind: metricCounter, labels: []string{`authenticator`}, help: `Number of requests denied by an authenticator.`},
+	} {
+		if family.kind == metricHistogram {
+			family.buckets = buckets
+		}
+
+		family.series = make(map[string]*metricSeries)
+		registry.families[family.name] = family
+	}
+
+	return registry
+}
+
+// retrieve (or create) the series with the given label values.  Must be called with the lock held.
+func (registry *metricsRegistry) seriesFor(name string, labels []string) *metricSeries {
+	var family, ok = registry.families[name]
+
+	if !ok {
+		panic(fmt.Sprintf("unknown metric %q", name))
+	} else if len(labels) != len(family.labels) {
+		panic(fmt.Sprintf("metric %q: expected %d labels, got %d", name, len(family.labels), len(labels)))
+	}
+
+	var key = strings.Join(labels, "\x00")
+
+	if series, ok := family.series[key]; ok {
+		return series
+	}
+
+	var series = &metricSeries{
+		labels:  labels,
+		buckets: make([]uint64, len(family.buckets)),
+	}
+
+	family.series[key] = series
+
+	return series
+}
+
+// increment a counter.  Calling this on a nil registry (i.e.: metrics are disabled) does nothing.
+func (registry *metricsRegistry) inc(name string, labels ...string) {
+	if registry == nil {
+		return
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.seriesFor(name, labels).value += 1
+}
+
+// set the value of a gauge.  Calling this on a nil registry does nothing.
+func (registry *metricsRegistry) set(name string, value float64, labels ...string) {
+	if registry == nil {
+		return
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.seriesFor(name, labels).value = value
+}
+
+// record a duration in a histogram.  Calling this on a nil registry does nothing.
+func (registry *metricsRegistry) observe(name string, took time.Duration, labels ...string) {
+	if registry == nil {
+		return
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var family = registry.families[name]
+	var series = registry.seriesFor(name, labels)
+	var value = took.Seconds()
+
+	series.sum += value
+	series.count += 1
+
+	for i, le := range family.buckets {
+		if value <= le {
+			series.buckets[i] += 1
+		}
+	}
+}
+
+// write all metrics in the Prometheus text exposition format.
+func (registry *metricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var out strings.Builder
+	var names = make([]string, 0, len(registry.families))
+
+	for name := range registry.families {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		var family = registry.families[name]
+		var keys = make([]string, 0, len(family.series))
+
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		fmt.Fprintf(&out, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(&out, "# TYPE %s %s\n", name, family.kind)
+
+		// counters without labels are always present, even before they are first incremented
+		if len(keys) == 0 && family.kind == metricCounter && len(family.labels) == 0 {
+			fmt.Fprintf(&out, "%s 0\n", name)
+		}
+
+		for _, key := range keys {
+			var series = family.series[key]
+
+			switch family.kind {
+			case metricCounter, metricGauge:
+				fmt.Fprintf(&out, "%s%s %s\n", name, formatMetricLabels(family.labels, series.labels), formatMetricValue(series.value))
+			case metricHistogram:
+				var bucketNames = make([]string, 0, len(family.labels)+1)
+				var bucketValues = make([]string, 0, len(series.labels)+1)
+
+				bucketNames = append(append(bucketNames, family.labels...), `le`)
+				bucketValues = append(bucketValues, series.labels...)
+
+				for i, le := range family.buckets {
+					fmt.Fprintf(
+						&out,
+						"%s_bucket%s %d\n",
+						name,
+						formatMetricLabels(bucketNames, append(bucketValues, formatMetricValue(le))),
+						series.buckets[i],
+					)
+				}
+
+				fmt.Fprintf(&out, "%s_bucket%s %d\n", name, formatMetricLabels(bucketNames, append(bucketValues, `+Inf`)), series.count)
+				fmt.Fprintf(&out, "%s_sum%s %s\n", name, formatMetricLabels(family.labels, series.labels), formatMetricValue(series.sum))
+				fmt.Fprintf(&out, "%s_count%s %d\n", name, formatMetricLabels(family.labels, series.labels), series.count)
+			}
+		}
+	}
+
+	var n, err = io.WriteString(w, out.String())
+
+	return int64(n), err
+}
+
+func formatMetricLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ``
+	}
+
+	var pairs = make([]string, len(names))
+	var escaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escaper.Replace(values[i]))
+	}
+
+	return `{` + strings.Join(pairs, `,`) + `}`
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return `+Inf`
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (server *Server) initMetrics() error {
+	var mc = server.Metrics
+
+	if mc == nil || !mc.Enable {
+		return nil
+	}
+
+	server.metrics = newMetricsRegistry(mc.Buckets)
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:          `metrics`,
+		Methods:       []string{http.MethodGet},
+		Authenticator: mc.Authenticator,
+		Handler:       server.handleMetrics,
+	})
+}
+
+// handle GET /_diecast/metrics
+func (server *Server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(`Content-Type`, `text/plain; version=0.0.4; charset=utf-8`)
+
+	if _, err := server.metrics.WriteTo(w); err != nil {
+		log.Warningf("[%s] metrics: %v", reqid(req), err)
+	}
+}
+
+// returns a short name for the given renderer (e.g.: "template", "markdown").
+func rendererName(renderer Renderer) string {
+	var name = fmt.Sprintf("%T", renderer)
+
+	if i := strings.LastIndex(name, `.`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return strings.ToLower(strings.TrimSuffix(name, `Renderer`))
+}
+
+// render using the given renderer, recording how long it took.
+func (server *Server) render(renderer Renderer, w http.ResponseWriter, req *http.Request, options RenderOptions) error {
+	var start = time.Now()
+	var err = renderer.Render(w, req, options)
+
+	server.metrics.observe(`diecast_render_duration_seconds`, time.Since(start), rendererName(renderer))
+
+	return err
+}
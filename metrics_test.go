@@ -0,0 +1,123 @@
+package diecast
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	var assert = require.New(t)
+	var registry = newMetricsRegistry([]float64{1, 0.1})
+	var out bytes.Buffer
+
+	registry.observe(`diecast_render_duration_seconds`, 50*time.Millisecond, `template`)
+	registry.observe(`diecast_render_duration_seconds`, 500*time.Millisecond, `template`)
+	registry.observe(`diecast_render_duration_seconds`, 2*time.Second, `template`)
+	registry.inc(`diecast_authenticator_denials_total`, `say "no"`)
+
+	_, err := registry.WriteTo(&out)
+	assert.NoError(err)
+
+	var text = out.String()
+
+	assert.Contains(text, "# TYPE diecast_render_duration_seconds histogram\n")
+	assert.Contains(text, "diecast_render_duration_seconds_bucket{renderer=\"template\",le=\"0.1\"} 1\n")
+	assert.Contains(text, "diecast_render_duration_seconds_bucket{renderer=\"template\",le=\"1\"} 2\n")
+	assert.Contains(text, "diecast_render_duration_seconds_bucket{renderer=\"template\",le=\"+Inf\"} 3\n")
+	assert.Contains(text, "diecast_render_duration_seconds_sum{renderer=\"template\"} 2.55\n")
+	assert.Contains(text, "diecast_render_duration_seconds_count{renderer=\"template\"} 3\n")
+	assert.Contains(text, "diecast_authenticator_denials_total{authenticator=\"say \\\"no\\\"\"} 1\n")
+	assert.Contains(text, "# TYPE diecast_csrf_failures_total counter\ndiecast_csrf_failures_total 0\n")
+
+	// disabled metrics are a no-op
+	var disabled *metricsRegistry
+
+	disabled.inc(`diecast_csrf_failures_total`)
+	disabled.observe(`diecast_render_duration_seconds`, time.Second, `template`)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	var assert = require.New(t)
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`ok`: true,
+		})
+	}))
+
+	defer upstream.Close()
+
+	var server = NewServer(`./tests/hello`)
+
+	server.Bindings = SharedBindingSet{
+		{
+			Name:     `upstream`,
+			Resource: upstream.URL,
+		},
+	}
+
+	server.Metrics = &MetricsConfig{
+		Enable: true,
+	}
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	doTestServerRequest(server, `GET`, `/nope.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(404, w.Code)
+	})
+
+	doTestServerRequest(server, `GET`, `/_diecast/metrics`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+		assert.Contains(w.Header().Get(`Content-Type`), `text/plain`)
+
+		var text = w.Body.String()
+
+		assert.Contains(text, `diecast_request_duration_seconds_count{route="/index.html",method="GET",status="200"} 1`)
+		assert.Contains(text, `diecast_request_duration_seconds_count{route="unmatched",method="GET",status="404"} 1`)
+		assert.Contains(text, `diecast_binding_duration_seconds_count{binding="upstream",protocol="http"} 1`)
+		assert.Contains(text, `diecast_render_duration_seconds_count{renderer="template"} 1`)
+	})
+
+	// protected by an authenticator
+	// ---------------------------------------------------------------------------------------------
+	var protected = NewServer(`./tests/hello`)
+
+	protected.Metrics = &MetricsConfig{
+		Enable: true,
+		Authenticator: &AuthenticatorConfig{
+			Type: `never`,
+		},
+	}
+
+	assert.NoError(protected.Initialize())
+
+	doTestServerRequest(protected, `GET`, `/_diecast/metrics`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(403, w.Code)
+	})
+
+	var out bytes.Buffer
+
+	_, err := protected.metrics.WriteTo(&out)
+	assert.NoError(err)
+	assert.Contains(out.String(), `diecast_authenticator_denials_total{authenticator="`)
+
+	// disabled by default
+	// ---------------------------------------------------------------------------------------------
+	var disabled = NewServer(`./tests/hello`)
+
+	assert.NoError(disabled.Initialize())
+
+	doTestServerRequest(disabled, `GET`, `/_diecast/metrics`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(404, w.Code)
+	})
+}
@@ -11,7 +11,10 @@ import (
 	"strings"
 
 	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
 )
 
 type MountConfig struct {
@@ -20,6 +23,60 @@ type MountConfig struct {
 	Options map[string]any `yaml:"options" json:"options"` // Mount-specific options
 }
 
+// A mount's "to" may also be a list of upstream URLs to load balance across, which is shorthand for
+// setting the first as "to" and all of them in the "upstreams" option.
+func (config *MountConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	var raw struct {
+		Mount   string         `yaml:"mount"`
+		To      any            `yaml:"to"`
+		Options map[string]any `yaml:"options"`
+	}
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	config.Mount = raw.Mount
+	config.Options = raw.Options
+
+	if to, ok := raw.To.([]any); ok {
+		if len(to) == 0 {
+			return fmt.Errorf("mount %s: must specify at least one upstream", raw.Mount)
+		}
+
+		if config.Options == nil {
+			config.Options = make(map[string]any)
+		}
+
+		config.Options[`upstreams`] = append(to, sliceutil.Sliceify(config.Options[`upstreams`])...)
+	} else if raw.To != nil {
+		config.To = typeutil.String(raw.To)
+	}
+
+	// upstreams may be given as URL strings, or as objects that specify a URL and weight
+	if upstreams, ok := config.Options[`upstreams`]; ok {
+		var normalized = make([]any, 0)
+
+		for _, upstream := range sliceutil.Sliceify(upstreams) {
+			if typeutil.IsMap(upstream) {
+				normalized = append(normalized, upstream)
+			} else {
+				normalized = append(normalized, map[string]any{
+					`url`: typeutil.String(upstream),
+				})
+			}
+		}
+
+		config.Options[`upstreams`] = normalized
+
+		if config.To == `` && len(normalized) > 0 {
+			config.To = maputil.M(normalized[0]).String(`url`)
+		}
+	}
+
+	return nil
+}
+
 var ErrMountHalt = errors.New(`mount halted`)
 
 type Mount interface {
@@ -48,6 +105,18 @@ func NewMountFromSpec(spec string) (Mount, error) {
 			MountPoint: mountPoint,
 		}
 
+	case `archive+http`, `archive+https`, `archive+file`:
+		mount = &ArchiveMount{
+			MountPoint: mountPoint,
+			Source:     source,
+		}
+
+	case `git`, `git+file`, `git+http`, `git+https`, `git+ssh`:
+		mount = &GitMount{
+			MountPoint: mountPoint,
+			URL:        source,
+		}
+
 	case `s3`, `aws+s3`:
 		mount = &S3Mount{
 			MountPoint: mountPoint,
@@ -70,6 +139,40 @@ func NewMountFromSpec(spec string) (Mount, error) {
 	return mount, nil
 }
 
+// (re)start the background work of the given mounts (e.g. proxy health checks, fetching from git
+// repositories, checking archives for changes), stopping that of any previous set.
+func (server *Server) startMountWorkers(mounts []Mount) {
+	server.mountsLock.Lock()
+	defer server.mountsLock.Unlock()
+
+	if server.mountsStop != nil {
+		close(server.mountsStop)
+	}
+
+	server.mountsStop = make(chan struct{})
+
+	for _, mount := range mounts {
+		switch mount := mount.(type) {
+		case *ProxyMount:
+			mount.startHealthChecks(server.mountsStop)
+		case *GitMount:
+			mount.startFetching(server.mountsStop)
+		case *ArchiveMount:
+			mount.startChecking(server.mountsStop)
+		}
+	}
+}
+
+func (server *Server) stopMountWorkers() {
+	server.mountsLock.Lock()
+	defer server.mountsLock.Unlock()
+
+	if server.mountsStop != nil {
+		close(server.mountsStop)
+		server.mountsStop = nil
+	}
+}
+
 func mountSummary(mount Mount) string {
 	var mtype = fmt.Sprintf("%T", mount)
 	mtype = strings.TrimPrefix(mtype, `*diecast.`)
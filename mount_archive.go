@@ -0,0 +1,456 @@
+package diecast
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/klauspost/compress/zstd"
+)
+
+var DefaultArchiveCheckInterval = 10 * time.Second
+var DefaultArchiveFetchTimeout = time.Minute
+
+const (
+	ArchiveZip    = `zip`
+	ArchiveTar    = `tar`
+	ArchiveTarGz  = `tar.gz`
+	ArchiveTarZst = `tar.zst`
+)
+
+// the archive format implied by a filename or URL, if any.
+func archiveFormat(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme != `` {
+		name = u.Path
+	}
+
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, `.zip`):
+		return ArchiveZip
+	case strings.HasSuffix(name, `.tar`):
+		return ArchiveTar
+	case strings.HasSuffix(name, `.tar.gz`), strings.HasSuffix(name, `.tgz`):
+		return ArchiveTarGz
+	case strings.HasSuffix(name, `.tar.zst`), strings.HasSuffix(name, `.tzst`):
+		return ArchiveTarZst
+	default:
+		return ``
+	}
+}
+
+// An ArchiveMount serves the contents of a zip or tar archive (optionally compressed with gzip or
+// zstd), read from a local file or a URL.  The archive is indexed once when it is loaded, and is
+// periodically checked for changes; a changed archive is loaded in full before it replaces the one
+// being served, so replacing the file (e.g. with an atomic rename) swaps every file at once.
+type ArchiveMount struct {
+	MountPoint    string `json:"mount"`
+	Source        string `json:"source"`
+	Format        string `json:"format,omitempty"`         // The archive format: "zip", "tar", "tar.gz", or "tar.zst" (default: from the source's extension).
+	CheckInterval any    `json:"check_interval,omitempty"` // How often to check the archive for changes (default: 10s; "0" disables).
+	lock          sync.Mutex
+	loadLock      sync.Mutex
+	archive       *archiveSnapshot
+}
+
+// a loaded archive.
+type archiveSnapshot struct {
+	fs           *indexFS
+	stat         os.FileInfo // for local archives, the file that was loaded
+	etag         string      // for remote archives, the validators of the response that was loaded
+	lastModified string
+}
+
+func (mount *ArchiveMount) GetMountPoint() string {
+	return mount.MountPoint
+}
+
+func (mount *ArchiveMount) GetTarget() string {
+	return mount.Source
+}
+
+func (mount *ArchiveMount) WillRespondTo(name string, req *http.Request, requestBody io.Reader) bool {
+	return strings.HasPrefix(name, mount.GetMountPoint())
+}
+
+func (mount *ArchiveMount) String() string {
+	return fmt.Sprintf("%T('%s')", mount, mount.GetMountPoint())
+}
+
+func (mount *ArchiveMount) Open(name string) (http.File, error) {
+	return openAsHttpFile(mount, name)
+}
+
+func (mount *ArchiveMount) OpenWithType(name string, req *http.Request, requestBody io.Reader) (*MountResponse, error) {
+	if archive, err := mount.current(); err == nil {
+		var files = &FileMount{
+			MountPoint: mount.MountPoint,
+			Path:       `/`,
+			FileSystem: archive.fs,
+		}
+
+		var response, err = files.OpenWithType(name, req, requestBody)
+
+		if response != nil {
+			if file, ok := response.GetPayload().(http.File); ok {
+				if info, err := file.Stat(); err == nil && !info.IsDir() {
+					response.lastModified = info.ModTime()
+				}
+			}
+		}
+
+		return response, err
+	} else {
+		return nil, err
+	}
+}
+
+// returns an ArchiveMount serving the source of the given proxy or file mount as an archive, which
+// is how mounts that set an archive format are served.  Other mounts are returned as-is.
+func archiveMountFor(mount Mount) Mount {
+	switch mount := mount.(type) {
+	case *ProxyMount:
+		return &ArchiveMount{
+			MountPoint: mount.MountPoint,
+			Source:     mount.URL,
+		}
+	case *FileMount:
+		return &ArchiveMount{
+			MountPoint: mount.MountPoint,
+			Source:     mount.Path,
+		}
+	default:
+		return mount
+	}
+}
+
+// the local path or URL of the archive.
+func (mount *ArchiveMount) location() (string, bool) {
+	var source = strings.TrimPrefix(mount.Source, `archive+`)
+
+	if strings.HasPrefix(source, `http://`) || strings.HasPrefix(source, `https://`) {
+		return source, true
+	} else {
+		return strings.TrimPrefix(source, `file://`), false
+	}
+}
+
+func (mount *ArchiveMount) format() string {
+	if mount.Format != `` {
+		return strings.TrimPrefix(strings.ToLower(mount.Format), `.`)
+	} else {
+		var location, _ = mount.location()
+		return archiveFormat(location)
+	}
+}
+
+func (mount *ArchiveMount) checkInterval() time.Duration {
+	if mount.CheckInterval == nil {
+		return DefaultArchiveCheckInterval
+	} else {
+		return typeutil.Duration(mount.CheckInterval)
+	}
+}
+
+// returns the archive being served, loading it the first time the mount is used.
+func (mount *ArchiveMount) current() (*archiveSnapshot, error) {
+	mount.lock.Lock()
+	var archive = mount.archive
+	mount.lock.Unlock()
+
+	if archive == nil {
+		if err := mount.Load(); err != nil {
+			return nil, err
+		}
+
+		mount.lock.Lock()
+		archive = mount.archive
+		mount.lock.Unlock()
+	}
+
+	return archive, nil
+}
+
+// Load the archive if it has changed since it was last loaded, and start serving it.  If the new
+// archive cannot be read, the previous one continues to be served.
+func (mount *ArchiveMount) Load() error {
+	mount.loadLock.Lock()
+	defer mount.loadLock.Unlock()
+
+	mount.lock.Lock()
+	var previous = mount.archive
+	mount.lock.Unlock()
+
+	var archive *archiveSnapshot
+	var err error
+
+	if location, remote := mount.location(); remote {
+		archive, err = mount.fetch(location, previous)
+	} else {
+		archive, err = mount.open(location, previous)
+	}
+
+	if err != nil {
+		return fmt.Errorf("archive %s: %v", mount.Source, err)
+	} else if archive != nil {
+		log.Infof("archive: %s serving %s", mount.MountPoint, mount.Source)
+
+		mount.lock.Lock()
+		mount.archive = archive
+		mount.lock.Unlock()
+	}
+
+	return nil
+}
+
+// load a local archive, unless it is the same file that was previously loaded.  The archive is
+// copied first, so that it can be changed in place without affecting the files being served.
+func (mount *ArchiveMount) open(filename string, previous *archiveSnapshot) (*archiveSnapshot, error) {
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		if stat, err := file.Stat(); err == nil {
+			if previous != nil && previous.stat != nil && os.SameFile(previous.stat, stat) &&
+				previous.stat.Size() == stat.Size() && previous.stat.ModTime().Equal(stat.ModTime()) {
+				return nil, nil
+			}
+
+			if copied, size, err := spool(file); err == nil {
+				if fs, err := indexArchive(copied, size, stat.ModTime(), mount.format()); err == nil {
+					return &archiveSnapshot{
+						fs:   fs,
+						stat: stat,
+					}, nil
+				} else {
+					copied.Close()
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// download a remote archive, unless it hasn't changed since it was previously downloaded.
+func (mount *ArchiveMount) fetch(uri string, previous *archiveSnapshot) (*archiveSnapshot, error) {
+	var client = &http.Client{
+		Timeout: DefaultArchiveFetchTimeout,
+	}
+
+	if req, err := http.NewRequest(http.MethodGet, uri, nil); err == nil {
+		req.Header.Set(`User-Agent`, DiecastUserAgentString)
+
+		if previous != nil {
+			if previous.etag != `` {
+				req.Header.Set(`If-None-Match`, previous.etag)
+			}
+
+			if previous.lastModified != `` {
+				req.Header.Set(`If-Modified-Since`, previous.lastModified)
+			}
+		}
+
+		if res, err := client.Do(req); err == nil {
+			defer res.Body.Close()
+
+			if res.StatusCode == http.StatusNotModified && previous != nil {
+				return nil, nil
+			} else if res.StatusCode >= 400 {
+				return nil, fmt.Errorf("HTTP %s", res.Status)
+			}
+
+			var modTime = time.Now()
+
+			if lm, err := http.ParseTime(res.Header.Get(`Last-Modified`)); err == nil {
+				modTime = lm
+			}
+
+			if file, size, err := spool(res.Body); err == nil {
+				if fs, err := indexArchive(file, size, modTime, mount.format()); err == nil {
+					return &archiveSnapshot{
+						fs:           fs,
+						etag:         res.Header.Get(`ETag`),
+						lastModified: res.Header.Get(`Last-Modified`),
+					}, nil
+				} else {
+					file.Close()
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// copy the given stream to an anonymous temporary file, which is removed once it is closed.  The
+// file is returned positioned at its start.
+func spool(src io.Reader) (*os.File, int64, error) {
+	if file, err := os.CreateTemp(``, `diecast-archive-`); err == nil {
+		os.Remove(file.Name())
+
+		if n, err := io.Copy(file, src); err != nil {
+			file.Close()
+			return nil, 0, err
+		} else if _, err := file.Seek(0, io.SeekStart); err == nil {
+			return file, n, nil
+		} else {
+			file.Close()
+			return nil, 0, err
+		}
+	} else {
+		return nil, 0, err
+	}
+}
+
+// build an index of the files in an archive.  The archive file is read from whenever a file is
+// opened, and is closed once nothing refers to the index any longer.
+func indexArchive(file *os.File, size int64, modTime time.Time, format string) (*indexFS, error) {
+	var fs = newIndexFS(modTime)
+
+	switch format {
+	case ArchiveZip:
+		if archive, err := zip.NewReader(file, size); err == nil {
+			for _, entry := range archive.File {
+				var name = path.Clean(`/` + entry.Name)
+
+				if strings.HasSuffix(entry.Name, `/`) {
+					fs.addDir(name, entry.Modified).info.SetModTime(entry.Modified)
+				} else if entry.Mode().IsRegular() {
+					fs.addFile(name, int64(entry.UncompressedSize64), entry.Modified, func() ([]byte, error) {
+						if rc, err := entry.Open(); err == nil {
+							defer rc.Close()
+							return io.ReadAll(rc)
+						} else {
+							return nil, err
+						}
+					})
+				}
+			}
+		} else {
+			return nil, err
+		}
+
+	case ArchiveTar, ArchiveTarGz, ArchiveTarZst:
+		var tarfile = file
+
+		// compressed archives are decompressed once, so that files can be read from them directly
+		if format != ArchiveTar {
+			var decompressed io.Reader
+
+			if format == ArchiveTarGz {
+				if gz, err := gzip.NewReader(file); err == nil {
+					defer gz.Close()
+					decompressed = gz
+				} else {
+					return nil, err
+				}
+			} else if zr, err := zstd.NewReader(file); err == nil {
+				defer zr.Close()
+				decompressed = zr
+			} else {
+				return nil, err
+			}
+
+			if spooled, _, err := spool(decompressed); err == nil {
+				tarfile = spooled
+				file.Close()
+			} else {
+				return nil, err
+			}
+		}
+
+		var offset = &countingReader{
+			Reader: tarfile,
+		}
+
+		var archive = tar.NewReader(offset)
+
+		for {
+			if header, err := archive.Next(); err == nil {
+				var name = path.Clean(`/` + header.Name)
+
+				switch header.Typeflag {
+				case tar.TypeDir:
+					fs.addDir(name, header.ModTime).info.SetModTime(header.ModTime)
+				case tar.TypeReg:
+					// the reader is positioned at the start of the file's contents
+					var start, size = offset.n, header.Size
+
+					fs.addFile(name, size, header.ModTime, func() ([]byte, error) {
+						return io.ReadAll(io.NewSectionReader(tarfile, start, size))
+					})
+				}
+			} else if err == io.EOF {
+				break
+			} else {
+				tarfile.Close()
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	return fs, nil
+}
+
+// periodically check the archive for changes until stop is closed.
+func (mount *ArchiveMount) startChecking(stop <-chan struct{}) {
+	var interval = mount.checkInterval()
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := mount.Load(); err != nil {
+					log.Warningf("%v", err)
+				}
+			}
+		}
+	}()
+}
+
+// counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	var n, err = reader.Reader.Read(p)
+	reader.n += int64(n)
+
+	return n, err
+}
@@ -74,7 +74,7 @@ func (mount *FileMount) OpenWithType(name string, req *http.Request, requestBody
 		// override the response status code (if specified)
 		if mount.ResponseCode > 0 {
 			response.StatusCode = mount.ResponseCode
-		} else if stat.IsDir() {
+		} else if stat.IsDir() && req != nil {
 			if strings.HasSuffix(req.URL.Path, `/`) {
 				return response, fmt.Errorf("is a directory")
 			} else {
@@ -0,0 +1,419 @@
+package diecast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+var DefaultGitFetchInterval = time.Minute
+var DefaultGitRef = `HEAD`
+
+// the fields of a commit read by gitCommitFormat, in order
+const gitCommitFormat = `--format=%H%x00%an%x00%ae%x00%aI%x00%s`
+
+// A GitMount serves files from a branch, tag, or commit of a git repository, without checking it
+// out into a working tree.  Local repositories (git+file://) are read in place; any other repository
+// (git://, git+https://, git+ssh://) is cloned into a cache directory.  Both are periodically
+// fetched from, so that new commits to a branch are served as they arrive.
+type GitMount struct {
+	MountPoint    string `json:"mount"`
+	URL           string `json:"source"`
+	Ref           string `json:"ref,omitempty"`            // The branch, tag, or commit to serve (default: the repository's HEAD, or the URL's #fragment).
+	Path          string `json:"path,omitempty"`           // Serve this directory of the repository instead of its root.
+	FetchInterval any    `json:"fetch_interval,omitempty"` // How often to fetch from the repository and check the ref for new commits (default: 1m; "0" disables).
+	CacheDir      string `json:"cache_dir,omitempty"`      // Where remote repositories are cloned to (default: a directory in the system temporary directory).
+	lock          sync.Mutex
+	fetchLock     sync.Mutex
+	snapshot      *gitSnapshot
+}
+
+// the files of the commit currently being served.
+type gitSnapshot struct {
+	commit *GitCommit
+	fs     *indexFS
+	files  sync.Map // path -> *GitCommit, the last commit that changed each file
+}
+
+// Describes a commit; the commit that last changed a file served from a GitMount is available to
+// templates rendering it as $.git.
+type GitCommit struct {
+	Ref         string    `json:"ref"`       // the ref being served
+	Head        string    `json:"head"`      // the commit being served
+	SHA         string    `json:"sha"`       // the last commit (at or before head) to change the file
+	ShortSHA    string    `json:"short_sha"` // an abbreviated form of SHA
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"email"`
+	Date        time.Time `json:"date"`
+	Subject     string    `json:"subject"` // the first line of the commit message
+}
+
+func (commit *GitCommit) asMap() (map[string]any, error) {
+	var rv map[string]any
+
+	if data, err := json.Marshal(commit); err == nil {
+		if err := json.Unmarshal(data, &rv); err == nil {
+			return rv, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+func (mount *GitMount) GetMountPoint() string {
+	return mount.MountPoint
+}
+
+func (mount *GitMount) GetTarget() string {
+	return mount.URL
+}
+
+func (mount *GitMount) WillRespondTo(name string, req *http.Request, requestBody io.Reader) bool {
+	return strings.HasPrefix(name, mount.GetMountPoint())
+}
+
+func (mount *GitMount) String() string {
+	return fmt.Sprintf("%T('%s')", mount, mount.GetMountPoint())
+}
+
+func (mount *GitMount) Open(name string) (http.File, error) {
+	return openAsHttpFile(mount, name)
+}
+
+func (mount *GitMount) OpenWithType(name string, req *http.Request, requestBody io.Reader) (*MountResponse, error) {
+	if snapshot, err := mount.current(); err == nil {
+		var files = &FileMount{
+			MountPoint: mount.MountPoint,
+			Path:       `/`,
+			FileSystem: snapshot.fs,
+		}
+
+		var response, err = files.OpenWithType(name, req, requestBody)
+
+		if response != nil {
+			if file, ok := response.GetPayload().(*httpFile); ok {
+				var filename = path.Join(`/`, strings.TrimPrefix(name, mount.MountPoint))
+
+				if commit, err := mount.fileCommit(snapshot, filename); err == nil {
+					file.SetModTime(commit.Date)
+					response.lastModified = commit.Date
+
+					if data, err := commit.asMap(); err == nil {
+						response.templateData = map[string]any{
+							`git`: data,
+						}
+					} else {
+						log.Warningf("git: %v", err)
+					}
+				} else {
+					log.Warningf("git: %v", err)
+				}
+			}
+		}
+
+		return response, err
+	} else {
+		return nil, err
+	}
+}
+
+func (mount *GitMount) ref() string {
+	if mount.Ref != `` {
+		return mount.Ref
+	} else if u, err := url.Parse(mount.URL); err == nil && u.Fragment != `` {
+		return u.Fragment
+	} else {
+		return DefaultGitRef
+	}
+}
+
+func (mount *GitMount) fetchInterval() time.Duration {
+	if mount.FetchInterval == nil {
+		return DefaultGitFetchInterval
+	} else {
+		return typeutil.Duration(mount.FetchInterval)
+	}
+}
+
+// the path of a local repository, or the URL a remote one is cloned from.
+func (mount *GitMount) source() (string, bool) {
+	var source = mount.URL
+
+	if i := strings.Index(source, `#`); i >= 0 {
+		source = source[:i]
+	}
+
+	if strings.HasPrefix(source, `git+file://`) {
+		return strings.TrimPrefix(source, `git+file://`), true
+	} else {
+		return strings.TrimPrefix(source, `git+`), false
+	}
+}
+
+// the repository commands are run in; remote repositories are cloned here.
+func (mount *GitMount) repository() string {
+	if source, local := mount.source(); local {
+		return source
+	} else if mount.CacheDir != `` {
+		return mount.CacheDir
+	} else {
+		var sum = sha256.Sum256([]byte(source))
+		return filepath.Join(os.TempDir(), `diecast-git`, hex.EncodeToString(sum[:8])+`.git`)
+	}
+}
+
+// the repository's git directory: the repository itself if it is bare, otherwise its .git directory.
+func (mount *GitMount) gitDir() string {
+	var repo = mount.repository()
+
+	if info, err := os.Stat(filepath.Join(repo, `.git`)); err == nil && info.IsDir() {
+		return filepath.Join(repo, `.git`)
+	}
+
+	return repo
+}
+
+// run a git command, returning its output.  The repository is read regardless of who owns it, and
+// git never prompts for credentials.
+func runGit(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	var cmd = exec.Command(`git`, append([]string{`-c`, `safe.directory=*`}, args...)...)
+
+	cmd.Env = append(os.Environ(), `GIT_TERMINAL_PROMPT=0`)
+	cmd.Stderr = &stderr
+
+	if out, err := cmd.Output(); err == nil {
+		return out, nil
+	} else if msg := strings.TrimSpace(stderr.String()); msg != `` {
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	} else {
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+}
+
+// run a git command in the mount's repository.
+func (mount *GitMount) repo(args ...string) ([]byte, error) {
+	return runGit(append([]string{`--git-dir`, mount.gitDir()}, args...)...)
+}
+
+// returns the snapshot of the commit being served, fetching it the first time the mount is used.
+func (mount *GitMount) current() (*gitSnapshot, error) {
+	mount.lock.Lock()
+	var snapshot = mount.snapshot
+	mount.lock.Unlock()
+
+	if snapshot == nil {
+		if err := mount.Fetch(); err != nil {
+			return nil, err
+		}
+
+		mount.lock.Lock()
+		snapshot = mount.snapshot
+		mount.lock.Unlock()
+	}
+
+	return snapshot, nil
+}
+
+// Fetch new commits from the repository (cloning it first if necessary), and start serving the
+// commit that the mount's ref now refers to.
+func (mount *GitMount) Fetch() error {
+	mount.fetchLock.Lock()
+	defer mount.fetchLock.Unlock()
+
+	var repo = mount.repository()
+
+	if source, local := mount.source(); !local {
+		if _, err := os.Stat(filepath.Join(repo, `HEAD`)); os.IsNotExist(err) {
+			log.Infof("git: cloning %s into %s", source, repo)
+
+			if err := os.MkdirAll(filepath.Dir(repo), 0700); err != nil {
+				return err
+			}
+
+			if _, err := runGit(`clone`, `--bare`, `--quiet`, source, repo); err != nil {
+				return err
+			}
+		} else if _, err := mount.repo(`fetch`, `--quiet`, `--prune`, `--force`, source, `+refs/heads/*:refs/heads/*`, `+refs/tags/*:refs/tags/*`); err != nil {
+			return err
+		}
+	}
+
+	if out, err := mount.repo(`rev-parse`, `--verify`, `--quiet`, mount.ref()+`^{commit}`); err == nil {
+		var sha = strings.TrimSpace(string(out))
+
+		mount.lock.Lock()
+		var current = mount.snapshot
+		mount.lock.Unlock()
+
+		if current != nil && current.commit.Head == sha {
+			return nil
+		}
+
+		if snapshot, err := mount.load(sha); err == nil {
+			log.Infof("git: %s serving %s at %s", mount.MountPoint, mount.ref(), snapshot.commit.ShortSHA)
+
+			mount.lock.Lock()
+			mount.snapshot = snapshot
+			mount.lock.Unlock()
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return fmt.Errorf("git: %s: no such ref %q", mount.URL, mount.ref())
+	}
+}
+
+// read the index of files in the given commit
+func (mount *GitMount) load(sha string) (*gitSnapshot, error) {
+	var snapshot = new(gitSnapshot)
+
+	if commit, err := mount.commit(sha); err == nil {
+		snapshot.commit = commit
+	} else {
+		return nil, err
+	}
+
+	var treeish = sha
+
+	if dir := strings.Trim(mount.Path, `/`); dir != `` {
+		treeish = sha + `:` + dir
+	}
+
+	snapshot.fs = newIndexFS(snapshot.commit.Date)
+
+	// each entry is "<mode> <type> <object> <size>\t<path>", NUL-terminated
+	if out, err := mount.repo(`ls-tree`, `-r`, `-t`, `-l`, `-z`, treeish); err == nil {
+		for _, line := range strings.Split(string(out), "\x00") {
+			var meta, name, ok = strings.Cut(line, "\t")
+
+			if !ok {
+				continue
+			}
+
+			var fields = strings.Fields(meta)
+
+			if len(fields) != 4 {
+				continue
+			}
+
+			switch fields[1] {
+			case `tree`:
+				snapshot.fs.addDir(name, snapshot.commit.Date)
+			case `blob`:
+				var object = fields[2]
+
+				snapshot.fs.addFile(name, typeutil.Int(fields[3]), snapshot.commit.Date, func() ([]byte, error) {
+					return mount.repo(`cat-file`, `blob`, object)
+				})
+			}
+		}
+	} else {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// describe the given commit.
+func (mount *GitMount) commit(rev string, paths ...string) (*GitCommit, error) {
+	var args = []string{`log`, `-1`, gitCommitFormat, rev}
+
+	if len(paths) > 0 {
+		args = append(append(args, `--`), paths...)
+	}
+
+	if out, err := mount.repo(args...); err == nil {
+		var fields = strings.SplitN(strings.TrimSpace(string(out)), "\x00", 5)
+
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("no commit found for %s", rev)
+		}
+
+		var commit = &GitCommit{
+			Ref:         mount.ref(),
+			Head:        fields[0],
+			SHA:         fields[0],
+			ShortSHA:    fields[0],
+			Author:      fields[1],
+			AuthorEmail: fields[2],
+			Subject:     fields[4],
+		}
+
+		if len(commit.ShortSHA) > 7 {
+			commit.ShortSHA = commit.ShortSHA[:7]
+		}
+
+		if date, err := time.Parse(time.RFC3339, fields[3]); err == nil {
+			commit.Date = date
+		} else {
+			return nil, err
+		}
+
+		return commit, nil
+	} else {
+		return nil, err
+	}
+}
+
+// describe the last commit that changed the given file in the snapshot.
+func (mount *GitMount) fileCommit(snapshot *gitSnapshot, filename string) (*GitCommit, error) {
+	if cached, ok := snapshot.files.Load(filename); ok {
+		return cached.(*GitCommit), nil
+	}
+
+	var repoPath = strings.TrimPrefix(path.Join(`/`, mount.Path, filename), `/`)
+
+	if commit, err := mount.commit(snapshot.commit.Head, repoPath); err == nil {
+		commit.Head = snapshot.commit.Head
+		snapshot.files.Store(filename, commit)
+
+		return commit, nil
+	} else {
+		return nil, err
+	}
+}
+
+// periodically fetch from the repository until stop is closed.
+func (mount *GitMount) startFetching(stop <-chan struct{}) {
+	var interval = mount.fetchInterval()
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := mount.Fetch(); err != nil {
+					log.Warningf("git: %s: %v", mount.MountPoint, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package diecast
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+)
+
+// A read-only http.FileSystem over an index of files whose contents are loaded on demand.  This is
+// used by mounts that serve files from something other than a directory on disk.
+type indexFS struct {
+	entries map[string]*indexEntry
+}
+
+type indexEntry struct {
+	info     *fileutil.FileInfo
+	children map[string]*indexEntry
+	open     func() ([]byte, error)
+}
+
+func newIndexFS(modTime time.Time) *indexFS {
+	var ifs = &indexFS{
+		entries: make(map[string]*indexEntry),
+	}
+
+	ifs.entries[`/`] = newIndexDir(`/`, modTime)
+
+	return ifs
+}
+
+func newIndexDir(name string, modTime time.Time) *indexEntry {
+	var info = fileutil.NewFileInfo(nil)
+
+	info.SetName(path.Base(name))
+	info.SetIsDir(true)
+	info.SetMode(os.ModeDir | 0555)
+	info.SetModTime(modTime)
+
+	return &indexEntry{
+		info:     info,
+		children: make(map[string]*indexEntry),
+	}
+}
+
+// add a directory to the index, along with any missing parent directories.
+func (ifs *indexFS) addDir(name string, modTime time.Time) *indexEntry {
+	name = path.Join(`/`, name)
+
+	if entry, ok := ifs.entries[name]; ok {
+		return entry
+	}
+
+	var entry = newIndexDir(name, modTime)
+
+	ifs.entries[name] = entry
+	ifs.addDir(path.Dir(name), modTime).children[path.Base(name)] = entry
+
+	return entry
+}
+
+// add a file to the index, along with any missing parent directories.  The open function is called
+// to read the file's contents each time it is opened.
+func (ifs *indexFS) addFile(name string, size int64, modTime time.Time, open func() ([]byte, error)) {
+	name = path.Join(`/`, name)
+
+	var info = fileutil.NewFileInfo(nil)
+
+	info.SetName(path.Base(name))
+	info.SetIsDir(false)
+	info.SetMode(0444)
+	info.SetSize(size)
+	info.SetModTime(modTime)
+
+	var entry = &indexEntry{
+		info: info,
+		open: open,
+	}
+
+	ifs.entries[name] = entry
+	ifs.addDir(path.Dir(name), modTime).children[path.Base(name)] = entry
+}
+
+func (ifs *indexFS) Open(name string) (http.File, error) {
+	name = path.Join(`/`, name)
+
+	if entry, ok := ifs.entries[name]; ok {
+		if entry.info.IsDir() {
+			return &indexDir{
+				entry: entry,
+			}, nil
+		} else if data, err := entry.open(); err == nil {
+			var file = newHttpFile(entry.info.Name(), data)
+
+			file.SetMode(entry.info.Mode())
+			file.SetModTime(entry.info.ModTime())
+
+			return file, nil
+		} else {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	} else {
+		return nil, &os.PathError{
+			Op:   `open`,
+			Path: name,
+			Err:  os.ErrNotExist,
+		}
+	}
+}
+
+// a directory opened from an indexFS.
+type indexDir struct {
+	entry  *indexEntry
+	offset int
+}
+
+func (dir *indexDir) Close() error {
+	return nil
+}
+
+func (dir *indexDir) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("is a directory")
+}
+
+func (dir *indexDir) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func (dir *indexDir) Stat() (os.FileInfo, error) {
+	return dir.entry.info, nil
+}
+
+// behaves as os.File.Readdir does, returning entries in name order.
+func (dir *indexDir) Readdir(count int) ([]os.FileInfo, error) {
+	var names = make([]string, 0, len(dir.entry.children))
+
+	for name := range dir.entry.children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if dir.offset >= len(names) {
+		if count > 0 {
+			return nil, io.EOF
+		} else {
+			return nil, nil
+		}
+	}
+
+	names = names[dir.offset:]
+
+	if count > 0 && count < len(names) {
+		names = names[:count]
+	}
+
+	var infos = make([]os.FileInfo, 0, len(names))
+
+	for _, name := range names {
+		infos = append(infos, dir.entry.children[name].info)
+	}
+
+	dir.offset += len(infos)
+
+	return infos, nil
+}
@@ -2,12 +2,14 @@ package diecast
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghetzel/go-stockutil/httputil"
@@ -17,36 +19,50 @@ import (
 	"github.com/ghetzel/go-stockutil/stringutil"
 	"github.com/ghetzel/go-stockutil/typeutil"
 	opentracing "github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var DefaultProxyMountTimeout = time.Duration(10) * time.Second
 var MaxBufferedBodySize int64 = 16535
 
 type ProxyMount struct {
-	MountPoint              string         `json:"-"`
-	URL                     string         `json:"-"`
-	Method                  string         `json:"method,omitempty"`
-	Headers                 map[string]any `json:"headers,omitempty"`
-	ResponseHeaders         map[string]any `json:"response_headers,omitempty"`
-	ResponseCode            int            `json:"response_code"`
-	RedirectOnSuccess       string         `json:"redirect_on_success"`
-	Params                  map[string]any `json:"params,omitempty"`
-	Timeout                 any            `json:"timeout,omitempty"`
-	PassthroughRequests     bool           `json:"passthrough_requests"`
-	PassthroughHeaders      bool           `json:"passthrough_headers"`
-	PassthroughQueryStrings bool           `json:"passthrough_query_strings"`
-	PassthroughBody         bool           `json:"passthrough_body"`
-	PassthroughErrors       bool           `json:"passthrough_errors"`
-	PassthroughRedirects    bool           `json:"passthrough_redirects"`
-	PassthroughUserAgent    bool           `json:"passthrough_user_agent"`
-	StripPathPrefix         string         `json:"strip_path_prefix"`
-	AppendPathPrefix        string         `json:"append_path_prefix"`
-	Insecure                bool           `json:"insecure"`
-	BodyBufferSize          int64          `json:"body_buffer_size"`
-	CloseConnection         *bool          `json:"close_connection"`
+	MountPoint              string              `json:"-"`
+	URL                     string              `json:"-"`
+	Method                  string              `json:"method,omitempty"`
+	Headers                 map[string]any      `json:"headers,omitempty"`
+	ResponseHeaders         map[string]any      `json:"response_headers,omitempty"`
+	ResponseCode            int                 `json:"response_code"`
+	RedirectOnSuccess       string              `json:"redirect_on_success"`
+	Params                  map[string]any      `json:"params,omitempty"`
+	Timeout                 any                 `json:"timeout,omitempty"`
+	PassthroughRequests     bool                `json:"passthrough_requests"`
+	PassthroughHeaders      bool                `json:"passthrough_headers"`
+	PassthroughQueryStrings bool                `json:"passthrough_query_strings"`
+	PassthroughBody         bool                `json:"passthrough_body"`
+	PassthroughErrors       bool                `json:"passthrough_errors"`
+	PassthroughRedirects    bool                `json:"passthrough_redirects"`
+	PassthroughUserAgent    bool                `json:"passthrough_user_agent"`
+	StripPathPrefix         string              `json:"strip_path_prefix"`
+	AppendPathPrefix        string              `json:"append_path_prefix"`
+	Insecure                bool                `json:"insecure"`
+	BodyBufferSize          int64               `json:"body_buffer_size"`
+	CloseConnection         *bool               `json:"close_connection"`
+	Upstreams               []*ProxyUpstream    `json:"upstreams,omitempty"`    // If set, requests are load balanced across these upstream URLs instead of being sent to URL.
+	Balance                 string              `json:"balance,omitempty"`      // How an upstream is chosen for each request: "round_robin" (the default), "least_conn", or "hash".
+	HashHeader              string              `json:"hash_header,omitempty"`  // For the "hash" strategy, the request header whose value consistently selects the same upstream.
+	HealthCheck             *ProxyHealthCheck   `json:"health_check,omitempty"` // Actively check the health of each upstream, and stop sending requests to those that fail.
+	EjectAfter              int                 `json:"eject_after,omitempty"`  // Stop sending requests to an upstream after this many consecutive connection errors.
+	EjectFor                any                 `json:"eject_for,omitempty"`    // How long an upstream is ejected for after repeated connection errors.
+	RewriteHeaders          bool                `json:"rewrite_headers"`        // Rewrite Location, Content-Location, and Set-Cookie headers that refer to the upstream so they refer to this mount instead.
+	RewriteHTML             bool                `json:"rewrite_html"`           // Rewrite href, src, and action attributes in HTML responses that refer to the upstream so they refer to this mount instead.
+	Replace                 []*ProxyReplacement `json:"replace,omitempty"`      // Regular expression replacements applied to textual response bodies.
+	Template                bool                `json:"template"`               // Render HTML responses in a layout, with the proxied document available to templates as $.proxy.
+	Layout                  string              `json:"layout,omitempty"`       // The layout HTML responses are rendered in when Template is set (default: "default").
 	Client                  *http.Client
 	urlRewriteFrom          string
 	urlRewriteTo            string
+	balancerLock            sync.Mutex
+	balancerNext            int
 }
 
 func (mount *ProxyMount) GetMountPoint() string {
@@ -89,24 +105,48 @@ func (mount *ProxyMount) OpenWithType(name string, req *http.Request, requestBod
 		opentracing.HTTPHeadersCarrier(traceHeaders),
 	)
 
+	var otelSpan, otelHeaders = startOtelChildSpan(req, traceName, attribute.String(`diecast.mount`, mount.MountPoint))
+
+	for k := range otelHeaders {
+		traceHeaders.Set(k, otelHeaders.Get(k))
+	}
+
 	res, err = mount.openWithType(name, req, requestBody, traceHeaders)
 
 	if err == nil {
 		if res.RedirectTo != `` {
 			childSpan.SetTag(`http.status_code`, res.RedirectCode)
 			childSpan.SetTag(`http.redirect`, res.RedirectTo)
+
+			if otelSpan != nil {
+				otelSpan.SetAttributes(attribute.Int(`http.status_code`, res.RedirectCode), attribute.String(`http.redirect`, res.RedirectTo))
+			}
 		} else {
 			childSpan.SetTag(`http.status_code`, res.StatusCode)
+
+			if otelSpan != nil {
+				otelSpan.SetAttributes(attribute.Int(`http.status_code`, res.StatusCode))
+			}
 		}
 	} else {
 		childSpan.SetTag(`error`, err.Error())
 	}
 
 	childSpan.Finish()
+	finishOtelSpan(otelSpan, err)
 	return
 }
 
-func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBody io.Reader, _ http.Header) (*MountResponse, error) {
+func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBody io.Reader, traceHeaders http.Header) (*MountResponse, error) {
+	if len(mount.Upstreams) > 0 {
+		return mount.openBalanced(name, req, requestBody, traceHeaders)
+	} else {
+		return mount.proxyTo(mount.url(), name, req, requestBody, traceHeaders)
+	}
+}
+
+// proxy the request to the given upstream URL.
+func (mount *ProxyMount) proxyTo(upstreamURL string, name string, req *http.Request, requestBody io.Reader, traceHeaders http.Header) (*MountResponse, error) {
 	var id = reqid(req)
 	var proxyURI string
 	var timeout time.Duration
@@ -152,7 +192,7 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 	}
 
 	if req != nil && (mount.PassthroughRequests || mount.PassthroughQueryStrings) {
-		if newURL, err := url.Parse(mount.url()); err == nil {
+		if newURL, err := url.Parse(upstreamURL); err == nil {
 			req.URL.Scheme = newURL.Scheme
 			req.URL.Host = newURL.Host
 
@@ -181,7 +221,7 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 		}
 	} else {
 		proxyURI = strings.Join([]string{
-			strings.TrimSuffix(mount.url(), `/`),
+			strings.TrimSuffix(upstreamURL, `/`),
 			strings.TrimPrefix(name, `/`),
 		}, `/`)
 	}
@@ -218,6 +258,11 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 			}
 		}
 
+		// continue the current trace (if any) in the upstream server
+		for name := range traceHeaders {
+			newReq.Header.Set(name, traceHeaders.Get(name))
+		}
+
 		// user-agent is either ours, overridden in explicit headers below, or passthrough from request
 		if !mount.PassthroughUserAgent {
 			newReq.Header.Set(`User-Agent`, DiecastUserAgentString)
@@ -239,6 +284,10 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 
 		newReq.Header.Set(`Accept-Encoding`, `identity`)
 
+		if isWebsocketUpgrade(req) {
+			copyUpgradeHeaders(req.Header, newReq.Header)
+		}
+
 		// inject params into new request
 		for name, value := range mount.Params {
 			if newReq.URL.Query().Get(name) == `` {
@@ -295,13 +344,43 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 		// perform the request
 		// -----------------------------------------------------------------------------------------
 		log.Debugf("[%s] proxy: sending request to %s://%s", id, newReq.URL.Scheme, newReq.URL.Host)
+		var client = mount.Client
+		var streaming bool
+		var headerTimer *time.Timer
+		var done context.CancelFunc
+
+		if isWebsocketUpgrade(req) || acceptsEventStream(req) {
+			client, newReq, headerTimer, done = mount.streamingRequest(req, newReq)
+		}
+
 		var reqStartAt = time.Now()
-		response, err := mount.Client.Do(newReq)
+		response, err := client.Do(newReq)
 		log.Debugf("[%s] proxy: responded in %v", id, time.Since(reqStartAt))
 
+		if headerTimer != nil {
+			headerTimer.Stop()
+		}
+
 		if err == nil {
-			if response.Body != nil {
-				defer response.Body.Close()
+			// upgraded connections and event streams outlive this call, and are closed elsewhere
+			defer func() {
+				if !streaming {
+					if response.Body != nil {
+						response.Body.Close()
+					}
+
+					if done != nil {
+						done()
+					}
+				}
+			}()
+
+			if response.StatusCode == http.StatusSwitchingProtocols {
+				return mount.tunnel(name, req, response)
+			}
+
+			if mount.RewriteHeaders {
+				mount.rewriteResponseHeaders(req, newReq.URL, response.Header)
 			}
 
 			// add explicit response headers to response
@@ -343,6 +422,11 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 			)
 
 			if response.StatusCode < 400 || mount.PassthroughErrors {
+				if isEventStream(response) {
+					streaming = true
+					return mount.streamResponse(name, response, done), nil
+				}
+
 				var responseBody io.Reader
 
 				if body, err := httputil.DecodeResponse(response); err == nil {
@@ -355,6 +439,22 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 				}
 
 				if data, err := io.ReadAll(responseBody); err == nil {
+					var contentType = response.Header.Get(`Content-Type`)
+					var document map[string]any
+
+					if data, err = mount.rewriteBody(newReq.URL, contentType, data); err != nil {
+						return nil, fmt.Errorf("proxy response: %v", err)
+					}
+
+					if mount.Template && isHTML(contentType) {
+						if document, err = proxyDocument(data); err == nil {
+							data = []byte(proxyDocumentTemplate)
+							response.Header.Del(`Content-Length`)
+						} else {
+							return nil, fmt.Errorf("proxy response: %v", err)
+						}
+					}
+
 					var payload = bytes.NewReader(data)
 
 					// correct the length, which is now potentially decompressed and longer
@@ -363,12 +463,22 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 
 					var mountResponse = NewMountResponse(name, payload.Size(), payload)
 					mountResponse.StatusCode = response.StatusCode
-					mountResponse.ContentType = response.Header.Get(`Content-Type`)
+					mountResponse.ContentType = contentType
 
-					for k, v := range response.Header {
-						mountResponse.Metadata[k] = strings.Join(v, `,`)
+					if document != nil {
+						mountResponse.templateData = map[string]any{
+							`proxy`: document,
+						}
+
+						mountResponse.layout = mount.Layout
+
+						if mountResponse.layout == `` {
+							mountResponse.layout = `default`
+						}
 					}
 
+					copyResponseMetadata(response.Header, mountResponse.Metadata)
+
 					return mountResponse, nil
 				} else {
 					return nil, fmt.Errorf("proxy response: %v", err)
@@ -385,6 +495,10 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 				return nil, ErrMountHalt
 			}
 		} else {
+			if done != nil {
+				done()
+			}
+
 			return nil, err
 		}
 	} else {
@@ -392,9 +506,24 @@ func (mount *ProxyMount) openWithType(name string, req *http.Request, requestBod
 	}
 }
 
+// copy upstream response headers into a mount response's metadata.  Set-Cookie headers can't be
+// combined into a single value, so they are kept as a list.
+func copyResponseMetadata(header http.Header, metadata map[string]any) {
+	for k, v := range header {
+		if k == `Set-Cookie` {
+			metadata[k] = v
+		} else {
+			metadata[k] = strings.Join(v, `,`)
+		}
+	}
+}
+
 func (mount *ProxyMount) url() string {
-	var uri = mount.URL
+	return mount.rewriteURL(mount.URL)
+}
 
+// apply any rewriting made necessary by an earlier redirect to the given upstream URL.
+func (mount *ProxyMount) rewriteURL(uri string) string {
 	if from := mount.urlRewriteFrom; from != `` {
 		if to := mount.urlRewriteTo; to != `` {
 			uri = strings.Replace(uri, from, to, 1)
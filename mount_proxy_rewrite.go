@@ -0,0 +1,245 @@
+package diecast
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// the template proxied HTML documents are rendered with; the document itself is never evaluated.
+const proxyDocumentTemplate = `{{ unsafe $.proxy.body }}`
+
+// the HTML attributes that are rewritten to refer to the mount instead of the upstream.
+var proxyRewriteAttributes = []string{
+	`href`,
+	`src`,
+	`action`,
+}
+
+// A regular expression replacement applied to proxied response bodies.
+type ProxyReplacement struct {
+	Pattern     string `json:"pattern"`     // A regular expression matched against the response body.
+	Replacement string `json:"replacement"` // What each match is replaced with; capture groups may be referred to as $1 or ${name}.
+	compile     sync.Once
+	rx          *regexp.Regexp
+	err         error
+}
+
+func (replacement *ProxyReplacement) compiled() (*regexp.Regexp, error) {
+	replacement.compile.Do(func() {
+		if rx, err := regexp.Compile(replacement.Pattern); err == nil {
+			replacement.rx = rx
+		} else {
+			replacement.err = fmt.Errorf("invalid replacement pattern %q: %v", replacement.Pattern, err)
+		}
+	})
+
+	return replacement.rx, replacement.err
+}
+
+func isHTML(contentType string) bool {
+	var mediaType, _, _ = mime.ParseMediaType(contentType)
+
+	return mediaType == `text/html` || mediaType == `application/xhtml+xml`
+}
+
+// whether the content type is one that can reasonably have text replacements applied to it.
+func isText(contentType string) bool {
+	var mediaType, _, _ = mime.ParseMediaType(contentType)
+
+	switch {
+	case strings.HasPrefix(mediaType, `text/`):
+		return true
+	case strings.HasSuffix(mediaType, `+json`), strings.HasSuffix(mediaType, `+xml`):
+		return true
+	}
+
+	switch mediaType {
+	case `application/json`, `application/javascript`, `application/x-javascript`, `application/xml`:
+		return true
+	default:
+		return false
+	}
+}
+
+// rewrite a URL or absolute path that refers to the upstream so that it refers to the same resource
+// by way of this mount, reversing the mount's StripPathPrefix and AppendPathPrefix.  References
+// to other hosts and relative paths are returned unchanged.
+func (mount *ProxyMount) rewriteReference(upstream *url.URL, ref string) (string, bool) {
+	var u, err = url.Parse(ref)
+
+	if err != nil || u.Opaque != `` {
+		return ref, false
+	} else if u.Scheme != `` || u.Host != `` {
+		if u.Scheme != `` && u.Scheme != `http` && u.Scheme != `https` {
+			return ref, false
+		} else if !strings.EqualFold(u.Host, upstream.Host) {
+			return ref, false
+		}
+	} else if !strings.HasPrefix(u.Path, `/`) {
+		return ref, false
+	}
+
+	var path = u.Path
+
+	if path == `` {
+		path = `/`
+	}
+
+	if pp := strings.TrimSuffix(mount.AppendPathPrefix, `/`); pp != `` {
+		if path == pp {
+			path = `/`
+		} else if strings.HasPrefix(path, pp+`/`) {
+			path = strings.TrimPrefix(path, pp)
+		} else {
+			// not something the mount could have proxied to
+			return ref, false
+		}
+	}
+
+	if pp := strings.TrimSuffix(mount.StripPathPrefix, `/`); pp != `` {
+		path = pp + path
+	}
+
+	u.Scheme = ``
+	u.Host = ``
+	u.User = nil
+	u.Path = path
+	u.RawPath = ``
+
+	return u.String(), true
+}
+
+// rewrite response headers that refer to the upstream (redirects and cookies) so they refer to this
+// mount and the host the client requested instead.
+func (mount *ProxyMount) rewriteResponseHeaders(req *http.Request, upstream *url.URL, header http.Header) {
+	for _, name := range []string{`Location`, `Content-Location`} {
+		if value := header.Get(name); value != `` {
+			if rewritten, ok := mount.rewriteReference(upstream, value); ok {
+				header.Set(name, rewritten)
+			}
+		}
+	}
+
+	var host string
+
+	if req != nil {
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = h
+		} else {
+			host = req.Host
+		}
+	}
+
+	if cookies := header.Values(`Set-Cookie`); len(cookies) > 0 {
+		header.Del(`Set-Cookie`)
+
+		for _, cookie := range cookies {
+			header.Add(`Set-Cookie`, mount.rewriteCookie(upstream, host, cookie))
+		}
+	}
+}
+
+// rewrite the Path and Domain attributes of a Set-Cookie header value, leaving everything else as-is.
+func (mount *ProxyMount) rewriteCookie(upstream *url.URL, host string, cookie string) string {
+	var attrs = strings.Split(cookie, `;`)
+
+	for i, attr := range attrs {
+		if i == 0 {
+			continue
+		}
+
+		var name, value, _ = strings.Cut(strings.TrimSpace(attr), `=`)
+
+		switch strings.ToLower(name) {
+		case `path`:
+			if rewritten, ok := mount.rewriteReference(upstream, value); ok {
+				attrs[i] = ` ` + name + `=` + rewritten
+			}
+		case `domain`:
+			var domain = strings.ToLower(strings.TrimPrefix(value, `.`))
+			var upstreamHost = strings.ToLower(upstream.Hostname())
+
+			if host != `` && (upstreamHost == domain || strings.HasSuffix(upstreamHost, `.`+domain)) {
+				attrs[i] = ` ` + name + `=` + host
+			}
+		}
+	}
+
+	return strings.Join(attrs, `;`)
+}
+
+// apply HTML link rewriting and any replacements to a proxied response body.
+func (mount *ProxyMount) rewriteBody(upstream *url.URL, contentType string, data []byte) ([]byte, error) {
+	if mount.RewriteHTML && isHTML(contentType) {
+		if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data)); err == nil {
+			var rewrote bool
+
+			for _, attr := range proxyRewriteAttributes {
+				doc.Find(`[` + attr + `]`).Each(func(_ int, el *goquery.Selection) {
+					if rewritten, ok := mount.rewriteReference(upstream, el.AttrOr(attr, ``)); ok {
+						el.SetAttr(attr, rewritten)
+						rewrote = true
+					}
+				})
+			}
+
+			// only re-serialize documents that were changed, since doing so normalizes the markup
+			if rewrote {
+				if html, err := doc.Html(); err == nil {
+					data = []byte(html)
+				} else {
+					return nil, err
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	if len(mount.Replace) > 0 && isText(contentType) {
+		for _, replacement := range mount.Replace {
+			if rx, err := replacement.compiled(); err == nil {
+				data = rx.ReplaceAll(data, []byte(replacement.Replacement))
+			} else {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// split a proxied HTML document into the parts exposed to templates as $.proxy when it is rendered
+// in a layout.
+func proxyDocument(data []byte) (map[string]any, error) {
+	if doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data)); err == nil {
+		var document = map[string]any{
+			`title`: strings.TrimSpace(doc.Find(`title`).First().Text()),
+		}
+
+		if head, err := doc.Find(`head`).Html(); err == nil {
+			document[`head`] = head
+		} else {
+			return nil, err
+		}
+
+		if body, err := doc.Find(`body`).Html(); err == nil {
+			document[`body`] = body
+		} else {
+			return nil, err
+		}
+
+		return document, nil
+	} else {
+		return nil, err
+	}
+}
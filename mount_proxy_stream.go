@@ -0,0 +1,226 @@
+package diecast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// whether the request is asking to be upgraded to a WebSocket connection.
+func isWebsocketUpgrade(req *http.Request) bool {
+	if req == nil || !strings.EqualFold(req.Header.Get(`Upgrade`), `websocket`) {
+		return false
+	}
+
+	for _, value := range req.Header.Values(`Connection`) {
+		for _, token := range strings.Split(value, `,`) {
+			if strings.EqualFold(strings.TrimSpace(token), `upgrade`) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// whether the request is from a client expecting a stream of Server-Sent Events.
+func acceptsEventStream(req *http.Request) bool {
+	return req != nil && strings.Contains(req.Header.Get(`Accept`), `text/event-stream`)
+}
+
+func isEventStream(response *http.Response) bool {
+	var mediaType, _, _ = mime.ParseMediaType(response.Header.Get(`Content-Type`))
+
+	return mediaType == `text/event-stream`
+}
+
+// copy the headers needed to negotiate a WebSocket upgrade, which are sent upstream regardless of
+// whether headers are otherwise passed through.
+func copyUpgradeHeaders(from http.Header, to http.Header) {
+	to.Set(`Connection`, `Upgrade`)
+	to.Set(`Upgrade`, from.Get(`Upgrade`))
+
+	for name, values := range from {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), `Sec-Websocket-`) {
+			to[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+}
+
+// long-lived requests can't be subject to the client's overall timeout, so the timeout only applies
+// until the response headers are received (at which point the returned timer must be stopped).  The
+// returned function must be called once the response is no longer needed.
+func (mount *ProxyMount) streamingRequest(req *http.Request, newReq *http.Request) (*http.Client, *http.Request, *time.Timer, context.CancelFunc) {
+	var client = *mount.Client
+	var timeout = client.Timeout
+	var ctx, cancel = context.WithCancel(req.Context())
+
+	if timeout <= 0 {
+		timeout = DefaultProxyMountTimeout
+	}
+
+	client.Timeout = 0
+
+	return &client, newReq.WithContext(ctx), time.AfterFunc(timeout, cancel), cancel
+}
+
+// returns a response that streams the upstream response body to the client as it arrives.
+func (mount *ProxyMount) streamResponse(name string, response *http.Response, done context.CancelFunc) *MountResponse {
+	var mountResponse = NewMountResponse(name, -1, &streamBody{
+		ReadCloser: response.Body,
+		done:       done,
+	})
+
+	mountResponse.StatusCode = response.StatusCode
+	mountResponse.ContentType = response.Header.Get(`Content-Type`)
+	mountResponse.streaming = true
+
+	response.Header.Del(`Content-Length`)
+
+	copyResponseMetadata(response.Header, mountResponse.Metadata)
+
+	return mountResponse
+}
+
+// take over the client's connection and relay data between it and the upgraded upstream connection
+// until either side closes it.
+func (mount *ProxyMount) tunnel(name string, req *http.Request, response *http.Response) (*MountResponse, error) {
+	var id = reqid(req)
+	var upstream, ok = response.Body.(io.ReadWriteCloser)
+
+	if !ok {
+		response.Body.Close()
+		return nil, fmt.Errorf("proxy: upstream connection cannot be upgraded")
+	}
+
+	defer upstream.Close()
+
+	var w = httputil.RequestGetValue(req, ContextResponseKey).Value
+	var hijacker, canHijack = w.(http.Hijacker)
+
+	if !canHijack {
+		return nil, fmt.Errorf("proxy: client connection cannot be upgraded")
+	}
+
+	conn, client, err := hijacker.Hijack()
+
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+
+	defer conn.Close()
+
+	if interceptor, ok := w.(*statusInterceptor); ok {
+		interceptor.code = response.StatusCode
+	}
+
+	// relay the upstream's handshake response to the client
+	fmt.Fprintf(client, "HTTP/1.1 %s\r\n", response.Status)
+	response.Header.Write(client)
+	client.WriteString("\r\n")
+
+	if err := client.Flush(); err != nil {
+		return nil, fmt.Errorf("proxy: %v", err)
+	}
+
+	log.Debugf("[%s] proxy: tunneling upgraded connection", id)
+
+	var relayed = make(chan error, 2)
+
+	go func() {
+		// the client's buffered reader may already hold data sent after the handshake
+		var _, err = io.Copy(upstream, client)
+		relayed <- err
+	}()
+
+	go func() {
+		var _, err = io.Copy(conn, upstream)
+		relayed <- err
+	}()
+
+	// once either side is done, the deferred closes end the other
+	<-relayed
+
+	log.Debugf("[%s] proxy: upgraded connection closed", id)
+
+	var mountResponse = NewMountResponse(name, 0, nil)
+
+	mountResponse.StatusCode = response.StatusCode
+	mountResponse.hijacked = true
+
+	return mountResponse, nil
+}
+
+// an upstream response body that releases the upstream request when closed.
+type streamBody struct {
+	io.ReadCloser
+	done    context.CancelFunc
+	closers []func()
+	closed  sync.Once
+}
+
+// returns the streamed body of the given response, if it has one.
+func streamedBody(response *MountResponse) (*streamBody, bool) {
+	if response != nil && response.streaming {
+		if body, ok := response.GetPayload().(*streamBody); ok {
+			return body, true
+		}
+	}
+
+	return nil, false
+}
+
+// call the given function (once) when the body is closed.
+func (body *streamBody) onClose(fn func()) {
+	body.closers = append(body.closers, fn)
+}
+
+func (body *streamBody) Close() error {
+	var err = body.ReadCloser.Close()
+
+	body.closed.Do(func() {
+		if body.done != nil {
+			body.done()
+		}
+
+		for _, fn := range body.closers {
+			fn()
+		}
+	})
+
+	return err
+}
+
+// copy a streaming response to the client, flushing everything that is read immediately.
+func flushingCopy(w http.ResponseWriter, src io.Reader) error {
+	var flusher, canFlush = w.(http.Flusher)
+	var buf = make([]byte, 32*1024)
+
+	for {
+		var n, err = src.Read(buf)
+
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
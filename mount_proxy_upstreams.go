@@ -0,0 +1,465 @@
+package diecast
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/spaolacci/murmur3"
+)
+
+var DefaultProxyEjectAfter = 3
+var DefaultProxyEjectFor = 30 * time.Second
+var DefaultProxyHealthCheckInterval = 10 * time.Second
+var DefaultProxyHealthCheckTimeout = 2 * time.Second
+var ErrNoHealthyUpstreams = errors.New(`no healthy upstreams`)
+
+const (
+	BalanceRoundRobin = `round_robin`
+	BalanceLeastConn  = `least_conn`
+	BalanceHash       = `hash`
+)
+
+// One of the upstream servers a ProxyMount balances requests across.
+type ProxyUpstream struct {
+	URL          string `json:"url"`    // The base URL of the upstream.
+	Weight       int    `json:"weight"` // How many requests this upstream receives relative to the others (default: 1).
+	checked      bool
+	healthy      bool
+	active       int
+	failures     int
+	current      int
+	ejectedUntil time.Time
+	lastChecked  time.Time
+	lastError    string
+}
+
+// the state of an upstream, as reported by /_diecast/upstreams.
+type proxyUpstreamState struct {
+	URL          string     `json:"url"`
+	Weight       int        `json:"weight"`
+	Healthy      bool       `json:"healthy"`
+	Active       int        `json:"active"`
+	Failures     int        `json:"failures"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	LastChecked  *time.Time `json:"last_checked,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Specifies how the health of a ProxyMount's upstreams is actively checked.
+type ProxyHealthCheck struct {
+	Path     string `json:"path"`     // The path (relative to each upstream URL) to request.
+	Interval any    `json:"interval"` // How often each upstream is checked.
+	Timeout  any    `json:"timeout"`  // How long to wait for a response before the check fails.
+}
+
+func (upstream *ProxyUpstream) weight() int {
+	if upstream.Weight > 0 {
+		return upstream.Weight
+	}
+
+	return 1
+}
+
+func (upstream *ProxyUpstream) available(now time.Time) bool {
+	if upstream.checked && !upstream.healthy {
+		return false
+	}
+
+	return !now.Before(upstream.ejectedUntil)
+}
+
+// proxy the request to one of the mount's upstreams.  If an upstream cannot be reached, the request
+// is retried against the others (so long as the request body can be replayed).
+func (mount *ProxyMount) openBalanced(name string, req *http.Request, requestBody io.Reader, traceHeaders http.Header) (*MountResponse, error) {
+	var id = reqid(req)
+	var tried = make(map[*ProxyUpstream]bool)
+	var lastErr error
+	var original url.URL
+
+	if req != nil {
+		original = *req.URL
+	}
+
+	for len(tried) < len(mount.Upstreams) {
+		var upstream = mount.pickUpstream(req, tried)
+
+		if upstream == nil {
+			break
+		}
+
+		tried[upstream] = true
+
+		if len(tried) > 1 {
+			// replay the request from the beginning
+			if rb, ok := requestBody.(*RequestBody); ok {
+				rb.Close()
+			} else if requestBody != nil {
+				break
+			}
+
+			if req != nil {
+				*req.URL = original
+			}
+
+			log.Debugf("[%s] proxy: retrying with upstream %s", id, upstream.URL)
+		}
+
+		var response, err = mount.proxyTo(mount.rewriteURL(upstream.URL), name, req, requestBody, traceHeaders)
+
+		mount.upstreamDone(upstream, err)
+
+		// streamed responses are still connected to the upstream until the client is done with them
+		if body, ok := streamedBody(response); ok {
+			body.onClose(func() {
+				mount.releaseUpstream(upstream)
+			})
+		} else {
+			mount.releaseUpstream(upstream)
+		}
+
+		if err == nil || err == ErrMountHalt || !IsHardStop(err) {
+			return response, err
+		} else if !mount.canFailover(req, err) {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = &url.Error{
+			Op:  `Proxy`,
+			URL: mount.MountPoint,
+			Err: ErrNoHealthyUpstreams,
+		}
+	}
+
+	return nil, lastErr
+}
+
+// whether a request that failed with the given error can safely be sent to another upstream.  The
+// request may have been (partially) processed unless the connection was refused outright, so only
+// idempotent requests are retried otherwise.
+func (mount *ProxyMount) canFailover(req *http.Request, err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var method = strings.ToUpper(mount.Method)
+
+	if method == `` && req != nil {
+		method = req.Method
+	}
+
+	switch method {
+	case ``, http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// choose the upstream the next request should be sent to (skipping any already tried), and mark
+// the request as active on it.
+func (mount *ProxyMount) pickUpstream(req *http.Request, tried map[*ProxyUpstream]bool) *ProxyUpstream {
+	mount.balancerLock.Lock()
+	defer mount.balancerLock.Unlock()
+
+	var now = time.Now()
+	var candidates = make([]*ProxyUpstream, 0, len(mount.Upstreams))
+
+	// start from a different upstream each time, so that ties are broken evenly
+	for i := range mount.Upstreams {
+		var upstream = mount.Upstreams[(mount.balancerNext+i)%len(mount.Upstreams)]
+
+		if upstream != nil && !tried[upstream] && upstream.available(now) {
+			candidates = append(candidates, upstream)
+		}
+	}
+
+	mount.balancerNext++
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var picked *ProxyUpstream
+	var key string
+
+	if req != nil && mount.HashHeader != `` {
+		key = req.Header.Get(mount.HashHeader)
+	}
+
+	switch strategy := mount.Balance; {
+	case strategy == BalanceLeastConn:
+		for _, upstream := range candidates {
+			// compare active/weight ratios without dividing
+			if picked == nil || upstream.active*picked.weight() < picked.active*upstream.weight() {
+				picked = upstream
+			}
+		}
+
+	case strategy == BalanceHash && key != ``:
+		// rendezvous hashing: each key consistently prefers the same upstream, and only the keys
+		// belonging to an upstream that becomes unavailable are moved elsewhere.
+		var best float64
+
+		for _, upstream := range candidates {
+			var hash = murmur3.Sum64([]byte(key + "\x00" + upstream.URL))
+			var unit = (float64(hash>>11) + 0.5) / float64(uint64(1)<<53)
+			var score = -float64(upstream.weight()) / math.Log(unit)
+
+			if picked == nil || score > best {
+				picked = upstream
+				best = score
+			}
+		}
+
+	default:
+		// smooth weighted round-robin
+		var total int
+
+		for _, upstream := range candidates {
+			upstream.current += upstream.weight()
+			total += upstream.weight()
+
+			if picked == nil || upstream.current > picked.current {
+				picked = upstream
+			}
+		}
+
+		picked.current -= total
+	}
+
+	picked.active++
+
+	return picked
+}
+
+// mark a request picked for the given upstream as no longer active.
+func (mount *ProxyMount) releaseUpstream(upstream *ProxyUpstream) {
+	mount.balancerLock.Lock()
+	defer mount.balancerLock.Unlock()
+
+	upstream.active--
+}
+
+// record the outcome of a request sent to the given upstream, ejecting it after too many consecutive
+// connection errors.
+func (mount *ProxyMount) upstreamDone(upstream *ProxyUpstream, err error) {
+	mount.balancerLock.Lock()
+	defer mount.balancerLock.Unlock()
+
+	if err == nil || err == ErrMountHalt || !IsHardStop(err) {
+		upstream.failures = 0
+		return
+	}
+
+	upstream.failures++
+	upstream.lastError = err.Error()
+
+	var threshold = mount.EjectAfter
+
+	if threshold <= 0 {
+		threshold = DefaultProxyEjectAfter
+	}
+
+	if upstream.failures >= threshold {
+		var ejectFor = DefaultProxyEjectFor
+
+		if d := typeutil.Duration(mount.EjectFor); d > 0 {
+			ejectFor = d
+		}
+
+		upstream.failures = 0
+		upstream.ejectedUntil = time.Now().Add(ejectFor)
+
+		log.Warningf("proxy %s: ejecting upstream %s for %v: %v", mount.MountPoint, upstream.URL, ejectFor, err)
+	}
+}
+
+// periodically check the health of each upstream until the given channel is closed.
+func (mount *ProxyMount) startHealthChecks(stop <-chan struct{}) {
+	var check = mount.HealthCheck
+
+	if check == nil || len(mount.Upstreams) == 0 {
+		return
+	}
+
+	var interval = DefaultProxyHealthCheckInterval
+	var client = &http.Client{
+		Timeout: DefaultProxyHealthCheckTimeout,
+	}
+
+	if d := typeutil.Duration(check.Interval); d > 0 {
+		interval = d
+	}
+
+	if d := typeutil.Duration(check.Timeout); d > 0 {
+		client.Timeout = d
+	}
+
+	if mount.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+
+	go func() {
+		var ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			mount.checkUpstreams(client)
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// check the health of each upstream once.
+func (mount *ProxyMount) checkUpstreams(client *http.Client) {
+	for _, upstream := range mount.Upstreams {
+		if upstream == nil {
+			continue
+		}
+
+		var checkURL = strings.TrimSuffix(upstream.URL, `/`) + `/` + strings.TrimPrefix(mount.HealthCheck.Path, `/`)
+		var healthy bool
+		var reason string
+
+		if req, err := http.NewRequest(http.MethodGet, checkURL, nil); err == nil {
+			req.Header.Set(`User-Agent`, DiecastUserAgentString)
+
+			if res, err := client.Do(req); err == nil {
+				res.Body.Close()
+
+				if res.StatusCode < 400 {
+					healthy = true
+				} else {
+					reason = fmt.Sprintf("health check returned %s", res.Status)
+				}
+			} else {
+				reason = err.Error()
+			}
+		} else {
+			reason = err.Error()
+		}
+
+		mount.balancerLock.Lock()
+
+		if healthy != upstream.healthy || !upstream.checked {
+			if healthy {
+				log.Noticef("proxy %s: upstream %s is healthy", mount.MountPoint, upstream.URL)
+			} else {
+				log.Warningf("proxy %s: upstream %s is unhealthy: %s", mount.MountPoint, upstream.URL, reason)
+			}
+		}
+
+		upstream.checked = true
+		upstream.healthy = healthy
+		upstream.lastChecked = time.Now()
+
+		if healthy {
+			// a passing health check returns an ejected upstream to service
+			upstream.failures = 0
+			upstream.ejectedUntil = time.Time{}
+		} else {
+			upstream.lastError = reason
+		}
+
+		mount.balancerLock.Unlock()
+	}
+}
+
+// a snapshot of the current state of each upstream.
+func (mount *ProxyMount) upstreamStates() []proxyUpstreamState {
+	mount.balancerLock.Lock()
+	defer mount.balancerLock.Unlock()
+
+	var states = make([]proxyUpstreamState, 0, len(mount.Upstreams))
+	var now = time.Now()
+
+	for _, upstream := range mount.Upstreams {
+		if upstream == nil {
+			continue
+		}
+
+		var state = proxyUpstreamState{
+			URL:      upstream.URL,
+			Weight:   upstream.weight(),
+			Healthy:  upstream.available(now),
+			Active:   upstream.active,
+			Failures: upstream.failures,
+		}
+
+		if now.Before(upstream.ejectedUntil) {
+			var until = upstream.ejectedUntil
+			state.EjectedUntil = &until
+		}
+
+		if upstream.checked {
+			var at = upstream.lastChecked
+			state.LastChecked = &at
+		}
+
+		if !state.Healthy {
+			state.LastError = upstream.lastError
+		}
+
+		states = append(states, state)
+	}
+
+	return states
+}
+
+func (server *Server) initUpstreamsEndpoint() error {
+	if !server.EnableDebugging {
+		return nil
+	}
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:    `upstreams`,
+		Methods: []string{http.MethodGet},
+		Handler: server.handleUpstreams,
+	})
+}
+
+// handle GET /_diecast/upstreams
+func (server *Server) handleUpstreams(w http.ResponseWriter, req *http.Request) {
+	var out = make([]map[string]any, 0)
+
+	for _, mount := range server.reloadable().Mounts {
+		if proxy, ok := mount.(*ProxyMount); ok && len(proxy.Upstreams) > 0 {
+			out = append(out, map[string]any{
+				`mount`:     proxy.MountPoint,
+				`balance`:   typeutil.OrString(proxy.Balance, BalanceRoundRobin),
+				`upstreams`: proxy.upstreamStates(),
+			})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return typeutil.String(out[i][`mount`]) < typeutil.String(out[j][`mount`])
+	})
+
+	httputil.RespondJSON(w, out)
+}
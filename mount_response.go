@@ -19,6 +19,11 @@ type MountResponse struct {
 	size               int64
 	underlyingFile     http.File
 	underlyingFileInfo os.FileInfo
+	streaming          bool           // the payload is streamed to the client as it is read, rather than buffered
+	hijacked           bool           // the mount has taken over the client connection, so nothing more should be written to it
+	templateData       map[string]any // variables available to templates rendering the response (e.g. $.proxy)
+	layout             string         // if set, the response is rendered as a template in this layout regardless of its path
+	lastModified       time.Time      // when the file was last changed, for answering conditional requests
 }
 
 func NewMountResponse(name string, size int64, payload any) *MountResponse {
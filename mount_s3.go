@@ -140,6 +140,6 @@ func (mount *S3Mount) Open(name string) (http.File, error) {
 	}
 }
 
-func s3client() *s3.S3 {
-	return s3.New(awsSession)
+func s3client(cfgs ...*aws.Config) *s3.S3 {
+	return s3.New(awsSession, cfgs...)
 }
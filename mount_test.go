@@ -1,13 +1,31 @@
 package diecast
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/ghetzel/testify/require"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
 )
 
 type TestFileSystem map[string]http.File
@@ -115,3 +133,779 @@ func TestMounts(t *testing.T) {
 	_, err = mount.Open(`/fs-test/NOPE`)
 	assert.Equal(os.ErrNotExist, err)
 }
+
+func TestProxyMountUpstreams(t *testing.T) {
+	var assert = require.New(t)
+	var healthy = map[string]bool{`a`: true, `b`: true}
+	var lock sync.Mutex
+
+	var upstream = func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			lock.Lock()
+			var ok = healthy[name]
+			lock.Unlock()
+
+			if req.URL.Path == `/health` && !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Write([]byte(name))
+		}))
+	}
+
+	var a = upstream(`a`)
+	defer a.Close()
+
+	var b = upstream(`b`)
+	defer b.Close()
+
+	// an upstream that refuses connections
+	var down = httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var get = func(mount *ProxyMount, header ...string) (string, error) {
+		var req = httptest.NewRequest(`GET`, `/api/thing`, nil)
+
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+
+		if res, err := mount.OpenWithType(`/api/thing`, req, nil); err == nil {
+			var data, err = io.ReadAll(res)
+			return string(data), err
+		} else {
+			return ``, err
+		}
+	}
+
+	var counts = func(mount *ProxyMount, n int) map[string]int {
+		var out = make(map[string]int)
+
+		for i := 0; i < n; i++ {
+			name, err := get(mount)
+			assert.NoError(err)
+			out[name]++
+		}
+
+		return out
+	}
+
+	// weighted round-robin
+	var mount = &ProxyMount{
+		MountPoint: `/api`,
+		Upstreams: []*ProxyUpstream{
+			{URL: a.URL},
+			{URL: b.URL, Weight: 2},
+		},
+	}
+
+	assert.Equal(map[string]int{`a`: 10, `b`: 20}, counts(mount, 30))
+
+	// least connections
+	mount = &ProxyMount{
+		MountPoint: `/api`,
+		Balance:    BalanceLeastConn,
+		Upstreams: []*ProxyUpstream{
+			{URL: a.URL},
+			{URL: b.URL},
+		},
+	}
+
+	mount.Upstreams[0].active = 5
+	assert.Equal(map[string]int{`b`: 10}, counts(mount, 10))
+
+	// consistent hashing: the same key always goes to the same upstream
+	mount = &ProxyMount{
+		MountPoint: `/api`,
+		Balance:    BalanceHash,
+		HashHeader: `X-Session`,
+		Upstreams: []*ProxyUpstream{
+			{URL: a.URL},
+			{URL: b.URL},
+		},
+	}
+
+	var assigned = make(map[string]string)
+
+	for i := 0; i < 20; i++ {
+		var key = fmt.Sprintf("session-%d", i)
+
+		first, err := get(mount, `X-Session`, key)
+		assert.NoError(err)
+
+		again, err := get(mount, `X-Session`, key)
+		assert.NoError(err)
+		assert.Equal(first, again)
+
+		assigned[key] = first
+	}
+
+	assert.Len(sliceutil.Unique(maputil.MapValues(assigned)), 2)
+
+	// connection errors fail over to the next upstream, and repeated errors eject it
+	mount = &ProxyMount{
+		MountPoint: `/api`,
+		EjectAfter: 2,
+		Upstreams: []*ProxyUpstream{
+			{URL: a.URL},
+			{URL: down.URL},
+		},
+	}
+
+	assert.Equal(map[string]int{`a`: 6}, counts(mount, 6))
+
+	var states = mount.upstreamStates()
+
+	assert.True(states[0].Healthy)
+	assert.False(states[1].Healthy)
+	assert.NotNil(states[1].EjectedUntil)
+	assert.Contains(states[1].LastError, `refused`)
+
+	// active health checks take failing upstreams out of service until they recover
+	mount = &ProxyMount{
+		MountPoint: `/api`,
+		HealthCheck: &ProxyHealthCheck{
+			Path: `/health`,
+		},
+		Upstreams: []*ProxyUpstream{
+			{URL: a.URL},
+			{URL: b.URL},
+		},
+	}
+
+	lock.Lock()
+	healthy[`a`] = false
+	lock.Unlock()
+
+	mount.checkUpstreams(http.DefaultClient)
+	assert.Equal(map[string]int{`b`: 4}, counts(mount, 4))
+
+	lock.Lock()
+	healthy[`a`] = true
+	lock.Unlock()
+
+	mount.checkUpstreams(http.DefaultClient)
+	assert.Equal(map[string]int{`a`: 2, `b`: 2}, counts(mount, 4))
+
+	// with no healthy upstreams, requests fail
+	mount = &ProxyMount{
+		MountPoint: `/api`,
+		Upstreams: []*ProxyUpstream{
+			{URL: down.URL},
+		},
+	}
+
+	_, err := get(mount)
+	assert.Error(err)
+	assert.True(IsHardStop(err))
+
+	mount.Upstreams[0].ejectedUntil = time.Now().Add(time.Minute)
+
+	_, err = get(mount)
+	assert.True(errors.Is(err, ErrNoHealthyUpstreams))
+	assert.True(IsHardStop(err))
+}
+
+func TestMountConfigUpstreams(t *testing.T) {
+	var assert = require.New(t)
+	var server = NewServer(`./tests/hello`)
+
+	assert.NoError(server.LoadConfigFromReader(bytes.NewBufferString(`
+mounts:
+-   mount: /api
+    to:
+    -   http://127.0.0.1:1234
+    -   url:    http://127.0.0.1:1235
+        weight: 3
+    options:
+        balance: least_conn
+
+-   mount: /other
+    to:    http://127.0.0.1:1236
+    options:
+        upstreams: [http://127.0.0.1:1237, http://127.0.0.1:1238]
+        health_check:
+            path:     /healthz
+            interval: 5s
+`), `diecast.yml`))
+
+	assert.Len(server.Mounts, 2)
+
+	var api = server.Mounts[0].(*ProxyMount)
+
+	assert.Equal(`http://127.0.0.1:1234`, api.GetTarget())
+	assert.Equal(BalanceLeastConn, api.Balance)
+	assert.Len(api.Upstreams, 2)
+	assert.Equal(`http://127.0.0.1:1234`, api.Upstreams[0].URL)
+	assert.Equal(1, api.Upstreams[0].weight())
+	assert.Equal(`http://127.0.0.1:1235`, api.Upstreams[1].URL)
+	assert.Equal(3, api.Upstreams[1].weight())
+
+	var other = server.Mounts[1].(*ProxyMount)
+
+	assert.Equal(`http://127.0.0.1:1236`, other.GetTarget())
+	assert.Len(other.Upstreams, 2)
+	assert.Equal(`http://127.0.0.1:1238`, other.Upstreams[1].URL)
+	assert.Equal(`/healthz`, other.HealthCheck.Path)
+}
+
+func TestProxyMountStreaming(t *testing.T) {
+	var assert = require.New(t)
+	var release = make(chan struct{})
+	var upgrader websocket.Upgrader
+	var seen = make(chan *http.Request, 1)
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case `/events`:
+			w.Header().Set(`Content-Type`, `text/event-stream`)
+			w.Write([]byte("data: one\n\n"))
+			w.(http.Flusher).Flush()
+
+			// the first event must reach the client before the stream continues
+			<-release
+			w.Write([]byte("data: two\n\n"))
+
+		case `/socket`:
+			seen <- req
+
+			if conn, err := upgrader.Upgrade(w, req, nil); err == nil {
+				defer conn.Close()
+
+				for {
+					if mt, msg, err := conn.ReadMessage(); err == nil {
+						conn.WriteMessage(mt, append([]byte(`echo: `), msg...))
+					} else {
+						return
+					}
+				}
+			}
+
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+
+	defer upstream.Close()
+
+	var server = NewServer(`./tests/hello`)
+	var live = &ProxyMount{
+		MountPoint:      `/live`,
+		URL:             upstream.URL,
+		StripPathPrefix: `/live`,
+		Timeout:         `100ms`,
+		Upstreams: []*ProxyUpstream{
+			{URL: upstream.URL},
+		},
+	}
+
+	server.SetMounts([]Mount{live})
+
+	server.Authenticators = AuthenticatorConfigs{
+		{
+			Type:  `never`,
+			Paths: []string{`/live/private/*`},
+		},
+	}
+
+	var front = httptest.NewServer(server)
+	defer front.Close()
+
+	// server-sent events are passed through as they arrive, without being cut off by the timeout
+	var req, _ = http.NewRequest(`GET`, front.URL+`/live/events`, nil)
+	req.Header.Set(`Accept`, `text/event-stream`)
+
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	defer res.Body.Close()
+
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(`text/event-stream`, res.Header.Get(`Content-Type`))
+
+	var events = bufio.NewReader(res.Body)
+
+	line, err := events.ReadString('\n')
+	assert.NoError(err)
+	assert.Equal("data: one\n", line)
+
+	// the stream counts as a connection to the upstream for as long as it is open
+	var active = live.upstreamStates()[0].Active
+
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+
+	assert.Equal(1, active)
+
+	rest, err := io.ReadAll(events)
+	assert.NoError(err)
+	assert.Equal("\ndata: two\n\n", string(rest))
+
+	assert.Eventually(func() bool {
+		return live.upstreamStates()[0].Active == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// websockets are tunneled to the upstream
+	var wsURL = `ws` + strings.TrimPrefix(front.URL, `http`)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+`/live/socket`, http.Header{
+		`Cookie`: []string{`session=secret`},
+	})
+
+	assert.NoError(err)
+	defer conn.Close()
+
+	var upgraded = <-seen
+
+	assert.Equal(`/socket`, upgraded.URL.Path)
+	assert.Empty(upgraded.Header.Get(`Cookie`))
+
+	time.Sleep(200 * time.Millisecond)
+
+	for _, msg := range []string{`hello`, `world`} {
+		assert.NoError(conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+
+		_, reply, err := conn.ReadMessage()
+		assert.NoError(err)
+		assert.Equal(`echo: `+msg, string(reply))
+	}
+
+	// authenticators still apply
+	_, res, err = websocket.DefaultDialer.Dial(wsURL+`/live/private/socket`, nil)
+	assert.Error(err)
+	assert.Equal(http.StatusForbidden, res.StatusCode)
+}
+
+func TestProxyMountRewriting(t *testing.T) {
+	var assert = require.New(t)
+	var upstream *httptest.Server
+
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case `/app/login`:
+			w.Header().Add(`Set-Cookie`, `session=abc; Path=/app; Domain=127.0.0.1; HttpOnly`)
+			w.Header().Add(`Set-Cookie`, `theme=dark; Path=/; Domain=example.com`)
+			http.Redirect(w, req, upstream.URL+`/app/home?from=login`, http.StatusFound)
+
+		case `/app/home`:
+			w.Header().Set(`Content-Type`, `text/html; charset=utf-8`)
+			w.Write([]byte(`<html><head><title>Legacy Home</title></head><body>` +
+				`<a href="/app/about">About</a>` +
+				`<img src="` + upstream.URL + `/app/logo.png">` +
+				`<form action="/app/search#results"></form>` +
+				`<a href="/elsewhere">Outside</a>` +
+				`<a href="https://example.com/app/">External</a>` +
+				`<a href="relative">Relative</a>` +
+				`<p>{{ not a template }} Copyright 2009</p>` +
+				`</body></html>`))
+
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+
+	defer upstream.Close()
+
+	var root = t.TempDir()
+
+	assert.NoError(os.MkdirAll(filepath.Join(root, `_layouts`), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`home`), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(root, `_layouts`, `default.html`), []byte(
+		`<title>{{ $.proxy.title }} | Site</title><nav>site</nav>{{ template "content" . }}`,
+	), 0644))
+
+	var server = NewServer(root)
+
+	server.SetMounts([]Mount{
+		&ProxyMount{
+			MountPoint:           `/legacy`,
+			URL:                  upstream.URL,
+			StripPathPrefix:      `/legacy`,
+			AppendPathPrefix:     `/app`,
+			PassthroughRedirects: true,
+			RewriteHeaders:       true,
+			RewriteHTML:          true,
+			Replace: []*ProxyReplacement{
+				{
+					Pattern:     `Copyright (\d+)`,
+					Replacement: `Copyright ${1}-2026`,
+				},
+			},
+		},
+		&ProxyMount{
+			MountPoint:       `/chrome`,
+			URL:              upstream.URL,
+			StripPathPrefix:  `/chrome`,
+			AppendPathPrefix: `/app`,
+			Template:         true,
+		},
+	})
+
+	assert.NoError(server.Initialize())
+
+	// redirects and cookies refer to the mount, not the upstream
+	doTestServerRequest(server, `GET`, `/legacy/login`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusFound, w.Code)
+		assert.Equal(`/legacy/home?from=login`, w.Header().Get(`Location`))
+		assert.Equal([]string{
+			`session=abc; Path=/legacy/; Domain=127.0.0.1; HttpOnly`,
+			`theme=dark; Path=/; Domain=example.com`,
+		}, w.Header().Values(`Set-Cookie`))
+	})
+
+	var upstreamURL, _ = url.Parse(upstream.URL + `/app/`)
+	var legacy = server.Mounts[0].(*ProxyMount)
+
+	assert.Equal(
+		`session=abc; Path=/legacy/admin; Domain=www.example.com; Secure`,
+		legacy.rewriteCookie(upstreamURL, `www.example.com`, `session=abc; Path=/app/admin; Domain=.127.0.0.1; Secure`),
+	)
+
+	// ...as do links in HTML, and replacements are applied
+	doTestServerRequest(server, `GET`, `/legacy/home`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+
+		var body = w.Body.String()
+
+		assert.Contains(body, `href="/legacy/about"`)
+		assert.Contains(body, `src="/legacy/logo.png"`)
+		assert.Contains(body, `action="/legacy/search#results"`)
+		assert.Contains(body, `href="/elsewhere"`)
+		assert.Contains(body, `href="https://example.com/app/"`)
+		assert.Contains(body, `href="relative"`)
+		assert.Contains(body, `Copyright 2009-2026`)
+		assert.NotContains(body, upstream.URL)
+	})
+
+	// proxied documents can be rendered in a layout, but are never evaluated as templates
+	doTestServerRequest(server, `GET`, `/chrome/home`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+
+		var body = w.Body.String()
+
+		assert.True(strings.HasPrefix(body, `<title>Legacy Home | Site</title><nav>site</nav><a href="/app/about">About</a>`))
+		assert.Contains(body, `{{ not a template }} Copyright 2009`)
+		assert.Equal(strings.Count(body, `<title>`), 1)
+	})
+}
+
+func TestGitMount(t *testing.T) {
+	var assert = require.New(t)
+	var repo = t.TempDir()
+
+	var git = func(author string, args ...string) {
+		var cmd = exec.Command(`git`, append([]string{`-C`, repo, `-c`, `user.name=` + author, `-c`, `user.email=` + strings.ToLower(author) + `@example.com`}, args...)...)
+		var out, err = cmd.CombinedOutput()
+
+		assert.NoError(err, string(out))
+	}
+
+	var write = func(name string, data string) {
+		assert.NoError(os.MkdirAll(filepath.Join(repo, filepath.Dir(name)), 0755))
+		assert.NoError(os.WriteFile(filepath.Join(repo, name), []byte(data), 0644))
+	}
+
+	git(`Alice`, `init`, `--quiet`, `--initial-branch=main`)
+	write(`docs/index.html`, `{{ $.git.author }} ({{ $.git.email }}) {{ $.git.short_sha }}: {{ $.git.subject }}`)
+	write(`docs/guide/intro.txt`, `first`)
+	write(`README`, `not served`)
+	git(`Alice`, `add`, `.`)
+	git(`Alice`, `commit`, `--quiet`, `--message=Write the docs`)
+	git(`Alice`, `tag`, `v1`)
+	write(`docs/guide/intro.txt`, `second`)
+	git(`Bob`, `commit`, `--quiet`, `--all`, `--message=Revise the intro`)
+
+	var root = t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`home`), 0644))
+
+	latest, err := NewMountFromSpec(`/docs:git+file://` + repo)
+	assert.NoError(err)
+	assert.IsType(&GitMount{}, latest)
+	latest.(*GitMount).Path = `docs`
+
+	var server = NewServer(root, `*.html`)
+
+	server.SetMounts([]Mount{
+		latest,
+		&GitMount{
+			MountPoint: `/v1`,
+			URL:        `git+file://` + repo + `#v1`,
+			Path:       `/docs/`,
+		},
+	})
+
+	assert.NoError(server.Initialize())
+	defer server.stopMountWorkers()
+
+	// files are served from the latest commit, and templates see the last commit to change them
+	doTestServerRequest(server, `GET`, `/docs/guide/intro.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal(`second`, w.Body.String())
+	})
+
+	doTestServerRequest(server, `GET`, `/docs/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Regexp(`^Alice \(alice@example.com\) [0-9a-f]{7}: Write the docs$`, w.Body.String())
+	})
+
+	doTestServerRequest(server, `GET`, `/docs/README`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusNotFound, w.Code)
+	})
+
+	// ...or from a pinned ref
+	doTestServerRequest(server, `GET`, `/v1/guide/intro.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal(`first`, w.Body.String())
+	})
+
+	// directories can be listed, and files carry the date of their last change
+	file, err := latest.Open(`/docs/guide`)
+	assert.NoError(err)
+
+	entries, err := file.Readdir(-1)
+	assert.NoError(err)
+	assert.Len(entries, 1)
+	assert.Equal(`intro.txt`, entries[0].Name())
+
+	file, err = latest.Open(`/docs/guide/intro.txt`)
+	assert.NoError(err)
+
+	info, err := file.Stat()
+	assert.NoError(err)
+	assert.Equal(int64(6), info.Size())
+	assert.WithinDuration(time.Now(), info.ModTime(), time.Minute)
+
+	// new commits are served once fetched
+	write(`docs/guide/intro.txt`, `third`)
+	git(`Carol`, `commit`, `--quiet`, `--all`, `--message=Revise the intro again`)
+
+	assert.NoError(latest.(*GitMount).Fetch())
+
+	doTestServerRequest(server, `GET`, `/docs/guide/intro.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`third`, w.Body.String())
+	})
+
+	doTestServerRequest(server, `GET`, `/v1/guide/intro.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`first`, w.Body.String())
+	})
+
+	_, err = (&GitMount{MountPoint: `/x`, URL: `git+file://` + repo, Ref: `nope`}).Open(`/x/index.html`)
+	assert.Error(err)
+	assert.Contains(err.Error(), `no such ref "nope"`)
+}
+
+func TestArchiveMount(t *testing.T) {
+	var assert = require.New(t)
+	var dir = t.TempDir()
+	var modTime = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	// write a tar archive (compressed by the given writer) containing the given files
+	var writeTar = func(filename string, compress func(io.Writer) io.WriteCloser, files map[string]string) {
+		var out, err = os.Create(filepath.Join(dir, filename))
+		assert.NoError(err)
+		defer out.Close()
+
+		var cw = compress(out)
+		var archive = tar.NewWriter(cw)
+
+		assert.NoError(archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     `docs/`,
+			Mode:     0755,
+			ModTime:  modTime,
+		}))
+
+		for name, content := range files {
+			assert.NoError(archive.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     0644,
+				Size:     int64(len(content)),
+				ModTime:  modTime,
+			}))
+
+			_, err := archive.Write([]byte(content))
+			assert.NoError(err)
+		}
+
+		assert.NoError(archive.Close())
+		assert.NoError(cw.Close())
+	}
+
+	var files = map[string]string{
+		`docs/a.txt`:   `first file`,
+		`docs/b/c.txt`: `second file`,
+		`docs/long/` + strings.Repeat(`x`, 120) + `.txt`: `long name`,
+	}
+
+	writeTar(`site.tar`, func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }, files)
+	writeTar(`site.tar.gz`, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, files)
+	writeTar(`site.tar.zst`, func(w io.Writer) io.WriteCloser {
+		var zw, _ = zstd.NewWriter(w)
+		return zw
+	}, files)
+
+	for _, filename := range []string{`site.tar`, `site.tar.gz`, `site.tar.zst`} {
+		mount, err := NewMountFromSpec(`/site:archive+file://` + filepath.Join(dir, filename))
+		assert.NoError(err)
+		assert.IsType(&ArchiveMount{}, mount, filename)
+
+		for name, content := range files {
+			file, err := mount.Open(`/site/` + name)
+			assert.NoError(err, filename)
+
+			data, err := io.ReadAll(file)
+			assert.NoError(err)
+			assert.Equal(content, string(data), filename)
+
+			info, err := file.Stat()
+			assert.NoError(err)
+			assert.True(modTime.Equal(info.ModTime()), filename)
+		}
+
+		file, err := mount.Open(`/site/docs`)
+		assert.NoError(err)
+
+		entries, err := file.Readdir(-1)
+		assert.NoError(err)
+		assert.Len(entries, 3)
+		assert.Equal(`a.txt`, entries[0].Name())
+		assert.True(entries[1].IsDir())
+
+		_, err = mount.Open(`/site/docs/missing.txt`)
+		assert.True(os.IsNotExist(err))
+	}
+
+	// zip archives, served with modification times for conditional requests
+	var root = t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`home`), 0644))
+
+	var zipfile = filepath.Join(dir, `site.zip`)
+	var zipdata, _ = os.ReadFile(`./tests/zip-fs-test.zip`)
+	assert.NoError(os.WriteFile(zipfile, zipdata, 0644))
+
+	mount, err := NewMountFromSpec(`/zip:archive+file://` + zipfile)
+	assert.NoError(err)
+	mount.(*ArchiveMount).CheckInterval = `25ms`
+
+	var server = NewServer(root)
+
+	server.SetMounts([]Mount{mount})
+	assert.NoError(server.Initialize())
+	defer server.stopMountWorkers()
+
+	var lastModified string
+
+	doTestServerRequest(server, `GET`, `/zip/subdir/more/fifth.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal("5\n", w.Body.String())
+
+		lastModified = w.Header().Get(`Last-Modified`)
+		assert.NotEmpty(lastModified)
+	})
+
+	var req = httptest.NewRequest(`GET`, `/zip/subdir/more/fifth.txt`, nil)
+	var w = httptest.NewRecorder()
+
+	req.Header.Set(`If-Modified-Since`, lastModified)
+	server.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotModified, w.Code)
+	assert.Empty(w.Body.String())
+
+	// replacing the archive swaps its contents
+	var replacement bytes.Buffer
+	var zw = zip.NewWriter(&replacement)
+
+	fw, err := zw.Create(`subdir/more/fifth.txt`)
+	assert.NoError(err)
+	fw.Write([]byte(`five`))
+	assert.NoError(zw.Close())
+
+	assert.NoError(os.WriteFile(zipfile+`.new`, replacement.Bytes(), 0644))
+	assert.NoError(os.Rename(zipfile+`.new`, zipfile))
+
+	assert.Eventually(func() bool {
+		var w = httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(`GET`, `/zip/subdir/more/fifth.txt`, nil))
+
+		return w.Body.String() == `five`
+	}, 5*time.Second, 25*time.Millisecond)
+
+	doTestServerRequest(server, `GET`, `/zip/README.md`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusNotFound, w.Code)
+	})
+
+	// a broken replacement leaves the current archive in place
+	assert.NoError(os.WriteFile(zipfile, []byte(`not a zip file`), 0644))
+	assert.Error(mount.(*ArchiveMount).Load())
+
+	doTestServerRequest(server, `GET`, `/zip/subdir/more/fifth.txt`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`five`, w.Body.String())
+	})
+
+	// archives can be fetched from a URL, and are only downloaded again once they've changed
+	var tarball, _ = os.ReadFile(filepath.Join(dir, `site.tar.gz`))
+	var downloads int
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downloads++
+		http.ServeContent(w, req, `site.tar.gz`, modTime, bytes.NewReader(tarball))
+	}))
+
+	defer upstream.Close()
+
+	remote, err := NewMountFromSpec(`/remote:archive+` + upstream.URL + `/builds/site.tar.gz`)
+	assert.NoError(err)
+	assert.IsType(&ArchiveMount{}, remote)
+
+	file, err := remote.Open(`/remote/docs/b/c.txt`)
+	assert.NoError(err)
+	assert.Equal(`second file`, fileutil.Cat(file))
+
+	assert.NoError(remote.(*ArchiveMount).Load())
+	assert.Equal(2, downloads)
+
+	file, err = remote.Open(`/remote/docs/a.txt`)
+	assert.NoError(err)
+	assert.Equal(`first file`, fileutil.Cat(file))
+
+	// sources that merely look like archives are still proxied to or served from disk
+	proxied, err := NewMountFromSpec(`/downloads:https://example.com/downloads/site.zip`)
+	assert.NoError(err)
+	assert.IsType(&ProxyMount{}, proxied)
+
+	local, err := NewMountFromSpec(`/site:` + filepath.Join(dir, `site.tar`))
+	assert.NoError(err)
+	assert.IsType(&FileMount{}, local)
+
+	// ...unless the mount gives an archive format
+	var configured = NewServer(root)
+
+	assert.NoError(configured.LoadConfigFromReader(bytes.NewBufferString(`
+mounts:
+-   mount: /downloads
+    to:    https://example.com/downloads/site.zip
+
+-   mount: /release
+    to:    `+upstream.URL+`/builds/latest
+    options:
+        format: tar.gz
+`), `diecast.yml`))
+
+	assert.Len(configured.Mounts, 2)
+	assert.IsType(&ProxyMount{}, configured.Mounts[0])
+	assert.IsType(&ArchiveMount{}, configured.Mounts[1])
+
+	file, err = configured.Mounts[1].Open(`/release/docs/a.txt`)
+	assert.NoError(err)
+	assert.Equal(`first file`, fileutil.Cat(file))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
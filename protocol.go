@@ -2,6 +2,7 @@ package diecast
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -43,6 +44,9 @@ type ProtocolRequest struct {
 	TemplateFuncs     FuncMap
 	DefaultTimeout    time.Duration
 	AdditionalHeaders map[string]any
+	Conditional       http.Header // validators to send when revalidating a cached response (e.g.: If-None-Match)
+	body              []byte      // a pre-encoded request body, sent instead of the binding's body or rawbody
+	contentType       string      // the Content-Type of the pre-encoded request body
 }
 
 func (config *ProtocolRequest) ReadFile(filename string) ([]byte, error) {
@@ -70,6 +74,142 @@ func (config *ProtocolRequest) Template(input any) (typeutil.Variant, error) {
 	return typeutil.V(input), nil
 }
 
+// Evaluates any templates in the given value, descending into arrays and objects.  Strings that
+// contain templates are converted to the type they most resemble after evaluation, and empty strings
+// become nil.  Other values are returned as-is.
+func (config *ProtocolRequest) TemplateValue(value any) (any, error) {
+	return config.templateValue(value, true)
+}
+
+// evaluates templates as TemplateValue does, optionally leaving the output of templates as strings.
+func (config *ProtocolRequest) templateValue(value any, autotype bool) (any, error) {
+	switch v := value.(type) {
+	case string:
+		if config.Binding != nil && !config.Binding.NoTemplate && strings.Contains(v, `{{`) {
+			if rendered, err := config.Template(v); err == nil {
+				v = rendered.String()
+
+				if v == `` {
+					return nil, nil
+				} else if autotype {
+					return typeutil.Auto(v), nil
+				}
+
+				return v, nil
+			} else {
+				return nil, err
+			}
+		} else if v == `` {
+			return nil, nil
+		}
+
+		return v, nil
+	case []any:
+		var out = make([]any, len(v))
+
+		for i, item := range v {
+			if value, err := config.templateValue(item, autotype); err == nil {
+				out[i] = value
+			} else {
+				return nil, err
+			}
+		}
+
+		return out, nil
+	case map[string]any:
+		var out = make(map[string]any, len(v))
+
+		for k, item := range v {
+			if value, err := config.templateValue(item, autotype); err == nil {
+				out[k] = value
+			} else {
+				return nil, err
+			}
+		}
+
+		return out, nil
+	default:
+		return value, nil
+	}
+}
+
+// Returns the TLS configuration to use when connecting to the binding's resource, including the
+// server's trusted root certificates and any client certificate the binding specifies.
+func (config *ProtocolRequest) TLSConfig() (*tls.Config, error) {
+	var tcc = new(tls.Config)
+
+	if config.Binding == nil {
+		return tcc, nil
+	}
+
+	tcc.InsecureSkipVerify = config.Binding.Insecure
+
+	if config.Binding.server != nil {
+		tcc.RootCAs = config.Binding.server.altRootCaPool
+	}
+
+	// jump through some hoops to allow per-binding TLS client auth
+	if crt := config.Binding.TlsCertificate; crt != `` {
+		if key := config.Binding.TlsKey; key != `` {
+			if crtdata, err := config.ReadFile(crt); err == nil {
+				if keydata, err := config.ReadFile(key); err == nil {
+					if certificate, err := tls.X509KeyPair(crtdata, keydata); err == nil {
+						tcc.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+							return &certificate, nil
+						}
+					} else {
+						return nil, fmt.Errorf("bad certificate: %v", err)
+					}
+				} else {
+					return nil, fmt.Errorf("bad tls key: %v", err)
+				}
+			} else {
+				return nil, fmt.Errorf("bad tls cert: %v", err)
+			}
+		}
+	}
+
+	return tcc, nil
+}
+
+// Returns the timeout that should be applied to this request.  The binding's own timeout is
+// preferred, followed by the server-wide default.
+// parses a binding's timeout.  Numbers (including numeric strings) below 1000 are taken to be
+// seconds, and those below 1000000 milliseconds; anything larger is nanoseconds.
+func bindingTimeout(in any) time.Duration {
+	if in == nil {
+		return 0
+	} else if typeutil.IsNumeric(in) {
+		var n = typeutil.Float(in)
+
+		if n < 1000 {
+			return time.Duration(n * float64(time.Second))
+		} else if n < 1000000 {
+			return time.Duration(n * float64(time.Millisecond))
+		} else {
+			return time.Duration(n)
+		}
+	} else {
+		return typeutil.Duration(in)
+	}
+}
+
+func (config *ProtocolRequest) Timeout() time.Duration {
+	if config.Binding != nil {
+		if timeout := bindingTimeout(config.Binding.Timeout); timeout > 0 {
+			return timeout
+		}
+	}
+
+	if config.DefaultTimeout > 0 {
+		return config.DefaultTimeout
+	} else if BindingClient.Timeout > 0 {
+		return BindingClient.Timeout
+	} else {
+		return DefaultBindingTimeout
+	}
+}
+
 func (config *ProtocolRequest) Conf(proto string, key string, fallbacks ...any) typeutil.Variant {
 	if config.Binding != nil {
 		if config.Binding.server != nil {
@@ -0,0 +1,227 @@
+package diecast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/executil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	shellwords "github.com/mattn/go-shellwords"
+)
+
+// The Exec binding protocol runs a local program and returns its standard output.  It is specified
+// with URLs that use the exec://program or exec:///absolute/path/to/program schemes (cmd:// is
+// accepted as an alias).  Programs given by name are located using the PATH environment variable.
+// Only programs listed in the "exec.allow" option may be run; if that list is empty, no programs
+// may be run at all.
+//
+// Arguments are taken from the "arg" query string parameter of the resource URL (which may be
+// repeated), followed by the "args" binding parameter (either an array, each element of which may
+// contain a template, or a string which is split into words the same way a shell would).  All
+// other binding parameters are set as environment variables for the program.  If the binding
+// specifies a "rawbody", it is (optionally templated and) written to the program's standard input.
+//
+// Standard output is returned as text unless the binding specifies a "parser".  A zero exit status
+// is reported as a 200 status, and any other exit status as a 500 unless it is mapped to a
+// different status with the "exec.statuses" option; this allows for bindings to react to specific
+// exit statuses with "if_status".  Programs that run longer than the binding timeout are killed,
+// which is reported as a 504 status.
+//
+// Programs are never run if the server's "disable_commands" option is set, or if Diecast is
+// running as root (unless the DIECAST_ALLOW_ROOT_ACTIONS environment variable is set to "true").
+//
+// # Protocol Options
+//
+//   - exec.allow ([])
+//     A list of program names or absolute paths that bindings are permitted to run.
+//
+//   - exec.dir (server root directory)
+//     The working directory programs are run from.
+//
+//   - exec.inherit_env (false)
+//     Whether programs receive Diecast's own environment.  If false, only PATH is passed along.
+//
+//   - exec.statuses ({})
+//     A mapping of exit statuses to the HTTP status that should be reported for them.
+type ExecProtocol struct {
+}
+
+func (protocol *ExecProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	var server *Server
+
+	if rr.Binding != nil {
+		server = rr.Binding.server
+	}
+
+	if server != nil && server.DisableCommands {
+		return nil, fmt.Errorf("refusing to run commands because DisableCommands is set")
+	} else if executil.IsRoot() && !executil.EnvBool(`DIECAST_ALLOW_ROOT_ACTIONS`) {
+		return nil, fmt.Errorf("refusing to run commands as root.  Override with the environment variable DIECAST_ALLOW_ROOT_ACTIONS=true")
+	}
+
+	var program, err = execAllowed(rr.URL.Host+rr.URL.Path, sliceutil.Stringify(sliceutil.Compact(rr.Conf(`exec`, `allow`).Value)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	args, env, err := execArgs(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), rr.Timeout())
+	defer cancel()
+
+	var cmd = exec.CommandContext(ctx, program, args...)
+	var stdout = bytes.NewBuffer(nil)
+	var stderr = bytes.NewBuffer(nil)
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if dir := rr.Conf(`exec`, `dir`).String(); dir != `` {
+		cmd.Dir = dir
+	} else if server != nil {
+		cmd.Dir = server.RootPath
+	}
+
+	if rr.Conf(`exec`, `inherit_env`).Bool() {
+		cmd.Env = os.Environ()
+	} else {
+		cmd.Env = []string{`PATH=` + os.Getenv(`PATH`)}
+	}
+
+	cmd.Env = append(cmd.Env, env...)
+
+	if rr.Binding != nil && rr.Binding.RawBody != `` {
+		var stdin = rr.Binding.RawBody
+
+		if !rr.Binding.NoTemplate {
+			if v, err := rr.Template(stdin); err == nil {
+				stdin = v.String()
+			} else {
+				return nil, fmt.Errorf("rawbody: %v", err)
+			}
+		}
+
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	var status = http.StatusOK
+	var exitCode = 0
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Warningf("ExecProtocol: %s: killed after %v", program, rr.Timeout())
+			status = http.StatusGatewayTimeout
+		} else if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+			status = http.StatusInternalServerError
+		} else {
+			return nil, err
+		}
+	}
+
+	if exitCode != 0 {
+		log.Warningf("ExecProtocol: %s exited with status %d: %s", program, exitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	if status != http.StatusGatewayTimeout {
+		if mapped := typeutil.Int(rr.Conf(`exec`, `statuses`).MapNative()[typeutil.String(exitCode)]); mapped > 0 {
+			status = int(mapped)
+		}
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `text/plain; charset=utf-8`,
+		StatusCode: status,
+		Raw:        stdout.String(),
+		data:       io.NopCloser(stdout),
+	}, nil
+}
+
+// verify that the named program is permitted to run, and return the path to it.
+func execAllowed(program string, allow []string) (string, error) {
+	if program == `` {
+		return ``, fmt.Errorf("must specify a program to run")
+	} else if strings.Contains(program, `/`) && !filepath.IsAbs(program) {
+		return ``, fmt.Errorf("program %q must be a name or an absolute path", program)
+	}
+
+	var resolved, err = exec.LookPath(program)
+
+	if err != nil {
+		return ``, err
+	}
+
+	for _, entry := range allow {
+		if entry == program {
+			return resolved, nil
+		} else if other, err := exec.LookPath(entry); err == nil && other == resolved {
+			return resolved, nil
+		}
+	}
+
+	return ``, fmt.Errorf("program %q is not permitted (see the exec.allow option)", program)
+}
+
+// build the argument list and environment variables for the program from the request URL and the
+// binding's parameters (which are templates, unless the binding sets no_template).
+func execArgs(rr *ProtocolRequest) ([]string, []string, error) {
+	var args = rr.URL.Query()[`arg`]
+	var env = make([]string, 0)
+
+	if rr.Binding == nil {
+		return args, env, nil
+	}
+
+	for key, value := range rr.Binding.Params {
+		if key == `args` {
+			if typeutil.IsArray(value) {
+				for _, arg := range sliceutil.Sliceify(value) {
+					if v, err := execParam(rr, arg); err == nil {
+						args = append(args, v.String())
+					} else {
+						return nil, nil, fmt.Errorf("args: %v", err)
+					}
+				}
+			} else if line := typeutil.String(value); !rr.Binding.NoTemplate && strings.Contains(line, `{{`) {
+				// templated values could otherwise add arguments of their own
+				return nil, nil, fmt.Errorf("args: arguments containing templates must be given as a list")
+			} else if words, err := shellwords.Parse(line); err == nil {
+				args = append(args, words...)
+			} else {
+				return nil, nil, fmt.Errorf("args: %v", err)
+			}
+		} else if v, err := execParam(rr, value); err == nil {
+			env = append(env, key+`=`+v.String())
+		} else {
+			return nil, nil, fmt.Errorf("param %q: %v", key, err)
+		}
+	}
+
+	return args, env, nil
+}
+
+// evaluate an argument or environment variable, which may itself be a template.
+func execParam(rr *ProtocolRequest, value any) (typeutil.Variant, error) {
+	if rr.Binding.NoTemplate {
+		return typeutil.V(value), nil
+	} else {
+		return rr.Template(value)
+	}
+}
@@ -0,0 +1,398 @@
+package diecast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghodss/yaml"
+	"github.com/gobwas/glob"
+)
+
+// The File binding protocol reads and parses data files.  It is specified with URLs that use the
+// file://path/to/file.json scheme (relative to the server's root directory), or the
+// file:///absolute/path/to/file.json scheme (for files in one of the directories allowed by the
+// "file.roots" option).  Paths containing ".." are never permitted, nor are symlinks that point
+// outside of the root they are in (whether that is one of the "file.roots" or the server's root
+// directory).
+//
+// Files are parsed according to their extension (.json, .yaml/.yml, .csv, .tsv, .xml, .toml, and
+// .jsonl/.ndjson); files with any other extension are returned as text.  If the binding
+// specifies a "parser", a single file is returned as-is and parsed by the binding instead.
+//
+// Paths may contain glob patterns (e.g.: "file://data/posts/*.yaml" or "file://data/**.json"), in
+// which case an array of the parsed contents of each matching file (sorted by path) is returned.
+// Adding "?merge=true" to the URL merges the contents of all matching files into one value instead:
+// objects are deeply merged (later files taking precedence), arrays are concatenated, and CSV/TSV
+// records are appended to one another.
+//
+// # Protocol Options
+//
+//   - file.roots ([])
+//     A list of absolute directories that file:/// URLs are permitted to read from.
+type FileProtocol struct {
+}
+
+type fileProtocolSource struct {
+	fs      http.FileSystem
+	pattern string
+	files   []string
+}
+
+func (protocol *FileProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	var source, err = fileSourceFor(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var isGlob = strings.ContainsAny(source.pattern, `*[{`)
+
+	if isGlob {
+		if files, err := fileGlob(source.fs, source.pattern); err == nil {
+			source.files = files
+		} else {
+			return nil, err
+		}
+	} else {
+		source.files = []string{source.pattern}
+	}
+
+	// if the binding is going to parse the response itself, hand it the file as-is
+	if !isGlob && rr.Binding.Parser != `` {
+		if data, err := readFromFS(source.fs, source.pattern); err == nil {
+			return &ProtocolResponse{
+				MimeType:   fileMimeType(source.pattern),
+				StatusCode: http.StatusOK,
+				Raw:        data,
+				data:       io.NopCloser(bytes.NewBuffer(data)),
+			}, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	var results = make([]any, 0, len(source.files))
+	var allTabular = true
+
+	for _, name := range source.files {
+		if data, err := readFromFS(source.fs, name); err == nil {
+			if parsed, err := parseDataFile(name, data); err == nil {
+				results = append(results, parsed)
+				allTabular = allTabular && isTabularFile(name)
+			} else {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	var out any = results
+
+	if !isGlob {
+		out = results[0]
+	} else if typeutil.Bool(rr.URL.Query().Get(`merge`)) {
+		if allTabular {
+			out = mergeTabularData(results)
+		} else {
+			var merged any
+
+			for _, result := range results {
+				merged = mergeData(merged, result)
+			}
+
+			out = merged
+		}
+	}
+
+	var buf = bytes.NewBuffer(nil)
+
+	if err := json.NewEncoder(buf).Encode(out); err != nil {
+		return nil, err
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `application/json; charset=utf-8`,
+		StatusCode: http.StatusOK,
+		Raw:        out,
+		data:       io.NopCloser(buf),
+	}, nil
+}
+
+// determine which filesystem the requested path lives in, and the path within that filesystem.
+func fileSourceFor(rr *ProtocolRequest) (*fileProtocolSource, error) {
+	var name = rr.URL.Host + rr.URL.Path
+
+	if name == `` {
+		return nil, fmt.Errorf("must specify a file path")
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(name), `/`) {
+		if part == `..` {
+			return nil, fmt.Errorf("path %q: parent directory references are not permitted", name)
+		}
+	}
+
+	if rr.URL.Host != `` {
+		// relative path: read from the server's filesystem
+		if rr.Binding == nil || rr.Binding.server == nil {
+			return nil, fmt.Errorf("no server filesystem available")
+		}
+
+		var fs http.FileSystem = rr.Binding.server
+
+		// local root directories are subject to the same symlink restrictions as file.roots
+		if dir, ok := rr.Binding.server.fs.(http.Dir); ok {
+			fs = rootedDir(dir)
+		}
+
+		return &fileProtocolSource{
+			fs:      fs,
+			pattern: path.Clean(`/` + name),
+		}, nil
+	}
+
+	// absolute path: must be within one of the allowed roots
+	for _, root := range sliceutil.Stringify(sliceutil.Compact(rr.Conf(`file`, `roots`).Value)) {
+		if !filepath.IsAbs(root) {
+			log.Warningf("FileProtocol: ignoring root %q: roots must be absolute paths", root)
+			continue
+		}
+
+		root = filepath.Clean(root)
+
+		if rel, err := filepath.Rel(root, filepath.Clean(name)); err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			return &fileProtocolSource{
+				fs:      rootedDir(root),
+				pattern: path.Clean(`/` + filepath.ToSlash(rel)),
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("path %q is not within any of the permitted roots (see the file.roots option)", name)
+}
+
+// a directory that, unlike http.Dir, refuses to open files that symlinks resolve to outside of it.
+type rootedDir string
+
+func (dir rootedDir) Open(name string) (http.File, error) {
+	var root, err = filepath.EvalSymlinks(string(dir))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var filename = filepath.Join(root, filepath.FromSlash(path.Clean(`/`+name)))
+
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			return http.Dir(root).Open(filepath.ToSlash(rel))
+		} else {
+			return nil, fmt.Errorf("path %q resolves to a file outside of the permitted root %q", name, string(dir))
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// return all files in the given filesystem that match the pattern, sorted by path.
+func fileGlob(fs http.FileSystem, pattern string) ([]string, error) {
+	var matcher, err = glob.Compile(pattern, '/')
+
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %v", pattern, err)
+	}
+
+	// start walking from the deepest directory that doesn't contain any wildcards
+	var base = `/`
+	var parts = strings.Split(strings.TrimPrefix(pattern, `/`), `/`)
+
+	for _, part := range parts[:len(parts)-1] {
+		if strings.ContainsAny(part, `*[{`) {
+			break
+		}
+
+		base = path.Join(base, part)
+	}
+
+	var matches = make([]string, 0)
+
+	if err := walkFS(fs, base, func(name string) {
+		if matcher.Match(name) {
+			matches = append(matches, name)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+func walkFS(fs http.FileSystem, dir string, fn func(string)) error {
+	var d, err = fs.Open(dir)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	var entries, rerr = d.Readdir(-1)
+
+	if rerr != nil {
+		return rerr
+	}
+
+	for _, entry := range entries {
+		var name = path.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			if err := walkFS(fs, name, fn); err != nil {
+				return err
+			}
+		} else {
+			fn(name)
+		}
+	}
+
+	return nil
+}
+
+// parse a data file according to its extension.
+func parseDataFile(name string, data []byte) (any, error) {
+	var out any
+	var err error
+
+	switch strings.ToLower(path.Ext(name)) {
+	case `.json`:
+		err = json.Unmarshal(data, &out)
+	case `.yaml`, `.yml`:
+		err = yaml.Unmarshal(data, &out)
+	case `.toml`:
+		var obj map[string]any
+
+		err = toml.Unmarshal(data, &obj)
+		out = obj
+	case `.csv`:
+		return xsvToArray(data, ',')
+	case `.tsv`:
+		return xsvToArray(data, '\t')
+	case `.xml`:
+		return xmlToMap(data)
+	case `.jsonl`, `.ndjson`:
+		var records = make([]any, 0)
+		var scanner = bufio.NewScanner(bytes.NewReader(data))
+
+		scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				var record any
+
+				if err := json.Unmarshal(line, &record); err != nil {
+					return nil, err
+				}
+
+				records = append(records, record)
+			}
+		}
+
+		return records, scanner.Err()
+	default:
+		return string(data), nil
+	}
+
+	return out, err
+}
+
+func isTabularFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case `.csv`, `.tsv`:
+		return true
+	}
+
+	return false
+}
+
+func fileMimeType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case `.json`:
+		return `application/json`
+	case `.yaml`, `.yml`:
+		return `application/yaml`
+	case `.xml`:
+		return `text/xml`
+	case `.html`, `.htm`:
+		return `text/html`
+	default:
+		return `text/plain`
+	}
+}
+
+// combine the results of several CSV/TSV files, using the headers of the first.
+func mergeTabularData(results []any) map[string]any {
+	var out = map[string]any{
+		`headers`: make([]string, 0),
+		`records`: make([][]any, 0),
+	}
+
+	for i, result := range results {
+		if table, ok := result.(map[string]any); ok {
+			if i == 0 {
+				out[`headers`] = table[`headers`]
+			}
+
+			if records, ok := table[`records`].([][]any); ok {
+				out[`records`] = append(out[`records`].([][]any), records...)
+			}
+		}
+	}
+
+	return out
+}
+
+// deeply merge two values: objects are merged key-by-key, arrays are concatenated, and anything
+// else is replaced by the second value.
+func mergeData(first any, second any) any {
+	if first == nil {
+		return second
+	}
+
+	if a, ok := first.(map[string]any); ok {
+		if b, ok := second.(map[string]any); ok {
+			var out = make(map[string]any, len(a)+len(b))
+
+			for k, v := range a {
+				out[k] = v
+			}
+
+			for k, v := range b {
+				out[k] = mergeData(out[k], v)
+			}
+
+			return out
+		}
+	}
+
+	if a, ok := first.([]any); ok {
+		if b, ok := second.([]any); ok {
+			return append(append(make([]any, 0, len(a)+len(b)), a...), b...)
+		}
+	}
+
+	return second
+}
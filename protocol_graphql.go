@@ -0,0 +1,253 @@
+package diecast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+// The GraphQL binding protocol is used to query GraphQL APIs.  It is specified with URLs that use the
+// graphql+http:// or graphql+https:// schemes, which are otherwise identical to the HTTP URL of the
+// API endpoint.  Requests are sent as a JSON-encoded POST using the same mechanism (and supporting
+// the same headers, TLS, and timeout options) as the HTTP protocol.
+//
+// The query is given in the binding's "rawbody", or is read from a file in the server's root
+// directory named by the "query_file" protocol option.  The query text itself is never evaluated
+// as a template.  The binding's "params" are sent as the query variables: values keep their types
+// (numbers, booleans, arrays, and objects are sent as such), and values containing templates are
+// evaluated and sent as strings, unless the query declares the variable as an Int, Float, or
+// Boolean, in which case they are converted to that type.  Empty values are sent as null.  An
+// operation name may be given with the "operation" protocol option.
+//
+// The "data" object of the response becomes the binding's data.  If the response includes any
+// "errors", the binding fails with a 502 status (or the upstream status, if that is itself an
+// error) whose body is the list of error messages, so the error can be handled using the binding's
+// "on_error", "if_status", and "optional" properties.  Setting the "partial" protocol option
+// accepts responses that contain both data and errors.
+//
+// # Binding Protocol Options
+//
+//   - query_file
+//     The path to a file (relative to the root directory) containing the query.
+//
+//   - operation
+//     The name of the operation (in a query containing several) to execute.
+//
+//   - partial (false)
+//     Return whatever data was retrieved, even if the response also contains errors.
+type GraphQLProtocol struct {
+}
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []graphqlError  `json:"errors"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+}
+
+func (gerr graphqlError) String() string {
+	if len(gerr.Path) > 0 {
+		var path = make([]string, len(gerr.Path))
+
+		for i, part := range gerr.Path {
+			path[i] = typeutil.String(part)
+		}
+
+		return fmt.Sprintf("%s (at %s)", gerr.Message, strings.Join(path, `.`))
+	}
+
+	return gerr.Message
+}
+
+func (protocol *GraphQLProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	if rr.Binding == nil {
+		return nil, fmt.Errorf("graphql: no binding specified")
+	}
+
+	var options = maputil.M(rr.Binding.ProtocolOptions)
+	var payload = map[string]any{}
+	var query, err = graphqlQuery(rr)
+
+	if err == nil {
+		payload[`query`] = query
+	} else {
+		return nil, err
+	}
+
+	if operation := options.String(`operation`); operation != `` {
+		payload[`operationName`] = operation
+	}
+
+	if len(rr.Binding.Params) > 0 {
+		var variables = make(map[string]any)
+		var types = graphqlVariableTypes(query)
+
+		for k, v := range rr.Binding.Params {
+			if value, err := rr.templateValue(v, false); err == nil {
+				variables[k] = graphqlVariable(value, types[k])
+			} else {
+				return nil, fmt.Errorf("param %q: %v", k, err)
+			}
+		}
+
+		payload[`variables`] = variables
+	}
+
+	body, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// perform the request as a plain HTTP POST, without params (which have become variables)
+	var binding = *rr.Binding
+	var hreq = *rr
+	var endpoint = *rr.URL
+
+	endpoint.Scheme = strings.TrimPrefix(endpoint.Scheme, `graphql+`)
+	binding.Params = nil
+	binding.BodyParams = nil
+	binding.RawBody = ``
+	hreq.Binding = &binding
+	hreq.URL = &endpoint
+	hreq.Verb = http.MethodPost
+	hreq.body = body
+	hreq.contentType = `application/json`
+
+	response, err := new(HttpProtocol).Retrieve(&hreq)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Close()
+
+	var raw []byte
+
+	if raw, err = io.ReadAll(response); err != nil {
+		return nil, err
+	}
+
+	var result graphqlResponse
+
+	if err := json.Unmarshal(raw, &result); err != nil {
+		if response.StatusCode >= 400 {
+			return graphqlErrorResponse(response, response.StatusCode, string(raw)), nil
+		}
+
+		return nil, fmt.Errorf("graphql: invalid response: %v", err)
+	}
+
+	var hasData = len(result.Data) > 0 && string(result.Data) != `null`
+
+	if len(result.Errors) > 0 && !(hasData && options.Bool(`partial`)) {
+		var messages = make([]string, len(result.Errors))
+
+		for i, gerr := range result.Errors {
+			messages[i] = gerr.String()
+		}
+
+		var status = http.StatusBadGateway
+
+		if response.StatusCode >= 400 {
+			status = response.StatusCode
+		}
+
+		return graphqlErrorResponse(response, status, `graphql: `+strings.Join(messages, `; `)), nil
+	} else if response.StatusCode >= 400 {
+		return graphqlErrorResponse(response, response.StatusCode, string(raw)), nil
+	}
+
+	if !hasData {
+		result.Data = nil
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `application/json`,
+		StatusCode: response.StatusCode,
+		Raw:        response.Raw,
+		data:       io.NopCloser(bytes.NewBuffer(result.Data)),
+	}, nil
+}
+
+func graphqlErrorResponse(response *ProtocolResponse, status int, message string) *ProtocolResponse {
+	return &ProtocolResponse{
+		MimeType:   `text/plain`,
+		StatusCode: status,
+		Raw:        response.Raw,
+		data:       io.NopCloser(bytes.NewBufferString(message)),
+	}
+}
+
+// matches variable definitions (e.g.: "$first: Int") in a query, capturing the name and the named type.
+var graphqlVariableDefinition = regexp.MustCompile(`\$([_A-Za-z][_0-9A-Za-z]*)\s*:\s*([_A-Za-z][_0-9A-Za-z]*)`)
+
+// return the type of each (non-list) variable defined in the query, keyed by variable name.
+func graphqlVariableTypes(query string) map[string]string {
+	var types = make(map[string]string)
+
+	for _, match := range graphqlVariableDefinition.FindAllStringSubmatch(query, -1) {
+		types[match[1]] = match[2]
+	}
+
+	return types
+}
+
+// convert a string variable value to the built-in scalar type the query declares for it.  Values of
+// any other type, or that cannot be converted, are sent as-is.
+func graphqlVariable(value any, vartype string) any {
+	if str, ok := value.(string); ok {
+		switch vartype {
+		case `Int`:
+			if v, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64); err == nil {
+				return v
+			}
+		case `Float`:
+			if v, err := strconv.ParseFloat(strings.TrimSpace(str), 64); err == nil {
+				return v
+			}
+		case `Boolean`:
+			if v, err := strconv.ParseBool(strings.TrimSpace(str)); err == nil {
+				return v
+			}
+		}
+	}
+
+	return value
+}
+
+// retrieve the query text, either inline from the binding's rawbody or from a query file.
+func graphqlQuery(rr *ProtocolRequest) (string, error) {
+	var filename = typeutil.String(rr.Binding.ProtocolOptions[`query_file`])
+
+	if filename != `` {
+		if !rr.Binding.NoTemplate {
+			if v, err := rr.Template(filename); err == nil {
+				filename = v.String()
+			} else {
+				return ``, fmt.Errorf("query_file: %v", err)
+			}
+		}
+
+		if data, err := rr.ReadFile(filename); err == nil {
+			return string(data), nil
+		} else {
+			return ``, fmt.Errorf("query_file: %v", err)
+		}
+	} else if query := strings.TrimSpace(rr.Binding.RawBody); query != `` {
+		return query, nil
+	}
+
+	return ``, fmt.Errorf("graphql: must specify a query in rawbody or the query_file protocol option")
+}
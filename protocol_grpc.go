@@ -0,0 +1,575 @@
+package diecast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var grpcConnectionPool sync.Map
+var grpcDescriptorCache sync.Map
+var grpcDescriptorTTL = 5 * time.Minute
+
+// The HTTP status reported for each gRPC status code, so that bindings can react to them with
+// "if_status".
+var GrpcStatusMap = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// The gRPC binding protocol is used to call methods on gRPC services.  It is specified with URLs
+// that use the grpc://host:port/package.Service/Method (plaintext) or
+// grpcs://host:port/package.Service/Method (TLS) schemes.  TLS connections honor the binding's
+// "insecure", "tlscrt", and "tlskey" properties and the server's "trustedRootPEMs"; specifying a
+// client certificate also implies TLS.
+//
+// The schema of the method is retrieved from the server using gRPC server reflection, unless a
+// descriptor set (as generated by "protoc --descriptor_set_out=... --include_imports") is given
+// with the "protoset" option, in which case the server does not need to support reflection.
+//
+// The request message is built from the binding's "params", overlaid with its "body" (so that
+// nested messages can be given as objects); values may contain templates, and are converted to the
+// type of the field they are assigned to.  The binding's "headers" are sent as request metadata.
+// The response message is returned as an object whose keys are the field names from the .proto
+// definition (with unset fields given their default values).  Methods that stream responses from
+// the server return an array of all the messages received.  Methods that stream requests from the
+// client are not supported.
+//
+// gRPC status codes are reported as the equivalent HTTP status (e.g.: NOT_FOUND is reported as
+// 404, UNAVAILABLE as 503), with the status message as the response body.
+//
+// # Binding Protocol Options
+//
+//   - protoset
+//     The path to a descriptor set file (relative to the root directory) describing the service.
+//
+// # Protocol Options
+//
+//   - grpc.protoset
+//     A descriptor set file to use for all gRPC bindings that don't specify one.
+//
+//   - grpc.descriptor_ttl (5m)
+//     How long the schemas retrieved from each server (or read from descriptor sets) are cached for.
+type GrpcProtocol struct {
+}
+
+type grpcDescriptorEntry struct {
+	files     *protoregistry.Files
+	expiresAt time.Time
+}
+
+func (protocol *GrpcProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	if rr.Binding == nil {
+		return nil, fmt.Errorf("grpc: no binding specified")
+	}
+
+	var service, method = grpcMethodName(rr.URL.Path)
+
+	if service == `` || method == `` {
+		return nil, fmt.Errorf("grpc: resource must be in the form grpc://host:port/package.Service/Method")
+	}
+
+	conn, err := grpcConnection(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), rr.Timeout())
+	defer cancel()
+
+	if md, err := grpcMetadata(rr); err == nil {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	} else {
+		return nil, err
+	}
+
+	files, err := grpcDescriptors(ctx, rr, conn, service)
+
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return nil, fmt.Errorf("grpc: %s does not support server reflection, a protoset must be specified", rr.URL.Host)
+		} else if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+			return grpcStatusResponse(st), nil
+		}
+
+		return nil, fmt.Errorf("grpc: %v", err)
+	}
+
+	var md protoreflect.MethodDescriptor
+
+	if desc, err := files.FindDescriptorByName(protoreflect.FullName(service)); err == nil {
+		if svc, ok := desc.(protoreflect.ServiceDescriptor); ok {
+			if md = svc.Methods().ByName(protoreflect.Name(method)); md == nil {
+				return nil, fmt.Errorf("grpc: service %s has no method %q", service, method)
+			}
+		} else {
+			return nil, fmt.Errorf("grpc: %s is not a service", service)
+		}
+	} else {
+		return nil, fmt.Errorf("grpc: %v", err)
+	}
+
+	if md.IsStreamingClient() {
+		return nil, fmt.Errorf("grpc: client streaming methods are not supported")
+	}
+
+	var input = dynamicpb.NewMessage(md.Input())
+
+	if err := grpcRequestMessage(rr, input); err != nil {
+		return nil, err
+	}
+
+	var fullMethod = `/` + service + `/` + method
+	var outputs []proto.Message
+
+	if md.IsStreamingServer() {
+		outputs, err = grpcServerStream(ctx, conn, fullMethod, input, md.Output())
+	} else {
+		var output = dynamicpb.NewMessage(md.Output())
+
+		err = conn.Invoke(ctx, fullMethod, input, output)
+		outputs = []proto.Message{output}
+	}
+
+	if err != nil {
+		if st, ok := status.FromError(err); ok {
+			log.Debugf("[%s]  binding %q: grpc %v: %s", reqid(rr.Request), rr.Binding.Name, st.Code(), st.Message())
+			return grpcStatusResponse(st), nil
+		}
+
+		return nil, err
+	}
+
+	var marshaler = protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: true,
+	}
+
+	var results = make([]json.RawMessage, len(outputs))
+
+	for i, output := range outputs {
+		if data, err := marshaler.Marshal(output); err == nil {
+			results[i] = data
+		} else {
+			return nil, err
+		}
+	}
+
+	var body []byte
+
+	if md.IsStreamingServer() {
+		body, err = json.Marshal(results)
+	} else {
+		body = results[0]
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `application/json`,
+		StatusCode: http.StatusOK,
+		Raw:        outputs,
+		data:       io.NopCloser(bytes.NewBuffer(body)),
+	}, nil
+}
+
+// split a URL path into the fully-qualified service name and method name.
+func grpcMethodName(path string) (string, string) {
+	path = strings.Trim(path, `/`)
+
+	if i := strings.LastIndex(path, `/`); i > 0 {
+		return path[:i], path[i+1:]
+	}
+
+	return path, ``
+}
+
+// retrieve (or create) a connection to the server described by the request URL.
+func grpcConnection(rr *ProtocolRequest) (*grpc.ClientConn, error) {
+	var useTLS = (rr.URL.Scheme == `grpcs` || rr.Binding.TlsCertificate != ``)
+	var cid = fmt.Sprintf("%s|%v|%v|%s|%s", rr.URL.Host, useTLS, rr.Binding.Insecure, rr.Binding.TlsCertificate, rr.Binding.TlsKey)
+
+	if v, ok := grpcConnectionPool.Load(cid); ok {
+		if conn, ok := v.(*grpc.ClientConn); ok {
+			return conn, nil
+		}
+	}
+
+	var creds = insecure.NewCredentials()
+
+	if useTLS {
+		if tcc, err := rr.TLSConfig(); err == nil {
+			creds = credentials.NewTLS(tcc)
+		} else {
+			return nil, err
+		}
+	}
+
+	if conn, err := grpc.NewClient(rr.URL.Host, grpc.WithTransportCredentials(creds)); err == nil {
+		if existing, loaded := grpcConnectionPool.LoadOrStore(cid, conn); loaded {
+			conn.Close()
+			return existing.(*grpc.ClientConn), nil
+		}
+
+		log.Debugf("GrpcProtocol: connecting to %s (tls: %v)", rr.URL.Host, useTLS)
+
+		return conn, nil
+	} else {
+		return nil, err
+	}
+}
+
+// build the outgoing request metadata from the binding's headers.
+func grpcMetadata(rr *ProtocolRequest) (metadata.MD, error) {
+	var md = metadata.MD{}
+
+	for k, v := range rr.Binding.Headers {
+		if !rr.Binding.NoTemplate {
+			if vv, err := rr.Template(v); err == nil {
+				v = vv.String()
+			} else {
+				return nil, fmt.Errorf("headers: %v", err)
+			}
+		}
+
+		md.Append(k, v)
+	}
+
+	for k, v := range rr.AdditionalHeaders {
+		md.Append(k, typeutil.String(v))
+	}
+
+	return md, nil
+}
+
+// populate the request message from the binding's params and body.
+func grpcRequestMessage(rr *ProtocolRequest, msg *dynamicpb.Message) error {
+	var input any = make(map[string]any)
+
+	for _, source := range []map[string]any{rr.Binding.Params, rr.Binding.BodyParams} {
+		if len(source) > 0 {
+			if values, err := rr.TemplateValue(source); err == nil {
+				input = mergeData(input, values)
+			} else {
+				return fmt.Errorf("grpc: request: %v", err)
+			}
+		}
+	}
+
+	if data, err := json.Marshal(input); err == nil {
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return fmt.Errorf("grpc: request: %v", err)
+		}
+	} else {
+		return err
+	}
+
+	return nil
+}
+
+// call a server streaming method, collecting all of the messages it returns.
+func grpcServerStream(ctx context.Context, conn *grpc.ClientConn, fullMethod string, input proto.Message, output protoreflect.MessageDescriptor) ([]proto.Message, error) {
+	var stream, err = conn.NewStream(ctx, &grpc.StreamDesc{
+		ServerStreams: true,
+	}, fullMethod)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(input); err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var outputs = make([]proto.Message, 0)
+
+	for {
+		var msg = dynamicpb.NewMessage(output)
+
+		if err := stream.RecvMsg(msg); err == io.EOF {
+			return outputs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, msg)
+	}
+}
+
+func grpcStatusResponse(st *status.Status) *ProtocolResponse {
+	var code = http.StatusInternalServerError
+
+	if c, ok := GrpcStatusMap[st.Code()]; ok {
+		code = c
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `text/plain`,
+		StatusCode: code,
+		Raw:        st,
+		data:       io.NopCloser(bytes.NewBufferString(st.Message())),
+	}
+}
+
+// retrieve the descriptors that describe the given service, either from a descriptor set file or
+// from the server itself.
+func grpcDescriptors(ctx context.Context, rr *ProtocolRequest, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	var protoset = typeutil.String(rr.Binding.ProtocolOptions[`protoset`])
+
+	if protoset == `` {
+		protoset = rr.Conf(`grpc`, `protoset`).String()
+	}
+
+	var key = `reflect:` + conn.Target() + `/` + service
+
+	if protoset != `` {
+		key = `protoset:` + protoset
+	}
+
+	if v, ok := grpcDescriptorCache.Load(key); ok {
+		if entry, ok := v.(*grpcDescriptorEntry); ok && time.Now().Before(entry.expiresAt) {
+			return entry.files, nil
+		}
+	}
+
+	var files *protoregistry.Files
+	var err error
+
+	if protoset != `` {
+		files, err = grpcProtoset(rr, protoset)
+	} else {
+		files, err = grpcReflect(ctx, conn, service)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	grpcDescriptorCache.Store(key, &grpcDescriptorEntry{
+		files:     files,
+		expiresAt: time.Now().Add(rr.Conf(`grpc`, `descriptor_ttl`, grpcDescriptorTTL).Duration()),
+	})
+
+	return files, nil
+}
+
+func grpcProtoset(rr *ProtocolRequest, filename string) (*protoregistry.Files, error) {
+	var set descriptorpb.FileDescriptorSet
+
+	if data, err := rr.ReadFile(filename); err == nil {
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("protoset %s: %v", filename, err)
+		}
+	} else {
+		return nil, fmt.Errorf("protoset %s: %v", filename, err)
+	}
+
+	return protodesc.NewFiles(&set)
+}
+
+// performs a single server reflection request for either the file containing a symbol, or a file
+// by name; returning the serialized file descriptors in the response.
+type grpcReflectionRequest func(symbol string, filename string) ([][]byte, error)
+
+// use server reflection to retrieve the file describing the given service, along with all of the
+// files it depends on.
+func grpcReflect(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	var ctx2, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	var request, err = grpcReflectionV1(ctx2, conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var protos = make(map[string]*descriptorpb.FileDescriptorProto)
+	var add = func(raw [][]byte) error {
+		for _, data := range raw {
+			var fdp = new(descriptorpb.FileDescriptorProto)
+
+			if err := proto.Unmarshal(data, fdp); err != nil {
+				return err
+			}
+
+			protos[fdp.GetName()] = fdp
+		}
+
+		return nil
+	}
+
+	raw, err := request(service, ``)
+
+	// fall back to the older version of the reflection service
+	if status.Code(err) == codes.Unimplemented {
+		if request, err = grpcReflectionV1Alpha(ctx2, conn); err != nil {
+			return nil, err
+		}
+
+		raw, err = request(service, ``)
+	}
+
+	if err != nil {
+		return nil, err
+	} else if err := add(raw); err != nil {
+		return nil, err
+	}
+
+	// retrieve any dependencies that weren't included in the response
+	for {
+		var missing []string
+
+		for _, fdp := range protos {
+			for _, dep := range fdp.GetDependency() {
+				if _, ok := protos[dep]; !ok {
+					missing = append(missing, dep)
+				}
+			}
+		}
+
+		if len(missing) == 0 {
+			break
+		}
+
+		for _, dep := range sliceutil.UniqueStrings(missing) {
+			if raw, err := request(``, dep); err == nil {
+				if err := add(raw); err != nil {
+					return nil, err
+				}
+			} else {
+				return nil, err
+			}
+
+			if _, ok := protos[dep]; !ok {
+				return nil, fmt.Errorf("server did not return a descriptor for %s", dep)
+			}
+		}
+	}
+
+	var set = new(descriptorpb.FileDescriptorSet)
+
+	for _, fdp := range protos {
+		set.File = append(set.File, fdp)
+	}
+
+	return protodesc.NewFiles(set)
+}
+
+func grpcReflectionV1(ctx context.Context, conn *grpc.ClientConn) (grpcReflectionRequest, error) {
+	var stream, err = reflectionv1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(symbol string, filename string) ([][]byte, error) {
+		var req = new(reflectionv1.ServerReflectionRequest)
+
+		if symbol != `` {
+			req.MessageRequest = &reflectionv1.ServerReflectionRequest_FileContainingSymbol{
+				FileContainingSymbol: symbol,
+			}
+		} else {
+			req.MessageRequest = &reflectionv1.ServerReflectionRequest_FileByFilename{
+				FileByFilename: filename,
+			}
+		}
+
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+
+		if res, err := stream.Recv(); err == nil {
+			if e := res.GetErrorResponse(); e != nil {
+				return nil, status.Error(codes.Code(e.GetErrorCode()), e.GetErrorMessage())
+			}
+
+			return res.GetFileDescriptorResponse().GetFileDescriptorProto(), nil
+		} else {
+			return nil, err
+		}
+	}, nil
+}
+
+func grpcReflectionV1Alpha(ctx context.Context, conn *grpc.ClientConn) (grpcReflectionRequest, error) {
+	var stream, err = reflectionv1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(symbol string, filename string) ([][]byte, error) {
+		var req = new(reflectionv1alpha.ServerReflectionRequest)
+
+		if symbol != `` {
+			req.MessageRequest = &reflectionv1alpha.ServerReflectionRequest_FileContainingSymbol{
+				FileContainingSymbol: symbol,
+			}
+		} else {
+			req.MessageRequest = &reflectionv1alpha.ServerReflectionRequest_FileByFilename{
+				FileByFilename: filename,
+			}
+		}
+
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+
+		if res, err := stream.Recv(); err == nil {
+			if e := res.GetErrorResponse(); e != nil {
+				return nil, status.Error(codes.Code(e.GetErrorCode()), e.GetErrorMessage())
+			}
+
+			return res.GetFileDescriptorResponse().GetFileDescriptorProto(), nil
+		} else {
+			return nil, err
+		}
+	}, nil
+}
@@ -2,7 +2,6 @@ package diecast
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +9,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/ghetzel/go-stockutil/httputil"
 	"github.com/ghetzel/go-stockutil/log"
@@ -92,7 +90,10 @@ func (protocol *HttpProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 		//
 		var body bytes.Buffer
 
-		if rr.Binding.BodyParams != nil {
+		if rr.body != nil {
+			log.Debugf("[%s]  binding %q: body (%d bytes)", id, rr.Binding.Name, len(rr.body))
+			request.Body = io.NopCloser(bytes.NewBuffer(rr.body))
+		} else if rr.Binding.BodyParams != nil {
 			var bodyParams = make(map[string]any)
 
 			if len(rr.Binding.BodyParams) > 0 {
@@ -206,39 +207,34 @@ func (protocol *HttpProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 			}
 		}
 
+		if rr.contentType != `` {
+			request.Header.Set(`Content-Type`, rr.contentType)
+		}
+
+		// if we're revalidating a cached response, ask the upstream to only send a new one if it changed
+		for k := range rr.Conditional {
+			request.Header.Set(k, rr.Conditional.Get(k))
+		}
+
 		request.Header.Set(`X-Diecast-Binding`, rr.Binding.Name)
 
-		// big block of custom TLS override setup
+		// custom TLS override setup
 		// ---------------------------------------------------------------------------------------------
-		var newTCC = &tls.Config{
-			InsecureSkipVerify: rr.Binding.Insecure,
-			RootCAs:            rr.Binding.server.altRootCaPool,
-		}
+		var newTCC, err = rr.TLSConfig()
 
-		// jump through some hoops to allow per-binding TLS client auth
-		if crt := rr.Binding.TlsCertificate; crt != `` {
-			if key := rr.Binding.TlsKey; key != `` {
-				if crtdata, err := rr.ReadFile(crt); err == nil {
-					if keydata, err := rr.ReadFile(key); err == nil {
-						if certificate, err := tls.X509KeyPair(crtdata, keydata); err == nil {
-							newTCC.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
-								return &certificate, nil
-							}
-						} else {
-							return nil, fmt.Errorf("bad certificate: %v", err)
-						}
-					} else {
-						return nil, fmt.Errorf("bad tls key: %v", err)
-					}
-				} else {
-					return nil, fmt.Errorf("bad tls cert: %v", err)
-				}
-			}
+		if err != nil {
+			return nil, err
 		}
 
 		// newTCC.BuildNameToCertificate()
 
-		if transport, ok := BindingClient.Transport.(*http.Transport); ok {
+		// each request gets its own client and transport so that per-binding TLS and timeout
+		// settings don't leak into other (possibly concurrent) binding requests
+		var transport *http.Transport
+
+		if t, ok := BindingClient.Transport.(*http.Transport); ok {
+			transport = t.Clone()
+
 			if tcc := transport.TLSClientConfig; tcc != nil {
 				tcc.InsecureSkipVerify = newTCC.InsecureSkipVerify
 				tcc.RootCAs = newTCC.RootCAs
@@ -246,28 +242,21 @@ func (protocol *HttpProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 				transport.TLSClientConfig = newTCC
 			}
 		} else {
-			BindingClient.Transport = &http.Transport{
+			transport = &http.Transport{
 				TLSClientConfig: newTCC,
 			}
 		}
 
-		if timeout := typeutil.V(rr.Binding.Timeout).Duration(); timeout > 0 {
-			if timeout < time.Microsecond {
-				// probably given as numeric seconds
-				timeout = timeout * time.Second
-			} else if timeout < time.Millisecond {
-				// probably given as numeric seconds
-				timeout = timeout * time.Millisecond
-			}
-
-			BindingClient.Timeout = timeout
-		}
-
-		if BindingClient.Timeout == 0 {
-			BindingClient.Timeout = DefaultBindingTimeout
+		var client = &http.Client{
+			Transport: &transportAwareRoundTripper{
+				transport: transport,
+			},
+			CheckRedirect: BindingClient.CheckRedirect,
+			Jar:           BindingClient.Jar,
+			Timeout:       rr.Timeout(),
 		}
 
-		log.Debugf("[%s]  binding: timeout=%v", id, BindingClient.Timeout)
+		log.Debugf("[%s]  binding: timeout=%v", id, client.Timeout)
 
 		if request.URL.Scheme == `https` && rr.Binding.Insecure {
 			log.Noticef("[%s] SSL/TLS certificate validation is disabled for this request.", id)
@@ -277,18 +266,12 @@ func (protocol *HttpProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 		// end TLS setup
 		// ---------------------------------------------------------------------------------------------
 
-		if t, ok := BindingClient.Transport.(*http.Transport); ok {
-			BindingClient.Transport = &transportAwareRoundTripper{
-				transport: t,
-			}
-		}
-
 		// tell the server we want to close the connection when done
 		request.Close = true
 
 		// perform binding request
 		// ---------------------------------------------------------------------------------------------
-		if res, err := BindingClient.Do(request); err == nil {
+		if res, err := client.Do(request); err == nil {
 			log.Infof("[%s] Binding: < HTTP %d (body: %d bytes)", id, res.StatusCode, res.ContentLength)
 
 			// debug log response headers
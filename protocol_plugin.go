@@ -0,0 +1,686 @@
+package diecast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/executil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+var DefaultPluginRestartDelay = time.Second
+var MaxPluginRestartDelay = 30 * time.Second
+var MaxPluginMessageSize = 64 * 1024 * 1024
+
+// the configuration keys that are used by Diecast itself, and not passed along to plugins.
+var pluginConfigKeys = []string{`plugin`, `plugin_args`, `plugin_env`, `timeout`, `restart_delay`, `forward_credentials`}
+
+// request headers that are only sent to plugins that have the "forward_credentials" option set.
+var pluginCredentialHeaders = []string{`Authorization`, `Cookie`, `Proxy-Authorization`}
+
+// A PluginProtocol handles bindings for a URL scheme using an external program.  Plugins are
+// declared by setting the "plugin" option of a protocol in the server's configuration:
+//
+//	protocols:
+//	  weather:
+//	    plugin: /usr/local/bin/diecast-weather
+//
+// The program is started the first time a binding uses the scheme, and is kept running to handle
+// all subsequent requests.  Diecast writes one JSON-RPC 2.0 request per line to the program's
+// standard input, and reads one response per line from its standard output.  Requests call the
+// "retrieve" method, whose params are a PluginRequest, and expect a PluginResponse as the result.
+// Several requests may be outstanding at once, and responses may be written in any order.
+// Anything the program writes to standard error is logged.
+//
+// If the program exits, any requests it was handling fail and the program is started again on
+// the next request.  Programs that exit repeatedly are restarted after an increasing delay, during
+// which requests fail with a 503 status.  Requests that take longer than the timeout fail with a
+// 504 status.
+//
+// Plugins are never run if the server's "disable_commands" option is set, or if Diecast is running
+// as root (unless the DIECAST_ALLOW_ROOT_ACTIONS environment variable is set to "true").
+//
+// # Protocol Options
+//
+//   - plugin
+//     The program to run: a name (located using the PATH environment variable), an absolute path,
+//     or a path relative to the server's root directory.
+//
+//   - plugin_args ([])
+//     Arguments to run the program with.
+//
+//   - plugin_env ({})
+//     Environment variables to set for the program, in addition to PATH.
+//
+//   - timeout (binding timeout)
+//     How long to wait for a response to each request.
+//
+//   - restart_delay (1s)
+//     How long to wait before restarting a program that exited, doubling (up to 30s) each time it
+//     exits without having handled a request.
+//
+//   - forward_credentials (false)
+//     Include the Authorization and Cookie headers of the request being served in the details of
+//     the request sent to the plugin.  They are removed otherwise.
+//
+// All other options are sent to the plugin with every request.
+type PluginProtocol struct {
+	Scheme       string
+	Program      string
+	Args         []string
+	Env          map[string]any
+	Dir          string
+	Timeout      time.Duration
+	RestartDelay time.Duration
+	Credentials  bool
+	Config       map[string]any
+	lock         sync.Mutex
+	proc         *pluginProcess
+	nextID       int64
+	failures     int
+	retryAt      time.Time
+}
+
+// The request sent to plugins as the params of the "retrieve" method.  Any templates in the
+// binding's params, body, rawbody, and headers have already been evaluated.
+type PluginRequest struct {
+	Verb    string             `json:"verb"`              // The binding's method (e.g.: GET).
+	URL     string             `json:"url"`               // The binding's resource URL.
+	Name    string             `json:"name,omitempty"`    // The name of the binding.
+	Headers map[string]string  `json:"headers,omitempty"` // The binding's headers.
+	Params  map[string]any     `json:"params,omitempty"`  // The binding's params.
+	Body    map[string]any     `json:"body,omitempty"`    // The binding's body.
+	RawBody string             `json:"rawbody,omitempty"` // The binding's rawbody.
+	Options map[string]any     `json:"options,omitempty"` // The binding's protocol options.
+	Config  map[string]any     `json:"config,omitempty"`  // The protocol's options from the server configuration.
+	Timeout float64            `json:"timeout"`           // How long (in seconds) Diecast will wait for the response.
+	Request *PluginRequestInfo `json:"request,omitempty"` // The request being served that caused the binding to be evaluated.
+}
+
+// Details of the request being served by Diecast.
+type PluginRequestInfo struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+// The result of the "retrieve" method, as returned by plugins.  The response body is given as a
+// string in "body" (which may be base64-encoded binary data if "encoding" is "base64"), or as any
+// JSON value in "data", in which case it is returned as JSON.
+type PluginResponse struct {
+	MimeType   string          `json:"mime_type,omitempty"` // The type of the response body (default: text/plain, or application/json if "data" is given).
+	StatusCode int             `json:"status,omitempty"`    // The status of the response, which bindings can react to with "if_status" (default: 200).
+	Body       string          `json:"body,omitempty"`      // The response body.
+	Encoding   string          `json:"encoding,omitempty"`  // How the body is encoded: "base64", or empty for plain text.
+	Data       json.RawMessage `json:"data,omitempty"`      // A JSON value to return instead of the body.
+}
+
+type pluginCall struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type pluginReply struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *pluginError    `json:"error,omitempty"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type pluginProcess struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	writeLock sync.Mutex
+	pending   map[int64]chan *pluginReply
+	lock      sync.Mutex
+	done      chan struct{}
+	err       error
+}
+
+// create a plugin from the given protocol configuration.
+func newPluginProtocol(scheme string, config ProtocolConfig, root string) *PluginProtocol {
+	var plugin = &PluginProtocol{
+		Scheme:       scheme,
+		Program:      config.Get(`plugin`).String(),
+		Args:         sliceutil.Stringify(sliceutil.Compact(config.Get(`plugin_args`).Value)),
+		Env:          config.Get(`plugin_env`).MapNative(),
+		Dir:          root,
+		Timeout:      config.Get(`timeout`).Duration(),
+		RestartDelay: config.Get(`restart_delay`, DefaultPluginRestartDelay).Duration(),
+		Credentials:  config.Get(`forward_credentials`).Bool(),
+		Config:       make(map[string]any),
+	}
+
+	if strings.Contains(plugin.Program, `/`) && !filepath.IsAbs(plugin.Program) {
+		plugin.Program = filepath.Join(root, plugin.Program)
+	}
+
+	for k, v := range config {
+		if !sliceutil.ContainsString(pluginConfigKeys, k) {
+			plugin.Config[k] = v
+		}
+	}
+
+	return plugin
+}
+
+func (plugin *PluginProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	if rr.Binding != nil && rr.Binding.server != nil && rr.Binding.server.DisableCommands {
+		return nil, fmt.Errorf("refusing to run plugins because DisableCommands is set")
+	} else if executil.IsRoot() && !executil.EnvBool(`DIECAST_ALLOW_ROOT_ACTIONS`) {
+		return nil, fmt.Errorf("refusing to run plugins as root.  Override with the environment variable DIECAST_ALLOW_ROOT_ACTIONS=true")
+	}
+
+	var timeout = plugin.Timeout
+
+	if timeout <= 0 {
+		timeout = rr.Timeout()
+	}
+
+	var preq, err = plugin.request(rr, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proc, id, err := plugin.process()
+
+	if err != nil {
+		log.Warningf("PluginProtocol: %s: %v", plugin.Scheme, err)
+		return pluginErrorResponse(http.StatusServiceUnavailable, fmt.Sprintf("plugin %s: %v", plugin.Scheme, err)), nil
+	}
+
+	var replies = proc.register(id)
+	defer proc.unregister(id)
+
+	// the write is bounded by the timeout too, since a program that has stopped reading its input
+	// would otherwise block it forever
+	var sent = make(chan error, 1)
+	var deadline = time.NewTimer(timeout)
+	var reply *pluginReply
+
+	defer deadline.Stop()
+
+	go func() {
+		sent <- proc.send(&pluginCall{
+			JSONRPC: `2.0`,
+			ID:      id,
+			Method:  `retrieve`,
+			Params:  preq,
+		})
+	}()
+
+	for reply == nil {
+		select {
+		case err := <-sent:
+			if err != nil {
+				return pluginErrorResponse(http.StatusServiceUnavailable, fmt.Sprintf("plugin %s: %v", plugin.Scheme, err)), nil
+			}
+
+			sent = nil
+		case reply = <-replies:
+		case <-proc.done:
+			return pluginErrorResponse(http.StatusBadGateway, fmt.Sprintf("plugin %s exited: %v", plugin.Scheme, proc.err)), nil
+		case <-deadline.C:
+			if sent != nil {
+				log.Warningf("PluginProtocol: %s: request could not be sent within %v", plugin.Scheme, timeout)
+			} else {
+				log.Warningf("PluginProtocol: %s: no response after %v", plugin.Scheme, timeout)
+			}
+
+			return pluginErrorResponse(http.StatusGatewayTimeout, fmt.Sprintf("plugin %s: timed out after %v", plugin.Scheme, timeout)), nil
+		}
+	}
+
+	plugin.lock.Lock()
+	plugin.failures = 0
+	plugin.lock.Unlock()
+
+	if reply.Error != nil {
+		return nil, fmt.Errorf("plugin %s: %s", plugin.Scheme, reply.Error.Message)
+	}
+
+	var result PluginResponse
+
+	if err := json.Unmarshal(reply.Result, &result); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %v", plugin.Scheme, err)
+	}
+
+	return result.protocolResponse()
+}
+
+// Stops the plugin's program, if it is running.  It will be started again if another request is made.
+func (plugin *PluginProtocol) Stop() {
+	plugin.lock.Lock()
+	var proc = plugin.proc
+	plugin.proc = nil
+	plugin.lock.Unlock()
+
+	if proc != nil {
+		proc.stdin.Close()
+
+		if p := proc.cmd.Process; p != nil {
+			p.Kill()
+		}
+
+		<-proc.done
+	}
+}
+
+// build the request that is sent to the plugin, evaluating any templates in the binding.
+func (plugin *PluginProtocol) request(rr *ProtocolRequest, timeout time.Duration) (*PluginRequest, error) {
+	var preq = &PluginRequest{
+		Verb:    strings.ToUpper(typeutil.OrString(rr.Verb, http.MethodGet)),
+		URL:     rr.URL.String(),
+		Config:  plugin.Config,
+		Timeout: timeout.Seconds(),
+	}
+
+	if req := rr.Request; req != nil {
+		var headers = req.Header
+
+		if !plugin.Credentials {
+			headers = headers.Clone()
+
+			for _, k := range pluginCredentialHeaders {
+				headers.Del(k)
+			}
+		}
+
+		preq.Request = &PluginRequestInfo{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: headers,
+		}
+	}
+
+	if binding := rr.Binding; binding != nil {
+		preq.Name = binding.Name
+		preq.Options = binding.ProtocolOptions
+
+		for _, field := range []struct {
+			name string
+			in   map[string]any
+			out  *map[string]any
+		}{
+			{`params`, binding.Params, &preq.Params},
+			{`body`, binding.BodyParams, &preq.Body},
+		} {
+			if len(field.in) > 0 {
+				if value, err := rr.TemplateValue(field.in); err == nil {
+					*field.out, _ = value.(map[string]any)
+				} else {
+					return nil, fmt.Errorf("%s: %v", field.name, err)
+				}
+			}
+		}
+
+		if len(binding.Headers) > 0 {
+			preq.Headers = make(map[string]string, len(binding.Headers))
+
+			for k, v := range binding.Headers {
+				if binding.NoTemplate {
+					preq.Headers[k] = v
+				} else if value, err := rr.Template(v); err == nil {
+					preq.Headers[k] = value.String()
+				} else {
+					return nil, fmt.Errorf("header %q: %v", k, err)
+				}
+			}
+		}
+
+		if preq.RawBody = binding.RawBody; preq.RawBody != `` && !binding.NoTemplate {
+			if value, err := rr.Template(preq.RawBody); err == nil {
+				preq.RawBody = value.String()
+			} else {
+				return nil, fmt.Errorf("rawbody: %v", err)
+			}
+		}
+	}
+
+	return preq, nil
+}
+
+// return the running plugin process (starting it if necessary), and the ID to use for the next call.
+func (plugin *PluginProtocol) process() (*pluginProcess, int64, error) {
+	plugin.lock.Lock()
+	defer plugin.lock.Unlock()
+
+	plugin.nextID += 1
+
+	if proc := plugin.proc; proc != nil {
+		select {
+		case <-proc.done:
+			plugin.proc = nil
+		default:
+			return proc, plugin.nextID, nil
+		}
+	}
+
+	if wait := time.Until(plugin.retryAt); wait > 0 {
+		return nil, 0, fmt.Errorf("exited recently, restarting in %v", wait.Round(time.Millisecond))
+	}
+
+	if plugin.Program == `` {
+		return nil, 0, fmt.Errorf("no program specified")
+	}
+
+	var cmd = exec.Command(plugin.Program, plugin.Args...)
+	var proc = &pluginProcess{
+		cmd:     cmd,
+		pending: make(map[int64]chan *pluginReply),
+		done:    make(chan struct{}),
+	}
+
+	cmd.Dir = plugin.Dir
+	cmd.Env = []string{`PATH=` + os.Getenv(`PATH`)}
+
+	for k, v := range plugin.Env {
+		cmd.Env = append(cmd.Env, k+`=`+typeutil.String(v))
+	}
+
+	var stdout, stderr io.ReadCloser
+	var err error
+
+	if proc.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, 0, err
+	} else if stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, 0, err
+	} else if stderr, err = cmd.StderrPipe(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		plugin.failed()
+		return nil, 0, err
+	}
+
+	log.Infof("PluginProtocol: %s: started %s (pid %d)", plugin.Scheme, plugin.Program, cmd.Process.Pid)
+
+	go func() {
+		var lines = bufio.NewScanner(stderr)
+
+		for lines.Scan() {
+			log.Warningf("PluginProtocol: %s: %s", plugin.Scheme, lines.Text())
+		}
+	}()
+
+	go func() {
+		proc.read(stdout)
+		proc.err = cmd.Wait()
+
+		if proc.err == nil {
+			proc.err = fmt.Errorf("exited")
+		}
+
+		plugin.lock.Lock()
+
+		if plugin.proc == proc {
+			plugin.proc = nil
+			plugin.failed()
+			log.Warningf("PluginProtocol: %s: %v; restarting after %v", plugin.Scheme, proc.err, time.Until(plugin.retryAt).Round(time.Millisecond))
+		}
+
+		plugin.lock.Unlock()
+		close(proc.done)
+	}()
+
+	plugin.proc = proc
+
+	return proc, plugin.nextID, nil
+}
+
+// record that the program exited (or could not start), and work out when to try again.
+// must be called with the plugin lock held.
+func (plugin *PluginProtocol) failed() {
+	var delay = plugin.RestartDelay
+
+	for i := 0; i < plugin.failures && delay < MaxPluginRestartDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > MaxPluginRestartDelay {
+		delay = MaxPluginRestartDelay
+	}
+
+	plugin.failures += 1
+	plugin.retryAt = time.Now().Add(delay)
+}
+
+func (proc *pluginProcess) register(id int64) chan *pluginReply {
+	var replies = make(chan *pluginReply, 1)
+
+	proc.lock.Lock()
+	proc.pending[id] = replies
+	proc.lock.Unlock()
+
+	return replies
+}
+
+func (proc *pluginProcess) unregister(id int64) {
+	proc.lock.Lock()
+	delete(proc.pending, id)
+	proc.lock.Unlock()
+}
+
+func (proc *pluginProcess) send(call *pluginCall) error {
+	var line, err = json.Marshal(call)
+
+	if err != nil {
+		return err
+	}
+
+	proc.writeLock.Lock()
+	defer proc.writeLock.Unlock()
+
+	_, err = proc.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// read responses from the program's standard output until it is closed, handing each to the
+// request that is waiting for it.
+func (proc *pluginProcess) read(stdout io.Reader) {
+	var lines = bufio.NewScanner(stdout)
+
+	lines.Buffer(make([]byte, 0, 64*1024), MaxPluginMessageSize)
+
+	for lines.Scan() {
+		var reply pluginReply
+
+		if line := bytes.TrimSpace(lines.Bytes()); len(line) == 0 {
+			continue
+		} else if err := json.Unmarshal(line, &reply); err != nil {
+			log.Warningf("PluginProtocol: invalid message from pid %d: %v", proc.cmd.Process.Pid, err)
+			continue
+		}
+
+		proc.lock.Lock()
+
+		if replies, ok := proc.pending[reply.ID]; ok {
+			replies <- &reply
+			delete(proc.pending, reply.ID)
+		}
+
+		proc.lock.Unlock()
+	}
+
+	if err := lines.Err(); err != nil {
+		log.Warningf("PluginProtocol: reading from pid %d: %v", proc.cmd.Process.Pid, err)
+		proc.cmd.Process.Kill()
+	}
+}
+
+func (presp *PluginResponse) protocolResponse() (*ProtocolResponse, error) {
+	var response = &ProtocolResponse{
+		MimeType:   presp.MimeType,
+		StatusCode: presp.StatusCode,
+		Raw:        presp,
+	}
+
+	if response.StatusCode == 0 {
+		response.StatusCode = http.StatusOK
+	}
+
+	var body []byte
+
+	if len(presp.Data) > 0 {
+		body = presp.Data
+
+		if response.MimeType == `` {
+			response.MimeType = `application/json`
+		}
+	} else {
+		switch presp.Encoding {
+		case `base64`:
+			if decoded, err := base64.StdEncoding.DecodeString(presp.Body); err == nil {
+				body = decoded
+			} else {
+				return nil, fmt.Errorf("invalid base64 body: %v", err)
+			}
+		case ``:
+			body = []byte(presp.Body)
+		default:
+			return nil, fmt.Errorf("unsupported body encoding %q", presp.Encoding)
+		}
+
+		if response.MimeType == `` {
+			response.MimeType = `text/plain; charset=utf-8`
+		}
+	}
+
+	response.data = io.NopCloser(bytes.NewBuffer(body))
+
+	return response, nil
+}
+
+func pluginErrorResponse(status int, message string) *ProtocolResponse {
+	return &ProtocolResponse{
+		MimeType:   `text/plain; charset=utf-8`,
+		StatusCode: status,
+		Raw:        message,
+		data:       io.NopCloser(bytes.NewBufferString(message)),
+	}
+}
+
+// A function that handles requests made to a plugin.
+type PluginHandlerFunc func(*PluginRequest) (*PluginResponse, error)
+
+// Implements the plugin side of the protocol used by PluginProtocol, for plugins written in Go.
+// Requests are read from the given reader (usually os.Stdin) and passed to the handler, and each
+// response (or error) returned by the handler is written to the given writer (usually os.Stdout).
+// Requests are handled concurrently.  ServePlugin returns once the reader is closed and all
+// requests have been handled.
+func ServePlugin(in io.Reader, out io.Writer, handler PluginHandlerFunc) error {
+	var lines = bufio.NewScanner(in)
+	var wg sync.WaitGroup
+	var writeLock sync.Mutex
+
+	lines.Buffer(make([]byte, 0, 64*1024), MaxPluginMessageSize)
+
+	var reply = func(msg *pluginReply) {
+		msg.JSONRPC = `2.0`
+
+		if line, err := json.Marshal(msg); err == nil {
+			writeLock.Lock()
+			out.Write(append(line, '\n'))
+			writeLock.Unlock()
+		}
+	}
+
+	for lines.Scan() {
+		var call struct {
+			ID     int64           `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+
+		if line := bytes.TrimSpace(lines.Bytes()); len(line) == 0 {
+			continue
+		} else if err := json.Unmarshal(line, &call); err != nil {
+			reply(&pluginReply{Error: &pluginError{Code: -32700, Message: err.Error()}})
+			continue
+		} else if call.Method != `retrieve` {
+			reply(&pluginReply{ID: call.ID, Error: &pluginError{Code: -32601, Message: fmt.Sprintf("unknown method %q", call.Method)}})
+			continue
+		}
+
+		var preq PluginRequest
+
+		if err := json.Unmarshal(call.Params, &preq); err != nil {
+			reply(&pluginReply{ID: call.ID, Error: &pluginError{Code: -32602, Message: err.Error()}})
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(id int64) {
+			defer wg.Done()
+
+			if presp, err := handler(&preq); err == nil {
+				if result, err := json.Marshal(presp); err == nil {
+					reply(&pluginReply{ID: id, Result: result})
+				} else {
+					reply(&pluginReply{ID: id, Error: &pluginError{Code: -32603, Message: err.Error()}})
+				}
+			} else {
+				reply(&pluginReply{ID: id, Error: &pluginError{Code: -32000, Message: err.Error()}})
+			}
+		}(call.ID)
+	}
+
+	wg.Wait()
+
+	return lines.Err()
+}
+
+// return the plugin that handles the given scheme, if one is configured.
+func (server *Server) pluginProtocol(scheme string) *PluginProtocol {
+	var config, ok = server.Protocols[scheme]
+
+	if !ok || config.Get(`plugin`).String() == `` {
+		return nil
+	}
+
+	server.pluginLock.Lock()
+	defer server.pluginLock.Unlock()
+
+	if server.plugins == nil {
+		server.plugins = make(map[string]*PluginProtocol)
+	}
+
+	if _, ok := server.plugins[scheme]; !ok {
+		server.plugins[scheme] = newPluginProtocol(scheme, config, server.RootPath)
+	}
+
+	return server.plugins[scheme]
+}
+
+// stop all running plugins.
+func (server *Server) stopPlugins() {
+	server.pluginLock.Lock()
+	defer server.pluginLock.Unlock()
+
+	for _, plugin := range server.plugins {
+		plugin.Stop()
+	}
+}
@@ -0,0 +1,260 @@
+package diecast
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/ghetzel/testify/require"
+)
+
+// when run with DIECAST_TEST_PLUGIN set, the test binary acts as a plugin for TestBindingPlugin.
+func TestMain(m *testing.M) {
+	if os.Getenv(`DIECAST_TEST_PLUGIN`) != `` {
+		if err := ServePlugin(os.Stdin, os.Stdout, testPluginHandler); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func testPluginHandler(preq *PluginRequest) (*PluginResponse, error) {
+	var _, path, _ = strings.Cut(preq.URL, `://`)
+
+	switch path {
+	case `echo`:
+		var data, err = json.Marshal(map[string]any{
+			`verb`:    preq.Verb,
+			`name`:    preq.Name,
+			`params`:  preq.Params,
+			`body`:    preq.Body,
+			`rawbody`: preq.RawBody,
+			`headers`: preq.Headers,
+			`options`: preq.Options,
+			`config`:  preq.Config,
+			`env`:     os.Getenv(`GREETING`),
+			`request`: preq.Request.Method + ` ` + preq.Request.URL,
+			`credentials`: preq.Request.Headers[`Authorization`] != nil ||
+				preq.Request.Headers[`Cookie`] != nil,
+		})
+
+		return &PluginResponse{Data: data}, err
+	case `pid`:
+		return &PluginResponse{Body: typeutil.String(os.Getpid())}, nil
+	case `missing`:
+		return &PluginResponse{StatusCode: http.StatusNotFound, Body: `not here`}, nil
+	case `binary`:
+		return &PluginResponse{
+			MimeType: `application/octet-stream`,
+			Body:     base64.StdEncoding.EncodeToString([]byte{0x00, 0xff, 0x10}),
+			Encoding: `base64`,
+		}, nil
+	case `slow`:
+		time.Sleep(2 * time.Second)
+		return &PluginResponse{Body: `finally`}, nil
+	case `fail`:
+		return nil, fmt.Errorf("something went wrong")
+	case `crash`:
+		fmt.Fprintln(os.Stderr, `crashing on purpose`)
+		os.Exit(3)
+	}
+
+	return nil, fmt.Errorf("unknown path %q", path)
+}
+
+func TestBindingPlugin(t *testing.T) {
+	var assert = require.New(t)
+	var program, err = os.Executable()
+
+	assert.NoError(err)
+	t.Setenv(`DIECAST_ALLOW_ROOT_ACTIONS`, `true`)
+
+	var dc = NewServer(`./tests/hello`)
+	assert.NoError(dc.Initialize())
+
+	dc.Protocols = map[string]ProtocolConfig{
+		`test`: {
+			`plugin`: program,
+			`plugin_env`: map[string]any{
+				`DIECAST_TEST_PLUGIN`: `1`,
+				`GREETING`:            `hello`,
+			},
+			`timeout`:       `500ms`,
+			`restart_delay`: `200ms`,
+			`region`:        `north`,
+		},
+		`trusted`: {
+			`plugin`: program,
+			`plugin_env`: map[string]any{
+				`DIECAST_TEST_PLUGIN`: `1`,
+			},
+			`forward_credentials`: true,
+		},
+		`stalled`: {
+			`plugin`:      `/bin/sleep`,
+			`plugin_args`: []any{`30`},
+			`timeout`:     `500ms`,
+		},
+	}
+
+	defer dc.stopPlugins()
+
+	var data = map[string]any{`limit`: 5}
+	var funcs = dc.GetTemplateFunctions(data, nil)
+	var evaluate = func(binding *Binding) (any, error) {
+		binding.Name = `plugin`
+		binding.server = dc
+
+		var r = req(`GET`, `/page`)
+
+		r.Header.Set(`Authorization`, `Bearer secret`)
+		r.Header.Set(`Cookie`, `session=secret`)
+
+		return binding.Evaluate(r, &TemplateHeader{}, data, funcs)
+	}
+
+	// templates are evaluated before the request is sent
+	out, err := evaluate(&Binding{
+		Resource: `test://echo`,
+		Method:   `post`,
+		Params: map[string]any{
+			`limit`: `{{ $.limit }}`,
+			`tags`:  []any{`a`, `b`},
+		},
+		BodyParams: map[string]any{
+			`nested`: map[string]any{`x`: `{{ add $.limit 1 }}`},
+		},
+		RawBody: `limit={{ $.limit }}`,
+		Headers: map[string]string{
+			`X-Limit`: `{{ $.limit }}`,
+		},
+		ProtocolOptions: map[string]any{
+			`units`: `metric`,
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]any{
+		`verb`:        `POST`,
+		`name`:        `plugin`,
+		`params`:      map[string]any{`limit`: float64(5), `tags`: []any{`a`, `b`}},
+		`body`:        map[string]any{`nested`: map[string]any{`x`: float64(6)}},
+		`rawbody`:     `limit=5`,
+		`headers`:     map[string]any{`X-Limit`: `5`},
+		`options`:     map[string]any{`units`: `metric`},
+		`config`:      map[string]any{`region`: `north`},
+		`env`:         `hello`,
+		`request`:     `GET /page`,
+		`credentials`: false,
+	}, out)
+
+	// credentials are only sent to plugins that are configured to receive them
+	out, err = evaluate(&Binding{
+		Resource: `trusted://echo`,
+	})
+
+	assert.NoError(err)
+	assert.Equal(true, out.(map[string]any)[`credentials`])
+
+	// programs that stop reading their input cannot block calls beyond the timeout
+	var started = time.Now()
+
+	_, err = evaluate(&Binding{
+		Resource: `stalled://anything`,
+		RawBody:  strings.Repeat(`x`, 1<<20),
+		IfStatus: map[string]BindingErrorAction{
+			`504`: `/stalled`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/stalled`), err)
+	assert.True(time.Since(started) < 5*time.Second)
+
+	// statuses are reported to the binding
+	_, err = evaluate(&Binding{
+		Resource: `test://missing`,
+		IfStatus: map[string]BindingErrorAction{
+			`404`: `/not-found`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/not-found`), err)
+
+	out, err = evaluate(&Binding{
+		Resource: `test://binary`,
+	})
+
+	assert.NoError(err)
+	assert.Equal([]byte{0x00, 0xff, 0x10}, out)
+
+	_, err = evaluate(&Binding{
+		Resource: `test://fail`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `something went wrong`)
+
+	// calls that take too long time out, without affecting other calls
+	_, err = evaluate(&Binding{
+		Resource: `test://slow`,
+		IfStatus: map[string]BindingErrorAction{
+			`504`: `/timeout`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/timeout`), err)
+
+	pid, err := evaluate(&Binding{
+		Resource: `test://pid`,
+	})
+
+	assert.NoError(err)
+
+	// the process is restarted after it exits
+	_, err = evaluate(&Binding{
+		Resource: `test://crash`,
+		IfStatus: map[string]BindingErrorAction{
+			`502`: `/crashed`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/crashed`), err)
+
+	_, err = evaluate(&Binding{
+		Resource: `test://pid`,
+		IfStatus: map[string]BindingErrorAction{
+			`503`: `/restarting`,
+		},
+	})
+
+	assert.Equal(RedirectTo(`/restarting`), err)
+
+	time.Sleep(250 * time.Millisecond)
+
+	restarted, err := evaluate(&Binding{
+		Resource: `test://pid`,
+	})
+
+	assert.NoError(err)
+	assert.NotEqual(pid, restarted)
+
+	// plugins are not run when commands are disabled
+	dc.DisableCommands = true
+
+	_, err = evaluate(&Binding{
+		Resource: `test://pid`,
+	})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), `DisableCommands`)
+}
@@ -3,9 +3,12 @@ package diecast
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +24,7 @@ var redisConnectionPool sync.Map
 var redisPoolMaxIdle = 10
 var redisPoolIdleTimeout = 120 * time.Second
 var redisPoolMaxLifetime = 10 * time.Minute
+var redisScanMax = 10000
 var redisBlacklistedCommands = []string{
 	`AUTH`,
 	`BGREWRITEAOF`,
@@ -56,6 +60,7 @@ var redisBlacklistedCommands = []string{
 	`SAVE`,
 	`SCAN`,
 	`SCRIPT`,
+	`SENTINEL`,
 	`SELECT`,
 	`SHUTDOWN`,
 	`SLAVEOF`,
@@ -71,7 +76,34 @@ var redisBlacklistedCommands = []string{
 }
 
 // The Redis binding protocol is used to retrieve or modify items in a Redis server.
-// It is specified with URLs that use the redis://[host[:port]]/db/key scheme.
+// It is specified with URLs that use the redis://[[user]:password@][host[:port]]/key scheme, where
+// the binding's "method" is the command to run (GET by default) and the path segments are its
+// arguments.  Other ways of connecting are specified with these schemes:
+//
+//   - rediss://host[:port]/key
+//     Connect using TLS.
+//
+//   - redis+unix:///key?socket=/path/to/redis.sock
+//     Connect to a local Unix socket.
+//
+//   - redis+sentinel://sentinel1[:port][,sentinel2[:port]...]/key?master=name
+//     Ask the given Sentinels for the address of the current master, then connect to it.
+//
+// Commands that could be used to view or modify server configuration, or that block, are not
+// permitted.  In their place, the following safe read helpers are provided:
+//
+//   - SCAN
+//     Iterate through all keys matching the "match" param (in batches of "count", and optionally
+//     limited to those of a given "type"), returning a sorted list of up to "max" keys.
+//
+//   - XRANGE, XREVRANGE (/stream[/start[/end]])
+//     Read entries from a stream, returning a list of objects containing each entry's "id" and
+//     "fields".  Up to "count" entries are returned if that param is given.
+//
+//   - SCRIPT (/name[/key...])
+//     Run the Lua script registered under the given name in the "redis.scripts" option, with the
+//     remaining path segments as its KEYS and the "args" param as its ARGV.  Arbitrary scripts
+//     cannot be run.
 //
 // # Protocol Options
 //
@@ -86,46 +118,58 @@ var redisBlacklistedCommands = []string{
 //
 //   - redis.max_lifetime (10m)
 //     The maximum amount of time a connection can remain open before being recycled.
+//
+//   - redis.username, redis.password
+//     Credentials to authenticate with (credentials in the URL take precedence).
+//
+//   - redis.db (0)
+//     The database number to select (the "db" URL parameter takes precedence).
+//
+//   - redis.tls (false)
+//     Connect using TLS (implied by the rediss:// scheme).
+//
+//   - redis.tls_insecure (false)
+//     Skip verification of the server's TLS certificate.
+//
+//   - redis.socket
+//     The Unix socket to connect to with redis+unix:// URLs that don't specify one.
+//
+//   - redis.sentinel_master (mymaster)
+//     The name of the master to ask Sentinels for (the "master" URL parameter takes precedence).
+//
+//   - redis.sentinel_password
+//     The password to authenticate to Sentinels with.
+//
+//   - redis.scripts ({})
+//     A mapping of script names to the files (relative to the root directory) containing them.
+//
+//   - redis.scan_max (10000)
+//     The maximum number of keys SCAN will return.
 type RedisProtocol struct {
 }
 
 func (protocol *RedisProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
-	var pid = rr.URL.Host
-
-	if pid == `` {
-		pid = rr.Conf(`redis`, `default_host`, `localhost:6379`).String()
-	}
-
 	if rr.Verb == `` {
 		rr.Verb = `GET`
 	} else {
 		rr.Verb = strings.ToUpper(rr.Verb)
 	}
 
-	if first, _ := stringutil.SplitPair(rr.Verb, ` `); sliceutil.ContainsString(redisBlacklistedCommands, first) {
-		return nil, fmt.Errorf("the %q command is not permitted", rr.Verb)
-	}
-
-	var pool *redis.Pool
+	var command, _ = stringutil.SplitPair(rr.Verb, ` `)
 
-	if v, ok := redisConnectionPool.Load(pid); ok {
-		if p, ok := v.(*redis.Pool); ok {
-			pool = p
+	switch command {
+	case `SCAN`, `SCRIPT`:
+		break
+	default:
+		if sliceutil.ContainsString(redisBlacklistedCommands, command) {
+			return nil, fmt.Errorf("the %q command is not permitted", rr.Verb)
 		}
 	}
 
-	if pool == nil {
-		pool = &redis.Pool{
-			MaxIdle:         int(rr.Conf(`redis`, `max_idle`, redisPoolMaxIdle).Int()),
-			IdleTimeout:     rr.Conf(`redis`, `idle_timeout`, redisPoolIdleTimeout).Duration(),
-			MaxConnLifetime: rr.Conf(`redis`, `max_lifetime`, redisPoolMaxLifetime).Duration(),
-			Dial: func() (redis.Conn, error) {
-				return redis.Dial(`tcp`, pid)
-			},
-		}
+	var pool, err = redisPool(rr)
 
-		log.Debugf("RedisProtocol: created new pool to handle connections to %s", pid)
-		redisConnectionPool.Store(pid, pool)
+	if err != nil {
+		return nil, err
 	}
 
 	// setup context and load it up with cancel functions and timeouts and cool stuff like that
@@ -144,7 +188,20 @@ func (protocol *RedisProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 		var args = strings.Split(strings.TrimPrefix(rr.URL.Path, `/`), `/`)
 		args = sliceutil.CompactString(args)
 
-		if reply, err := conn.Do(rr.Verb, sliceutil.Sliceify(args)...); err == nil {
+		var reply any
+
+		switch command {
+		case `SCAN`:
+			reply, err = redisScan(rr, conn)
+		case `XRANGE`, `XREVRANGE`:
+			reply, err = redisStreamRange(rr, conn, command, args)
+		case `SCRIPT`:
+			reply, err = redisScript(rr, conn, args)
+		default:
+			reply, err = conn.Do(rr.Verb, sliceutil.Sliceify(args)...)
+		}
+
+		if err == nil {
 			var buf = bytes.NewBuffer(nil)
 			var response = &ProtocolResponse{
 				Raw:        reply,
@@ -159,6 +216,13 @@ func (protocol *RedisProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 				response.MimeType = `text/plain; charset=utf-8`
 				buf.Write([]byte(typeutil.String(reply)))
 
+			case []string, []map[string]any:
+				response.MimeType = `application/json; charset=utf-8`
+
+				if err := json.NewEncoder(buf).Encode(reply); err != nil {
+					return response, err
+				}
+
 			case []any:
 				response.MimeType = `application/json; charset=utf-8`
 
@@ -204,3 +268,294 @@ func (protocol *RedisProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse,
 		return nil, fmt.Errorf("cannot obtain Redis connection: %v", err)
 	}
 }
+
+// retrieve (or create) the connection pool for the server described by the request URL.
+func redisPool(rr *ProtocolRequest) (*redis.Pool, error) {
+	var qs = rr.URL.Query()
+	var network = `tcp`
+	var addr = rr.URL.Host
+	var useTLS = rr.Conf(`redis`, `tls`).Bool()
+	var sentinels []string
+	var master string
+
+	switch rr.URL.Scheme {
+	case `rediss`:
+		useTLS = true
+	case `redis+unix`:
+		network = `unix`
+		addr = sliceutil.OrString(qs.Get(`socket`), rr.Conf(`redis`, `socket`).String())
+
+		if addr == `` {
+			return nil, fmt.Errorf("redis+unix: must specify a socket")
+		}
+	case `redis+sentinel`:
+		sentinels = sliceutil.CompactString(strings.Split(rr.URL.Host, `,`))
+		master = sliceutil.OrString(qs.Get(`master`), rr.Conf(`redis`, `sentinel_master`, `mymaster`).String())
+
+		if len(sentinels) == 0 {
+			return nil, fmt.Errorf("redis+sentinel: must specify at least one sentinel")
+		}
+
+		for i, sentinel := range sentinels {
+			if _, _, err := net.SplitHostPort(sentinel); err != nil {
+				sentinels[i] = net.JoinHostPort(sentinel, `26379`)
+			}
+		}
+	}
+
+	if addr == `` {
+		addr = rr.Conf(`redis`, `default_host`, `localhost:6379`).String()
+	}
+
+	var username = rr.Conf(`redis`, `username`).String()
+	var password = rr.Conf(`redis`, `password`).String()
+	var db = rr.Conf(`redis`, `db`).Int()
+
+	if u := rr.URL.User; u != nil {
+		username = u.Username()
+
+		if pw, ok := u.Password(); ok {
+			password = pw
+		}
+	}
+
+	if v := qs.Get(`db`); v != `` {
+		db = typeutil.Int(v)
+	}
+
+	var pid = fmt.Sprintf("%s://%s@%s|%s|%d", rr.URL.Scheme, username, addr, master, db)
+
+	if v, ok := redisConnectionPool.Load(pid); ok {
+		if p, ok := v.(*redis.Pool); ok {
+			return p, nil
+		}
+	}
+
+	var options = []redis.DialOption{
+		redis.DialUsername(username),
+		redis.DialPassword(password),
+		redis.DialDatabase(int(db)),
+	}
+
+	if useTLS {
+		var tcc = &tls.Config{
+			InsecureSkipVerify: rr.Conf(`redis`, `tls_insecure`).Bool(),
+		}
+
+		if rr.Binding != nil && rr.Binding.server != nil {
+			tcc.RootCAs = rr.Binding.server.altRootCaPool
+		}
+
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tcc))
+	}
+
+	var sentinelPassword = rr.Conf(`redis`, `sentinel_password`).String()
+
+	var pool = &redis.Pool{
+		MaxIdle:         int(rr.Conf(`redis`, `max_idle`, redisPoolMaxIdle).Int()),
+		IdleTimeout:     rr.Conf(`redis`, `idle_timeout`, redisPoolIdleTimeout).Duration(),
+		MaxConnLifetime: rr.Conf(`redis`, `max_lifetime`, redisPoolMaxLifetime).Duration(),
+		Dial: func() (redis.Conn, error) {
+			if len(sentinels) > 0 {
+				if a, err := redisSentinelMaster(sentinels, master, sentinelPassword); err == nil {
+					return redis.Dial(network, a, options...)
+				} else {
+					return nil, err
+				}
+			}
+
+			return redis.Dial(network, addr, options...)
+		},
+	}
+
+	if existing, loaded := redisConnectionPool.LoadOrStore(pid, pool); loaded {
+		return existing.(*redis.Pool), nil
+	}
+
+	log.Debugf("RedisProtocol: created new pool to handle connections to %s", pid)
+
+	return pool, nil
+}
+
+// ask each sentinel in turn for the address of the named master.
+func redisSentinelMaster(sentinels []string, master string, password string) (string, error) {
+	var lastErr error
+
+	for _, sentinel := range sentinels {
+		if conn, err := redis.Dial(`tcp`, sentinel, redis.DialPassword(password)); err == nil {
+			var reply, err = redis.Strings(conn.Do(`SENTINEL`, `get-master-addr-by-name`, master))
+			conn.Close()
+
+			if err == nil && len(reply) == 2 {
+				return net.JoinHostPort(reply[0], reply[1]), nil
+			} else if err == nil || err == redis.ErrNil {
+				lastErr = fmt.Errorf("sentinel %s does not know of master %q", sentinel, master)
+			} else {
+				lastErr = err
+			}
+		} else {
+			lastErr = err
+		}
+	}
+
+	return ``, fmt.Errorf("redis+sentinel: cannot locate master %q: %v", master, lastErr)
+}
+
+// retrieve the value of a binding param, evaluating any templates in it.
+func redisParam(rr *ProtocolRequest, name string) (any, error) {
+	if rr.Binding == nil {
+		return nil, nil
+	} else if v, err := rr.TemplateValue(rr.Binding.Params[name]); err == nil {
+		return v, nil
+	} else {
+		return nil, fmt.Errorf("param %q: %v", name, err)
+	}
+}
+
+// iterate through all keys matching a pattern, returning them sorted.
+func redisScan(rr *ProtocolRequest, conn redis.Conn) ([]string, error) {
+	var opts []any
+	var max = int(rr.Conf(`redis`, `scan_max`, redisScanMax).Int())
+
+	for _, name := range []string{`match`, `count`, `type`} {
+		if v, err := redisParam(rr, name); err != nil {
+			return nil, err
+		} else if v != nil {
+			opts = append(opts, strings.ToUpper(name), v)
+		}
+	}
+
+	if v, err := redisParam(rr, `max`); err != nil {
+		return nil, err
+	} else if m := int(typeutil.Int(v)); m > 0 && m < max {
+		max = m
+	}
+
+	var seen = make(map[string]bool)
+	var cursor = `0`
+
+	for {
+		var values, err = redis.Values(conn.Do(`SCAN`, append([]any{cursor}, opts...)...))
+
+		if err != nil {
+			return nil, err
+		} else if len(values) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply")
+		}
+
+		keys, err := redis.Strings(values[1], nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			seen[key] = true
+		}
+
+		cursor = typeutil.String(values[0])
+
+		if cursor == `0` || len(seen) >= max {
+			break
+		}
+	}
+
+	var keys = make([]string, 0, len(seen))
+
+	for key := range seen {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) > max {
+		keys = keys[:max]
+	}
+
+	return keys, nil
+}
+
+// read a range of entries from a stream.
+func redisStreamRange(rr *ProtocolRequest, conn redis.Conn, command string, args []string) ([]map[string]any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: must specify a stream", command)
+	}
+
+	var start, end = `-`, `+`
+
+	if command == `XREVRANGE` {
+		start, end = end, start
+	}
+
+	if len(args) > 1 {
+		start = args[1]
+	}
+
+	if len(args) > 2 {
+		end = args[2]
+	}
+
+	var cmdargs = []any{args[0], start, end}
+
+	if v, err := redisParam(rr, `count`); err != nil {
+		return nil, err
+	} else if v != nil {
+		cmdargs = append(cmdargs, `COUNT`, v)
+	}
+
+	var values, err = redis.Values(conn.Do(command, cmdargs...))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries = make([]map[string]any, 0, len(values))
+
+	for _, value := range values {
+		if entry, err := redis.Values(value, nil); err == nil && len(entry) == 2 {
+			var fields, err = redis.StringMap(entry[1], nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, map[string]any{
+				`id`:     typeutil.String(entry[0]),
+				`fields`: fields,
+			})
+		} else {
+			return nil, fmt.Errorf("%s: unexpected reply", command)
+		}
+	}
+
+	return entries, nil
+}
+
+// run one of the scripts registered in the redis.scripts option.
+func redisScript(rr *ProtocolRequest, conn redis.Conn, args []string) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("SCRIPT: must specify a script name")
+	}
+
+	var filename = typeutil.String(rr.Conf(`redis`, `scripts`).MapNative()[args[0]])
+
+	if filename == `` {
+		return nil, fmt.Errorf("SCRIPT: script %q is not permitted (see the redis.scripts option)", args[0])
+	}
+
+	var source, err = rr.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("SCRIPT: %v", err)
+	}
+
+	var keysAndArgs = sliceutil.Sliceify(args[1:])
+
+	if v, err := redisParam(rr, `args`); err != nil {
+		return nil, err
+	} else if v != nil {
+		keysAndArgs = append(keysAndArgs, sliceutil.Sliceify(v)...)
+	}
+
+	return redis.NewScript(len(args)-1, string(source)).Do(conn, keysAndArgs...)
+}
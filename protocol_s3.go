@@ -0,0 +1,372 @@
+package diecast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/ghetzel/go-stockutil/executil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+var DefaultS3PresignExpiry = 15 * time.Minute
+var DefaultS3ListMax = 1000
+
+// The S3 binding protocol reads objects and bucket listings from Amazon S3 (or an S3-compatible
+// service).  It is specified with URLs that use the s3://bucket/path/to/object scheme.  Credentials,
+// region, and endpoint are configured the same way as for S3 mounts: using the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_REGION, AWS_PROFILE, and AWS_ENDPOINT_URL environment variables, which
+// may be further overridden with the options below.
+//
+// The binding's "method" selects what is retrieved:
+//
+//   - GET (the default) returns the contents of the object.  The response is parsed according to
+//     the object's Content-Type (or its file extension, if the object has a generic type), or with
+//     the binding's "parser", if one is specified.  Missing objects are reported as a 404 status.
+//
+//   - LIST returns an object describing all objects under the given path, with "objects" (each
+//     with its key, name, size, etag, last_modified, and storage_class) and "directories" (the
+//     common prefixes under the path, as split by the "delimiter" param, which defaults to "/").
+//     At most "max" (default: 1000) objects are returned, fetched across as many pages as needed.
+//
+//   - HEAD returns the object's metadata without its contents.
+//
+//   - PRESIGN returns a presigned URL that grants temporary access to the object.  The "expires"
+//     param sets how long the URL is valid for (default: 15m), and the "method" param may be set to
+//     PUT to generate a URL for uploading the object instead of downloading it.
+//
+// # Protocol Options
+//
+//   - s3.endpoint ($AWS_ENDPOINT_URL)
+//     The URL of an S3-compatible service to use instead of Amazon S3.
+//
+//   - s3.region ($AWS_REGION)
+//     The region the buckets are located in.
+//
+//   - s3.path_style (true if an endpoint is set)
+//     Whether the bucket is specified in the request path instead of the hostname, which is
+//     usually required by S3-compatible services such as MinIO.
+type S3Protocol struct {
+}
+
+func (protocol *S3Protocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	var client, err = s3ProtocolClient(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bucket = rr.URL.Host
+	var key = strings.TrimPrefix(rr.URL.Path, `/`)
+
+	if bucket == `` {
+		return nil, fmt.Errorf("s3: must specify a bucket")
+	}
+
+	params, err := s3Params(rr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), rr.Timeout())
+	defer cancel()
+
+	switch verb := strings.ToUpper(rr.Verb); verb {
+	case ``, http.MethodGet:
+		if key == `` {
+			return nil, fmt.Errorf("s3: must specify an object key")
+		}
+
+		if object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err == nil {
+			return &ProtocolResponse{
+				MimeType:   s3MimeType(key, aws.StringValue(object.ContentType)),
+				StatusCode: http.StatusOK,
+				Raw:        object,
+				data:       object.Body,
+			}, nil
+		} else {
+			return s3ErrorResponse(err)
+		}
+
+	case http.MethodHead:
+		if key == `` {
+			return nil, fmt.Errorf("s3: must specify an object key")
+		}
+
+		if object, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err == nil {
+			var metadata = make(map[string]any, len(object.Metadata))
+
+			for k, v := range object.Metadata {
+				metadata[strings.ToLower(k)] = aws.StringValue(v)
+			}
+
+			return s3JSONResponse(map[string]any{
+				`bucket`:        bucket,
+				`key`:           key,
+				`name`:          path.Base(key),
+				`size`:          aws.Int64Value(object.ContentLength),
+				`etag`:          strings.Trim(aws.StringValue(object.ETag), `"`),
+				`content_type`:  aws.StringValue(object.ContentType),
+				`last_modified`: aws.TimeValue(object.LastModified),
+				`storage_class`: aws.StringValue(object.StorageClass),
+				`metadata`:      metadata,
+			})
+		} else {
+			return s3ErrorResponse(err)
+		}
+
+	case `LIST`:
+		return s3List(ctx, client, bucket, key, params)
+
+	case `PRESIGN`:
+		if key == `` {
+			return nil, fmt.Errorf("s3: must specify an object key")
+		}
+
+		var expires = DefaultS3PresignExpiry
+		var presigned string
+
+		if v, ok := params[`expires`]; ok {
+			if expires = typeutil.Duration(v); expires <= 0 {
+				return nil, fmt.Errorf("s3: invalid expiry %v", v)
+			}
+		}
+
+		switch method := strings.ToUpper(typeutil.OrString(params[`method`], http.MethodGet)); method {
+		case http.MethodGet:
+			req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			})
+
+			presigned, err = req.Presign(expires)
+		case http.MethodPut:
+			req, _ := client.PutObjectRequest(&s3.PutObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			})
+
+			presigned, err = req.Presign(expires)
+		default:
+			return nil, fmt.Errorf("s3: cannot presign %s requests", method)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ProtocolResponse{
+			MimeType:   `text/plain; charset=utf-8`,
+			StatusCode: http.StatusOK,
+			Raw:        presigned,
+			data:       io.NopCloser(bytes.NewBufferString(presigned)),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("s3: unsupported method %q", verb)
+	}
+}
+
+// list the objects and "directories" under the given key.
+func s3List(ctx context.Context, client *s3.S3, bucket string, key string, params map[string]any) (*ProtocolResponse, error) {
+	var prefix = key
+	var delimiter = `/`
+	var max = DefaultS3ListMax
+
+	if v, ok := params[`delimiter`]; ok {
+		delimiter = typeutil.String(v)
+	}
+
+	if v, ok := params[`max`]; ok {
+		if max = int(typeutil.Int(v)); max <= 0 {
+			return nil, fmt.Errorf("s3: invalid max %v", v)
+		}
+	}
+
+	// a path names a directory, so only list what's inside of it
+	if prefix != `` && delimiter != `` && !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+
+	var objects = make([]map[string]any, 0)
+	var directories = make([]map[string]any, 0)
+	var truncated bool
+	var input = &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+	}
+
+	if prefix != `` {
+		input.Prefix = aws.String(prefix)
+	}
+
+	if delimiter != `` {
+		input.Delimiter = aws.String(delimiter)
+	}
+
+	for {
+		input.MaxKeys = aws.Int64(int64(max - len(objects)))
+
+		var page, err = client.ListObjectsV2WithContext(ctx, input)
+
+		if err != nil {
+			return s3ErrorResponse(err)
+		}
+
+		for _, object := range page.Contents {
+			objects = append(objects, map[string]any{
+				`key`:           aws.StringValue(object.Key),
+				`name`:          strings.TrimPrefix(aws.StringValue(object.Key), prefix),
+				`size`:          aws.Int64Value(object.Size),
+				`etag`:          strings.Trim(aws.StringValue(object.ETag), `"`),
+				`last_modified`: aws.TimeValue(object.LastModified),
+				`storage_class`: aws.StringValue(object.StorageClass),
+			})
+		}
+
+		for _, common := range page.CommonPrefixes {
+			var dir = aws.StringValue(common.Prefix)
+
+			directories = append(directories, map[string]any{
+				`prefix`: dir,
+				`name`:   strings.TrimSuffix(strings.TrimPrefix(dir, prefix), delimiter),
+			})
+		}
+
+		truncated = aws.BoolValue(page.IsTruncated)
+
+		if !truncated || len(objects) >= max || aws.StringValue(page.NextContinuationToken) == `` {
+			break
+		}
+
+		input.ContinuationToken = page.NextContinuationToken
+	}
+
+	return s3JSONResponse(map[string]any{
+		`bucket`:      bucket,
+		`prefix`:      prefix,
+		`objects`:     objects,
+		`directories`: directories,
+		`truncated`:   truncated,
+	})
+}
+
+// build a client for the request, applying any protocol options on top of the shared AWS session.
+func s3ProtocolClient(rr *ProtocolRequest) (*s3.S3, error) {
+	if awsSession == nil {
+		return nil, fmt.Errorf("s3: no credentials")
+	}
+
+	var cfg = aws.NewConfig()
+	var endpoint = executil.Env(`AWS_ENDPOINT_URL`)
+
+	if ep := rr.Conf(`s3`, `endpoint`).String(); ep != `` {
+		endpoint = ep
+		cfg.WithEndpoint(ep)
+	}
+
+	if region := rr.Conf(`s3`, `region`).String(); region != `` {
+		cfg.WithRegion(region)
+	}
+
+	if pathStyle := rr.Conf(`s3`, `path_style`); !pathStyle.IsNil() {
+		cfg.WithS3ForcePathStyle(pathStyle.Bool())
+	} else if endpoint != `` {
+		cfg.WithS3ForcePathStyle(true)
+	}
+
+	return s3client(cfg), nil
+}
+
+// template all of the binding's params.
+func s3Params(rr *ProtocolRequest) (map[string]any, error) {
+	var params = make(map[string]any)
+
+	if rr.Binding == nil {
+		return params, nil
+	}
+
+	for k, v := range rr.Binding.Params {
+		if value, err := rr.TemplateValue(v); err == nil {
+			if value != nil {
+				params[k] = value
+			}
+		} else {
+			return nil, fmt.Errorf("param %q: %v", k, err)
+		}
+	}
+
+	return params, nil
+}
+
+// objects are frequently stored with a generic content type, so fall back to the type implied by
+// their file extension in that case.
+func s3MimeType(key string, contentType string) string {
+	var mediaType, _, _ = mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case ``, `binary/octet-stream`, `application/octet-stream`:
+		if byExt := mime.TypeByExtension(path.Ext(key)); byExt != `` {
+			return byExt
+		} else if contentType == `` {
+			return `application/octet-stream`
+		}
+	}
+
+	return contentType
+}
+
+// errors that came from the service are returned as a response with the corresponding status, so
+// that bindings can react to them with "if_status".
+func s3ErrorResponse(err error) (*ProtocolResponse, error) {
+	var reqerr awserr.RequestFailure
+
+	if errors.As(err, &reqerr) && reqerr.StatusCode() > 0 {
+		var message = reqerr.Code()
+
+		if msg := reqerr.Message(); msg != `` {
+			message += `: ` + msg
+		}
+
+		return &ProtocolResponse{
+			MimeType:   `text/plain; charset=utf-8`,
+			StatusCode: reqerr.StatusCode(),
+			Raw:        err,
+			data:       io.NopCloser(bytes.NewBufferString(`s3: ` + message)),
+		}, nil
+	}
+
+	return nil, err
+}
+
+func s3JSONResponse(out any) (*ProtocolResponse, error) {
+	var buf = bytes.NewBuffer(nil)
+
+	if err := json.NewEncoder(buf).Encode(out); err != nil {
+		return nil, err
+	}
+
+	return &ProtocolResponse{
+		MimeType:   `application/json; charset=utf-8`,
+		StatusCode: http.StatusOK,
+		Raw:        out,
+		data:       io.NopCloser(buf),
+	}, nil
+}
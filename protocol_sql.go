@@ -0,0 +1,457 @@
+package diecast
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+var sqlConnectionPool sync.Map
+var sqlPoolMaxOpen = 0
+var sqlPoolMaxIdle = 2
+var sqlPoolIdleTimeout = 5 * time.Minute
+var sqlPoolMaxLifetime = 10 * time.Minute
+
+// The query string parameter (on the resource URL) that makes all queries against a database read-only.
+var SQLReadOnlyParam = `readonly`
+
+// The SQL binding protocol is used to query relational databases.  It is specified with URLs that use
+// the sqlite://path/to/file.db (relative to the server's root directory, unless the path is absolute),
+// postgres://[user[:password]@]host[:port]/dbname, or mysql://[user[:password]@]host[:port]/dbname
+// schemes.  Any query string parameters are passed along to the database driver, except for
+// "readonly", which (if true) prevents any query on that database from modifying data.
+//
+// The query itself is given in the binding's "rawbody" (or "method") field, and may contain named
+// placeholders (e.g.: ":id") whose values are taken from the binding's "params".  Parameters are
+// always sent to the database separately from the query, and array values are expanded into a
+// comma-separated list of placeholders (for use with IN (...) clauses).  The query text itself is
+// never evaluated as a template.
+//
+// Rows are returned as an array of objects, with column values converted to the closest matching
+// type (numbers, booleans, strings, timestamps, and decoded JSON).
+//
+// # Protocol Options
+//
+// Options can be given in the "sql" protocol configuration, or in a section named for a specific
+// scheme (e.g.: "postgres"), which takes precedence.
+//
+//   - sql.max_open (0)
+//     The maximum number of open connections to each database (0 is unlimited).
+//
+//   - sql.max_idle (2)
+//     The maximum number of idle connections to maintain in a connection pool.
+//
+//   - sql.idle_timeout (5m)
+//     The maximum idle time of a connection before it is closed.
+//
+//   - sql.max_lifetime (10m)
+//     The maximum amount of time a connection can remain open before being recycled.
+//
+//   - sql.read_only (false)
+//     Make every database read-only, regardless of the "readonly" URL parameter.
+type SQLProtocol struct {
+}
+
+type sqlDialect struct {
+	driver       string
+	positional   bool // placeholders are numbered ($1, $2, ...) rather than "?"
+	readOnlyOpts bool // read-only mode is enforced with read-only transactions
+}
+
+var sqlDialects = map[string]sqlDialect{
+	`sqlite`:     {driver: `sqlite`},
+	`sqlite3`:    {driver: `sqlite`},
+	`postgres`:   {driver: `postgres`, positional: true, readOnlyOpts: true},
+	`postgresql`: {driver: `postgres`, positional: true, readOnlyOpts: true},
+	`mysql`:      {driver: `mysql`, readOnlyOpts: true},
+}
+
+func (protocol *SQLProtocol) Retrieve(rr *ProtocolRequest) (*ProtocolResponse, error) {
+	var dialect, ok = sqlDialects[rr.URL.Scheme]
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type %q", rr.URL.Scheme)
+	}
+
+	var readOnly = rr.Conf(rr.URL.Scheme, `read_only`, rr.Conf(`sql`, `read_only`).Value).Bool()
+
+	if ro := rr.URL.Query().Get(SQLReadOnlyParam); ro != `` {
+		readOnly = readOnly || typeutil.Bool(ro)
+	}
+
+	var query, args, err = sqlQuery(rr, dialect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlPool(rr, dialect, readOnly)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), rr.Timeout())
+	defer cancel()
+
+	var rows *sql.Rows
+
+	if readOnly && dialect.readOnlyOpts {
+		if tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err == nil {
+			defer tx.Rollback()
+
+			if rows, err = tx.QueryContext(ctx, query, args...); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	} else if rows, err = db.QueryContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if results, err := sqlRows(rows); err == nil {
+		var buf = bytes.NewBuffer(nil)
+
+		if err := json.NewEncoder(buf).Encode(results); err != nil {
+			return nil, err
+		}
+
+		return &ProtocolResponse{
+			MimeType:   `application/json; charset=utf-8`,
+			StatusCode: 200,
+			Raw:        results,
+			data:       io.NopCloser(buf),
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// retrieve (or create) the connection pool for the database described by the request URL.
+func sqlPool(rr *ProtocolRequest, dialect sqlDialect, readOnly bool) (*sql.DB, error) {
+	var root string
+
+	if rr.Binding != nil && rr.Binding.server != nil && !strings.Contains(rr.Binding.server.RootPath, `://`) {
+		root = rr.Binding.server.RootPath
+	}
+
+	var dsn, err = sqlDSN(rr.URL, dialect, readOnly, root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pid = dialect.driver + `:` + dsn
+
+	if v, ok := sqlConnectionPool.Load(pid); ok {
+		if db, ok := v.(*sql.DB); ok {
+			return db, nil
+		}
+	}
+
+	var conf = func(key string, fallback any) typeutil.Variant {
+		return rr.Conf(rr.URL.Scheme, key, rr.Conf(`sql`, key, fallback).Value)
+	}
+
+	if db, err := sql.Open(dialect.driver, dsn); err == nil {
+		db.SetMaxOpenConns(int(conf(`max_open`, sqlPoolMaxOpen).Int()))
+		db.SetMaxIdleConns(int(conf(`max_idle`, sqlPoolMaxIdle).Int()))
+		db.SetConnMaxIdleTime(conf(`idle_timeout`, sqlPoolIdleTimeout).Duration())
+		db.SetConnMaxLifetime(conf(`max_lifetime`, sqlPoolMaxLifetime).Duration())
+
+		if existing, loaded := sqlConnectionPool.LoadOrStore(pid, db); loaded {
+			db.Close()
+			return existing.(*sql.DB), nil
+		}
+
+		log.Debugf("SQLProtocol: created new %s pool (read-only: %v)", dialect.driver, readOnly)
+
+		return db, nil
+	} else {
+		return nil, err
+	}
+}
+
+// convert a binding resource URL into a DSN the database driver understands.
+func sqlDSN(u *url.URL, dialect sqlDialect, readOnly bool, root string) (string, error) {
+	var qs = u.Query()
+
+	qs.Del(SQLReadOnlyParam)
+
+	switch dialect.driver {
+	case `sqlite`:
+		var path = u.Host + u.Path
+
+		if path == `` {
+			return ``, fmt.Errorf("sqlite: must specify a database file")
+		}
+
+		// relative paths are relative to the server's root directory
+		if root != `` && !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+
+		if readOnly {
+			qs.Set(`mode`, `ro`)
+			qs.Add(`_pragma`, `query_only(1)`)
+		}
+
+		if len(qs) > 0 {
+			return `file:` + path + `?` + qs.Encode(), nil
+		}
+
+		return path, nil
+	case `postgres`:
+		var dsn = *u
+
+		dsn.Scheme = `postgres`
+
+		if readOnly {
+			qs.Set(`default_transaction_read_only`, `on`)
+		}
+
+		dsn.RawQuery = qs.Encode()
+
+		return dsn.String(), nil
+	case `mysql`:
+		var dsn string
+
+		if u.User != nil {
+			dsn = u.User.Username()
+
+			if pw, ok := u.User.Password(); ok {
+				dsn += `:` + pw
+			}
+
+			dsn += `@`
+		}
+
+		dsn += `tcp(` + u.Host + `)` + sliceutil.OrString(u.Path, `/`)
+
+		if len(qs) > 0 {
+			dsn += `?` + qs.Encode()
+		}
+
+		return dsn, nil
+	default:
+		return ``, fmt.Errorf("unsupported driver %q", dialect.driver)
+	}
+}
+
+// build the query and its arguments from the binding, replacing named placeholders (":name") with the
+// placeholder syntax of the database.
+func sqlQuery(rr *ProtocolRequest, dialect sqlDialect) (string, []any, error) {
+	var query = strings.TrimSpace(rr.Binding.RawBody)
+
+	if query == `` && strings.ContainsAny(strings.TrimSpace(rr.Binding.Method), " \t\r\n") {
+		query = strings.TrimSpace(rr.Binding.Method)
+	}
+
+	if query == `` {
+		return ``, nil, fmt.Errorf("must specify a query in 'rawbody' or 'method'")
+	}
+
+	var out strings.Builder
+	var args []any
+	var placeholder = func() string {
+		if dialect.positional {
+			return `$` + strconv.Itoa(len(args))
+		}
+
+		return `?`
+	}
+
+	var src = []rune(query)
+
+	for i := 0; i < len(src); i++ {
+		var c = src[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// copy quoted strings and identifiers verbatim
+			var j = i + 1
+
+			for j < len(src) && src[j] != c {
+				j++
+			}
+
+			out.WriteString(string(src[i:min(j+1, len(src))]))
+			i = j
+		case c == '-' && i+1 < len(src) && src[i+1] == '-':
+			// copy line comments verbatim
+			var j = i
+
+			for j < len(src) && src[j] != '\n' {
+				j++
+			}
+
+			out.WriteString(string(src[i:j]))
+			i = j - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			// copy block comments verbatim
+			var j = i + 2
+
+			for j < len(src) && !(src[j-1] == '*' && src[j] == '/' && j > i+2) {
+				j++
+			}
+
+			out.WriteString(string(src[i:min(j+1, len(src))]))
+			i = j
+		case c == ':' && i+1 < len(src) && src[i+1] == ':':
+			// PostgreSQL type casts (e.g.: "x::text")
+			out.WriteString(`::`)
+			i++
+		case c == ':' && i+1 < len(src) && (unicode.IsLetter(src[i+1]) || src[i+1] == '_'):
+			var j = i + 1
+
+			for j < len(src) && (unicode.IsLetter(src[j]) || unicode.IsDigit(src[j]) || src[j] == '_') {
+				j++
+			}
+
+			var name = string(src[i+1 : j])
+			var value, ok = rr.Binding.Params[name]
+
+			if !ok {
+				return ``, nil, fmt.Errorf("query parameter %q is not defined in 'params'", name)
+			}
+
+			if typeutil.IsArray(value) {
+				var items = sliceutil.Sliceify(value)
+
+				if len(items) == 0 {
+					return ``, nil, fmt.Errorf("query parameter %q: empty array", name)
+				}
+
+				for k, item := range items {
+					if v, err := sqlParam(rr, item); err == nil {
+						args = append(args, v)
+					} else {
+						return ``, nil, fmt.Errorf("query parameter %q: %v", name, err)
+					}
+
+					if k > 0 {
+						out.WriteString(`, `)
+					}
+
+					out.WriteString(placeholder())
+				}
+			} else if v, err := sqlParam(rr, value); err == nil {
+				args = append(args, v)
+				out.WriteString(placeholder())
+			} else {
+				return ``, nil, fmt.Errorf("query parameter %q: %v", name, err)
+			}
+
+			i = j - 1
+		default:
+			out.WriteRune(c)
+		}
+	}
+
+	return out.String(), args, nil
+}
+
+// evaluate a parameter value, which may itself be a template.
+func sqlParam(rr *ProtocolRequest, value any) (any, error) {
+	if rr.Binding.NoTemplate {
+		return value, nil
+	} else if v, err := rr.Template(value); err == nil {
+		return v.Value, nil
+	} else {
+		return nil, err
+	}
+}
+
+// read all rows into a slice of maps, converting values to types that match their columns.
+func sqlRows(rows *sql.Rows) ([]map[string]any, error) {
+	var columns, err = rows.ColumnTypes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results = make([]map[string]any, 0)
+
+	for rows.Next() {
+		var values = make([]any, len(columns))
+		var pointers = make([]any, len(columns))
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		var row = make(map[string]any)
+
+		for i, column := range columns {
+			row[column.Name()] = sqlValue(column.DatabaseTypeName(), values[i])
+		}
+
+		results = append(results, row)
+	}
+
+	return results, rows.Err()
+}
+
+// some drivers return most values as raw bytes; convert them according to the column's type.
+func sqlValue(dbtype string, value any) any {
+	var raw, ok = value.([]byte)
+
+	if !ok {
+		return value
+	}
+
+	dbtype = strings.ToUpper(dbtype)
+
+	switch {
+	case strings.Contains(dbtype, `BLOB`), strings.Contains(dbtype, `BINARY`), dbtype == `BYTEA`:
+		return raw
+	case strings.Contains(dbtype, `INT`):
+		if v, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+			return v
+		}
+	case strings.Contains(dbtype, `DECIMAL`),
+		strings.Contains(dbtype, `NUMERIC`),
+		strings.Contains(dbtype, `FLOAT`),
+		strings.Contains(dbtype, `DOUBLE`),
+		strings.Contains(dbtype, `REAL`):
+		if v, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return v
+		}
+	case strings.HasPrefix(dbtype, `BOOL`):
+		if v, err := strconv.ParseBool(string(raw)); err == nil {
+			return v
+		}
+	case strings.HasPrefix(dbtype, `JSON`):
+		var v any
+
+		if err := json.Unmarshal(raw, &v); err == nil {
+			return v
+		}
+	}
+
+	return string(raw)
+}
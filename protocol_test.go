@@ -2,6 +2,7 @@ package diecast
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ghetzel/testify/require"
 )
@@ -30,3 +31,31 @@ func TestProtocolRequest(t *testing.T) {
 		`1`, `2`, `3`,
 	}, v.Value)
 }
+
+func TestProtocolRequestTimeout(t *testing.T) {
+	var assert = require.New(t)
+
+	for in, want := range map[any]time.Duration{
+		30:      30 * time.Second,
+		`30`:    30 * time.Second,
+		5000:    5 * time.Second,
+		`1500`:  1500 * time.Millisecond,
+		`2m`:    2 * time.Minute,
+		`250ms`: 250 * time.Millisecond,
+	} {
+		var req = &ProtocolRequest{
+			Binding: &Binding{
+				Timeout: in,
+			},
+		}
+
+		assert.Equal(want, req.Timeout(), "%v", in)
+	}
+
+	var req = &ProtocolRequest{
+		Binding:        &Binding{},
+		DefaultTimeout: 5 * time.Second,
+	}
+
+	assert.Equal(5*time.Second, req.Timeout())
+}
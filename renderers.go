@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -45,8 +46,11 @@ func RegisterRenderer(name string, renderer Renderer) {
 	renderers[name] = renderer
 }
 
+// Returns a renderer for the given server.  Renderers hold state specific to the request being
+// rendered, so each call returns a new copy of the registered renderer.
 func GetRenderer(name string, server *Server) (Renderer, error) {
 	if renderer, ok := renderers[name]; ok && renderer != nil {
+		renderer = copyRenderer(renderer)
 		renderer.SetServer(server)
 
 		return renderer, nil
@@ -72,3 +76,20 @@ func GetRendererForFilename(filename string, server *Server) (Renderer, bool) {
 
 	return nil, false
 }
+
+// returns a copy of a renderer that is a pointer to a struct; other renderers are returned as-is.
+func copyRenderer(renderer Renderer) Renderer {
+	var value = reflect.ValueOf(renderer)
+
+	if value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() == reflect.Struct {
+		var instance = reflect.New(value.Elem().Type())
+
+		instance.Elem().Set(value.Elem())
+
+		if copied, ok := instance.Interface().(Renderer); ok {
+			return copied
+		}
+	}
+
+	return renderer
+}
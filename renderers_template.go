@@ -66,7 +66,7 @@ func (renderer *TemplateRenderer) Render(w http.ResponseWriter, req *http.Reques
 		return err
 	}
 
-	if err := tmpl.ParseFragments(options.Fragments); err == nil {
+	if err := renderer.server.parseTemplateFragments(tmpl, options.Fragments); err == nil {
 		log.Debugf("[%s] Rendering %q as %v template", reqid(req), options.RequestedPath, tmpl.Engine())
 
 		if hdr := options.Header; hdr != nil {
@@ -123,6 +123,16 @@ func (renderer *TemplateRenderer) Render(w http.ResponseWriter, req *http.Reques
 				w.Write([]byte(fmt.Sprintf("{{/* DATA: error: %v */}}\n", err)))
 			}
 
+			if failed := failedBindings(req); len(failed) > 0 {
+				if errs, err := yaml.Marshal(failed); err == nil {
+					w.Write([]byte("{{/* BEGIN BINDING ERRORS --\n"))
+					w.Write(errs)
+					w.Write([]byte("\n-- END BINDING ERRORS */}}\n"))
+				} else {
+					w.Write([]byte(fmt.Sprintf("{{/* BINDING ERRORS: error: %v */}}\n", err)))
+				}
+			}
+
 			if _, err := w.Write(options.Fragments.DebugOutput()); err != nil {
 				return err
 			}
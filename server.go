@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
@@ -48,6 +49,8 @@ import (
 	"github.com/signalsciences/tlstext"
 	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"golang.org/x/text/language"
@@ -61,6 +64,10 @@ var ITotallyUnderstandRunningArbitraryCommandsAsRootIsRealRealBad = false
 var ErrIsDirectory = errors.New(`is a directory`)
 var DefaultLocale = language.AmericanEnglish
 var DefaultLogFormat = `common`
+
+// The log format that writes each request as a single line of JSON.
+const JSONLogFormat = `json`
+
 var DefaultProtocol = `http`
 
 const weirdPathsInHostnamesPlaceholder = "\u2044"
@@ -95,6 +102,8 @@ const LayoutTemplateName = `layout`
 const ContentTemplateName = `content`
 const ContextRequestKey = `diecast-request-id`
 const ContextResponseKey = `diecast-response`
+const ContextTemplateDataKey = `diecast-template-data`
+const ContextLayoutKey = `diecast-layout`
 const JaegerSpanKey = `jaeger-span`
 const RequestBodyKey = `request-body`
 
@@ -169,11 +178,16 @@ type TlsConfig struct {
 }
 
 type LogConfig struct {
-	Format      string `yaml:"format"               json:"format"`      // configure the output format for logging requests
-	Destination string `yaml:"destination"          json:"destination"` // specify where logs should be written to
-	Truncate    bool   `yaml:"truncate"             json:"truncate"`    // if true, the output log file will be truncated on startup
-	Colorize    bool   `yaml:"colorize"             json:"colorize"`    // if false, log output will not be colorized
-	Disable     bool   `yaml:"disable"              json:"disable"`     // if true, no log output will be written
+	Format         string `yaml:"format"               json:"format"`          // configure the output format for logging requests: "common", "json", the name of a registered format, or a format string
+	Destination    string `yaml:"destination"          json:"destination"`     // specify where logs should be written to: "stdout", "stderr", "syslog", "journald", or a filename
+	Truncate       bool   `yaml:"truncate"             json:"truncate"`        // if true, the output log file will be truncated on startup
+	Colorize       bool   `yaml:"colorize"             json:"colorize"`        // if false, log output will not be colorized
+	Disable        bool   `yaml:"disable"              json:"disable"`         // if true, no log output will be written
+	MaxSize        string `yaml:"max_size"             json:"max_size"`        // rotate the log file once it would grow larger than this size (e.g.: "100MB")
+	MaxAge         string `yaml:"max_age"              json:"max_age"`         // rotate the log file once it has been written to for this long (e.g.: "24h")
+	MaxBackups     int    `yaml:"max_backups"          json:"max_backups"`     // the number of rotated log files to keep (default: keep all of them)
+	SyslogFacility string `yaml:"syslog_facility"      json:"syslog_facility"` // the syslog facility to log to (default: "daemon")
+	SyslogTag      string `yaml:"syslog_tag"           json:"syslog_tag"`      // the identifier to log to syslog and journald with (default: "diecast")
 }
 
 type RateLimitConfig struct {
@@ -306,6 +320,10 @@ type Server struct {
 	BinPath              string                    `yaml:"-"                       json:"-"`                       // Exposes the location of the diecast binary
 	BindingPrefix        string                    `yaml:"bindingPrefix"           json:"bindingPrefix"`           // Specify a string to prefix all binding resource values that start with "/"
 	Bindings             SharedBindingSet          `yaml:"bindings"                json:"bindings"`                // Top-level bindings that apply to every rendered template
+	BindingCache         *BindingCacheConfig       `yaml:"bindingCache"            json:"bindingCache"`            // Configures caching of binding responses.
+	BindingRetry         *RetryConfig              `yaml:"bindingRetry"            json:"bindingRetry"`            // Default settings for retrying failed binding requests.
+	BindingConcurrency   int                       `yaml:"bindingConcurrency"      json:"bindingConcurrency"`      // If greater than one, bindings that don't depend on one another are evaluated concurrently, up to this many at a time.
+	CircuitBreaker       *CircuitBreakerConfig     `yaml:"circuitBreaker"          json:"circuitBreaker"`          // Stop making binding requests to upstream hosts that are failing.
 	DefaultPageObject    map[string]any            `yaml:"-"                       json:"-"`                       //
 	DisableCommands      bool                      `yaml:"disable_commands"        json:"disable_commands"`        // Disable the execution of PrestartCommands and StartCommand .
 	DisableTimings       bool                      `yaml:"disableTimings"          json:"disableTimings"`          // Disable emitting per-request Server-Timing headers to aid in tracing bottlenecks and performance issues.
@@ -325,6 +343,10 @@ type Server struct {
 	Mounts               []Mount                   `yaml:"-"                       json:"-"`                       // The set of all registered mounts.
 	OnAddHandler         AddHandlerFunc            `yaml:"-"                       json:"-"`                       // A function that can be used to intercept handlers being added to the server.
 	OverridePageObject   map[string]any            `yaml:"-"                       json:"-"`                       //
+	PageCache            *PageCacheConfig          `yaml:"pageCache"               json:"pageCache"`               // Configures caching of rendered pages.
+	LiveReload           bool                      `yaml:"liveReload"              json:"liveReload"`              // Watch the site and its configuration for changes, reloading the configuration and all connected browsers when they occur (for development use).
+	DisableTemplateCache bool                      `yaml:"disableTemplateCache"    json:"disableTemplateCache"`    // Always re-read and re-parse templates, layouts, and includes on every request instead of caching them until they change.
+	ReloadAuthenticator  *AuthenticatorConfig      `yaml:"reloadAuthenticator"     json:"reloadAuthenticator"`     // The authenticator that protects the configuration reload endpoint.  The endpoint is not available unless this is set.
 	PrestartCommands     []*StartCommand           `yaml:"prestart"                json:"prestart"`                // A command that will be executed before the server is started.
 	Protocols            map[string]ProtocolConfig `yaml:"protocols"               json:"protocols"`               // Setup global configuration details for Binding Protocols
 	RendererMappings     map[string]string         `yaml:"rendererMapping"         json:"rendererMapping"`         // Map file extensions to preferred renderers for a given file type.
@@ -347,9 +369,11 @@ type Server struct {
 	RateLimit            *RateLimitConfig          `yaml:"ratelimit"               json:"ratelimit"`               // Specify a rate limiting configuration.
 	BindingTimeout       any                       `yaml:"bindingTimeout"          json:"bindingTimeout"`          // Sets the default timeout for bindings that don't explicitly set one.
 	JaegerConfig         *JaegerConfig             `yaml:"jaeger"                  json:"jaeger"`                  // Configures distributed tracing using Jaeger.
+	OtelConfig           *OtelConfig               `yaml:"otel"                    json:"otel"`                    // Configures distributed tracing using OpenTelemetry (exported via OTLP).
 	AutocompressPatterns []string                  `yaml:"autocompress"            json:"autocompress"`            // A set of glob patterns indicating directories whose contents will be delivered as ZIP files
 	RequestBodyPreload   int64                     `yaml:"requestPreload"          json:"requestPreload"`          // Maximum number of bytes to read from a request body for the purpose of automatically parsing it.  Requests larger than this will not be available to templates.
 	JWT                  map[string]*JWTConfig     `yaml:"jwt"                     json:"jwt"`                     // Contains configurations for generating JSON Web Tokens in templates.
+	Metrics              *MetricsConfig            `yaml:"metrics"                 json:"metrics"`                 // Expose request, binding, mount, and renderer metrics in the Prometheus text format.
 	altRootCaPool        *x509.CertPool
 	faviconImageIco      []byte
 	fs                   http.FileSystem
@@ -363,9 +387,34 @@ type Server struct {
 	jaegerCfg            *jaegercfg.Configuration
 	opentrace            opentracing.Tracer
 	otcloser             io.Closer
+	otelProvider         *sdktrace.TracerProvider
+	otelTracer           trace.Tracer
 	viaConstructor       bool
 	sharedBindingData    sync.Map
 	lockGetFunctions     sync.Mutex
+	bindingCacheBackend  BindingCache
+	bindingCacheLock     sync.Mutex
+	pageCacheEntries     *lruCache[*pageCacheEntry]
+	pageCacheLock        sync.Mutex
+	templateFiles        *lruCache[*templateFileEntry]
+	templatesParsed      *lruCache[any]
+	templateCacheLock    sync.Mutex
+	configFile           string
+	liveReload           *liveReloader
+	explicitMounts       []Mount
+	actionRoutes         map[string]bool
+	logLock              sync.Mutex
+	configLock           sync.RWMutex
+	reloadLock           sync.Mutex
+	sharedBindingsStop   chan struct{}
+	sharedBindingsLock   sync.Mutex
+	metrics              *metricsRegistry
+	plugins              map[string]*PluginProtocol
+	pluginLock           sync.Mutex
+	breakers             sync.Map
+	schemas              sync.Map
+	mountsStop           chan struct{}
+	mountsLock           sync.Mutex
 }
 
 func NewServer(root any, patterns ...string) *Server {
@@ -409,6 +458,13 @@ func (server *Server) ShouldReturnSource(req *http.Request) bool {
 
 func (server *Server) LoadConfig(filename string) error {
 	if pathutil.FileExists(filename) {
+		// remember the first file we were configured from so that it can be reloaded later
+		if server.configFile == `` {
+			if abs, err := filepath.Abs(filename); err == nil {
+				server.configFile = abs
+			}
+		}
+
 		if file, err := os.Open(filename); err == nil {
 			defer file.Close()
 			return server.LoadConfigFromReader(file, filename)
@@ -426,16 +482,10 @@ func (server *Server) LoadConfigFromReader(file io.Reader, filename string) erro
 
 		if err := yaml.UnmarshalStrict(data, server); err == nil {
 			// apply environment-specific overrides
-			if server.Environment != `` && filename != `` {
-				eDir, eFile := filepath.Split(filename)
-				var base = strings.TrimSuffix(eFile, filepath.Ext(eFile))
-				var ext = filepath.Ext(eFile)
-				eFile = fmt.Sprintf("%s.%s%s", base, server.Environment, ext)
-				var envPath = filepath.Join(eDir, eFile)
-
+			if envPath := server.environmentConfigFile(filename); envPath != `` {
 				if fileutil.IsNonemptyFile(envPath) {
 					if err := server.LoadConfig(envPath); err != nil {
-						return fmt.Errorf("failed to load %s: %v", eFile, err)
+						return fmt.Errorf("failed to load %s: %v", filepath.Base(envPath), err)
 					}
 				}
 			}
@@ -445,6 +495,10 @@ func (server *Server) LoadConfigFromReader(file io.Reader, filename string) erro
 				if mount, err := NewMountFromSpec(fmt.Sprintf("%s:%s", config.Mount, config.To)); err == nil {
 					var mountOverwriteIndex = -1
 
+					if typeutil.String(config.Options[`format`]) != `` {
+						mount = archiveMountFor(mount)
+					}
+
 					for i, existing := range server.Mounts {
 						if IsSameMount(mount, existing) {
 							mountOverwriteIndex = i
@@ -476,8 +530,24 @@ func (server *Server) LoadConfigFromReader(file io.Reader, filename string) erro
 	return nil
 }
 
+// returns the path of the environment-specific override file for the given config file (e.g.:
+// "diecast.production.yml"), or an empty string if no environment is set.
+func (server *Server) environmentConfigFile(filename string) string {
+	if server.Environment == `` || filename == `` {
+		return ``
+	}
+
+	var eDir, eFile = filepath.Split(filename)
+	var ext = filepath.Ext(eFile)
+
+	return filepath.Join(eDir, fmt.Sprintf("%s.%s%s", strings.TrimSuffix(eFile, ext), server.Environment, ext))
+}
+
 // Append the specified mounts to the current server.
 func (server *Server) SetMounts(mounts []Mount) {
+	// mounts specified here (rather than in the configuration file) survive configuration reloads
+	server.explicitMounts = append(server.explicitMounts, mounts...)
+
 	if len(server.Mounts) > 0 {
 		server.Mounts = append(server.Mounts, mounts...)
 	} else {
@@ -638,6 +708,10 @@ func (server *Server) Initialize() error {
 		return fmt.Errorf("jaeger: %v", err)
 	}
 
+	if err := server.initOtelTracing(); err != nil {
+		return fmt.Errorf("otel: %v", err)
+	}
+
 	// if configured, this path must exist (relative to RootPath or the root filesystem) or Diecast will refuse to start
 	if server.VerifyFile != `` {
 		if verify, err := server.fs.Open(server.VerifyFile); err == nil {
@@ -651,10 +725,18 @@ func (server *Server) Initialize() error {
 		return err
 	}
 
-	if err := server.Bindings.init(server); err != nil {
+	if err := server.startSharedBindings(server.Bindings); err != nil {
 		return fmt.Errorf("async bindings: %v", err)
 	}
 
+	server.startMountWorkers(server.Mounts)
+
+	if server.BindingConcurrency > 1 {
+		if _, err := server.bindingDependencies(server.Bindings.perRequestBindings(), server.BaseHeader); err != nil {
+			return err
+		}
+	}
+
 	server.initialized = true
 
 	if server.DisableCommands {
@@ -1057,6 +1139,8 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		if err := server.rateLimiter.Hit(rl.KeyFor(req)); err != nil {
 			var didPenalty bool
 
+			server.metrics.inc(`diecast_ratelimit_rejections_total`)
+
 			// impose sleep penalty if specified
 			if penalty := rl.Penalty; penalty != `` {
 				if pd := typeutil.Duration(penalty); pd > 0 {
@@ -1171,6 +1255,17 @@ func (server *Server) applyTemplate(
 	var fragments = make(FragmentSet, 0)
 	var forceSkipLayout = false
 	var layouts = make([]string, 0)
+	var forceLayout = false
+
+	// a layout set on the request (e.g. by a mount) applies regardless of the path
+	if layout := httputil.RequestGetValue(req, ContextLayoutKey).String(); layout != `` {
+		if layout == `false` || layout == `none` {
+			forceSkipLayout = true
+		} else {
+			layouts = []string{layout}
+			forceLayout = true
+		}
+	}
 
 	// start building headers stack and calculate line offsets (for error reporting)
 	if header != nil {
@@ -1194,7 +1289,7 @@ func (server *Server) applyTemplate(
 	var earlyFuncs = server.GetTemplateFunctions(earlyData, header)
 
 	// only process layouts if we're supposed to
-	if server.EnableLayouts && !forceSkipLayout && server.shouldApplyLayout(requestPath) {
+	if server.EnableLayouts && !forceSkipLayout && (forceLayout || server.shouldApplyLayout(requestPath)) {
 		// files starting with "_" are partials and should not have layouts applied
 		if !strings.HasPrefix(path.Base(requestPath), `_`) {
 			// if no layouts were explicitly specified, and a layout named "default" exists, add it to the list
@@ -1208,7 +1303,9 @@ func (server *Server) applyTemplate(
 				for _, layoutName := range layouts {
 					if layoutName, err := EvalInline(layoutName, nil, earlyFuncs); err == nil {
 						if layoutFile, err := server.LoadLayout(layoutName); err == nil {
-							if err := fragments.Parse(LayoutTemplateName, layoutFile); err != nil {
+							if layoutHeader, layoutData, err := server.splitTemplateFile(layoutFile); err == nil {
+								fragments.Set(LayoutTemplateName, layoutHeader, layoutData)
+							} else {
 								return err
 							}
 
@@ -1243,6 +1340,12 @@ func (server *Server) applyTemplate(
 	// put any url route params in there too
 	finalHeader.UrlParams = urlParams
 
+	// in development mode, pages reload themselves whenever the site changes
+	if server.liveReload != nil {
+		finalHeader.Postprocessors = sliceutil.UniqueStrings(append(finalHeader.Postprocessors, LiveReloadPostprocessor))
+		httputil.RequestSetValue(req, ContextLiveReloadPath, server.internalPath(`livereload`))
+	}
+
 	// render locale from template
 	if locale, err := EvalInline(finalHeader.Locale, earlyData, earlyFuncs); err == nil {
 		finalHeader.Locale = locale
@@ -1333,7 +1436,7 @@ func (server *Server) applyTemplate(
 					break SwitchCaseLoop
 
 				} else if swTemplate, err := server.fs.Open(usePath); err == nil {
-					if swHeader, swData, err := SplitTemplateHeaderContent(swTemplate); err == nil {
+					if swHeader, swData, err := server.splitTemplateFile(swTemplate); err == nil {
 						if fh, err := finalHeader.Merge(swHeader); err == nil {
 							log.Debugf("[%s] Switch case %d matched, switching to template %v", reqid(req), i, usePath)
 							// httputil.RequestSetValue(req, SwitchCaseKey, usePath)
@@ -1419,7 +1522,7 @@ func (server *Server) applyTemplate(
 					// and pass it as input to the final renderer.
 					var intercept = httptest.NewRecorder()
 
-					err = baseRenderer.Render(intercept, req, renderOpts)
+					err = server.render(baseRenderer, intercept, req, renderOpts)
 					var res = intercept.Result()
 					renderOpts.MimeType = res.Header.Get(`Content-Type`)
 					renderOpts.Input = res.Body
@@ -1436,7 +1539,7 @@ func (server *Server) applyTemplate(
 						writeRequestTimerHeaders(server, w, r)
 					})
 
-					return postTemplateRenderer.Render(w, req, renderOpts)
+					return server.render(postTemplateRenderer, w, req, renderOpts)
 				} else {
 					return err
 				}
@@ -1448,7 +1551,7 @@ func (server *Server) applyTemplate(
 					writeRequestTimerHeaders(server, w, r)
 				})
 
-				return baseRenderer.Render(w, req, renderOpts)
+				return server.render(baseRenderer, w, req, renderOpts)
 			}
 		} else {
 			return err
@@ -1913,7 +2016,7 @@ func (server *Server) getPreBindingData(req *http.Request, header *TemplateHeade
 
 	var publicMountDetails = make([]map[string]any, 0)
 
-	for _, mount := range server.MountConfigs {
+	for _, mount := range server.reloadable().MountConfigs {
 		publicMountDetails = append(publicMountDetails, map[string]any{
 			`from`: mount.Mount,
 			`to`:   mount.To,
@@ -2000,264 +2103,292 @@ func (server *Server) GetTemplateData(req *http.Request, header *TemplateHeader)
 	var bindingsToEval = make([]Binding, 0)
 
 	// only use top-level bindings that
-	bindingsToEval = append(bindingsToEval, server.Bindings.perRequestBindings()...)
+	bindingsToEval = append(bindingsToEval, server.reloadable().Bindings.perRequestBindings()...)
 
 	if header != nil {
 		bindingsToEval = append(bindingsToEval, header.Bindings...)
 	}
 
-	for i, binding := range bindingsToEval {
-		if strings.TrimSpace(binding.Name) == `` {
-			binding.Name = fmt.Sprintf("binding%d", i)
+	for i := range bindingsToEval {
+		if strings.TrimSpace(bindingsToEval[i].Name) == `` {
+			bindingsToEval[i].Name = fmt.Sprintf("binding%d", i)
 		}
 
-		binding.server = server
+		bindingsToEval[i].server = server
+	}
 
-		var start = time.Now()
-		describeTimer(fmt.Sprintf("binding-%s", binding.Name), fmt.Sprintf("Diecast Bindings: %s", binding.Name))
+	if server.BindingConcurrency > 1 && len(bindingsToEval) > 1 {
+		if err := server.evalBindingsConcurrently(req, header, bindingsToEval, data, bindings); err != nil {
+			return funcs, nil, err
+		}
+	} else {
+		for i, binding := range bindingsToEval {
+			if err := server.evalBinding(req, header, &binding, i, data, funcs, bindings); err != nil {
+				return funcs, nil, err
+			}
 
-		if header != nil {
-			if v, err := maputil.Merge(header.DefaultHeaders, binding.Headers); err == nil {
-				binding.Headers = maputil.Stringify(v)
-			} else {
-				return nil, nil, fmt.Errorf("merge headers: %v", err)
+			// re-evaluate page based on new binding results
+			server.evalPageData(false, req, header, funcs, data)
+		}
+	}
+
+	server.updateBindings(data, bindings)
+
+	// Evaluate "flags" data: this data is templatized, and has access to $.page and $.bindings
+	// ---------------------------------------------------------------------------------------------
+	if header != nil {
+		var flags = make(map[string]bool)
+
+		for name, def := range header.FlagDefs {
+			switch def := def.(type) {
+			case bool:
+				flags[name] = def
+			default:
+				if flag, err := EvalInline(typeutil.String(def), data, funcs); err == nil {
+					flags[name] = typeutil.V(flag).Bool()
+				} else {
+					return nil, nil, fmt.Errorf("flags: %v", err)
+				}
 			}
 		}
 
-		// pagination data
-		if pgConfig := binding.Paginate; pgConfig != nil {
-			var results = make([]map[string]any, 0)
-			var proceed = true
-			var total int64
-			var count int64
-			var soFar int64
-			var page = 1
+		data[`flags`] = flags
+	}
 
-			var lastPage = maputil.M(&ResultsPage{
-				Page:    page,
-				Counter: soFar,
-			}).MapNative(`json`)
+	// the final pass on page; any empty values resulting from this are final
+	server.evalPageData(true, req, header, funcs, data)
 
-			for proceed {
-				var suffix = fmt.Sprintf("binding(%s):page(%d)", binding.Name, page+1)
+	return funcs, data, nil
+}
 
-				bindings[binding.Name] = binding.Fallback
-				data[`page`] = lastPage
+// evaluates a single binding (including any pagination or repetition it specifies), storing its
+// results in the given bindings map and updating data to reflect them.
+func (server *Server) evalBinding(req *http.Request, header *TemplateHeader, binding *Binding, i int, data map[string]any, funcs FuncMap, bindings map[string]any) error {
+	var start = time.Now()
+	describeTimer(fmt.Sprintf("binding-%s", binding.Name), fmt.Sprintf("Diecast Bindings: %s", binding.Name))
 
-				if len(binding.Params) == 0 {
-					binding.Params = make(map[string]any)
-				}
+	if header != nil {
+		if v, err := maputil.Merge(header.DefaultHeaders, binding.Headers); err == nil {
+			binding.Headers = maputil.Stringify(v)
+		} else {
+			return fmt.Errorf("merge headers: %v", err)
+		}
+	}
 
-				// eval the URL
-				if r, err := EvalInline(binding.Resource, data, funcs, suffix); err == nil {
-					binding.Resource = r
-				} else {
-					return nil, nil, fmt.Errorf("resource: %v", err)
-				}
+	// pagination data
+	if pgConfig := binding.Paginate; pgConfig != nil {
+		var results = make([]map[string]any, 0)
+		var proceed = true
+		var total int64
+		var count int64
+		var soFar int64
+		var page = 1
 
-				// eval / set querystring params
-				for qsk, qsv := range pgConfig.QueryStrings {
-					if t, err := EvalInline(qsv, data, funcs, suffix); err == nil {
-						binding.Params[qsk] = typeutil.Auto(t)
-					} else {
-						return nil, nil, fmt.Errorf("param: %v", err)
-					}
-				}
+		var lastPage = maputil.M(&ResultsPage{
+			Page:    page,
+			Counter: soFar,
+		}).MapNative(`json`)
 
-				// eval / set request headers
-				for hk, hv := range pgConfig.Headers {
-					if t, err := EvalInline(hv, data, funcs, suffix); err == nil {
-						binding.Headers[hk] = t
-					} else {
-						return nil, nil, fmt.Errorf("headers: %v", err)
-					}
-				}
+		for proceed {
+			var suffix = fmt.Sprintf("binding(%s):page(%d)", binding.Name, page+1)
 
-				v, err := binding.tracedEvaluate(req, header, data, funcs)
+			bindings[binding.Name] = binding.Fallback
+			data[`page`] = lastPage
 
-				if err == nil {
-					var asMap = maputil.M(v)
+			if len(binding.Params) == 0 {
+				binding.Params = make(map[string]any)
+			}
 
-					if v, err := EvalInline(pgConfig.Total, asMap.MapNative(), funcs, suffix); err == nil {
-						total = typeutil.Int(v)
-					} else {
-						return nil, nil, fmt.Errorf("paginate: %v", err)
-					}
+			// eval the URL
+			if r, err := EvalInline(binding.Resource, data, funcs, suffix); err == nil {
+				binding.Resource = r
+			} else {
+				return fmt.Errorf("resource: %v", err)
+			}
 
-					if v, err := EvalInline(pgConfig.Count, asMap.MapNative(), funcs, suffix); err == nil {
-						count = typeutil.Int(v)
-					} else {
-						return nil, nil, fmt.Errorf("paginate: %v", err)
-					}
+			// eval / set querystring params
+			for qsk, qsv := range pgConfig.QueryStrings {
+				if t, err := EvalInline(qsv, data, funcs, suffix); err == nil {
+					binding.Params[qsk] = typeutil.Auto(t)
+				} else {
+					return fmt.Errorf("param: %v", err)
+				}
+			}
 
-					soFar += count
+			// eval / set request headers
+			for hk, hv := range pgConfig.Headers {
+				if t, err := EvalInline(hv, data, funcs, suffix); err == nil {
+					binding.Headers[hk] = t
+				} else {
+					return fmt.Errorf("headers: %v", err)
+				}
+			}
 
-					log.Debugf("[%v] paginated binding %q: total=%v count=%v soFar=%v", reqid(req), binding.Name, total, count, soFar)
+			v, err := binding.tracedEvaluate(req, header, data, funcs)
 
-					if v, err := EvalInline(pgConfig.Done, asMap.MapNative(), funcs, suffix); err == nil {
-						proceed = !typeutil.Bool(v)
-					} else {
-						return nil, nil, fmt.Errorf("paginate: %v", err)
-					}
+			if err == nil {
+				var asMap = maputil.M(v)
 
-					if pgConfig.Maximum > 0 && soFar >= pgConfig.Maximum {
-						proceed = false
-					}
+				if v, err := EvalInline(pgConfig.Total, asMap.MapNative(), funcs, suffix); err == nil {
+					total = typeutil.Int(v)
+				} else {
+					return fmt.Errorf("paginate: %v", err)
+				}
 
-					if !proceed {
-						log.Debugf("[%v] paginated binding %q: proceed is false, this is the last loop", reqid(req), binding.Name)
-					}
+				if v, err := EvalInline(pgConfig.Count, asMap.MapNative(), funcs, suffix); err == nil {
+					count = typeutil.Int(v)
+				} else {
+					return fmt.Errorf("paginate: %v", err)
+				}
 
-					var thisPage = maputil.M(&ResultsPage{
-						Total:   total,
-						Page:    page,
-						Last:    !proceed,
-						Counter: soFar,
-						Range: []int64{
-							(soFar - count),
-							soFar,
-						},
-					}).MapNative(`json`)
-
-					if output, err := ApplyJPath(v, pgConfig.Data); err == nil {
-						v = output
-					} else {
-						return funcs, nil, err
-					}
+				soFar += count
 
-					thisPage[`data`] = v
-					results = append(results, thisPage)
-					data[`page`] = maputil.M(thisPage).MapNative(`json`)
-					lastPage = thisPage
+				var cursor string
 
-					bindings[binding.Name] = results
-					server.updateBindings(data, bindings)
-				} else if redir, ok := err.(RedirectTo); ok {
-					return funcs, nil, redir
+				if v, err := EvalInline(pgConfig.Cursor, asMap.MapNative(), funcs, suffix); err == nil {
+					cursor = v
 				} else {
-					if err != ErrSkipEval {
-						log.Warningf("[%s] Binding %q (iteration %d) failed: %v", reqid(req), binding.Name, i, err)
-					}
+					return fmt.Errorf("paginate: %v", err)
+				}
 
-					if binding.OnError == ActionContinue {
-						continue
-					} else if binding.OnError == ActionBreak {
-						break
-					} else if !binding.Optional {
-						return funcs, nil, err
-					}
+				log.Debugf("[%v] paginated binding %q: total=%v count=%v soFar=%v", reqid(req), binding.Name, total, count, soFar)
+
+				if v, err := EvalInline(pgConfig.Done, asMap.MapNative(), funcs, suffix); err == nil {
+					proceed = !typeutil.Bool(v)
+				} else {
+					return fmt.Errorf("paginate: %v", err)
 				}
 
-				server.updateBindings(data, bindings)
-				page++
-			}
+				if pgConfig.Maximum > 0 && soFar >= pgConfig.Maximum {
+					proceed = false
+				}
 
-			bindings[binding.Name] = results
-			server.updateBindings(data, bindings)
+				if !proceed {
+					log.Debugf("[%v] paginated binding %q: proceed is false, this is the last loop", reqid(req), binding.Name)
+				}
 
-		} else if binding.Repeat == `` {
-			bindings[binding.Name] = binding.Fallback
-			server.updateBindings(data, bindings)
+				var thisPage = maputil.M(&ResultsPage{
+					Total:   total,
+					Page:    page,
+					Last:    !proceed,
+					Counter: soFar,
+					Cursor:  cursor,
+					Range: []int64{
+						(soFar - count),
+						soFar,
+					},
+				}).MapNative(`json`)
+
+				if output, err := ApplyJPath(v, pgConfig.Data); err == nil {
+					v = output
+				} else {
+					return err
+				}
 
-			v, err := binding.tracedEvaluate(req, header, data, funcs)
+				thisPage[`data`] = v
+				results = append(results, thisPage)
+				data[`page`] = maputil.M(thisPage).MapNative(`json`)
+				lastPage = thisPage
 
-			if err == nil && v != nil {
-				bindings[binding.Name] = v
+				bindings[binding.Name] = results
 				server.updateBindings(data, bindings)
 			} else if redir, ok := err.(RedirectTo); ok {
-				return funcs, nil, redir
-			} else if v == nil && binding.Fallback != nil {
-				bindings[binding.Name] = binding.Fallback
+				return redir
 			} else {
 				if err != ErrSkipEval {
-					log.Warningf("[%s] Binding %q failed: %v", reqid(req), binding.Name, err)
+					log.Warningf("[%s] Binding %q (iteration %d) failed: %v", reqid(req), binding.Name, i, err)
 				}
 
-				if !binding.Optional {
-					return funcs, nil, err
+				if binding.OnError == ActionContinue {
+					continue
+				} else if binding.OnError == ActionBreak {
+					break
+				} else if !binding.Optional {
+					return err
 				}
 			}
-		} else {
-			var results = make([]any, 0)
-
-			var repeatExpr = fmt.Sprintf("{{ range $index, $item := (%v) }}\n", binding.Repeat)
-			repeatExpr += fmt.Sprintf("%v\n", binding.Resource)
-			repeatExpr += "{{ end }}"
-			var repeatExprOut string
-
-			if v, err := EvalInline(repeatExpr, data, funcs); err == nil {
-				repeatExprOut = rxEmptyLine.ReplaceAllString(strings.TrimSpace(v), ``)
-			} else {
-				return nil, nil, fmt.Errorf("repeater: %v", err)
-			}
 
-			log.Debugf("Repeater: \n%v\nOutput:\n%v", repeatExpr, repeatExprOut)
-			var repeatIters = strings.Split(repeatExprOut, "\n")
+			server.updateBindings(data, bindings)
+			page++
+		}
 
-			for i, resource := range repeatIters {
-				binding.Resource = strings.TrimSpace(resource)
-				binding.Repeat = ``
+		bindings[binding.Name] = results
+		server.updateBindings(data, bindings)
 
-				bindings[binding.Name] = binding.Fallback
+	} else if binding.Repeat == `` {
+		bindings[binding.Name] = binding.Fallback
+		server.updateBindings(data, bindings)
 
-				var v, err = binding.tracedEvaluate(req, header, data, funcs)
+		v, err := binding.tracedEvaluate(req, header, data, funcs)
 
-				if err == nil {
-					results = append(results, v)
-					bindings[binding.Name] = results
-					server.updateBindings(data, bindings)
-				} else if redir, ok := err.(RedirectTo); ok {
-					return funcs, nil, redir
-				} else {
-					log.Warningf("Binding %q (iteration %d) failed: %v", binding.Name, i, err)
-
-					if binding.OnError == ActionContinue {
-						continue
-					} else if binding.OnError == ActionBreak {
-						break
-					} else if !binding.Optional {
-						return funcs, nil, err
-					}
-				}
+		if err == nil && v != nil {
+			bindings[binding.Name] = v
+			server.updateBindings(data, bindings)
+		} else if redir, ok := err.(RedirectTo); ok {
+			return redir
+		} else if v == nil && binding.Fallback != nil {
+			bindings[binding.Name] = binding.Fallback
+		} else {
+			if err != ErrSkipEval {
+				log.Warningf("[%s] Binding %q failed: %v", reqid(req), binding.Name, err)
+			}
 
-				server.updateBindings(data, bindings)
+			if !binding.Optional {
+				return err
 			}
+		}
+	} else {
+		var results = make([]any, 0)
+
+		var repeatExpr = fmt.Sprintf("{{ range $index, $item := (%v) }}\n", binding.Repeat)
+		repeatExpr += fmt.Sprintf("%v\n", binding.Resource)
+		repeatExpr += "{{ end }}"
+		var repeatExprOut string
 
+		if v, err := EvalInline(repeatExpr, data, funcs); err == nil {
+			repeatExprOut = rxEmptyLine.ReplaceAllString(strings.TrimSpace(v), ``)
+		} else {
+			return fmt.Errorf("repeater: %v", err)
 		}
 
-		reqtime(req, fmt.Sprintf("binding-%s", binding.Name), time.Since(start))
+		log.Debugf("Repeater: \n%v\nOutput:\n%v", repeatExpr, repeatExprOut)
+		var repeatIters = strings.Split(repeatExprOut, "\n")
 
-		// re-evaluate page based on new binding results
-		server.evalPageData(false, req, header, funcs, data)
-	}
+		for i, resource := range repeatIters {
+			binding.Resource = strings.TrimSpace(resource)
+			binding.Repeat = ``
 
-	server.updateBindings(data, bindings)
+			bindings[binding.Name] = binding.Fallback
 
-	// Evaluate "flags" data: this data is templatized, and has access to $.page and $.bindings
-	// ---------------------------------------------------------------------------------------------
-	if header != nil {
-		var flags = make(map[string]bool)
+			var v, err = binding.tracedEvaluate(req, header, data, funcs)
 
-		for name, def := range header.FlagDefs {
-			switch def := def.(type) {
-			case bool:
-				flags[name] = def
-			default:
-				if flag, err := EvalInline(typeutil.String(def), data, funcs); err == nil {
-					flags[name] = typeutil.V(flag).Bool()
-				} else {
-					return nil, nil, fmt.Errorf("flags: %v", err)
+			if err == nil {
+				results = append(results, v)
+				bindings[binding.Name] = results
+				server.updateBindings(data, bindings)
+			} else if redir, ok := err.(RedirectTo); ok {
+				return redir
+			} else {
+				log.Warningf("Binding %q (iteration %d) failed: %v", binding.Name, i, err)
+
+				if binding.OnError == ActionContinue {
+					continue
+				} else if binding.OnError == ActionBreak {
+					break
+				} else if !binding.Optional {
+					return err
 				}
 			}
+
+			server.updateBindings(data, bindings)
 		}
 
-		data[`flags`] = flags
 	}
 
-	// the final pass on page; any empty values resulting from this are final
-	server.evalPageData(true, req, header, funcs, data)
+	server.tagPageWithBinding(req, binding, data, funcs)
+	reqtime(req, fmt.Sprintf("binding-%s", binding.Name), time.Since(start))
 
-	return funcs, data, nil
+	return nil
 }
 
 func (server *Server) tryAutoindex() (http.File, string, bool) {
@@ -2307,20 +2438,32 @@ func (server *Server) tryMounts(requestPath string, req *http.Request) (Mount, *
 	var lastErr error
 
 	// find a mount that has this file
-	for _, mount := range server.Mounts {
+	for _, mount := range server.reloadable().Mounts {
 		// closing the RequestBody resets the reader to the beginning
 		body.Close()
 
 		if mount.WillRespondTo(requestPath, req, body) {
 			// attempt to open the file entry
+			var start = time.Now()
 			mountResponse, err := mount.OpenWithType(requestPath, req, body)
 			lastErr = err
 
+			server.metrics.observe(`diecast_mount_duration_seconds`, time.Since(start), mount.GetMountPoint())
+
 			if err == nil && !mountResponse.IsDir() {
 				log.Debugf("mount %v handled %q", mount.GetMountPoint(), requestPath)
+				reqmount(req, mount)
+				server.metrics.inc(`diecast_mount_requests_total`, mount.GetMountPoint(), `hit`)
+
 				return mount, mountResponse, nil
-			} else if IsHardStop(err) {
-				return nil, nil, err
+			} else if err == nil || errors.Is(err, os.ErrNotExist) {
+				server.metrics.inc(`diecast_mount_requests_total`, mount.GetMountPoint(), `miss`)
+			} else {
+				server.metrics.inc(`diecast_mount_requests_total`, mount.GetMountPoint(), `error`)
+
+				if IsHardStop(err) {
+					return nil, nil, err
+				}
 			}
 		}
 	}
@@ -2429,7 +2572,10 @@ func (server *Server) appendIncludes(fragments *FragmentSet, header *TemplateHea
 				defer includeFile.Close()
 
 				log.Debugf("Include template %q from file %s", name, includePath)
-				fragments.Parse(name, includeFile)
+
+				if header, data, err := server.splitTemplateFile(includeFile); err == nil {
+					fragments.Set(name, header, data)
+				}
 			} else {
 				return err
 			}
@@ -2468,7 +2614,7 @@ func reqres(req *http.Request) *statusInterceptor {
 func (server *Server) actionForRequest(req *http.Request) http.HandlerFunc {
 	var route = req.URL.Path
 
-	for _, action := range server.Actions {
+	for _, action := range server.reloadable().Actions {
 		var actionPath = filepath.Join(server.rp(), action.Path)
 
 		if actionPath == route {
@@ -2660,6 +2806,13 @@ func (server *Server) requestToEvalData(req *http.Request, header *TemplateHeade
 		panic(err.Error())
 	}
 
+	// variables provided by whatever served the request (e.g. a mount)
+	if vars, ok := httputil.RequestGetValue(req, ContextTemplateDataKey).Value.(map[string]any); ok {
+		for k, v := range vars {
+			rv[k] = v
+		}
+	}
+
 	// environment variables
 	var env = make(map[string]any)
 
@@ -2793,6 +2946,10 @@ func (server *Server) cleanupCommands() {
 			}
 		}
 	}
+
+	server.stopPlugins()
+	server.stopMountWorkers()
+	server.stopOtelTracing()
 }
 
 // called by the cleanup middleware to log the completed request according to LogFormat.
@@ -2804,7 +2961,9 @@ func (server *Server) logreq(_ http.ResponseWriter, req *http.Request) {
 	if tm := getRequestTimer(req); tm != nil {
 		var format = logFormats[server.Log.Format]
 
-		if format == `` {
+		if server.Log.Format == JSONLogFormat {
+			format = JSONLogFormat
+		} else if format == `` {
 			if server.Log.Format != `` {
 				format = server.Log.Format
 			} else {
@@ -2812,38 +2971,38 @@ func (server *Server) logreq(_ http.ResponseWriter, req *http.Request) {
 			}
 		}
 
+		server.logLock.Lock()
+		defer server.logLock.Unlock()
+
 		if server.logwriter == nil {
 			// discard by default, unless some brave configuration below changes this
 			server.logwriter = io.Discard
 
-			switch lf := strings.ToLower(server.Log.Destination); lf {
-			case ``, `none`, `false`:
-				return
-			case `-`, `stdout`:
-				server.isTerminalOutput = true
-				server.logwriter = os.Stdout
-			case `stderr`:
-				server.isTerminalOutput = true
-				server.logwriter = os.Stderr
-			case `syslog`:
-				log.Warningf("logfile: %q destination is not implemented", lf)
-				return
-			default:
-				if server.Log.Truncate {
-					os.Truncate(server.Log.Destination, 0)
-				}
-
-				if f, err := os.OpenFile(server.Log.Destination, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
-					server.logwriter = f
-				} else {
-					log.Warningf("logfile: failed to open logfile: %v", err)
-					return
+			if w, terminal, err := server.Log.open(); err == nil {
+				if w != nil {
+					server.logwriter = w
+					server.isTerminalOutput = terminal
 				}
+			} else {
+				log.Warningf("logfile: %v", err)
 			}
 		}
 
+		if server.logwriter == io.Discard {
+			return
+		}
+
 		var interceptor = reqres(req)
 		rh, rp := stringutil.SplitPair(req.RemoteAddr, `:`)
+
+		if format == JSONLogFormat {
+			if err := json.NewEncoder(server.logwriter).Encode(newRequestLogEntry(req, tm, interceptor, rh)); err != nil {
+				log.Warningf("logfile: %v", err)
+			}
+
+			return
+		}
+
 		var code = typeutil.String(interceptor.code)
 
 		if server.isTerminalOutput && server.Log.Colorize {
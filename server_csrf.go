@@ -112,6 +112,7 @@ func (csrf *CSRF) Handle(w http.ResponseWriter, req *http.Request) bool {
 				// if the token is missing/invalid, stop here and return an error
 				if !csrf.Verify(creq) {
 					if csrf.server != nil {
+						csrf.server.metrics.inc(`diecast_csrf_failures_total`)
 						csrf.server.respondError(w, req, fmt.Errorf("cSRF validation failed"), http.StatusBadRequest)
 					} else {
 						http.Error(w, "CSRF validation failed", http.StatusBadRequest)
@@ -270,7 +271,7 @@ func (csrf *CSRF) IsExempt(req *http.Request) bool {
 
 func (server *Server) middlewareCsrf(w http.ResponseWriter, req *http.Request) bool {
 	// enforce CSRF protection (if configured)
-	if csrf := server.CSRF; csrf != nil && csrf.Enable {
+	if csrf := server.reloadable().CSRF; csrf != nil && csrf.Enable {
 		if !csrf.registered {
 			csrf.server = server
 
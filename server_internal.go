@@ -0,0 +1,83 @@
+package diecast
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+)
+
+// The path under which Diecast exposes its own management endpoints.
+var InternalRoutePrefix = `/_diecast`
+
+// An internalEndpoint describes one of Diecast's own management routes (e.g.: purging caches).
+type internalEndpoint struct {
+	Path           string               // path relative to InternalRoutePrefix
+	Methods        []string             // permitted HTTP methods (all methods if empty)
+	Authenticator  *AuthenticatorConfig // the authenticator protecting this endpoint
+	RequireAuth    bool                 // refuse all requests if no authenticator is configured
+	Handler        http.HandlerFunc     // the handler to call once the request is permitted
+	server         *Server
+	authenticator  Authenticator
+	normalizedPath string
+}
+
+func (server *Server) internalPath(name string) string {
+	return path.Join(`/`, server.rp(), InternalRoutePrefix, name)
+}
+
+// registers an internal endpoint on the server's mux, wrapping the handler with method and
+// authentication checks.
+func (server *Server) registerInternalEndpoint(endpoint *internalEndpoint) error {
+	endpoint.server = server
+	endpoint.normalizedPath = server.internalPath(endpoint.Path)
+
+	if endpoint.Authenticator != nil {
+		if auth, err := returnAuthenticatorFor(endpoint.Authenticator); err == nil {
+			endpoint.authenticator = auth
+		} else {
+			return fmt.Errorf("endpoint %s: authenticator: %v", endpoint.normalizedPath, err)
+		}
+	} else if endpoint.RequireAuth {
+		log.Warningf("[internal] %s: no authenticator is configured, all requests will be refused", endpoint.normalizedPath)
+	}
+
+	server.mux.HandleFunc(endpoint.normalizedPath, endpoint.ServeHTTP)
+	log.Debugf("[internal] Registered %s", endpoint.normalizedPath)
+
+	return nil
+}
+
+func (endpoint *internalEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reqroute(req, endpoint.normalizedPath)
+
+	if len(endpoint.Methods) > 0 && !sliceutil.ContainsString(endpoint.Methods, strings.ToUpper(req.Method)) {
+		w.Header().Set(`Allow`, strings.Join(endpoint.Methods, `, `))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if auth := endpoint.authenticator; auth != nil {
+		if !auth.Authenticate(w, req) {
+			log.Warningf("[%s] %s: denied by authenticator %q", reqid(req), endpoint.normalizedPath, auth.Name())
+			endpoint.server.metrics.inc(`diecast_authenticator_denials_total`, auth.Name())
+			httputil.RequestSetValue(req, ContextStatusKey, http.StatusForbidden)
+
+			// not all authenticators write a response of their own
+			if rw, ok := w.(*statusInterceptor); !ok || !rw.wroteHeader {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			}
+
+			return
+		}
+	} else if endpoint.RequireAuth {
+		http.Error(w, `this endpoint requires an authenticator to be configured`, http.StatusForbidden)
+		return
+	}
+
+	endpoint.Handler(w, req)
+}
@@ -10,11 +10,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	ico "github.com/biessek/golang-ico"
-	"github.com/ghetzel/go-stockutil/executil"
 	"github.com/ghetzel/go-stockutil/fileutil"
 	"github.com/ghetzel/go-stockutil/httputil"
 	"github.com/ghetzel/go-stockutil/log"
@@ -25,6 +25,9 @@ import (
 	base58 "github.com/jbenet/go-base58"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // A function that receives the current request, ResponseWriter, and returns whether to call the next middleware
@@ -57,11 +60,19 @@ func (server *Server) setupServer() error {
 }
 
 func (server *Server) traceName(candidate string) string {
+	var mappings []*TraceMapping
+
 	if jc := server.JaegerConfig; jc != nil && jc.Enable {
-		for _, mapping := range jc.OperationsMappings {
-			if newName, matched := mapping.TraceName(candidate); matched {
-				return newName
-			}
+		mappings = append(mappings, jc.OperationsMappings...)
+	}
+
+	if oc := server.OtelConfig; oc != nil && oc.Enable {
+		mappings = append(mappings, oc.OperationsMappings...)
+	}
+
+	for _, mapping := range mappings {
+		if newName, matched := mapping.TraceName(candidate); matched {
+			return newName
 		}
 	}
 
@@ -119,6 +130,13 @@ func (server *Server) middlewareStartRequest(w http.ResponseWriter, req *http.Re
 		}
 	}
 
+	// setup OpenTelemetry tracing for this request (if we should)
+	if server.otelTracer != nil {
+		if traceName := server.traceNameFromRequest(req); traceName != `` {
+			server.startOtelRequestSpan(req, traceName, requestId)
+		}
+	}
+
 	httputil.RequestSetValue(req, ContextRequestKey, requestId)
 	w.Header().Set(`X-Diecast-Request-ID`, requestId)
 
@@ -220,16 +238,19 @@ func (server *Server) middlewareInjectHeaders(w http.ResponseWriter, req *http.R
 
 // process authenticators
 func (server *Server) middlewareProcessAuthenticators(w http.ResponseWriter, req *http.Request) bool {
-	if len(server.Authenticators) > 0 {
+	if authenticators := server.reloadable().Authenticators; len(authenticators) > 0 {
 		log.Debugf("[%s] middleware: process authenticators", reqid(req))
 
-		if auth, err := server.Authenticators.Authenticator(req); err == nil {
+		if auth, err := authenticators.Authenticator(req); err == nil {
 			if auth != nil {
+				reqauthenticator(req, auth.Name())
+
 				if auth.IsCallback(req.URL) {
 					auth.Callback(w, req)
 					return false
 				} else if !auth.Authenticate(w, req) {
 					httputil.RequestSetValue(req, ContextStatusKey, http.StatusForbidden)
+					server.metrics.inc(`diecast_authenticator_denials_total`, auth.Name())
 					return false
 				}
 			}
@@ -251,6 +272,20 @@ func (server *Server) afterFinalizeAndLog(w http.ResponseWriter, req *http.Reque
 		took = time.Since(tm.StartedAt).Round(time.Microsecond)
 		log.Debugf("[%s] completed: %v", tm.ID, took)
 		httputil.RequestSetValue(req, `duration`, took)
+
+		if server.metrics != nil {
+			tm.lock.Lock()
+			var route = sliceutil.OrString(tm.Route, `unmatched`)
+			tm.lock.Unlock()
+
+			server.metrics.observe(
+				`diecast_request_duration_seconds`,
+				took,
+				route,
+				strings.ToUpper(req.Method),
+				strconv.Itoa(reqres(req).code),
+			)
+		}
 	}
 
 	// finish up and close out trace
@@ -261,6 +296,21 @@ func (server *Server) afterFinalizeAndLog(w http.ResponseWriter, req *http.Reque
 		ot.Finish()
 	}
 
+	if span, ok := httputil.RequestGetValue(req, OtelSpanKey).Value.(trace.Span); ok {
+		var interceptor = reqres(req)
+
+		span.SetAttributes(
+			attribute.Int(`http.status_code`, interceptor.code),
+			attribute.Int64(`http.response_content_length`, int64(interceptor.bytesWritten)),
+		)
+
+		if interceptor.code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(interceptor.code))
+		}
+
+		span.End()
+	}
+
 	server.logreq(w, req)
 	removeRequestTimer(req)
 }
@@ -358,28 +408,30 @@ func (server *Server) registerInternalRoutes() error {
 		}
 	})
 
-	// add action handlers
-	for i, action := range server.Actions {
-		var hndPath = filepath.Join(server.rp(), action.Path)
+	if err := server.initPageCache(); err != nil {
+		return err
+	}
 
-		if executil.IsRoot() && !executil.EnvBool(`DIECAST_ALLOW_ROOT_ACTIONS`) {
-			return fmt.Errorf("refusing to start as root with actions specified.  Override with the environment variable DIECAST_ALLOW_ROOT_ACTIONS=true")
-		}
+	if err := server.initLiveReload(); err != nil {
+		return err
+	}
 
-		if action.Path == `` {
-			return fmt.Errorf("Action %d: Must specify a 'path'", i)
-		}
+	if err := server.initReloadEndpoint(); err != nil {
+		return err
+	}
 
-		server.mux.HandleFunc(hndPath, func(w http.ResponseWriter, req *http.Request) {
-			if handler := server.actionForRequest(req); handler != nil {
-				handler(w, req)
-			} else {
-				http.Error(w, "cannot find handler for action", http.StatusInternalServerError)
-			}
-		})
+	if err := server.initMetrics(); err != nil {
+		return err
+	}
 
-		log.Debugf("[actions] Registered %s", hndPath)
+	if err := server.initCircuitBreakerEndpoint(); err != nil {
+		return err
 	}
 
-	return nil
+	if err := server.initUpstreamsEndpoint(); err != nil {
+		return err
+	}
+
+	// add action handlers
+	return server.registerActionRoutes(server.Actions)
 }
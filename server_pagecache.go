@@ -0,0 +1,407 @@
+package diecast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"github.com/gobwas/glob"
+	"golang.org/x/text/language"
+)
+
+var DefaultPageCacheSize = 1024
+var DefaultPageCacheTTL = time.Minute
+
+const ContextPageCaptureKey = `diecast-page-capture`
+
+// response headers that are specific to a single response, and so are never stored in the page cache
+var pageCacheSkipHeaders = []string{
+	`Age`,
+	`Connection`,
+	`Date`,
+	`Server-Timing`,
+	`Set-Cookie`,
+	`Traceparent`,
+	`Tracestate`,
+	`Uber-Trace-Id`,
+	`X-Amzn-Trace-Id`,
+	`X-Cache`,
+	`X-Diecast-Request-Id`,
+}
+
+type PageCacheRule struct {
+	Path        string   `yaml:"path"         json:"path"`         // A path glob specifying which requests this rule applies to.
+	TTL         string   `yaml:"ttl"          json:"ttl"`          // How long rendered pages are cached for (default: 1m).
+	VaryQuery   []string `yaml:"vary_query"   json:"vary_query"`   // Query string parameters that produce distinct pages.  If unset, the whole query string is used.
+	VaryHeaders []string `yaml:"vary_headers" json:"vary_headers"` // Request headers that produce distinct pages.
+	VaryCookies []string `yaml:"vary_cookies" json:"vary_cookies"` // Cookies that produce distinct pages.  If unset, all cookies are used.
+	VaryLocale  bool     `yaml:"vary_locale"  json:"vary_locale"`  // Cache pages separately for each of the client's preferred locales (via Accept-Language).
+	glob        glob.Glob
+}
+
+func (rule *PageCacheRule) ttl() time.Duration {
+	if ttl := secondsOrDuration(rule.TTL); ttl > 0 {
+		return ttl
+	}
+
+	return DefaultPageCacheTTL
+}
+
+// generate the cache key for the given request, incorporating everything this rule varies on.
+func (rule *PageCacheRule) key(req *http.Request) string {
+	var parts = []string{
+		rule.Path,
+		req.Host,
+		req.URL.Path,
+	}
+
+	if rule.VaryQuery == nil {
+		var qs = req.URL.Query()
+
+		for _, k := range maputil.StringKeys(qs) {
+			var values = qs[k]
+
+			sort.Strings(values)
+			parts = append(parts, fmt.Sprintf("q:%s=%s", k, strings.Join(values, "\x00")))
+		}
+	} else {
+		for _, k := range rule.VaryQuery {
+			parts = append(parts, fmt.Sprintf("q:%s=%s", k, strings.Join(req.URL.Query()[k], "\x00")))
+		}
+	}
+
+	for _, k := range rule.VaryHeaders {
+		parts = append(parts, fmt.Sprintf("h:%s=%s", http.CanonicalHeaderKey(k), strings.Join(req.Header.Values(k), "\x00")))
+	}
+
+	// pages may vary by the identity of the requester, so credentials are always part of the key, as
+	// are cookies (unless the rule names the cookies that the page varies by)
+	if v := req.Header.Get(`Authorization`); v != `` {
+		parts = append(parts, `a:`+v)
+	}
+
+	if rule.VaryCookies == nil {
+		if cookies := req.Header.Values(`Cookie`); len(cookies) > 0 {
+			parts = append(parts, `c:`+strings.Join(cookies, "\x00"))
+		}
+	} else {
+		for _, k := range rule.VaryCookies {
+			if cookie, err := req.Cookie(k); err == nil {
+				parts = append(parts, fmt.Sprintf("c:%s=%s", k, cookie.Value))
+			} else {
+				parts = append(parts, fmt.Sprintf("c:%s", k))
+			}
+		}
+	}
+
+	if rule.VaryLocale {
+		var locale string
+
+		if tags, _, err := language.ParseAcceptLanguage(req.Header.Get(`Accept-Language`)); err == nil && len(tags) > 0 {
+			locale = tags[0].String()
+		}
+
+		parts = append(parts, `l:`+locale)
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+type PageCacheConfig struct {
+	Enable        bool                 `yaml:"enable"        json:"enable"`        // Enable caching of rendered pages.
+	Size          int                  `yaml:"size"          json:"size"`          // The maximum number of rendered pages to keep in memory.
+	Rules         []*PageCacheRule     `yaml:"rules"         json:"rules"`         // Rules specifying which paths are cached, and for how long.
+	Authenticator *AuthenticatorConfig `yaml:"authenticator" json:"authenticator"` // The authenticator that protects the purge endpoint.  Purging is refused if this is not set.
+}
+
+type pageCacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Keys       []string
+	StoredAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// pageCapture records a response as it is written so that it can be stored in the page cache.
+type pageCapture struct {
+	http.ResponseWriter
+	server   *Server
+	req      *http.Request
+	rule     *PageCacheRule
+	key      string
+	code     int
+	body     bytes.Buffer
+	keys     []string
+	rendered bool
+	lock     sync.Mutex
+}
+
+func (capture *pageCapture) WriteHeader(code int) {
+	if capture.code == 0 {
+		capture.code = code
+	}
+
+	capture.ResponseWriter.WriteHeader(code)
+}
+
+func (capture *pageCapture) Write(b []byte) (int, error) {
+	if capture.code == 0 {
+		capture.code = http.StatusOK
+	}
+
+	capture.body.Write(b)
+	return capture.ResponseWriter.Write(b)
+}
+
+// tag the page being captured with the given surrogate keys
+func (capture *pageCapture) addKeys(keys ...string) {
+	capture.lock.Lock()
+	defer capture.lock.Unlock()
+
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != `` {
+			capture.keys = sliceutil.UniqueStrings(append(capture.keys, key))
+		}
+	}
+}
+
+// store the captured response, provided it was a successfully-rendered template.
+func (capture *pageCapture) store() {
+	if !capture.rendered || capture.code != http.StatusOK {
+		return
+	}
+
+	// responses that set cookies are specific to a single client
+	if len(capture.Header().Values(`Set-Cookie`)) > 0 {
+		log.Debugf("[%s] pagecache: not storing %s: response sets cookies", reqid(capture.req), capture.req.URL.Path)
+		return
+	}
+
+	var now = time.Now()
+	var header = capture.Header().Clone()
+
+	for _, k := range pageCacheSkipHeaders {
+		header.Del(k)
+	}
+
+	capture.addKeys(`path:` + capture.req.URL.Path)
+
+	capture.server.pageCache().Set(capture.key, &pageCacheEntry{
+		StatusCode: capture.code,
+		Header:     header,
+		Body:       capture.body.Bytes(),
+		Keys:       capture.keys,
+		StoredAt:   now,
+		ExpiresAt:  now.Add(capture.rule.ttl()),
+	})
+
+	log.Debugf("[%s] pagecache: stored %s (keys: %s)", reqid(capture.req), capture.req.URL.Path, strings.Join(capture.keys, ` `))
+}
+
+func (server *Server) pageCache() *lruCache[*pageCacheEntry] {
+	server.pageCacheLock.Lock()
+	defer server.pageCacheLock.Unlock()
+
+	if server.pageCacheEntries == nil {
+		var size = DefaultPageCacheSize
+
+		if pc := server.PageCache; pc != nil && pc.Size > 0 {
+			size = pc.Size
+		}
+
+		server.pageCacheEntries = newLruCache[*pageCacheEntry](size)
+	}
+
+	return server.pageCacheEntries
+}
+
+// compile page cache rules and register the purge endpoint.
+func (server *Server) initPageCache() error {
+	var pc = server.PageCache
+
+	if pc == nil || !pc.Enable {
+		return nil
+	}
+
+	for i, rule := range pc.Rules {
+		if rule == nil {
+			continue
+		} else if g, err := glob.Compile(rule.Path); err == nil {
+			rule.glob = g
+		} else {
+			return fmt.Errorf("pagecache rule %d: bad path %q: %v", i, rule.Path, err)
+		}
+	}
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:          `purge`,
+		Methods:       []string{http.MethodPost},
+		Authenticator: pc.Authenticator,
+		RequireAuth:   true,
+		Handler:       server.handlePageCachePurge,
+	})
+}
+
+// return the first page cache rule that applies to the given request, or nil if the request
+// cannot be cached.
+func (server *Server) pageCacheRuleFor(req *http.Request) *PageCacheRule {
+	if pc := server.PageCache; pc != nil && pc.Enable {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			break
+		default:
+			return nil
+		}
+
+		if server.ShouldReturnSource(req) {
+			return nil
+		}
+
+		for _, rule := range pc.Rules {
+			if rule != nil && rule.glob != nil && rule.glob.Match(req.URL.Path) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
+
+// Serves the request from the page cache if possible, returning true if it was.  Otherwise, if the
+// request is cacheable, a writer that captures the response is returned; the caller is expected to
+// render the response to it and then call store().
+func (server *Server) pageCacheLookup(w http.ResponseWriter, req *http.Request) (bool, *pageCapture) {
+	var rule = server.pageCacheRuleFor(req)
+
+	if rule == nil {
+		return false, nil
+	}
+
+	var id = reqid(req)
+	var key = rule.key(req)
+	var now = time.Now()
+	var cache = server.pageCache()
+
+	if entry, ok := cache.Get(key); ok {
+		if now.Before(entry.ExpiresAt) {
+			// headers already set for this request (e.g. its ID) take precedence over stored ones
+			for k, vv := range entry.Header {
+				if _, ok := w.Header()[k]; !ok {
+					w.Header()[k] = vv
+				}
+			}
+
+			w.Header().Set(`Age`, typeutil.String(int64(now.Sub(entry.StoredAt)/time.Second)))
+			w.Header().Set(`X-Cache`, `HIT`)
+			w.WriteHeader(entry.StatusCode)
+
+			if req.Method != http.MethodHead {
+				w.Write(entry.Body)
+			}
+
+			log.Debugf("[%s] pagecache: hit %s", id, req.URL.Path)
+			return true, nil
+		} else {
+			cache.Delete(key)
+		}
+	}
+
+	w.Header().Set(`X-Cache`, `MISS`)
+
+	// HEAD responses have no body to store
+	if req.Method != http.MethodGet {
+		return false, nil
+	}
+
+	var capture = &pageCapture{
+		ResponseWriter: w,
+		server:         server,
+		req:            req,
+		rule:           rule,
+		key:            key,
+	}
+
+	httputil.RequestSetValue(req, ContextPageCaptureKey, capture)
+
+	return false, capture
+}
+
+// tag the page being rendered (if it's being cached) with the surrogate keys for the given binding.
+func (server *Server) tagPageWithBinding(req *http.Request, binding *Binding, data map[string]any, funcs FuncMap) {
+	if capture, ok := httputil.RequestGetValue(req, ContextPageCaptureKey).Value.(*pageCapture); ok {
+		capture.addKeys(`binding:` + binding.Name)
+
+		for _, key := range binding.SurrogateKeys {
+			if k, err := EvalInline(key, data, funcs); err == nil {
+				capture.addKeys(k)
+			} else {
+				log.Warningf("[%s] binding %q: surrogate key: %v", reqid(req), binding.Name, err)
+			}
+		}
+	}
+}
+
+// Purges all pages tagged with any of the given surrogate keys, returning the number of pages
+// removed.  The special key "*" purges every page.
+func (server *Server) PurgePages(keys ...string) int {
+	var cache = server.pageCache()
+	var purge = make([]string, 0)
+	var all = sliceutil.ContainsString(keys, `*`)
+
+	cache.Each(func(key string, entry *pageCacheEntry) bool {
+		if all || sliceutil.ContainsAnyString(entry.Keys, keys...) {
+			purge = append(purge, key)
+		}
+
+		return true
+	})
+
+	for _, key := range purge {
+		cache.Delete(key)
+	}
+
+	return len(purge)
+}
+
+// handle POST /_diecast/purge
+func (server *Server) handlePageCachePurge(w http.ResponseWriter, req *http.Request) {
+	var keys = req.URL.Query()[`key`]
+
+	if req.ContentLength > 0 {
+		var body struct {
+			Keys []string `json:"keys"`
+		}
+
+		if err := json.NewDecoder(req.Body).Decode(&body); err == nil {
+			keys = append(keys, body.Keys...)
+		} else {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(keys) == 0 {
+		http.Error(w, `must specify at least one surrogate key to purge`, http.StatusBadRequest)
+		return
+	}
+
+	var purged = server.PurgePages(keys...)
+
+	log.Infof("[%s] pagecache: purged %d pages (keys: %s)", reqid(req), purged, strings.Join(keys, ` `))
+
+	httputil.RespondJSON(w, map[string]any{
+		`keys`:   keys,
+		`purged`: purged,
+	})
+}
@@ -0,0 +1,178 @@
+package diecast
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+)
+
+func TestPageCache(t *testing.T) {
+	var assert = require.New(t)
+	var hits int64
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httputil.RespondJSON(w, map[string]any{
+			`hit`: atomic.AddInt64(&hits, 1),
+		})
+	}))
+
+	defer upstream.Close()
+
+	var server = NewServer(`./tests/hello`)
+
+	server.Bindings = SharedBindingSet{
+		{
+			Name:          `counter`,
+			Resource:      upstream.URL,
+			SurrogateKeys: []string{`counter-{{ $.bindings.counter.hit }}`},
+		},
+	}
+
+	server.PageCache = &PageCacheConfig{
+		Enable: true,
+		Rules: []*PageCacheRule{
+			{
+				Path:      `/index.html`,
+				VaryQuery: []string{`lang`},
+			},
+		},
+		Authenticator: &AuthenticatorConfig{
+			Type: `always`,
+		},
+	}
+
+	assert.NoError(server.Initialize())
+
+	// first request renders the page, second is served from cache
+	// ---------------------------------------------------------------------------------------------
+	var firstId string
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+		assert.Equal(`MISS`, w.Header().Get(`X-Cache`))
+		firstId = w.Header().Get(`X-Diecast-Request-ID`)
+	})
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+		assert.Equal(`HIT`, w.Header().Get(`X-Cache`))
+		assert.Equal(`0`, w.Header().Get(`Age`))
+		assert.Contains(w.Body.String(), `Hello`)
+
+		// cached pages are served with the current request's ID, not that of the one that stored them
+		assert.Len(w.Header().Values(`X-Diecast-Request-ID`), 1)
+		assert.NotEmpty(firstId)
+		assert.NotEqual(firstId, w.Header().Get(`X-Diecast-Request-ID`))
+	})
+
+	assert.EqualValues(1, atomic.LoadInt64(&hits))
+
+	// unrelated query strings share the cached page, varied ones do not
+	// ---------------------------------------------------------------------------------------------
+	doTestServerRequest(server, `GET`, `/index.html?utm_source=test`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`HIT`, w.Header().Get(`X-Cache`))
+	})
+
+	doTestServerRequest(server, `GET`, `/index.html?lang=de`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`MISS`, w.Header().Get(`X-Cache`))
+	})
+
+	assert.EqualValues(2, atomic.LoadInt64(&hits))
+
+	// uncached paths are untouched
+	// ---------------------------------------------------------------------------------------------
+	doTestServerRequest(server, `GET`, `/functions.html`, func(w *httptest.ResponseRecorder) {
+		assert.Empty(w.Header().Get(`X-Cache`))
+	})
+
+	// purging by surrogate key only removes the pages tagged with it
+	// ---------------------------------------------------------------------------------------------
+	doTestServerRequest(server, `GET`, `/_diecast/purge?key=counter-2`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusMethodNotAllowed, w.Code)
+	})
+
+	doTestServerRequest(server, `POST`, `/_diecast/purge`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusBadRequest, w.Code)
+	})
+
+	doTestServerRequest(server, `POST`, `/_diecast/purge?key=counter-2`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+		assert.Contains(w.Body.String(), `"purged":1`)
+	})
+
+	doTestServerRequest(server, `GET`, `/index.html?lang=de`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`MISS`, w.Header().Get(`X-Cache`))
+	})
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`HIT`, w.Header().Get(`X-Cache`))
+	})
+
+	assert.Equal(2, server.PurgePages(`binding:counter`))
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(`MISS`, w.Header().Get(`X-Cache`))
+	})
+
+	// requests with different credentials or cookies never share a cached page
+	// ---------------------------------------------------------------------------------------------
+	var before = atomic.LoadInt64(&hits)
+
+	for _, header := range []string{`Cookie`, `Authorization`} {
+		for _, value := range []string{`session=alice`, `session=bob`} {
+			var req = httptest.NewRequest(`GET`, `/index.html`, nil)
+			var w = httptest.NewRecorder()
+
+			req.Header.Set(header, value)
+			server.ServeHTTP(w, req)
+
+			assert.Equal(200, w.Code)
+			assert.Equal(`MISS`, w.Header().Get(`X-Cache`), "%s: %s", header, value)
+		}
+	}
+
+	assert.EqualValues(before+4, atomic.LoadInt64(&hits))
+
+	var req = httptest.NewRequest(`GET`, `/index.html`, nil)
+	var w = httptest.NewRecorder()
+
+	req.Header.Set(`Cookie`, `session=alice`)
+	server.ServeHTTP(w, req)
+
+	assert.Equal(`HIT`, w.Header().Get(`X-Cache`))
+}
+
+func TestPageCachePurgeRequiresAuthenticator(t *testing.T) {
+	var assert = require.New(t)
+	var server = NewServer(`./tests/hello`)
+
+	server.PageCache = &PageCacheConfig{
+		Enable: true,
+		Rules: []*PageCacheRule{
+			{Path: `/**`},
+		},
+	}
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `POST`, `/_diecast/purge?key=*`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusForbidden, w.Code)
+	})
+
+	// authenticators that deny the request without responding still result in a 403
+	server = NewServer(`./tests/hello`)
+	server.PageCache = &PageCacheConfig{
+		Enable:        true,
+		Authenticator: &AuthenticatorConfig{Type: `never`},
+	}
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `POST`, `/_diecast/purge?key=*`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusForbidden, w.Code)
+	})
+}
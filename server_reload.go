@@ -0,0 +1,295 @@
+package diecast
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/ghetzel/go-stockutil/executil"
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/gobwas/glob"
+)
+
+// the subset of the server's configuration that can be replaced while the server is running.
+type reloadableConfig struct {
+	Mounts         []Mount
+	MountConfigs   []MountConfig
+	Bindings       SharedBindingSet
+	Authenticators AuthenticatorConfigs
+	CSRF           *CSRF
+	Actions        []*Action
+}
+
+// returns a consistent view of the reloadable parts of the current configuration.
+func (server *Server) reloadable() reloadableConfig {
+	server.configLock.RLock()
+	defer server.configLock.RUnlock()
+
+	return reloadableConfig{
+		Mounts:         server.Mounts,
+		MountConfigs:   server.MountConfigs,
+		Bindings:       server.Bindings,
+		Authenticators: server.Authenticators,
+		CSRF:           server.CSRF,
+		Actions:        server.Actions,
+	}
+}
+
+// Re-reads the configuration file the server was started with (including any environment-specific
+// overrides), validates it, and replaces the current mounts, shared bindings, authenticators, CSRF
+// configuration, and actions with the new ones.  Requests already in progress finish using the
+// configuration they started with.  If the new configuration is invalid, the current configuration
+// is left untouched and the error is returned.  Changes to any other settings require a restart.
+func (server *Server) Reload() error {
+	server.reloadLock.Lock()
+	defer server.reloadLock.Unlock()
+
+	if server.configFile == `` {
+		return fmt.Errorf("reload: server was not started from a configuration file")
+	}
+
+	var candidate = NewServer(server.RootPath)
+
+	candidate.Environment = server.Environment
+
+	if err := candidate.LoadConfig(server.configFile); err != nil {
+		return fmt.Errorf("reload: %v", err)
+	}
+
+	var next = reloadableConfig{
+		Mounts:         append(candidate.Mounts, server.explicitMounts...),
+		MountConfigs:   candidate.MountConfigs,
+		Bindings:       candidate.Bindings,
+		Authenticators: candidate.Authenticators,
+		CSRF:           candidate.CSRF,
+		Actions:        candidate.Actions,
+	}
+
+	if err := server.validateReloadable(next); err != nil {
+		return fmt.Errorf("reload: %v", err)
+	}
+
+	// routes for new actions only take effect once the configuration containing them is swapped in,
+	// so they can be registered beforehand
+	if err := server.registerActionRoutes(next.Actions); err != nil {
+		return fmt.Errorf("reload: %v", err)
+	}
+
+	var previous = server.swapReloadable(next)
+
+	if err := server.startSharedBindings(next.Bindings); err != nil {
+		server.swapReloadable(previous)
+
+		if err := server.startSharedBindings(previous.Bindings); err != nil {
+			log.Errorf("reload: restoring async bindings: %v", err)
+		}
+
+		return fmt.Errorf("reload: async bindings: %v", err)
+	}
+
+	server.startMountWorkers(next.Mounts)
+
+	server.PurgePages(`*`)
+
+	log.Noticef(
+		"reload: configuration reloaded from %s (%d mounts, %d shared bindings, %d authenticators, %d actions)",
+		server.configFile,
+		len(next.Mounts),
+		len(next.Bindings),
+		len(next.Authenticators),
+		len(next.Actions),
+	)
+
+	return nil
+}
+
+// replace the reloadable parts of the current configuration, returning what they were.
+func (server *Server) swapReloadable(next reloadableConfig) reloadableConfig {
+	server.configLock.Lock()
+	defer server.configLock.Unlock()
+
+	var previous = reloadableConfig{
+		Mounts:         server.Mounts,
+		MountConfigs:   server.MountConfigs,
+		Bindings:       server.Bindings,
+		Authenticators: server.Authenticators,
+		CSRF:           server.CSRF,
+		Actions:        server.Actions,
+	}
+
+	server.Mounts = next.Mounts
+	server.MountConfigs = next.MountConfigs
+	server.Bindings = next.Bindings
+	server.Authenticators = next.Authenticators
+	server.CSRF = next.CSRF
+	server.Actions = next.Actions
+
+	return previous
+}
+
+// checks everything in the given configuration that would otherwise only fail at request time.
+func (server *Server) validateReloadable(config reloadableConfig) error {
+	for i := range config.Authenticators {
+		var auth = config.Authenticators[i]
+
+		for _, pattern := range append(auth.Paths, auth.Except...) {
+			if _, err := glob.Compile(pattern); err != nil {
+				return fmt.Errorf("authenticator %d: bad path %q: %v", i, pattern, err)
+			}
+		}
+
+		if _, err := returnAuthenticatorFor(&auth); err != nil {
+			return fmt.Errorf("authenticator %d: %v", i, err)
+		}
+	}
+
+	if err := server.validateActions(config.Actions); err != nil {
+		return err
+	}
+
+	for i, binding := range config.Bindings {
+		if binding == nil {
+			return fmt.Errorf("binding %d: empty binding", i)
+		}
+	}
+
+	if server.BindingConcurrency > 1 {
+		if _, err := server.bindingDependencies(config.Bindings.perRequestBindings(), server.BaseHeader); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// (re)start polling the given shared bindings, stopping the polling of any previous set and
+// discarding data belonging to bindings that are no longer present.
+func (server *Server) startSharedBindings(set SharedBindingSet) error {
+	server.sharedBindingsLock.Lock()
+	defer server.sharedBindingsLock.Unlock()
+
+	if server.sharedBindingsStop != nil {
+		close(server.sharedBindingsStop)
+	}
+
+	server.sharedBindingsStop = make(chan struct{})
+
+	var names = make(map[string]bool)
+
+	for i, binding := range set {
+		if binding.Interval != `` {
+			if binding.Name == `` {
+				binding.Name = fmt.Sprintf("shared.%d", i)
+			}
+
+			names[binding.Name] = true
+		}
+	}
+
+	server.sharedBindingData.Range(func(key any, _ any) bool {
+		if name, ok := key.(string); ok && !names[name] {
+			server.sharedBindingData.Delete(key)
+		}
+
+		return true
+	})
+
+	return set.init(server, server.sharedBindingsStop)
+}
+
+func (server *Server) validateActions(actions []*Action) error {
+	if len(actions) > 0 && executil.IsRoot() && !executil.EnvBool(`DIECAST_ALLOW_ROOT_ACTIONS`) {
+		return fmt.Errorf("refusing to start as root with actions specified.  Override with the environment variable DIECAST_ALLOW_ROOT_ACTIONS=true")
+	}
+
+	for i, action := range actions {
+		if action.Path == `` {
+			return fmt.Errorf("Action %d: Must specify a 'path'", i)
+		}
+	}
+
+	return nil
+}
+
+// register handlers for any of the given actions' paths that don't already have one.  Handlers are
+// never removed; requests to paths whose action has since gone away are handled normally.
+func (server *Server) registerActionRoutes(actions []*Action) error {
+	if err := server.validateActions(actions); err != nil {
+		return err
+	}
+
+	server.configLock.Lock()
+	defer server.configLock.Unlock()
+
+	if server.actionRoutes == nil {
+		server.actionRoutes = make(map[string]bool)
+	}
+
+	for _, action := range actions {
+		var hndPath = filepath.Join(server.rp(), action.Path)
+
+		if server.actionRoutes[hndPath] {
+			continue
+		}
+
+		server.mux.HandleFunc(hndPath, func(w http.ResponseWriter, req *http.Request) {
+			if handler := server.actionForRequest(req); handler != nil {
+				reqroute(req, hndPath)
+				handler(w, req)
+			} else if server.hasActionAt(req.URL.Path) {
+				http.Error(w, "cannot find handler for action", http.StatusInternalServerError)
+			} else {
+				server.handleRequest(w, req)
+			}
+		})
+
+		server.actionRoutes[hndPath] = true
+		log.Debugf("[actions] Registered %s", hndPath)
+	}
+
+	return nil
+}
+
+// returns whether any currently-configured action is served from the given path.
+func (server *Server) hasActionAt(route string) bool {
+	for _, action := range server.reloadable().Actions {
+		if filepath.Join(server.rp(), action.Path) == route {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (server *Server) initReloadEndpoint() error {
+	if server.ReloadAuthenticator == nil {
+		return nil
+	}
+
+	return server.registerInternalEndpoint(&internalEndpoint{
+		Path:          `reload`,
+		Methods:       []string{http.MethodPost},
+		Authenticator: server.ReloadAuthenticator,
+		RequireAuth:   true,
+		Handler:       server.handleReload,
+	})
+}
+
+// handle POST /_diecast/reload
+func (server *Server) handleReload(w http.ResponseWriter, req *http.Request) {
+	if err := server.Reload(); err == nil {
+		log.Infof("[%s] reload: requested via %s", reqid(req), req.URL.Path)
+
+		httputil.RespondJSON(w, map[string]any{
+			`reloaded`: true,
+		})
+	} else {
+		log.Errorf("[%s] %v", reqid(req), err)
+
+		httputil.RespondJSON(w, map[string]any{
+			`reloaded`: false,
+			`error`:    err.Error(),
+		}, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,116 @@
+package diecast
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var assets = t.TempDir()
+	var config = filepath.Join(root, `diecast.yml`)
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hello`), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(assets, `a.txt`), []byte(`asset`), 0644))
+	assert.NoError(os.WriteFile(config, []byte("mounts:\n- mount: "+assets+"\n  to: /assets/\n"), 0644))
+
+	var server = NewServer(root)
+
+	server.ReloadAuthenticator = &AuthenticatorConfig{
+		Type: `always`,
+	}
+
+	assert.NoError(server.LoadConfig(config))
+	assert.NoError(server.Initialize())
+
+	var status = func(path string) int {
+		var code int
+
+		doTestServerRequest(server, `GET`, path, func(w *httptest.ResponseRecorder) {
+			code = w.Code
+		})
+
+		return code
+	}
+
+	// mounts added outside of the configuration file are kept across reloads
+	server.SetMounts([]Mount{
+		&FileMount{MountPoint: `/extra/`, Path: assets},
+	})
+
+	assert.Equal(200, status(`/index.html`))
+	assert.Len(server.reloadable().Mounts, 2)
+
+	// mounts and authenticators are swapped in
+	assert.NoError(os.WriteFile(config, []byte("authenticators:\n- type: never\n  paths: ['/index.html']\n"), 0644))
+	assert.NoError(server.Reload())
+
+	assert.Equal(http.StatusForbidden, status(`/index.html`))
+	assert.Equal(200, status(`/extra/a.txt`))
+	assert.Len(server.reloadable().Mounts, 1)
+
+	// invalid configurations are rejected, leaving the current one in place
+	assert.NoError(os.WriteFile(config, []byte("authenticators:\n- type: nope\n"), 0644))
+
+	var err = server.Reload()
+	assert.Error(err)
+	assert.Contains(err.Error(), `authenticator 0`)
+
+	assert.NoError(os.WriteFile(config, []byte("authenticators:\n- type: never\n  paths: ['[']\n"), 0644))
+	assert.Error(server.Reload())
+
+	assert.Equal(http.StatusForbidden, status(`/index.html`))
+	assert.Len(server.reloadable().Authenticators, 1)
+
+	// ...including when reloading via the endpoint
+	doTestServerRequest(server, `POST`, `/_diecast/reload`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(w.Body.String(), `"reloaded":false`)
+	})
+
+	assert.NoError(os.WriteFile(config, []byte("mounts:\n- mount: "+assets+"\n  to: /assets/\n"), 0644))
+
+	doTestServerRequest(server, `POST`, `/_diecast/reload`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+		assert.Contains(w.Body.String(), `"reloaded":true`)
+	})
+
+	assert.Equal(200, status(`/index.html`))
+	assert.Len(server.reloadable().Mounts, 2)
+	assert.Len(server.reloadable().MountConfigs, 1)
+}
+
+func TestReloadSharedBindings(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var config = filepath.Join(root, `diecast.yml`)
+
+	assert.NoError(os.WriteFile(filepath.Join(root, `index.html`), []byte(`hello`), 0644))
+	assert.NoError(os.WriteFile(config, []byte("bindings:\n- name: old\n  resource: /nope\n  interval: 1h\n"), 0644))
+
+	var server = NewServer(root)
+
+	assert.NoError(server.LoadConfig(config))
+	assert.NoError(server.Initialize())
+
+	server.sharedBindingData.Store(`old`, true)
+
+	var stop = server.sharedBindingsStop
+
+	assert.NoError(os.WriteFile(config, []byte("bindings:\n- name: new\n  resource: /nope\n  interval: 1h\n"), 0644))
+	assert.NoError(server.Reload())
+
+	// the previous set stops polling and its data goes away
+	_, open := <-stop
+	assert.False(open)
+
+	_, ok := server.sharedBindingData.Load(`old`)
+	assert.False(ok)
+	assert.Equal(`new`, server.reloadable().Bindings[0].Name)
+}
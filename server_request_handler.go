@@ -10,6 +10,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ghetzel/go-stockutil/fileutil"
 	"github.com/ghetzel/go-stockutil/httputil"
@@ -22,6 +23,7 @@ type candidateFile struct {
 	Type          string
 	Source        string
 	Path          string
+	Route         string
 	Data          http.File
 	StatusCode    int
 	MimeType      string
@@ -30,6 +32,11 @@ type candidateFile struct {
 	Headers       map[string]any
 	PathParams    []KV
 	ForceTemplate bool
+	Stream        bool           // copy Data to the client as it is read, rather than buffering or rendering it
+	Hijacked      bool           // the connection has already been taken over by whatever produced this candidate
+	TemplateData  map[string]any // variables made available to templates rendering this candidate
+	Layout        string         // render the candidate as a template in this layout, regardless of its path
+	LastModified  time.Time      // when the file last changed, if known
 }
 
 // The main entry point for handling requests not otherwise intercepted by Actions or User Routes.
@@ -50,6 +57,15 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 	var serveFile *candidateFile
 
 	if strings.HasPrefix(req.URL.Path, prefix) {
+		// serve the request from the page cache if we can; otherwise capture the response so that
+		// it can be cached for subsequent requests
+		if hit, capture := server.pageCacheLookup(w, req); hit {
+			return
+		} else if capture != nil {
+			w = capture
+			defer capture.store()
+		}
+
 		// get a sequence of paths to search
 		var requestPaths = server.candidatePathsForRequest(req)
 		var localCandidate *candidateFile
@@ -71,6 +87,7 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 						Type:     `local`,
 						Source:   httpFilename(file),
 						Path:     rPath,
+						Route:    rPath,
 						Data:     file,
 						MimeType: mimetype,
 					}
@@ -83,6 +100,7 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 						Type:     `local`,
 						Source:   httpFilename(archive),
 						Path:     rPath,
+						Route:    rPath,
 						Data:     archive,
 						MimeType: mimetype,
 					}
@@ -94,6 +112,7 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 								Type:          `autoindex`,
 								Source:        httpFilename(file),
 								Path:          rPath,
+								Route:         rPath,
 								Data:          file,
 								MimeType:      mimetype,
 								ForceTemplate: true,
@@ -114,12 +133,18 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 						Type:         `mount`,
 						Source:       mountSummary(mount),
 						Path:         rPath,
+						Route:        mount.GetMountPoint(),
 						Data:         mountResponse.GetFile(),
 						MimeType:     mountResponse.ContentType,
 						StatusCode:   mountResponse.StatusCode,
 						Headers:      mountResponse.Metadata,
 						RedirectTo:   mountResponse.RedirectTo,
 						RedirectCode: mountResponse.RedirectCode,
+						Stream:       mountResponse.streaming,
+						Hijacked:     mountResponse.hijacked,
+						TemplateData: mountResponse.templateData,
+						Layout:       mountResponse.layout,
+						LastModified: mountResponse.lastModified,
 					}
 
 					break
@@ -168,6 +193,7 @@ func (server *Server) handleRequest(w http.ResponseWriter, req *http.Request) {
 
 			if serveFile != nil {
 				log.Debugf("[%s] found: %s (%v)", id, serveFile.Type, serveFile.Source)
+				reqroute(req, serveFile.Route)
 
 				if strings.Contains(serveFile.Path, `__id.`) {
 					var value = strings.Trim(path.Base(req.URL.Path), `/`)
@@ -264,11 +290,21 @@ func (server *Server) handleCandidateFile(
 ) bool {
 	if file == nil {
 		return false
+	} else if file.Hijacked {
+		return true
 	}
 
 	// add in any metadata as response headers
 	for k, v := range file.Headers {
-		w.Header().Set(k, fmt.Sprintf("%v", v))
+		if values, ok := v.([]string); ok {
+			w.Header().Del(k)
+
+			for _, value := range values {
+				w.Header().Add(k, value)
+			}
+		} else {
+			w.Header().Set(k, fmt.Sprintf("%v", v))
+		}
 	}
 
 	if file.MimeType == `` {
@@ -278,15 +314,50 @@ func (server *Server) handleCandidateFile(
 	// set Content-Type
 	w.Header().Set(`Content-Type`, file.MimeType)
 
+	var templated = file.ForceTemplate || file.Layout != `` || server.shouldApplyTemplate(file.Path)
+
+	// files that are served as-is can be revalidated by clients using their modification time
+	if !file.LastModified.IsZero() && !templated && httputil.Q(req, `renderer`) == `` {
+		if file.StatusCode == 0 || file.StatusCode == http.StatusOK {
+			w.Header().Set(`Last-Modified`, file.LastModified.UTC().Format(http.TimeFormat))
+
+			if notModifiedSince(req, file.LastModified) {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+
 	// write out the HTTP status if we were given one
 	if file.StatusCode > 0 {
 		w.WriteHeader(file.StatusCode)
 	}
 
+	if file.Stream {
+		// streams are never cached, and capturing one would buffer it indefinitely
+		if capture, ok := w.(*pageCapture); ok {
+			w = capture.ResponseWriter
+		}
+
+		if err := flushingCopy(w, file.Data); err != nil {
+			log.Debugf("[%s] stream ended: %v", reqid(req), err)
+		}
+
+		return true
+	}
+
+	if file.TemplateData != nil {
+		httputil.RequestSetValue(req, ContextTemplateDataKey, file.TemplateData)
+	}
+
+	if file.Layout != `` {
+		httputil.RequestSetValue(req, ContextLayoutKey, file.Layout)
+	}
+
 	// we got a real actual file here, figure out if we're templating it or not
-	if file.ForceTemplate || server.shouldApplyTemplate(file.Path) {
+	if templated {
 		// tease the template header out of the file
-		if header, templateData, err := SplitTemplateHeaderContent(file.Data); err == nil {
+		if header, templateData, err := server.splitTemplateFile(file.Data); err == nil {
 			// render the final template and write it out
 			if err := server.applyTemplate(
 				w,
@@ -296,7 +367,11 @@ func (server *Server) handleCandidateFile(
 				header,
 				file.PathParams,
 				file.MimeType,
-			); err != nil {
+			); err == nil {
+				if capture, ok := w.(*pageCapture); ok {
+					capture.rendered = true
+				}
+			} else {
 				server.respondError(w, req, fmt.Errorf("render template: %v", err), http.StatusInternalServerError)
 			}
 		} else {
@@ -313,11 +388,11 @@ func (server *Server) handleCandidateFile(
 		if rendererName := httputil.Q(req, `renderer`); rendererName == `` {
 			io.Copy(w, file.Data)
 		} else if renderer, err := GetRenderer(rendererName, server); err == nil {
-			if err := renderer.Render(w, req, renderOptions); err != nil {
+			if err := server.render(renderer, w, req, renderOptions); err != nil {
 				server.respondError(w, req, err, http.StatusInternalServerError)
 			}
 		} else if renderer, ok := GetRendererForFilename(file.Path, server); ok {
-			if err := renderer.Render(w, req, renderOptions); err != nil {
+			if err := server.render(renderer, w, req, renderOptions); err != nil {
 				server.respondError(w, req, err, http.StatusInternalServerError)
 			}
 		} else {
@@ -415,3 +490,16 @@ func httpFilename(file http.File) string {
 
 	return `<unknown>`
 }
+
+// whether the request is conditional on the file having changed since a time that it hasn't.
+func notModifiedSince(req *http.Request, modTime time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	} else if req.Header.Get(`If-None-Match`) != `` {
+		return false
+	} else if since, err := http.ParseTime(req.Header.Get(`If-Modified-Since`)); err == nil {
+		return !modTime.Truncate(time.Second).After(since)
+	} else {
+		return false
+	}
+}
@@ -13,22 +13,27 @@ var SharedBindingPollInterval time.Duration = 100 * time.Millisecond
 
 type SharedBindingSet []*Binding
 
-func (set SharedBindingSet) init(server *Server) error {
+// start polling this set's async bindings until the given channel is closed.
+func (set SharedBindingSet) init(server *Server, stop <-chan struct{}) error {
 	go func(s *Server) {
 		if SharedBindingPollInterval > 0 {
-			for at := range time.NewTicker(SharedBindingPollInterval).C {
-				if at.IsZero() {
-					break
-				}
+			var ticker = time.NewTicker(SharedBindingPollInterval)
+			defer ticker.Stop()
 
-				for i, binding := range set {
-					if ri := typeutil.Duration(binding.Interval); ri > 0 {
-						if binding.lastRefreshedAt.IsZero() || time.Since(binding.lastRefreshedAt) >= ri {
-							if binding.Name == `` {
-								binding.Name = fmt.Sprintf("shared.%d", i)
-							}
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					for i, binding := range set {
+						if ri := typeutil.Duration(binding.Interval); ri > 0 {
+							if binding.lastRefreshedAt.IsZero() || time.Since(binding.lastRefreshedAt) >= ri {
+								if binding.Name == `` {
+									binding.Name = fmt.Sprintf("shared.%d", i)
+								}
 
-							go set.refreshAndStore(server, binding)
+								go set.refreshAndStore(server, binding, stop)
+							}
 						}
 					}
 				}
@@ -39,7 +44,7 @@ func (set SharedBindingSet) init(server *Server) error {
 	return nil
 }
 
-func (set SharedBindingSet) refreshAndStore(server *Server, binding *Binding) {
+func (set SharedBindingSet) refreshAndStore(server *Server, binding *Binding, stop <-chan struct{}) {
 	if binding.syncing {
 		return
 	} else {
@@ -53,6 +58,13 @@ func (set SharedBindingSet) refreshAndStore(server *Server, binding *Binding) {
 	binding.server = server
 
 	if data, err := binding.asyncEval(); err == nil {
+		// this set was replaced while we were evaluating, so the result is no longer wanted
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
 		if binding.Name != `` {
 			server.sharedBindingData.Store(binding.Name, data)
 			binding.lastRefreshedAt = time.Now()
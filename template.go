@@ -209,6 +209,49 @@ func (tpl *Template) ParseFragments(fragments FragmentSet) error {
 	return nil
 }
 
+// returns an unexecuted copy of the parsed template, suitable for reuse by later renders.
+func (tpl *Template) parsedCopy() (any, error) {
+	switch t := tpl.tmpl.(type) {
+	case *text.Template:
+		return t.Clone()
+	case *html.Template:
+		return t.Clone()
+	default:
+		return nil, fmt.Errorf("no template input provided")
+	}
+}
+
+// replaces this template's content with a copy of a previously-parsed template (as returned from
+// parsedCopy), bound to this template's functions.
+func (tpl *Template) useParsed(parsed any) error {
+	switch t := parsed.(type) {
+	case *text.Template:
+		if c, err := t.Clone(); err == nil {
+			if tpl.funcs != nil {
+				c.Funcs(text.FuncMap(tpl.funcs))
+			}
+
+			tpl.tmpl = c
+		} else {
+			return err
+		}
+	case *html.Template:
+		if c, err := t.Clone(); err == nil {
+			if tpl.funcs != nil {
+				c.Funcs(html.FuncMap(tpl.funcs))
+			}
+
+			tpl.tmpl = c
+		} else {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid parsed template type %T", parsed)
+	}
+
+	return nil
+}
+
 func (tpl *Template) Funcs(funcs FuncMap) {
 	tpl.funcs = funcs
 }
@@ -0,0 +1,108 @@
+package diecast
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+var DefaultTemplateCacheSize = 512
+
+// a template file that has already been split into its header and content.
+type templateFileEntry struct {
+	header *TemplateHeader
+	data   []byte
+}
+
+func (server *Server) templateCaches() (*lruCache[*templateFileEntry], *lruCache[any]) {
+	server.templateCacheLock.Lock()
+	defer server.templateCacheLock.Unlock()
+
+	if server.templateFiles == nil {
+		server.templateFiles = newLruCache[*templateFileEntry](DefaultTemplateCacheSize)
+	}
+
+	if server.templatesParsed == nil {
+		server.templatesParsed = newLruCache[any](DefaultTemplateCacheSize)
+	}
+
+	return server.templateFiles, server.templatesParsed
+}
+
+// Reads the given template file and splits it into its header and content (see
+// SplitTemplateHeaderContent).  Files read from local disk are cached by filename, modification
+// time, and size, so that unchanged templates are not re-read or re-parsed.  The returned header
+// is always a copy that the caller is free to modify.
+func (server *Server) splitTemplateFile(reader io.Reader) (*TemplateHeader, []byte, error) {
+	var key string
+
+	if file, ok := reader.(*os.File); ok && !server.DisableTemplateCache {
+		if stat, err := file.Stat(); err == nil && !stat.ModTime().IsZero() {
+			key = fmt.Sprintf("%s:%d:%d", file.Name(), stat.ModTime().UnixNano(), stat.Size())
+		}
+	}
+
+	if key == `` {
+		return SplitTemplateHeaderContent(reader)
+	}
+
+	var files, _ = server.templateCaches()
+
+	if entry, ok := files.Get(key); ok {
+		return entry.header.clone(), entry.data, nil
+	}
+
+	if header, data, err := SplitTemplateHeaderContent(reader); err == nil {
+		files.Set(key, &templateFileEntry{
+			header: header.clone(),
+			data:   data,
+		})
+
+		return header, data, nil
+	} else {
+		return nil, nil, err
+	}
+}
+
+// Parses the given fragments into the template.  Because the cache key is derived from the
+// content of every fragment (the page itself, its layout, and all includes), a change to any
+// one of them results in the whole set being parsed again.
+func (server *Server) parseTemplateFragments(tmpl *Template, fragments FragmentSet) error {
+	if server.DisableTemplateCache {
+		return tmpl.ParseFragments(fragments)
+	}
+
+	var hash = sha256.New()
+
+	fmt.Fprintf(hash, "%v:%s:%d\n", tmpl.engine, tmpl.name, len(tmpl.funcs))
+
+	for _, fragment := range fragments {
+		fmt.Fprintf(hash, "%s:%d\n", fragment.Name, len(fragment.Data))
+		hash.Write(fragment.Data)
+	}
+
+	var key = hex.EncodeToString(hash.Sum(nil))
+	var _, parsed = server.templateCaches()
+
+	if master, ok := parsed.Get(key); ok {
+		if err := tmpl.useParsed(master); err == nil {
+			return nil
+		} else {
+			log.Warningf("template cache: %v", err)
+		}
+	}
+
+	if err := tmpl.ParseFragments(fragments); err == nil {
+		if master, err := tmpl.parsedCopy(); err == nil {
+			parsed.Set(key, master)
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,101 @@
+package diecast
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/testify/require"
+)
+
+func TestTemplateCache(t *testing.T) {
+	var assert = require.New(t)
+	var root = t.TempDir()
+	var mtime = time.Now().Add(-time.Hour)
+
+	var write = func(name string, content string) {
+		var filename = filepath.Join(root, name)
+
+		assert.NoError(os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(os.WriteFile(filename, []byte(content), 0644))
+
+		// advance the modification time explicitly so that coarse filesystem timestamps don't
+		// hide the change
+		mtime = mtime.Add(time.Second)
+		assert.NoError(os.Chtimes(filename, mtime, mtime))
+	}
+
+	write(`_layouts/default.html`, "<main>{{ template \"content\" . }}</main>")
+	write(`_footer.html`, "footer-v1")
+	write(`index.html`, "---\nincludes:\n  footer: '/_footer.html'\npage:\n  title: hello\n---\n{{ $.page.title }} {{ template \"footer\" . }}")
+
+	var server = NewServer(root)
+
+	assert.NoError(server.Initialize())
+
+	var get = func() string {
+		var body string
+
+		doTestServerRequest(server, `GET`, `/`, func(w *httptest.ResponseRecorder) {
+			assert.Equal(200, w.Code)
+			body = w.Body.String()
+		})
+
+		return body
+	}
+
+	assert.Equal(`<main>hello footer-v1</main>`, get())
+	assert.Equal(`<main>hello footer-v1</main>`, get())
+
+	var files, parsed = server.templateCaches()
+
+	assert.Equal(3, files.Len())
+	assert.Equal(1, parsed.Len())
+
+	// changing an include re-parses every page that uses it
+	write(`_footer.html`, "footer-v2")
+	assert.Equal(`<main>hello footer-v2</main>`, get())
+
+	// ...as does changing the layout
+	write(`_layouts/default.html`, "<div>{{ template \"content\" . }}</div>")
+	assert.Equal(`<div>hello footer-v2</div>`, get())
+
+	// ...and the page itself
+	write(`index.html`, "---\npage:\n  title: goodbye\n---\n{{ $.page.title }}")
+	assert.Equal(`<div>goodbye</div>`, get())
+
+	// cached headers are copied before being handed out
+	var filename = filepath.Join(root, `index.html`)
+	var open = func() *TemplateHeader {
+		var file, err = os.Open(filename)
+		assert.NoError(err)
+		defer file.Close()
+
+		header, _, err := server.splitTemplateFile(file)
+		assert.NoError(err)
+
+		return header
+	}
+
+	open().Page[`title`] = `modified`
+	assert.Equal(`goodbye`, open().Page[`title`])
+}
+
+func TestTemplateCacheDisabled(t *testing.T) {
+	var assert = require.New(t)
+	var server = NewServer(`./tests/hello`)
+
+	server.DisableTemplateCache = true
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	var files, parsed = server.templateCaches()
+
+	assert.Zero(files.Len())
+	assert.Zero(parsed.Len())
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ghetzel/go-stockutil/httputil"
 	"github.com/ghetzel/go-stockutil/stringutil"
 	"github.com/ghetzel/go-stockutil/typeutil"
 )
@@ -15,10 +16,93 @@ var reqTimes sync.Map
 var timerDescriptions sync.Map
 
 type requestTimer struct {
-	ID        string
-	Request   *http.Request
-	StartedAt time.Time
-	Times     map[string]time.Duration
+	ID            string
+	Request       *http.Request
+	StartedAt     time.Time
+	Times         map[string]time.Duration
+	Bindings      []requestLogBinding
+	Authenticator string
+	Mount         string
+	Route         string
+	lock          sync.Mutex
+}
+
+// details about a binding evaluated while handling a request, for inclusion in the request log.
+type requestLogBinding struct {
+	Name       string   `json:"name"`
+	Status     int      `json:"status,omitempty"`
+	DurationMs float64  `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+	Skipped    bool     `json:"skipped,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`   // the number of attempts made, if the request was retried
+	Breaker    string   `json:"breaker,omitempty"`    // the state of the upstream host's circuit breaker, if it isn't closed
+	Violations []string `json:"violations,omitempty"` // how the response failed to conform to the binding's schema, if it has one
+}
+
+// a single request, as written by the "json" log format.
+type requestLogEntry struct {
+	Time           time.Time           `json:"time"`
+	RequestID      string              `json:"request_id"`
+	RemoteAddress  string              `json:"remote_address"`
+	Method         string              `json:"method"`
+	Host           string              `json:"host"`
+	URL            string              `json:"url"`
+	Protocol       string              `json:"protocol"`
+	StatusCode     int                 `json:"status_code"`
+	RequestLength  int64               `json:"request_length"`
+	ResponseLength int64               `json:"response_length"`
+	DurationMs     float64             `json:"duration_ms"`
+	Timings        map[string]float64  `json:"timings,omitempty"`
+	Bindings       []requestLogBinding `json:"bindings,omitempty"`
+	Authenticator  string              `json:"authenticator,omitempty"`
+	Mount          string              `json:"mount,omitempty"`
+	UserAgent      string              `json:"user_agent,omitempty"`
+	Referer        string              `json:"referer,omitempty"`
+}
+
+func newRequestLogEntry(req *http.Request, timer *requestTimer, res *statusInterceptor, remoteAddr string) *requestLogEntry {
+	var duration = httputil.RequestGetValue(req, `duration`).Duration()
+
+	if duration == 0 {
+		duration = time.Since(timer.StartedAt)
+	}
+
+	var entry = &requestLogEntry{
+		Time:           timer.StartedAt,
+		RequestID:      timer.ID,
+		RemoteAddress:  remoteAddr,
+		Method:         req.Method,
+		Host:           req.Host,
+		URL:            req.URL.String(),
+		Protocol:       req.Proto,
+		StatusCode:     res.code,
+		RequestLength:  req.ContentLength,
+		ResponseLength: res.bytesWritten,
+		DurationMs:     millis(duration),
+		UserAgent:      req.UserAgent(),
+		Referer:        req.Referer(),
+	}
+
+	timer.lock.Lock()
+	defer timer.lock.Unlock()
+
+	if len(timer.Times) > 0 {
+		entry.Timings = make(map[string]float64)
+
+		for key, took := range timer.Times {
+			entry.Timings[key] = millis(took)
+		}
+	}
+
+	entry.Bindings = timer.Bindings
+	entry.Authenticator = timer.Authenticator
+	entry.Mount = timer.Mount
+
+	return entry
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000.0
 }
 
 func startRequestTimer(req *http.Request) {
@@ -41,10 +125,65 @@ func reqtime(req *http.Request, key string, took time.Duration) {
 		if v, ok := reqTimes.Load(id); ok {
 			if timer, ok := v.(*requestTimer); ok {
 				// log.Debugf("[%v] %v=%v", id, key, took)
+				timer.lock.Lock()
 				timer.Times[key] = took
+				timer.lock.Unlock()
+			}
+		}
+	}
+}
+
+// record the outcome of a binding evaluated while handling the given request.
+func reqbinding(req *http.Request, entry requestLogBinding) {
+	if timer := getRequestTimer(req); timer != nil {
+		timer.lock.Lock()
+		timer.Bindings = append(timer.Bindings, entry)
+		timer.lock.Unlock()
+	}
+}
+
+// return the bindings evaluated while handling the given request that failed or did not conform
+// to their schema.
+func failedBindings(req *http.Request) (failed []requestLogBinding) {
+	if timer := getRequestTimer(req); timer != nil {
+		timer.lock.Lock()
+		defer timer.lock.Unlock()
+
+		for _, entry := range timer.Bindings {
+			if entry.Error != `` || len(entry.Violations) > 0 {
+				failed = append(failed, entry)
 			}
 		}
 	}
+
+	return
+}
+
+// record the name of the authenticator that handled the given request.
+func reqauthenticator(req *http.Request, name string) {
+	if timer := getRequestTimer(req); timer != nil {
+		timer.lock.Lock()
+		timer.Authenticator = name
+		timer.lock.Unlock()
+	}
+}
+
+// record the mount that served the given request.
+func reqmount(req *http.Request, mount Mount) {
+	if timer := getRequestTimer(req); timer != nil {
+		timer.lock.Lock()
+		timer.Mount = mount.GetMountPoint()
+		timer.lock.Unlock()
+	}
+}
+
+// record the route (a local file, mount point, or internal path) that handled the given request.
+func reqroute(req *http.Request, route string) {
+	if timer := getRequestTimer(req); timer != nil {
+		timer.lock.Lock()
+		timer.Route = route
+		timer.lock.Unlock()
+	}
 }
 
 func getRequestTimer(req *http.Request) *requestTimer {
@@ -69,6 +208,8 @@ func writeRequestTimerHeaders(server *Server, w http.ResponseWriter, req *http.R
 	if id := reqid(req); id != `` {
 		if v, ok := reqTimes.Load(id); ok {
 			if timer, ok := v.(*requestTimer); ok {
+				timer.lock.Lock()
+
 				for tk, dur := range timer.Times {
 					var timing string
 
@@ -83,6 +224,8 @@ func writeRequestTimerHeaders(server *Server, w http.ResponseWriter, req *http.R
 
 					timings = append(timings, timing)
 				}
+
+				timer.lock.Unlock()
 			}
 		}
 
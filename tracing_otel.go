@@ -0,0 +1,226 @@
+package diecast
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const OtelSpanKey = `otel-span`
+
+// How long to wait for spans that haven't been exported yet when the server stops.
+var OtelShutdownTimeout = 5 * time.Second
+
+// W3C Trace Context (i.e.: the "traceparent" and "tracestate" headers) is used to continue incoming
+// traces and to propagate them to bindings and proxied requests.
+var otelPropagator = propagation.TraceContext{}
+
+type OtelConfig struct {
+	Enable             bool              `yaml:"enable"        json:"enable"`        // Explicitly enable or disable OpenTelemetry tracing
+	ServiceName        string            `yaml:"service"       json:"service"`       // Set the service name that traces will fall under.
+	Protocol           string            `yaml:"protocol"      json:"protocol"`      // The OTLP transport to export traces with: "http" (default) or "grpc".
+	Endpoint           string            `yaml:"endpoint"      json:"endpoint"`      // The host:port or URL of the collector to export traces to.  Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable, then the transport's default local collector address.
+	Insecure           bool              `yaml:"insecure"      json:"insecure"`      // Connect to the collector without TLS (implied by "http://" endpoint URLs).
+	Headers            map[string]string `yaml:"headers"       json:"headers"`       // Additional headers (e.g.: for authentication) to send to the collector with each export.
+	Timeout            string            `yaml:"timeout"       json:"timeout"`       // How long to wait for each export to complete.
+	Tags               map[string]any    `yaml:"tags"          json:"tags"`          // A set of key-value pairs that are included in every trace.
+	SamplingRatio      float64           `yaml:"samplingRatio" json:"samplingRatio"` // The fraction of new traces (0.0-1.0) to record; traces continued from an incoming request follow the caller's decision.  Defaults to recording everything.
+	OperationsMappings []*TraceMapping   `yaml:"operations"    json:"operations"`    // Maps regular expressions used to match specific routes to the operation name that will be emitted in traces, exactly as the "operations" setting for Jaeger does.
+}
+
+func (config *OtelConfig) exporter() (*otlptrace.Exporter, error) {
+	var timeout time.Duration
+
+	if config.Timeout != `` {
+		if timeout = typeutil.Duration(config.Timeout); timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", config.Timeout)
+		}
+	}
+
+	switch proto := strings.ToLower(config.Protocol); proto {
+	case ``, `http`, `http/protobuf`:
+		var opts []otlptracehttp.Option
+
+		if strings.Contains(config.Endpoint, `://`) {
+			opts = append(opts, otlptracehttp.WithEndpointURL(config.Endpoint))
+		} else if config.Endpoint != `` {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Headers))
+		}
+
+		if timeout > 0 {
+			opts = append(opts, otlptracehttp.WithTimeout(timeout))
+		}
+
+		return otlptracehttp.New(context.Background(), opts...)
+	case `grpc`:
+		var opts []otlptracegrpc.Option
+
+		if strings.Contains(config.Endpoint, `://`) {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(config.Endpoint))
+		} else if config.Endpoint != `` {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		if len(config.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(config.Headers))
+		}
+
+		if timeout > 0 {
+			opts = append(opts, otlptracegrpc.WithTimeout(timeout))
+		}
+
+		return otlptracegrpc.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", proto)
+	}
+}
+
+func (server *Server) initOtelTracing() error {
+	var oc = server.OtelConfig
+
+	server.stopOtelTracing()
+
+	if oc == nil || !oc.Enable {
+		return nil
+	}
+
+	var exporter, err = oc.exporter()
+
+	if err != nil {
+		return err
+	}
+
+	var attrs = []attribute.KeyValue{
+		attribute.String(`service.name`, sliceutil.OrString(oc.ServiceName, `diecast`)),
+		attribute.String(`diecast-version`, ApplicationVersion),
+	}
+
+	for k, v := range oc.Tags {
+		attrs = append(attrs, attribute.String(k, typeutil.String(v)))
+	}
+
+	var sampler = sdktrace.AlwaysSample()
+
+	if oc.SamplingRatio > 0 && oc.SamplingRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(oc.SamplingRatio)
+	}
+
+	server.otelProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+
+	server.otelTracer = server.otelProvider.Tracer(`github.com/ghetzel/diecast`)
+
+	otel.SetTracerProvider(server.otelProvider)
+	otel.SetTextMapPropagator(otelPropagator)
+
+	log.Debugf("trace: OpenTelemetry tracing enabled: service=%s protocol=%s", sliceutil.OrString(oc.ServiceName, `diecast`), sliceutil.OrString(oc.Protocol, `http`))
+
+	return nil
+}
+
+// export any spans that are still buffered and stop the tracer provider, giving up after
+// OtelShutdownTimeout.
+func (server *Server) stopOtelTracing() {
+	if server.otelProvider == nil {
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), OtelShutdownTimeout)
+	defer cancel()
+
+	if err := server.otelProvider.Shutdown(ctx); err != nil {
+		log.Warningf("trace: failed to export remaining spans: %v", err)
+	}
+
+	server.otelProvider = nil
+}
+
+// start the span that traces the handling of the given request, continuing the caller's trace if
+// the request carries one.
+func (server *Server) startOtelRequestSpan(req *http.Request, traceName string, requestId string) {
+	var ctx = otelPropagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	_, span := server.otelTracer.Start(
+		ctx,
+		traceName,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String(`diecast.request_id`, requestId),
+			attribute.String(`http.method`, req.Method),
+			attribute.String(`http.target`, req.URL.RequestURI()),
+		),
+	)
+
+	httputil.RequestSetValue(req, OtelSpanKey, span)
+}
+
+// start a span that is a child of the given request's span.  Returns nil if the request is not being
+// traced, otherwise the returned headers carry the new span's context to outbound requests.
+func startOtelChildSpan(req *http.Request, traceName string, attrs ...attribute.KeyValue) (trace.Span, http.Header) {
+	if req == nil {
+		return nil, nil
+	}
+
+	if parent, ok := httputil.RequestGetValue(req, OtelSpanKey).Value.(trace.Span); ok {
+		var ctx, span = parent.TracerProvider().Tracer(`github.com/ghetzel/diecast`).Start(
+			trace.ContextWithSpan(context.Background(), parent),
+			traceName,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrs...),
+		)
+
+		var headers = make(http.Header)
+
+		otelPropagator.Inject(ctx, propagation.HeaderCarrier(headers))
+
+		return span, headers
+	}
+
+	return nil, nil
+}
+
+// finish a span, marking it as failed if an error occurred.
+func finishOtelSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,184 @@
+package diecast
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOtelTracing(t *testing.T) {
+	var assert = require.New(t)
+	var spans []*tracepb.Span
+	var traceparents []string
+	var lock sync.Mutex
+
+	// a stand-in for an OTLP/HTTP collector
+	var collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var export coltracepb.ExportTraceServiceRequest
+
+		if body, err := io.ReadAll(req.Body); err == nil {
+			if err := proto.Unmarshal(body, &export); err == nil {
+				lock.Lock()
+
+				for _, rs := range export.ResourceSpans {
+					for _, ss := range rs.ScopeSpans {
+						spans = append(spans, ss.Spans...)
+					}
+				}
+
+				lock.Unlock()
+			}
+		}
+
+		w.Header().Set(`Content-Type`, `application/x-protobuf`)
+		w.Write(nil)
+	}))
+
+	defer collector.Close()
+
+	var upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		traceparents = append(traceparents, req.Header.Get(`traceparent`))
+		lock.Unlock()
+
+		httputil.RespondJSON(w, map[string]any{
+			`ok`: true,
+		})
+	}))
+
+	defer upstream.Close()
+
+	var server = NewServer(`./tests/hello`)
+
+	server.Bindings = SharedBindingSet{
+		{
+			Name:     `upstream`,
+			Resource: upstream.URL,
+		},
+	}
+
+	server.SetMounts([]Mount{
+		&ProxyMount{
+			MountPoint: `/api`,
+			URL:        upstream.URL,
+		},
+	})
+
+	server.OtelConfig = &OtelConfig{
+		Enable:   true,
+		Endpoint: collector.URL,
+		OperationsMappings: []*TraceMapping{
+			{
+				Match:   `^GET /api/.*`,
+				Replace: `GET /api/*`,
+			},
+		},
+	}
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	doTestServerRequest(server, `GET`, `/api/things`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	assert.NoError(server.otelProvider.ForceFlush(context.Background()))
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	var byName = make(map[string]*tracepb.Span)
+
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	assert.Contains(byName, `GET /index.html`)
+	assert.Contains(byName, `GET /api/*`)
+	assert.Contains(byName, `Binding: upstream`)
+	assert.Contains(byName, `Mount: /api`)
+
+	// bindings and mounts are children of the request that triggered them
+	var page = byName[`GET /index.html`]
+	var binding = byName[`Binding: upstream`]
+	var proxied = byName[`GET /api/*`]
+	var mount = byName[`Mount: /api`]
+
+	assert.Equal(tracepb.Span_SPAN_KIND_SERVER, page.Kind)
+	assert.Equal(page.SpanId, binding.ParentSpanId)
+	assert.Equal(page.TraceId, binding.TraceId)
+	assert.Equal(proxied.SpanId, mount.ParentSpanId)
+
+	// and the trace continues in the upstream server
+	assert.GreaterOrEqual(len(traceparents), 2)
+
+	var seen = strings.Join(traceparents, ` `)
+
+	assert.Contains(seen, hex.EncodeToString(binding.TraceId)+`-`+hex.EncodeToString(binding.SpanId))
+	assert.Contains(seen, hex.EncodeToString(mount.TraceId)+`-`+hex.EncodeToString(mount.SpanId))
+}
+
+func TestOtelTracingShutdown(t *testing.T) {
+	var assert = require.New(t)
+	var exported int
+	var lock sync.Mutex
+
+	var collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var export coltracepb.ExportTraceServiceRequest
+
+		if body, err := io.ReadAll(req.Body); err == nil {
+			if err := proto.Unmarshal(body, &export); err == nil {
+				lock.Lock()
+
+				for _, rs := range export.ResourceSpans {
+					for _, ss := range rs.ScopeSpans {
+						exported += len(ss.Spans)
+					}
+				}
+
+				lock.Unlock()
+			}
+		}
+
+		w.Header().Set(`Content-Type`, `application/x-protobuf`)
+		w.Write(nil)
+	}))
+
+	defer collector.Close()
+
+	var server = NewServer(`./tests/hello`)
+
+	server.OtelConfig = &OtelConfig{
+		Enable:   true,
+		Endpoint: collector.URL,
+	}
+
+	assert.NoError(server.Initialize())
+
+	doTestServerRequest(server, `GET`, `/index.html`, func(w *httptest.ResponseRecorder) {
+		assert.Equal(200, w.Code)
+	})
+
+	// spans still waiting to be batched are exported when the server stops
+	server.cleanupCommands()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(1, exported)
+	assert.Nil(server.otelProvider)
+}
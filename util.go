@@ -2,16 +2,19 @@ package diecast
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"encoding/csv"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/ghetzel/go-stockutil/fileutil"
 	"github.com/ghetzel/go-stockutil/log"
@@ -180,6 +183,7 @@ type statusInterceptor struct {
 	http.ResponseWriter
 	code         int
 	bytesWritten int64
+	wroteHeader  bool
 }
 
 func intercept(upstream http.ResponseWriter) *statusInterceptor {
@@ -192,14 +196,32 @@ func intercept(upstream http.ResponseWriter) *statusInterceptor {
 func (intercept *statusInterceptor) WriteHeader(code int) {
 	intercept.ResponseWriter.WriteHeader(code)
 	intercept.code = code
+	intercept.wroteHeader = true
 }
 
 func (intercept *statusInterceptor) Write(b []byte) (int, error) {
+	intercept.wroteHeader = true
 	n, err := intercept.ResponseWriter.Write(b)
 	intercept.bytesWritten += int64(n)
 	return n, err
 }
 
+// allows connections to be taken over (e.g.: for websockets), provided the underlying writer permits it.
+func (intercept *statusInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := intercept.ResponseWriter.(http.Hijacker); ok {
+		intercept.wroteHeader = true
+		return hijacker.Hijack()
+	} else {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", intercept.ResponseWriter)
+	}
+}
+
+func (intercept *statusInterceptor) Flush() {
+	if flusher, ok := intercept.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func fancyMapJoin(in any) string {
 	var m = maputil.M(in)
 
@@ -453,3 +475,38 @@ func (hfile *httpFile) Readdir(count int) ([]os.FileInfo, error) {
 func (hfile *httpFile) Stat() (os.FileInfo, error) {
 	return hfile.FileInfo, nil
 }
+
+// returns a deep copy of the given map, or nil if it is nil.
+func deepCopyMap(in map[string]any) map[string]any {
+	if in == nil {
+		return nil
+	}
+
+	return maputil.DeepCopy(in)
+}
+
+// returns a copy of the given map, or nil if it is nil.
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+
+	var out = make(map[string]string, len(in))
+
+	for k, v := range in {
+		out[k] = v
+	}
+
+	return out
+}
+
+// parses a duration (e.g. "90s", "1h"), treating numbers (including numeric strings) as seconds.
+func secondsOrDuration(in any) time.Duration {
+	if in == nil {
+		return 0
+	} else if typeutil.IsNumeric(in) {
+		return time.Duration(typeutil.Float(in) * float64(time.Second))
+	} else {
+		return typeutil.Duration(in)
+	}
+}